@@ -0,0 +1,363 @@
+package prober
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	adminAddrEnv            = "ADMIN_ADDR"
+	adminTokenEnv           = "ADMIN_TOKEN"
+	adminUsernameEnv        = "ADMIN_USERNAME"
+	adminPasswordEnv        = "ADMIN_PASSWORD"
+	adminRateLimitEnv       = "ADMIN_RATE_LIMIT"
+	adminLockoutFailuresEnv = "ADMIN_LOCKOUT_FAILURES"
+	adminLockoutDurationEnv = "ADMIN_LOCKOUT_DURATION"
+	adminAllowedCIDRsEnv    = "ADMIN_ALLOWED_CIDRS"
+	controlAuthEnv          = "CONTROL_AUTH"
+
+	defaultAdminLockoutDuration = 5 * time.Minute
+	// maxGuardedClients bounds the clients tracked, the stale ones being
+	// forgotten past it.
+	maxGuardedClients = 10000
+)
+
+// adminMetrics count the admin and control requests refused.
+type adminMetrics struct {
+	adminRateLimitedTotal  *prometheus.CounterVec
+	adminAuthFailuresTotal *prometheus.CounterVec
+	adminLockoutsTotal     *prometheus.CounterVec
+	adminForbiddenTotal    *prometheus.CounterVec
+}
+
+func newAdminMetrics(registry prometheus.Registerer) adminMetrics {
+	m := adminMetrics{
+		adminRateLimitedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admin_rate_limited_total",
+			Help: "Admin and control requests refused by the per-client rate limit, by router.",
+		}, []string{"router"}),
+
+		adminAuthFailuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admin_auth_failures_total",
+			Help: "Admin and control requests with missing or wrong credentials, by router.",
+		}, []string{"router"}),
+
+		adminLockoutsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admin_lockouts_total",
+			Help: "Clients locked out after repeated authentication failures, by router.",
+		}, []string{"router"}),
+
+		adminForbiddenTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admin_forbidden_total",
+			Help: "Admin and control requests refused because of their client IP, by router.",
+		}, []string{"router"}),
+	}
+	registry.MustRegister(m.adminRateLimitedTotal, m.adminAuthFailuresTotal, m.adminLockoutsTotal, m.adminForbiddenTotal)
+	return m
+}
+
+// parseNetworks reads a list of CIDRs and IPs, the IPs standing for
+// themselves.
+func parseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range splitList(value) {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// loadAdminNetworks reads ADMIN_ALLOWED_CIDRS, every client being allowed
+// without it.
+func loadAdminNetworks(config *settings) ([]*net.IPNet, error) {
+	networks, err := parseNetworks(config.get(adminAllowedCIDRsEnv))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", adminAllowedCIDRsEnv, err)
+	}
+	return networks, nil
+}
+
+func secureCompare(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// adminCredentials are the bearer token and/or basic auth credentials the
+// admin endpoints require. Without any of them the endpoints are open.
+type adminCredentials struct {
+	token    string
+	username string
+	password string
+}
+
+func loadAdminCredentials(config *settings) adminCredentials {
+	return adminCredentials{
+		token:    config.get(adminTokenEnv),
+		username: config.get(adminUsernameEnv),
+		password: config.get(adminPasswordEnv),
+	}
+}
+
+func (a adminCredentials) enabled() bool {
+	return a.token != "" || a.username != ""
+}
+
+func (a adminCredentials) check(r *http.Request) bool {
+	if a.token != "" && secureCompare(r.Header.Get("Authorization"), "Bearer "+a.token) {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	return ok && a.username != "" && secureCompare(user, a.username) && secureCompare(pass, a.password)
+}
+
+type clientAuth struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// clientGuard only lets in the client IPs of its networks, rate limits
+// the requests of each within the same second, and locks out the clients
+// failing to authenticate too many times in a row, so a test harness stuck
+// in a loop can't destabilize a shared prober nor brute force its
+// credentials. Empty networks and zero limits disable them.
+type clientGuard struct {
+	name     string
+	networks []*net.IPNet
+	limit    int
+	failures int
+	lockout  time.Duration
+
+	mu      sync.Mutex
+	windows map[string]messageWindow
+	auths   map[string]*clientAuth
+
+	adminMetrics
+}
+
+// loadClientGuard reads the guard settings, the networks being checked by
+// NewServer beforehand.
+func (st *state) loadClientGuard(name string) *clientGuard {
+	networks, _ := loadAdminNetworks(st.config)
+	return &clientGuard{
+		name:     name,
+		networks: networks,
+		limit:    st.config.getInt(adminRateLimitEnv, 0),
+		failures: st.config.getInt(adminLockoutFailuresEnv, 0),
+		lockout:  st.config.getDuration(adminLockoutDurationEnv, defaultAdminLockoutDuration),
+		windows:  make(map[string]messageWindow),
+		auths:    make(map[string]*clientAuth),
+
+		adminMetrics: st.adminMetrics,
+	}
+}
+
+// clientKey is the IP of the peer, or of the client conveyed by the PROXY
+// protocol, forwarding headers being easy to spoof.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// permit answers 403 to the clients outside of the networks.
+func (g *clientGuard) permit(c *web.Context) bool {
+	if len(g.networks) == 0 {
+		return true
+	}
+	if ip := net.ParseIP(clientKey(c.Request)); ip != nil {
+		for _, network := range g.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	g.adminForbiddenTotal.WithLabelValues(g.name).Inc()
+	c.AbortWithStatusJSON(http.StatusForbidden, web.H{"error": "Client not allowed"})
+	return false
+}
+
+// allow counts the request in the window of its client and answers 429
+// when over the limit.
+func (g *clientGuard) allow(c *web.Context) bool {
+	if g.limit <= 0 {
+		return true
+	}
+	key := clientKey(c.Request)
+	second := time.Now().Unix()
+	g.mu.Lock()
+	if len(g.windows) >= maxGuardedClients {
+		for client, window := range g.windows {
+			if window.second != second {
+				delete(g.windows, client)
+			}
+		}
+	}
+	window := g.windows[key]
+	if window.second != second {
+		window = messageWindow{second: second}
+	}
+	window.count++
+	g.windows[key] = window
+	g.mu.Unlock()
+
+	if window.count <= int64(g.limit) {
+		return true
+	}
+	g.adminRateLimitedTotal.WithLabelValues(g.name).Inc()
+	c.Header("Retry-After", "1")
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, web.H{"error": "Too many requests"})
+	return false
+}
+
+// authorize checks the credentials of the request, answering 401 when
+// wrong and 429 while its client is locked out.
+func (g *clientGuard) authorize(c *web.Context, credentials adminCredentials) bool {
+	if !credentials.enabled() {
+		return true
+	}
+	key := clientKey(c.Request)
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth := g.auths[key]
+	if auth != nil && now.Before(auth.lockedUntil) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(auth.lockedUntil.Sub(now).Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, web.H{"error": "Too many authentication failures"})
+		return false
+	}
+	if credentials.check(c.Request) {
+		delete(g.auths, key)
+		return true
+	}
+
+	g.adminAuthFailuresTotal.WithLabelValues(g.name).Inc()
+	if g.failures > 0 {
+		if auth == nil {
+			if len(g.auths) >= maxGuardedClients {
+				for client, stale := range g.auths {
+					if now.After(stale.lockedUntil) {
+						delete(g.auths, client)
+					}
+				}
+			}
+			auth = &clientAuth{}
+			g.auths[key] = auth
+		}
+		if auth.failures++; auth.failures >= g.failures {
+			auth.failures, auth.lockedUntil = 0, now.Add(g.lockout)
+			g.adminLockoutsTotal.WithLabelValues(g.name).Inc()
+			slog.Warn("Client locked out after authentication failures", "router", g.name, "client", key, "until", auth.lockedUntil)
+		}
+	}
+	if credentials.username != "" {
+		c.Header("WWW-Authenticate", `Basic realm="prober admin"`)
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, web.H{"error": "Unauthorized"})
+	return false
+}
+
+// adminAuth restricts the admin endpoints to the allowed clients, rate
+// limits them and protects them with the admin credentials.
+func (st *state) adminAuth(guard *clientGuard) web.HandlerFunc {
+	credentials := loadAdminCredentials(st.config)
+	return func(c *web.Context) {
+		if guard.permit(c) && guard.allow(c) && guard.authorize(c, credentials) {
+			c.Next()
+		}
+	}
+}
+
+// controlRoutes are the prefixes of the endpoints of the default router
+// changing the behavior of prober.
+var controlRoutes = []string{
+	"/config", "/scenario", "/mocks", "/counters", "/load", "/clock/advance", "/cache", "/resources/burn",
+	"/bandwidth/run", "/egress/run", "/longpoll/release", "/idempotency/keys",
+}
+
+// isControlRequest tells whether the request changes the behavior of
+// prober, reads being left alone.
+func isControlRequest(c *web.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	path := c.FullPath()
+	for _, prefix := range controlRoutes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// controlAccess applies the admin networks and rate limit to the control
+// requests of the default router and, with CONTROL_AUTH, the admin
+// credentials.
+func (st *state) controlAccess() web.HandlerFunc {
+	guard := st.loadClientGuard("control")
+	credentials := adminCredentials{}
+	if st.config.getBool(controlAuthEnv, false) {
+		credentials = loadAdminCredentials(st.config)
+	}
+	return func(c *web.Context) {
+		if !isControlRequest(c) {
+			c.Next()
+			return
+		}
+		if guard.permit(c) && guard.allow(c) && guard.authorize(c, credentials) {
+			c.Next()
+		}
+	}
+}
+
+func (st *state) newAdminRouter() *web.Engine {
+	router := web.New()
+	router.Use(st.inFlight(), recovery(), st.accessLog(), st.adminAuth(st.loadClientGuard("admin")))
+
+	// Profiling
+	pprofGroup := router.Group("/debug/pprof")
+	pprofGroup.GET("/", web.WrapF(pprof.Index))
+	pprofGroup.GET("/cmdline", web.WrapF(pprof.Cmdline))
+	pprofGroup.GET("/profile", web.WrapF(pprof.Profile))
+	pprofGroup.GET("/symbol", web.WrapF(pprof.Symbol))
+	pprofGroup.POST("/symbol", web.WrapF(pprof.Symbol))
+	pprofGroup.GET("/trace", web.WrapF(pprof.Trace))
+	// Named profiles such as heap, goroutine, allocs, block and mutex.
+	pprofGroup.GET("/:profile", web.WrapF(pprof.Index))
+
+	// Garbage collector
+	router.GET("/debug/gc", gcStatsHandler)
+	router.POST("/debug/gc", forceGC)
+	router.GET("/debug/gc/config", getGCConfig)
+	router.POST("/debug/gc/config", st.postGCConfig)
+
+	// Requests made from the pod
+	router.GET("/proxy", proxyRequest(loadProxyAllowlist(st.config)))
+
+	return router
+}
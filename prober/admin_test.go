@@ -0,0 +1,212 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdminPprof(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	t.Setenv(adminTokenEnv, "s3cr3t")
+	t.Setenv(adminUsernameEnv, "admin")
+	t.Setenv(adminPasswordEnv, "pa55")
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+
+	tests := []struct {
+		name   string
+		auth   func(*http.Request)
+		status int
+	}{
+		{"anonymous", func(*http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") }, http.StatusOK},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("admin", "pa55") }, http.StatusOK},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/debug/pprof/heap", nil)
+		test.auth(req)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, w.Code)
+		}
+	}
+}
+
+func TestAdminProxyRequiresAuth(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	t.Setenv(adminTokenEnv, "s3cret")
+	t.Setenv(proxyAllowedHostsEnv, "example.com")
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+
+	req, _ := http.NewRequest("GET", "/proxy?url=http://example.com/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAdminLockout(t *testing.T) {
+	t.Setenv(adminTokenEnv, "s3cr3t")
+	t.Setenv(adminLockoutFailuresEnv, "3")
+	t.Setenv(adminLockoutDurationEnv, "1m")
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+	request := func(token string, remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/debug/pprof/heap", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	lockouts := testutil.ToFloat64(st.adminLockoutsTotal.WithLabelValues("admin"))
+	for i := 0; i < 3; i++ {
+		if w := request("nope", "10.0.0.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Errorf("failure %d: expected status %d, got %d", i, http.StatusUnauthorized, w.Code)
+		}
+	}
+	w := request("s3cr3t", "10.0.0.1:4321")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected the client to be locked out for 60s, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := testutil.ToFloat64(st.adminLockoutsTotal.WithLabelValues("admin")); got != lockouts+1 {
+		t.Errorf("expected %v lockouts, got %v", lockouts+1, got)
+	}
+	if w := request("s3cr3t", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the other clients to be let in, got %d", w.Code)
+	}
+}
+
+func TestAdminRateLimit(t *testing.T) {
+	t.Setenv(adminRateLimitEnv, "2")
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+	limited := 0
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", "/debug/gc/config", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	// The requests may straddle two windows.
+	if limited < 1 || limited > 3 {
+		t.Errorf("expected the requests over 2 per second to be refused, got %d refused", limited)
+	}
+}
+
+func TestControlAccess(t *testing.T) {
+	t.Setenv(adminTokenEnv, "s3cr3t")
+	t.Setenv(controlAuthEnv, "true")
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"GET", "/cache", "", http.StatusOK},
+		{"DELETE", "/cache", "", http.StatusUnauthorized},
+		{"DELETE", "/cache", "s3cr3t", http.StatusNotFound},
+		{"POST", "/config/logging", "", http.StatusUnauthorized},
+		{"POST", "/echo", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.status, w.Code)
+		}
+	}
+}
+
+func TestAdminAllowedCIDRs(t *testing.T) {
+	t.Setenv(adminAllowedCIDRsEnv, "10.0.0.0/8, 127.0.0.1,::1")
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	admin := st.newAdminRouter()
+	control := st.newRouter(nil, listenerConfig{})
+	tests := []struct {
+		router     *web.Engine
+		method     string
+		path       string
+		remoteAddr string
+		status     int
+	}{
+		{admin, "GET", "/debug/gc/config", "10.1.2.3:1234", http.StatusOK},
+		{admin, "GET", "/debug/gc/config", "127.0.0.1:1234", http.StatusOK},
+		{admin, "GET", "/debug/gc/config", "[::1]:1234", http.StatusOK},
+		{admin, "GET", "/debug/gc/config", "127.0.0.2:1234", http.StatusForbidden},
+		{control, "DELETE", "/cache", "192.168.1.1:1234", http.StatusForbidden},
+		{control, "DELETE", "/cache", "10.1.2.3:1234", http.StatusNotFound},
+		{control, "GET", "/cache", "192.168.1.1:1234", http.StatusOK},
+		{control, "POST", "/egress/run", "192.168.1.1:1234", http.StatusForbidden},
+		{control, "POST", "/longpoll/release", "192.168.1.1:1234", http.StatusForbidden},
+		{control, "DELETE", "/idempotency/keys", "192.168.1.1:1234", http.StatusForbidden},
+		{control, "POST", "/bandwidth/run", "192.168.1.1:1234", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		req.RemoteAddr = test.remoteAddr
+		w := httptest.NewRecorder()
+		test.router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s from %s: expected status %d, got %d", test.method, test.path, test.remoteAddr, test.status, w.Code)
+		}
+	}
+
+	if _, err := parseNetworks("10.0.0.0/8,pod-network"); err == nil {
+		t.Error("expected an invalid CIDR to fail")
+	}
+}
@@ -0,0 +1,183 @@
+package prober
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	admissionAddrEnv        = "ADMISSION_ADDR"
+	admissionCertFileEnv    = "ADMISSION_CERT_FILE"
+	admissionKeyFileEnv     = "ADMISSION_KEY_FILE"
+	admissionAllowEnv       = "ADMISSION_ALLOW"
+	admissionMessageEnv     = "ADMISSION_DENY_MESSAGE"
+	admissionLatencyEnv     = "ADMISSION_LATENCY"
+	admissionFailureRateEnv = "ADMISSION_FAILURE_RATE"
+
+	defaultAdmissionMessage = "Denied by prober"
+)
+
+// admissionMetrics count the admission reviews answered.
+type admissionMetrics struct {
+	admissionReviewsTotal *prometheus.CounterVec
+}
+
+func newAdmissionMetrics(registry prometheus.Registerer) admissionMetrics {
+	m := admissionMetrics{
+		admissionReviewsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admission_reviews_total",
+			Help: "Admission reviews answered by operation and result.",
+		}, []string{"operation", "result"}),
+	}
+	registry.MustRegister(m.admissionReviewsTotal)
+	return m
+}
+
+type admissionRequest struct {
+	UID  string `json:"uid"`
+	Kind struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Operation string `json:"operation"`
+}
+
+type admissionStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type admissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Status  *admissionStatus `json:"status,omitempty"`
+}
+
+// admissionReview is the admission.k8s.io/v1 AdmissionReview.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+// admissionBehavior is how the webhook answers, from the environment or
+// the path of the webhook, so several webhook configurations can target
+// the same prober with different behaviors.
+type admissionBehavior struct {
+	allow       bool
+	message     string
+	latency     time.Duration
+	failureRate float64
+}
+
+func loadAdmissionBehavior(config *settings) admissionBehavior {
+	return admissionBehavior{
+		allow:       config.getBool(admissionAllowEnv, true),
+		message:     config.getString(admissionMessageEnv, defaultAdmissionMessage),
+		latency:     config.getDuration(admissionLatencyEnv, 0),
+		failureRate: config.getFloat(admissionFailureRateEnv, 0),
+	}
+}
+
+// override applies the segments of a path like /deny/latency-10s/failure-50,
+// which are "allow", "deny", "message-<words>", "latency-<duration>" and
+// "failure-<percent>" since the API server only accepts DNS label like
+// segments in the path of webhook services. The dashes of the message words
+// stand for spaces. It returns the first invalid segment.
+func (b admissionBehavior) override(path string) (admissionBehavior, string) {
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		switch {
+		case segment == "":
+		case segment == "allow":
+			b.allow = true
+		case segment == "deny":
+			b.allow = false
+		case strings.HasPrefix(segment, "message-"):
+			b.message = strings.ReplaceAll(strings.TrimPrefix(segment, "message-"), "-", " ")
+		case strings.HasPrefix(segment, "latency-"):
+			latency, err := time.ParseDuration(strings.TrimPrefix(segment, "latency-"))
+			if err != nil || latency < 0 {
+				return b, segment
+			}
+			b.latency = latency
+		case strings.HasPrefix(segment, "failure-"):
+			percent, err := strconv.ParseFloat(strings.TrimPrefix(segment, "failure-"), 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return b, segment
+			}
+			b.failureRate = percent / 100
+		default:
+			return b, segment
+		}
+	}
+	return b, ""
+}
+
+// validateAdmission answers ValidatingWebhook reviews after the latency,
+// failing with a 500 at the failure rate, to test the timeoutSeconds and
+// failurePolicy handling of the API server.
+func (st *state) validateAdmission(defaults admissionBehavior) web.HandlerFunc {
+	return func(c *web.Context) {
+		behavior, invalid := defaults.override(c.Param("behavior"))
+		if invalid != "" {
+			c.JSON(http.StatusNotFound, web.H{"error": "Unknown behavior " + invalid})
+			return
+		}
+		var review admissionReview
+		if err := c.BindJSON(&review); err != nil || review.Request == nil {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid AdmissionReview"})
+			return
+		}
+		operation := review.Request.Operation
+
+		if behavior.latency > 0 && st.sleepRequest(c.Request.Context(), behavior.latency) != nil {
+			st.admissionReviewsTotal.WithLabelValues(operation, "timeout").Inc()
+			return
+		}
+		if behavior.failureRate > 0 && st.random.Float64() < behavior.failureRate {
+			st.admissionReviewsTotal.WithLabelValues(operation, "failure").Inc()
+			c.JSON(http.StatusInternalServerError, web.H{"error": "Injected fault"})
+			return
+		}
+
+		response := &admissionResponse{UID: review.Request.UID, Allowed: behavior.allow}
+		result := "allowed"
+		if !behavior.allow {
+			response.Status = &admissionStatus{Code: http.StatusForbidden, Message: behavior.message}
+			result = "denied"
+		}
+		st.admissionReviewsTotal.WithLabelValues(operation, result).Inc()
+		c.JSON(http.StatusOK, admissionReview{APIVersion: review.APIVersion, Kind: "AdmissionReview", Response: response})
+	}
+}
+
+// loadAdmissionReloader returns nil when ADMISSION_ADDR is unset. The API
+// server only calls webhooks over HTTPS, hence the mandatory certificate.
+func loadAdmissionReloader(config *settings) (*certReloader, error) {
+	if config.get(admissionAddrEnv) == "" {
+		return nil, nil
+	}
+	certFile, keyFile := config.get(admissionCertFileEnv), config.get(admissionKeyFileEnv)
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both " + admissionCertFileEnv + " and " + admissionKeyFileEnv + " must be set")
+	}
+	return newCertReloader(certFile, keyFile)
+}
+
+func (st *state) newAdmissionRouter() *web.Engine {
+	router := web.New()
+	router.Use(st.inFlight(), recovery(), st.accessLog())
+	router.POST("/validate", st.validateAdmission(loadAdmissionBehavior(st.config)))
+	router.POST("/validate/*behavior", st.validateAdmission(loadAdmissionBehavior(st.config)))
+	return router
+}
@@ -0,0 +1,92 @@
+package prober
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const testAdmissionReview = `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"705ab4f5","kind":{"group":"","version":"v1","kind":"ConfigMap"},"namespace":"default","name":"settings","operation":"DELETE"}}`
+
+func TestValidateAdmission(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.POST("/validate", st.validateAdmission(admissionBehavior{allow: true, message: defaultAdmissionMessage}))
+	router.POST("/validate/*behavior", st.validateAdmission(admissionBehavior{allow: true, message: defaultAdmissionMessage}))
+
+	tests := []struct {
+		query   string
+		status  int
+		allowed bool
+		message string
+	}{
+		{"", http.StatusOK, true, ""},
+		{"/deny", http.StatusOK, false, defaultAdmissionMessage},
+		{"/deny/message-namespace-frozen", http.StatusOK, false, "namespace frozen"},
+		{"/deny/allow", http.StatusOK, true, ""},
+		{"/failure-100", http.StatusInternalServerError, false, ""},
+		{"/latency-forever", http.StatusNotFound, false, ""},
+		{"/unknown", http.StatusNotFound, false, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/validate"+test.query, bytes.NewBufferString(testAdmissionReview))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%q: expected status %d, got %d", test.query, test.status, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var review admissionReview
+		if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if review.Response == nil || review.Response.UID != "705ab4f5" || review.Response.Allowed != test.allowed || review.APIVersion != "admission.k8s.io/v1" {
+			t.Errorf("%q: unexpected review %+v", test.query, review.Response)
+			continue
+		}
+		if test.message != "" && (review.Response.Status == nil || review.Response.Status.Message != test.message) {
+			t.Errorf("%q: expected message %q, got %+v", test.query, test.message, review.Response.Status)
+		}
+	}
+}
+
+func TestValidateAdmissionLatency(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.POST("/validate", st.validateAdmission(admissionBehavior{allow: true, latency: 100 * time.Millisecond}))
+
+	req, _ := http.NewRequest("POST", "/validate", bytes.NewBufferString(testAdmissionReview))
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected a delay of at least 100ms, got %v", elapsed)
+	}
+}
+
+func TestLoadAdmissionReloader(t *testing.T) {
+	t.Setenv(admissionAddrEnv, "")
+	if reloader, err := loadAdmissionReloader(envSettings); reloader != nil || err != nil {
+		t.Errorf("expected no reloader and no error, got %v %v", reloader, err)
+	}
+	t.Setenv(admissionAddrEnv, ":8443")
+	t.Setenv(admissionCertFileEnv, "")
+	if _, err := loadAdmissionReloader(envSettings); err == nil {
+		t.Error("expected an error without certificate")
+	}
+}
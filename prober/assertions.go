@@ -0,0 +1,222 @@
+package prober
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxAssertionBodyBytes = 1 << 20
+
+// checkAssertion is a contract on the response of an HTTP check. Exactly
+// one of status, body, jsonPath, header or latency is set; jsonPath and
+// header compare with equals or matches, or only require presence.
+type checkAssertion struct {
+	Name     string        `yaml:"name"`
+	Status   string        `yaml:"status"`
+	Body     string        `yaml:"body"`
+	JSONPath string        `yaml:"jsonPath"`
+	Header   string        `yaml:"header"`
+	Equals   *string       `yaml:"equals"`
+	Matches  string        `yaml:"matches"`
+	Latency  time.Duration `yaml:"latency"`
+
+	statusMin, statusMax int
+	pattern              *regexp.Regexp
+}
+
+type assertionResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Actual string `json:"actual,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// validate compiles the assertion and names it after what it checks when
+// no name is given.
+func (a *checkAssertion) validate() error {
+	set := 0
+	for _, field := range []bool{a.Status != "", a.Body != "", a.JSONPath != "", a.Header != "", a.Latency > 0} {
+		if field {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("assertion must set one of status, body, jsonPath, header or latency")
+	}
+
+	pattern := a.Matches
+	var name string
+	switch {
+	case a.Status != "":
+		min, max, ok := parseStatusRange(a.Status)
+		if !ok {
+			return fmt.Errorf("invalid status range %q", a.Status)
+		}
+		a.statusMin, a.statusMax = min, max
+		name = "status " + a.Status
+	case a.Body != "":
+		pattern = a.Body
+		name = "body matches " + a.Body
+	case a.JSONPath != "":
+		if !strings.HasPrefix(a.JSONPath, "$") {
+			return fmt.Errorf("jsonPath %q must start with $", a.JSONPath)
+		}
+		name = "jsonPath " + a.JSONPath + a.comparison()
+	case a.Header != "":
+		name = "header " + a.Header + a.comparison()
+	case a.Latency > 0:
+		name = "latency <= " + a.Latency.String()
+	}
+	if pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		a.pattern = compiled
+	}
+	if a.Name == "" {
+		a.Name = name
+	}
+	return nil
+}
+
+func (a *checkAssertion) comparison() string {
+	switch {
+	case a.Equals != nil:
+		return " equals " + *a.Equals
+	case a.Matches != "":
+		return " matches " + a.Matches
+	}
+	return " exists"
+}
+
+// parseStatusRange accepts a code, a class like "2xx" or a range like
+// "200-299".
+func parseStatusRange(value string) (int, int, bool) {
+	if len(value) == 3 && strings.HasSuffix(strings.ToLower(value), "xx") {
+		class, err := strconv.Atoi(value[:1])
+		return class * 100, class*100 + 99, err == nil && class >= 1 && class <= 5
+	}
+	low, high, isRange := strings.Cut(value, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, false
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+			return 0, 0, false
+		}
+	}
+	return min, max, min >= 100 && max <= 599 && min <= max
+}
+
+func (a *checkAssertion) needsBody() bool {
+	return a.Body != "" || a.JSONPath != ""
+}
+
+// evaluate checks the assertion against a received response.
+func (a *checkAssertion) evaluate(resp *http.Response, body []byte, latency time.Duration) assertionResult {
+	result := assertionResult{Name: a.Name}
+	switch {
+	case a.Status != "":
+		result.Actual = strconv.Itoa(resp.StatusCode)
+		result.Passed = resp.StatusCode >= a.statusMin && resp.StatusCode <= a.statusMax
+	case a.Body != "":
+		result.Passed = a.pattern.Match(body)
+	case a.JSONPath != "":
+		value, err := evalJSONPath(body, a.JSONPath)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Actual = value
+		result.Passed = a.compare(value)
+	case a.Header != "":
+		values, ok := resp.Header[http.CanonicalHeaderKey(a.Header)]
+		if !ok {
+			result.Error = "header not found"
+			return result
+		}
+		result.Actual = strings.Join(values, ", ")
+		result.Passed = a.compare(result.Actual)
+	case a.Latency > 0:
+		result.Actual = latency.String()
+		result.Passed = latency <= a.Latency
+	}
+	return result
+}
+
+func (a *checkAssertion) compare(value string) bool {
+	switch {
+	case a.Equals != nil:
+		return value == *a.Equals
+	case a.pattern != nil:
+		return a.pattern.MatchString(value)
+	}
+	return true
+}
+
+// evalJSONPath resolves the subset of JSONPath made of fields and indexes,
+// like "$.items[0].status", returning scalars as text and the rest as JSON.
+func evalJSONPath(body []byte, path string) (string, error) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	rest := strings.TrimPrefix(path, "$")
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			field := rest[:end]
+			rest = rest[end:]
+			object, ok := value.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("%s is not an object", field)
+			}
+			if value, ok = object[field]; !ok {
+				return "", fmt.Errorf("field %s not found", field)
+			}
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return "", fmt.Errorf("invalid jsonPath %s", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return "", fmt.Errorf("invalid index %s", rest[1:end])
+			}
+			rest = rest[end+1:]
+			array, ok := value.([]any)
+			if !ok || index < 0 || index >= len(array) {
+				return "", fmt.Errorf("index %d not found", index)
+			}
+			value = array[index]
+		default:
+			return "", fmt.Errorf("invalid jsonPath %s", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "null", nil
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data), nil
+	}
+	return fmt.Sprint(value), nil
+}
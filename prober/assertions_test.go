@@ -0,0 +1,128 @@
+package prober
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvalJSONPath(t *testing.T) {
+	body := []byte(`{"status": "ok", "replicas": 3, "items": [{"name": "a", "ready": true}], "meta": {"tags": ["x"]}, "none": null}`)
+
+	tests := []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{"$.status", "ok", false},
+		{"$.replicas", "3", false},
+		{"$.items[0].ready", "true", false},
+		{"$.meta.tags", `["x"]`, false},
+		{"$.none", "null", false},
+		{"$.items[1].name", "", true},
+		{"$.missing", "", true},
+		{"$.status.code", "", true},
+	}
+
+	for _, test := range tests {
+		value, err := evalJSONPath(body, test.path)
+		if (err != nil) != test.err {
+			t.Errorf("expected error %v for %s, got %v", test.err, test.path, err)
+		}
+		if value != test.expected {
+			t.Errorf("expected %q for %s, got %q", test.expected, test.path, value)
+		}
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max int
+		ok       bool
+	}{
+		{"2xx", 200, 299, true},
+		{"404", 404, 404, true},
+		{"200-399", 200, 399, true},
+		{"9xx", 0, 0, false},
+		{"399-200", 0, 0, false},
+		{"abc", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		min, max, ok := parseStatusRange(test.value)
+		if ok != test.ok || (ok && (min != test.min || max != test.max)) {
+			t.Errorf("expected %d-%d %v for %s, got %d-%d %v", test.min, test.max, test.ok, test.value, min, max, ok)
+		}
+	}
+}
+
+func TestHTTPCheckAssertions(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "degraded", "version": "1.2.0"}`))
+	}))
+	defer target.Close()
+
+	degraded := "degraded"
+	healthy := "ok"
+	config := checkConfig{
+		Name: "api",
+		URL:  target.URL,
+		Assertions: []checkAssertion{
+			{Status: "2xx"},
+			{Header: "Content-Type", Matches: "json"},
+			{JSONPath: "$.status", Equals: &degraded},
+			{Body: `"version": "1\.`},
+			{Latency: time.Minute},
+		},
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := runCheck(context.Background(), config)
+	if !result.Success || len(result.Assertions) != 5 {
+		t.Fatalf("expected every assertion to pass, got %+v", result)
+	}
+	if result.Assertions[2].Name != "jsonPath $.status equals degraded" || result.Assertions[2].Actual != "degraded" {
+		t.Errorf("expected the jsonPath assertion to report its value, got %+v", result.Assertions[2])
+	}
+
+	config.Assertions = append(config.Assertions, checkAssertion{Name: "healthy", JSONPath: "$.status", Equals: &healthy}, checkAssertion{Header: "ETag"})
+	if err := config.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result = runCheck(context.Background(), config)
+	if result.Success || result.Error != "assertion failed: healthy" {
+		t.Errorf("expected the healthy assertion to fail, got %+v", result)
+	}
+	if missing := result.Assertions[6]; missing.Passed || missing.Error != "header not found" {
+		t.Errorf("expected the missing header to fail, got %+v", missing)
+	}
+}
+
+func TestAssertionValidation(t *testing.T) {
+	invalid := []checkAssertion{
+		{},
+		{Status: "2xx", Body: "ok"},
+		{Status: "600"},
+		{Body: "("},
+		{JSONPath: "status"},
+		{Header: "X-Version", Matches: "["},
+	}
+	for _, assertion := range invalid {
+		if err := assertion.validate(); err == nil {
+			t.Errorf("expected an error for %+v", assertion)
+		}
+	}
+
+	config := checkConfig{Name: "db", Type: checkTypeTCP, Address: "db:5432", Assertions: []checkAssertion{{Latency: time.Second}}}
+	if err := config.validate(); err == nil {
+		t.Errorf("expected an error for assertions on a tcp check")
+	}
+}
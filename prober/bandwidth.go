@@ -0,0 +1,205 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	defaultBandwidthBytes = 10 << 20
+	maxBandwidthBytes     = 1 << 30
+	defaultBandwidthRun   = 60 * time.Second
+	bandwidthChunk        = 32 << 10
+)
+
+// bandwidthPayload is the chunk repeated by the download endpoint.
+var bandwidthPayload = make([]byte, bandwidthChunk)
+
+type bandwidthResult struct {
+	Direction     string  `json:"direction"`
+	Bytes         int64   `json:"bytes"`
+	Duration      string  `json:"duration"`
+	BitsPerSecond float64 `json:"bitsPerSecond"`
+	Error         string  `json:"error,omitempty"`
+}
+
+type bandwidthReport struct {
+	Target  string            `json:"target"`
+	Results []bandwidthResult `json:"results"`
+}
+
+func newBandwidthResult(direction string, n int64, elapsed time.Duration) bandwidthResult {
+	result := bandwidthResult{Direction: direction, Bytes: n, Duration: elapsed.String()}
+	if elapsed > 0 {
+		result.BitsPerSecond = float64(n*8) / elapsed.Seconds()
+	}
+	return result
+}
+
+func bandwidthBytes(value string) (int64, bool) {
+	if value == "" {
+		return defaultBandwidthBytes, true
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil && n > 0 && n <= maxBandwidthBytes
+}
+
+// bandwidthDownload streams bytes=N zeroes to the client.
+func bandwidthDownload(c *web.Context) {
+	n, ok := bandwidthBytes(c.Query("bytes"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid bytes value"})
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(n, 10))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+	for remaining := n; remaining > 0; {
+		chunk := min(remaining, bandwidthChunk)
+		if _, err := c.Writer.Write(bandwidthPayload[:chunk]); err != nil {
+			return
+		}
+		remaining -= chunk
+	}
+}
+
+// bandwidthUpload discards the request body and reports the throughput
+// seen by the server.
+func bandwidthUpload(c *web.Context) {
+	start := time.Now()
+	n, err := io.Copy(io.Discard, http.MaxBytesReader(c.Writer, c.Request.Body, maxBandwidthBytes))
+	result := newBandwidthResult("upload", n, time.Since(start))
+	if err != nil {
+		result.Error = err.Error()
+		c.JSON(http.StatusBadRequest, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func measureDownload(ctx context.Context, base string, n int64) bandwidthResult {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/bandwidth/download?bytes=%d", base, n), nil)
+	if err != nil {
+		return bandwidthResult{Direction: "download", Error: err.Error()}
+	}
+	start := time.Now()
+	resp, err := bandwidthClient.Do(req)
+	if err != nil {
+		return bandwidthResult{Direction: "download", Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	received, err := io.Copy(io.Discard, resp.Body)
+	result := newBandwidthResult("download", received, time.Since(start))
+	if err != nil {
+		result.Error = err.Error()
+	} else if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return result
+}
+
+func measureUpload(ctx context.Context, base string, n int64) bandwidthResult {
+	body := io.LimitReader(zeroReader{}, n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/bandwidth/upload", body)
+	if err != nil {
+		return bandwidthResult{Direction: "upload", Error: err.Error()}
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := bandwidthClient.Do(req)
+	if err != nil {
+		return bandwidthResult{Direction: "upload", Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	result := newBandwidthResult("upload", n, time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return result
+}
+
+// bandwidthClient doesn't follow redirects, so a target can't send the
+// measurement elsewhere.
+var bandwidthClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// bandwidthTarget checks the target is a bare host:port, so the request
+// can't be pointed at another path or host of the peer.
+func bandwidthTarget(value string) (string, bool) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" || strings.ContainsAny(host, "/?#@%\\ ") {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", false
+	}
+	return net.JoinHostPort(host, port), true
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// bandwidthRun answers POST /bandwidth/run?target=host:port by measuring
+// the throughput against another prober. direction is download, upload or
+// both, bytes the amount sent each way. The response is 502 when a
+// direction failed.
+func bandwidthRun(c *web.Context) {
+	if c.Query("target") == "" {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Missing target"})
+		return
+	}
+	target, ok := bandwidthTarget(c.Query("target"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid target, expected host:port"})
+		return
+	}
+	n, ok := bandwidthBytes(c.Query("bytes"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid bytes value"})
+		return
+	}
+	direction := c.DefaultQuery("direction", "both")
+	if direction != "download" && direction != "upload" && direction != "both" {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid direction value"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultBandwidthRun)
+	defer cancel()
+
+	base := "http://" + target
+	report := bandwidthReport{Target: target}
+	if direction != "upload" {
+		report.Results = append(report.Results, measureDownload(ctx, base, n))
+	}
+	if direction != "download" {
+		report.Results = append(report.Results, measureUpload(ctx, base, n))
+	}
+
+	for _, result := range report.Results {
+		if result.Error != "" {
+			c.JSON(http.StatusBadGateway, report)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,95 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestBandwidthRun(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/bandwidth/download", bandwidthDownload)
+	router.POST("/bandwidth/upload", bandwidthUpload)
+	router.POST("/bandwidth/run", bandwidthRun)
+
+	peer := httptest.NewServer(router)
+	defer peer.Close()
+	target := strings.TrimPrefix(peer.URL, "http://")
+
+	req, _ := http.NewRequest("POST", "/bandwidth/run?bytes=1048576&target="+target, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var report bandwidthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("expected download and upload results, got %+v", report.Results)
+	}
+	for _, result := range report.Results {
+		if result.Bytes != 1<<20 || result.BitsPerSecond <= 0 || result.Error != "" {
+			t.Errorf("expected 1MiB measured %s, got %+v", result.Direction, result)
+		}
+	}
+}
+
+func TestBandwidthRunErrors(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.POST("/bandwidth/run", bandwidthRun)
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", http.StatusBadRequest},
+		{"target=localhost:1&bytes=0", http.StatusBadRequest},
+		{"target=localhost:1&direction=sideways", http.StatusBadRequest},
+		{"target=localhost:1/admin/reset?", http.StatusBadRequest},
+		{"target=user@localhost:1", http.StatusBadRequest},
+		{"target=localhost:http", http.StatusBadRequest},
+		{"target=localhost", http.StatusBadRequest},
+		{"target=127.0.0.1:1&direction=download", http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/bandwidth/run?"+test.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expected {
+			t.Errorf("expected status %d for %q, got %d", test.expected, test.query, w.Code)
+		}
+	}
+}
+
+func TestBandwidthRunRedirect(t *testing.T) {
+	elsewhere := false
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/elsewhere" {
+			elsewhere = true
+		}
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer peer.Close()
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.POST("/bandwidth/run", bandwidthRun)
+	req, _ := http.NewRequest("POST", "/bandwidth/run?direction=download&target="+strings.TrimPrefix(peer.URL, "http://"), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway || elsewhere {
+		t.Errorf("expected the redirection not to be followed, got %d %s", w.Code, w.Body.String())
+	}
+}
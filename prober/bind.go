@@ -0,0 +1,87 @@
+package prober
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+const (
+	bindAddressEnv = "BIND_ADDRESS"
+	ipFamilyEnv    = "IP_FAMILY"
+
+	ipFamilyDual = "dual"
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+)
+
+// bindConfig decides where TCP listeners bind. Listener addresses carrying
+// only a port (":8080") are bound to address using the configured family.
+type bindConfig struct {
+	address string
+	family  string
+}
+
+func loadBindConfig(config *settings) (bindConfig, error) {
+	bind := bindConfig{
+		address: config.getString(bindAddressEnv, ""),
+		family:  config.getString(ipFamilyEnv, ipFamilyDual),
+	}
+	if _, err := bind.network(); err != nil {
+		return bind, err
+	}
+	if bind.address != "" && net.ParseIP(bind.address) == nil {
+		return bind, fmt.Errorf("invalid %s %q", bindAddressEnv, bind.address)
+	}
+	return bind, nil
+}
+
+func (b bindConfig) network() (string, error) {
+	switch b.family {
+	case ipFamilyDual:
+		return "tcp", nil
+	case ipFamilyIPv4:
+		return "tcp4", nil
+	case ipFamilyIPv6:
+		return "tcp6", nil
+	}
+	return "", fmt.Errorf("invalid %s %q, expected %s, %s or %s", ipFamilyEnv, b.family, ipFamilyDual, ipFamilyIPv4, ipFamilyIPv6)
+}
+
+func (b bindConfig) resolve(addr string) (string, string, error) {
+	network, err := b.network()
+	if err != nil {
+		return "", "", err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if host == "" {
+		host = b.address
+	}
+	return network, net.JoinHostPort(host, port), nil
+}
+
+// connFamily reports the IP family the request's connection arrived over.
+// IPv4 clients reaching a dual-stack socket through mapped addresses count
+// as ipv4.
+func connFamily(req *http.Request) string {
+	if local, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return "unix"
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return "unknown"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.To4() != nil:
+		return ipFamilyIPv4
+	default:
+		return ipFamilyIPv6
+	}
+}
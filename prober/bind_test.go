@@ -0,0 +1,59 @@
+package prober
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBindConfigResolve(t *testing.T) {
+	tests := []struct {
+		config  bindConfig
+		addr    string
+		network string
+		bind    string
+	}{
+		{bindConfig{family: ipFamilyDual}, ":8080", "tcp", ":8080"},
+		{bindConfig{address: "::", family: ipFamilyIPv6}, ":8080", "tcp6", "[::]:8080"},
+		{bindConfig{address: "0.0.0.0", family: ipFamilyIPv4}, ":8080", "tcp4", "0.0.0.0:8080"},
+		{bindConfig{address: "::", family: ipFamilyDual}, "127.0.0.1:8081", "tcp", "127.0.0.1:8081"},
+	}
+
+	for _, test := range tests {
+		network, bind, err := test.config.resolve(test.addr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if network != test.network || bind != test.bind {
+			t.Errorf("%+v %s: expected %s %s, got %s %s", test.config, test.addr, test.network, test.bind, network, bind)
+		}
+	}
+}
+
+func TestLoadBindConfigInvalid(t *testing.T) {
+	t.Setenv(ipFamilyEnv, "ipv5")
+	if _, err := loadBindConfig(envSettings); err == nil {
+		t.Error("expected error for invalid family")
+	}
+
+	t.Setenv(ipFamilyEnv, ipFamilyIPv6)
+	t.Setenv(bindAddressEnv, "localhost")
+	if _, err := loadBindConfig(envSettings); err == nil {
+		t.Error("expected error for non IP bind address")
+	}
+}
+
+func TestConnFamily(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:1234":   ipFamilyIPv4,
+		"[2001:db8::1]:80": ipFamilyIPv6,
+		"@":                "unknown",
+	}
+
+	for remoteAddr, family := range tests {
+		req, _ := http.NewRequest("GET", "/echo", nil)
+		req.RemoteAddr = remoteAddr
+		if got := connFamily(req); got != family {
+			t.Errorf("%s: expected %s, got %s", remoteAddr, family, got)
+		}
+	}
+}
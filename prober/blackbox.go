@@ -0,0 +1,187 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	probeModulesConfigEnv = "PROBE_MODULES_CONFIG"
+
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// blackboxModules are the blackbox_exporter modules prober knows without
+// configuration.
+var blackboxModules = map[string]checkConfig{
+	"http_2xx":      {Type: checkTypeHTTP},
+	"http_post_2xx": {Type: checkTypeHTTP, Method: http.MethodPost},
+	"tcp_connect":   {Type: checkTypeTCP},
+	"icmp":          {Type: checkTypeICMP},
+	"grpc":          {Type: checkTypeGRPC, TLS: true},
+	"grpc_plain":    {Type: checkTypeGRPC},
+}
+
+// blackboxPhases maps the phases of HTTP checks to the ones of
+// blackbox_exporter.
+var blackboxPhases = map[string]string{
+	"dns":      "resolve",
+	"connect":  "connect",
+	"tls":      "tls",
+	"ttfb":     "processing",
+	"transfer": "transfer",
+}
+
+type modulesFile struct {
+	Modules map[string]checkConfig `yaml:"modules"`
+}
+
+// loadProbeModules adds the modules of PROBE_MODULES_CONFIG, written like
+// checks without their target, to the built-in ones.
+func loadProbeModules(path string) (map[string]checkConfig, error) {
+	modules := make(map[string]checkConfig, len(blackboxModules))
+	for name, module := range blackboxModules {
+		modules[name] = module
+	}
+	if path == "" {
+		return modules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file modulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for name, module := range file.Modules {
+		check := module.withTarget(name, "localhost:80")
+		if err := check.validate(); err != nil {
+			return nil, fmt.Errorf("module %q: %w", name, err)
+		}
+		modules[name] = module
+	}
+	return modules, nil
+}
+
+// withTarget fills the field the module type reads its target from.
+func (module checkConfig) withTarget(name string, target string) checkConfig {
+	module.Name = name
+	switch module.Type {
+	case "", checkTypeHTTP:
+		if !strings.Contains(target, "://") {
+			target = "http://" + target
+		}
+		module.URL = target
+	case checkTypeTCP, checkTypeGRPC:
+		module.Address = target
+	case checkTypeDNS, checkTypeICMP:
+		module.Host = target
+	case checkTypeKafka:
+		module.Brokers = []string{target}
+	default:
+		module.DSN = target
+	}
+	return module
+}
+
+// probeTimeout follows the scrape timeout Prometheus sends, like
+// blackbox_exporter, when it is shorter than the module timeout.
+func probeTimeout(header string, timeout time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return timeout
+	}
+	scrape := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset
+	if scrape > 0 && scrape < timeout {
+		return scrape
+	}
+	return timeout
+}
+
+// blackboxProbe answers GET /probe?target=...&module=... with the metric
+// families of blackbox_exporter, so its scrape configs work unchanged. The
+// probe runs on every scrape and its outcome is only in the metrics.
+func blackboxProbe(modules map[string]checkConfig) web.HandlerFunc {
+	return func(c *web.Context) {
+		target := c.Query("target")
+		if target == "" {
+			c.String(http.StatusBadRequest, "Target parameter is missing")
+			return
+		}
+		moduleName := c.DefaultQuery("module", "http_2xx")
+		module, ok := modules[moduleName]
+		if !ok {
+			c.String(http.StatusBadRequest, "Unknown module %q", moduleName)
+			return
+		}
+		config := module.withTarget(target, target)
+		if err := config.validate(); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		config.Timeout = probeTimeout(c.GetHeader(scrapeTimeoutHeader), config.Timeout)
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.Timeout)
+		defer cancel()
+		result := runCheck(ctx, config)
+
+		registry := prometheus.NewRegistry()
+		success := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Displays whether or not the probe was a success",
+		})
+		duration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Returns how long the probe took to complete in seconds",
+		})
+		registry.MustRegister(success, duration)
+		if result.Success {
+			success.Set(1)
+		}
+		duration.Set(time.Since(result.Time).Seconds())
+
+		switch config.Type {
+		case checkTypeHTTP:
+			status := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "probe_http_status_code",
+				Help: "Response HTTP status code",
+			})
+			phases := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_http_duration_seconds",
+				Help: "Duration of http request by phase, summed over all redirects",
+			}, []string{"phase"})
+			registry.MustRegister(status, phases)
+			status.Set(float64(result.StatusCode))
+			for _, phase := range blackboxPhases {
+				phases.WithLabelValues(phase)
+			}
+			if result.Phases != nil {
+				for phase, elapsed := range result.Phases.durations {
+					phases.WithLabelValues(blackboxPhases[phase]).Set(elapsed.Seconds())
+				}
+			}
+		case checkTypeGRPC:
+			status := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "probe_grpc_status_code",
+				Help: "Response gRPC status code",
+			})
+			registry.MustRegister(status)
+			status.Set(float64(result.grpcCode))
+		}
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+	}
+}
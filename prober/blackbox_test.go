@@ -0,0 +1,87 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestBlackboxProbe(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+
+	modules, err := loadProbeModules(writeChecksConfig(t, `
+modules:
+  http_500:
+    type: http
+    expectedStatus: [500]
+    timeout: 2s
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/probe", blackboxProbe(modules))
+
+	tests := []struct {
+		query    string
+		status   int
+		expected []string
+	}{
+		{"target=" + target.URL, http.StatusOK, []string{"probe_success 1", "probe_http_status_code 200", `probe_http_duration_seconds{phase="processing"}`}},
+		{"module=http_2xx&target=" + strings.TrimPrefix(target.URL, "http://") + "/down", http.StatusOK, []string{"probe_success 0", "probe_http_status_code 500"}},
+		{"module=http_500&target=" + target.URL + "/down", http.StatusOK, []string{"probe_success 1"}},
+		{"module=tcp_connect&target=" + target.Listener.Addr().String(), http.StatusOK, []string{"probe_success 1", "probe_duration_seconds"}},
+		{"module=tcp_connect", http.StatusBadRequest, nil},
+		{"module=smtp_starttls&target=mail:25", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/probe?"+test.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, w.Code)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(w.Body.String(), expected) {
+				t.Errorf("%s: expected %q in\n%s", test.query, expected, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestLoadProbeModulesInvalid(t *testing.T) {
+	if _, err := loadProbeModules(writeChecksConfig(t, "modules:\n  broken:\n    type: carrier-pigeon\n")); err == nil {
+		t.Error("expected an error for an unknown module type")
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"3", 2500 * time.Millisecond},
+		{"10", 5 * time.Second},
+		{"0.2", 5 * time.Second},
+		{"soon", 5 * time.Second},
+	}
+	for _, test := range tests {
+		if got := probeTimeout(test.header, 5*time.Second); got != test.expected {
+			t.Errorf("expected %v for %q, got %v", test.expected, test.header, got)
+		}
+	}
+}
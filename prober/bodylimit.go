@@ -0,0 +1,134 @@
+package prober
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	maxBodySizeEnv       = "MAX_BODY_SIZE"
+	maxBodySizeRoutesEnv = "MAX_BODY_SIZE_ROUTES"
+)
+
+// bodyLimitMetrics count the request bodies refused for their size.
+type bodyLimitMetrics struct {
+	requestBodyTooLargeTotal *prometheus.CounterVec
+}
+
+func newBodyLimitMetrics(registry prometheus.Registerer) bodyLimitMetrics {
+	m := bodyLimitMetrics{
+		requestBodyTooLargeTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "request_body_too_large_total",
+			Help: "Requests answered with a 413 because their body exceeded the limit, by route.",
+		}, []string{"route"}),
+	}
+	registry.MustRegister(m.requestBodyTooLargeTotal)
+	return m
+}
+
+// bodyLimits bounds the size of the request bodies, the limits of the
+// route templates taking precedence over the default, zero meaning no
+// limit.
+type bodyLimits struct {
+	fallback int64
+	routes   map[string]int64
+}
+
+// loadBodyLimits reads MAX_BODY_SIZE and MAX_BODY_SIZE_ROUTES, a list of
+// route templates and sizes like "/mocks=64Ki,/bandwidth/upload=0", nil
+// when neither is set.
+func loadBodyLimits(config *settings) (*bodyLimits, error) {
+	fallback, routes := config.getString(maxBodySizeEnv, ""), config.getString(maxBodySizeRoutesEnv, "")
+	if fallback == "" && routes == "" {
+		return nil, nil
+	}
+	limits := &bodyLimits{routes: make(map[string]int64)}
+	if fallback != "" {
+		size, err := parseMemoryLoad(fallback, resources{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", maxBodySizeEnv, fallback)
+		}
+		limits.fallback = size
+	}
+	for _, entry := range splitList(routes) {
+		route, value, ok := strings.Cut(entry, "=")
+		size, err := parseMemoryLoad(value, resources{})
+		if !ok || !strings.HasPrefix(route, "/") || err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q, expected route=size", maxBodySizeRoutesEnv, entry)
+		}
+		limits.routes[route] = size
+	}
+	return limits, nil
+}
+
+// validate checks the routes of the configuration exist in the router.
+func (l *bodyLimits) validate(routes web.RoutesInfo) error {
+	if l == nil {
+		return nil
+	}
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		known[route.Path] = true
+	}
+	for route := range l.routes {
+		if !known[route] {
+			return fmt.Errorf("unknown route %s in %s", route, maxBodySizeRoutesEnv)
+		}
+	}
+	return nil
+}
+
+// lookup returns the limit of a route template and the name it is counted
+// under.
+func (l *bodyLimits) lookup(route string) (int64, string) {
+	if limit, ok := l.routes[route]; ok {
+		return limit, route
+	}
+	return l.fallback, "default"
+}
+
+func (m bodyLimitMetrics) tooLarge(c *web.Context, route string, limit int64) {
+	m.requestBodyTooLargeTotal.WithLabelValues(route).Inc()
+	// The rest of the body is not read, so the connection can't be reused.
+	c.Header("Connection", "close")
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, web.H{
+		"error":  "Request body too large",
+		"detail": fmt.Sprintf("limit of %d bytes", limit),
+	})
+}
+
+// middleware answers 413 to the requests announcing a larger body before
+// reading it. The chunked bodies, of unknown length, are read up to the
+// limit first, so the answer doesn't depend on the handler reading them.
+func (l *bodyLimits) middleware(metrics bodyLimitMetrics) web.HandlerFunc {
+	return func(c *web.Context) {
+		limit, route := l.lookup(c.FullPath())
+		if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			metrics.tooLarge(c, route, limit)
+			return
+		}
+		if c.Request.ContentLength < 0 {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, web.H{"error": "Invalid body", "detail": err.Error()})
+				return
+			}
+			if int64(len(data)) > limit {
+				metrics.tooLarge(c, route, limit)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		c.Next()
+	}
+}
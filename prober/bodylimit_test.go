@@ -0,0 +1,81 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBodyLimits(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	t.Setenv(maxBodySizeEnv, "1Ki")
+	t.Setenv(maxBodySizeRoutesEnv, "/bandwidth/upload=0, /echo=16")
+	limits, err := loadBodyLimits(st.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.requestBodyLimits = limits
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	if err := limits.validate(router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := testutil.ToFloat64(st.requestBodyTooLargeTotal.WithLabelValues("/echo"))
+	tests := []struct {
+		path    string
+		size    int
+		chunked bool
+		status  int
+	}{
+		{"/echo", 16, false, http.StatusOK},
+		{"/echo", 17, false, http.StatusRequestEntityTooLarge},
+		{"/echo", 16, true, http.StatusOK},
+		{"/echo", 17, true, http.StatusRequestEntityTooLarge},
+		{"/bandwidth/upload", 4096, false, http.StatusOK},
+		{"/bandwidth/upload", 4096, true, http.StatusOK},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", test.path, strings.NewReader(strings.Repeat("x", test.size)))
+		if test.chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %d bytes, chunked %t: expected status %d, got %d %s", test.path, test.size, test.chunked, test.status, w.Code, w.Body.String())
+		}
+	}
+	if got := testutil.ToFloat64(st.requestBodyTooLargeTotal.WithLabelValues("/echo")); got != rejected+2 {
+		t.Errorf("expected %v oversized requests, got %v", rejected+2, got)
+	}
+
+	if limit, route := limits.lookup("/mocks"); limit != 1024 || route != "default" {
+		t.Errorf("expected the default limit of 1024, got %d from %s", limit, route)
+	}
+}
+
+func TestLoadBodyLimitsInvalid(t *testing.T) {
+	for env, value := range map[string]string{
+		maxBodySizeEnv:       "lots",
+		maxBodySizeRoutesEnv: "/echo",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := loadBodyLimits(envSettings); err == nil {
+				t.Errorf("%s=%s: expected an error", env, value)
+			}
+		})
+	}
+
+	limits := &bodyLimits{routes: map[string]int64{"/nope": 1}}
+	if err := limits.validate(web.RoutesInfo{{Path: "/echo"}}); err == nil {
+		t.Error("expected an unknown route to be refused")
+	}
+}
@@ -0,0 +1,261 @@
+package prober
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	responseCacheSizeEnv = "RESPONSE_CACHE_SIZE"
+	responseCacheTTLEnv  = "RESPONSE_CACHE_TTL"
+
+	defaultResponseCacheTTL = time.Minute
+
+	cacheHeader = "X-Cache"
+)
+
+// cacheMetrics account the lookups, evictions and size of the response cache.
+type cacheMetrics struct {
+	responseCacheRequestsTotal  *prometheus.CounterVec
+	responseCacheEvictionsTotal prometheus.Counter
+	responseCacheBytes          prometheus.Gauge
+}
+
+func newCacheMetrics(registry prometheus.Registerer) cacheMetrics {
+	m := cacheMetrics{
+		responseCacheRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "response_cache_requests_total",
+			Help: "Requests to cached routes by result, hit or miss.",
+		}, []string{"result"}),
+
+		responseCacheEvictionsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "response_cache_evictions_total",
+			Help: "Responses removed from the cache to make room for new ones.",
+		}),
+
+		responseCacheBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "response_cache_bytes",
+			Help: "Size of the response bodies held by the cache.",
+		}),
+	}
+	registry.MustRegister(m.responseCacheRequestsTotal, m.responseCacheEvictionsTotal, m.responseCacheBytes)
+	return m
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache keeps the generated payloads for a TTL, so high request
+// rates measure the network and not their generation. Past its size, the
+// least recently used responses are evicted.
+type responseCache struct {
+	maxBytes int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	bytes   int
+	hits    int64
+	misses  int64
+	entries map[string]*list.Element
+	lru     *list.List
+
+	cacheMetrics
+}
+
+func newResponseCache(maxBytes int, ttl time.Duration, metrics cacheMetrics) *responseCache {
+	return &responseCache{maxBytes: maxBytes, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New(), cacheMetrics: metrics}
+}
+
+// loadResponseCache reads RESPONSE_CACHE_SIZE, a quantity like "64Mi", the
+// cache being disabled without it.
+func loadResponseCache(config *settings, metrics cacheMetrics) (*responseCache, error) {
+	value := config.getString(responseCacheSizeEnv, "")
+	if value == "" {
+		return nil, nil
+	}
+	size, err := parseMemoryLoad(value, resources{})
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid %s %q", responseCacheSizeEnv, value)
+	}
+	ttl := config.getDuration(responseCacheTTLEnv, defaultResponseCacheTTL)
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid %s %s", responseCacheTTLEnv, ttl)
+	}
+	return newResponseCache(int(size), ttl, metrics), nil
+}
+
+func (rc *responseCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	element, ok := rc.entries[key]
+	if ok && now.After(element.Value.(*cachedResponse).expires) {
+		rc.remove(element)
+		ok = false
+	}
+	if !ok {
+		rc.misses++
+		rc.responseCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	rc.hits++
+	rc.responseCacheRequestsTotal.WithLabelValues("hit").Inc()
+	rc.lru.MoveToFront(element)
+	return element.Value.(*cachedResponse), true
+}
+
+func (rc *responseCache) set(response *cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if element, ok := rc.entries[response.key]; ok {
+		rc.remove(element)
+	}
+	for rc.bytes+len(response.body) > rc.maxBytes && rc.lru.Len() > 0 {
+		rc.remove(rc.lru.Back())
+		rc.responseCacheEvictionsTotal.Inc()
+	}
+	rc.entries[response.key] = rc.lru.PushFront(response)
+	rc.bytes += len(response.body)
+	rc.responseCacheBytes.Set(float64(rc.bytes))
+}
+
+// remove must be called with the lock held.
+func (rc *responseCache) remove(element *list.Element) {
+	response := rc.lru.Remove(element).(*cachedResponse)
+	delete(rc.entries, response.key)
+	rc.bytes -= len(response.body)
+	rc.responseCacheBytes.Set(float64(rc.bytes))
+}
+
+// purge empties the cache and returns the responses it held.
+func (rc *responseCache) purge() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	purged := rc.lru.Len()
+	rc.entries, rc.bytes = make(map[string]*list.Element), 0
+	rc.lru.Init()
+	rc.responseCacheBytes.Set(0)
+	return purged
+}
+
+type responseCacheStatus struct {
+	Enabled  bool   `json:"enabled"`
+	MaxBytes int    `json:"maxBytes,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+	Entries  int    `json:"entries"`
+	Bytes    int    `json:"bytes"`
+	Hits     int64  `json:"hits"`
+	Misses   int64  `json:"misses"`
+}
+
+func (rc *responseCache) status() responseCacheStatus {
+	if rc == nil {
+		return responseCacheStatus{}
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return responseCacheStatus{
+		Enabled:  true,
+		MaxBytes: rc.maxBytes,
+		TTL:      rc.ttl.String(),
+		Entries:  rc.lru.Len(),
+		Bytes:    rc.bytes,
+		Hits:     rc.hits,
+		Misses:   rc.misses,
+	}
+}
+
+// cacheWriter keeps a copy of the body while it fits in the cache.
+type cacheWriter struct {
+	web.ResponseWriter
+	body     []byte
+	tooLarge bool
+	maxBytes int
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(data string) (int, error) {
+	w.keep([]byte(data))
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w *cacheWriter) keep(data []byte) {
+	if w.tooLarge || len(w.body)+len(data) > w.maxBytes {
+		w.tooLarge, w.body = true, nil
+		return
+	}
+	w.body = append(w.body, data...)
+}
+
+// cached answers GET requests from the cache, keyed by path and query, and
+// stores the 200 answers with the headers the handler set, X-Cache telling
+// HIT or MISS.
+func (st *state) cached() web.HandlerFunc {
+	return func(c *web.Context) {
+		rc := st.payloadCache
+		if rc == nil || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+		key := c.Request.URL.RequestURI()
+		now := time.Now()
+		if response, ok := rc.get(key, now); ok {
+			header := c.Writer.Header()
+			for name, values := range response.header {
+				header[name] = values
+			}
+			header.Set(cacheHeader, "HIT")
+			c.Data(response.status, header.Get("Content-Type"), response.body)
+			c.Abort()
+			return
+		}
+
+		before := make(map[string]bool, len(c.Writer.Header()))
+		for name := range c.Writer.Header() {
+			before[name] = true
+		}
+		c.Header(cacheHeader, "MISS")
+		writer := &cacheWriter{ResponseWriter: c.Writer, maxBytes: rc.maxBytes}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.Status() != http.StatusOK || writer.tooLarge {
+			return
+		}
+		header := make(http.Header)
+		for name, values := range writer.Header() {
+			if !before[name] && name != cacheHeader {
+				header[name] = values
+			}
+		}
+		rc.set(&cachedResponse{key: key, status: writer.Status(), header: header, body: writer.body, expires: now.Add(rc.ttl)})
+	}
+}
+
+func (st *state) cacheHandler(c *web.Context) {
+	c.JSON(http.StatusOK, st.payloadCache.status())
+}
+
+// purgeCache answers DELETE /cache by emptying the response cache.
+func (st *state) purgeCache(c *web.Context) {
+	if st.payloadCache == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Response cache disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, web.H{"purged": st.payloadCache.purge()})
+}
@@ -0,0 +1,96 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestResponseCache(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	st.payloadCache = newResponseCache(1<<20, time.Minute, st.cacheMetrics)
+	router := st.newRouter(nil, listenerConfig{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	miss := get("/compress?bytes=4096")
+	hit := get("/compress?bytes=4096")
+	if miss.Header().Get(cacheHeader) != "MISS" || hit.Header().Get(cacheHeader) != "HIT" {
+		t.Errorf("expected a miss then a hit, got %q and %q", miss.Header().Get(cacheHeader), hit.Header().Get(cacheHeader))
+	}
+	if hit.Body.String() != miss.Body.String() || hit.Header().Get("Content-Type") != "application/gzip" {
+		t.Errorf("expected the cached answer, got %q", hit.Header())
+	}
+	if hit.Header().Get(requestIDHeader) == miss.Header().Get(requestIDHeader) {
+		t.Errorf("expected a request ID of its own, got %q", hit.Header().Get(requestIDHeader))
+	}
+
+	// Answers larger than the cache and errors are not kept.
+	get("/compress?bytes=4194304&level=0")
+	get("/compress?level=10")
+	status := st.payloadCache.status()
+	if status.Entries != 1 || status.Hits != 1 || status.Misses != 3 {
+		t.Errorf("expected 1 entry, 1 hit and 3 misses, got %+v", status)
+	}
+
+	req, _ := http.NewRequest("DELETE", "/cache", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"purged":1}` {
+		t.Errorf("expected 1 response purged, got %d %s", w.Code, w.Body.String())
+	}
+	if got := get("/compress?bytes=4096").Header().Get(cacheHeader); got != "MISS" {
+		t.Errorf("expected a miss after the purge, got %q", got)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	st := newState(Options{})
+	cache := newResponseCache(10, time.Minute, st.cacheMetrics)
+	now := time.Now()
+	cache.set(&cachedResponse{key: "a", body: []byte("aaaa"), expires: now.Add(time.Minute)})
+	cache.set(&cachedResponse{key: "b", body: []byte("bbbb"), expires: now.Add(time.Minute)})
+	cache.get("a", now)
+	cache.set(&cachedResponse{key: "c", body: []byte("cccc"), expires: now.Add(time.Minute)})
+
+	if _, ok := cache.get("b", now); ok {
+		t.Error("expected the least recently used response to be evicted")
+	}
+	if _, ok := cache.get("a", now); !ok {
+		t.Error("expected the recently used response to be kept")
+	}
+	if _, ok := cache.get("c", now.Add(2*time.Minute)); ok {
+		t.Error("expected the response to expire after the TTL")
+	}
+	if status := cache.status(); status.Bytes != 4 || status.Entries != 1 {
+		t.Errorf("expected 1 entry of 4 bytes, got %+v", status)
+	}
+}
+
+func TestLoadResponseCache(t *testing.T) {
+	t.Setenv(responseCacheSizeEnv, "")
+	st := newState(Options{})
+	if cache, err := loadResponseCache(st.config, st.cacheMetrics); cache != nil || err != nil {
+		t.Errorf("expected the cache to be disabled, got %v %v", cache, err)
+	}
+	t.Setenv(responseCacheSizeEnv, "64Mi")
+	t.Setenv(responseCacheTTLEnv, "5m")
+	cache, err := loadResponseCache(st.config, st.cacheMetrics)
+	if err != nil || cache.maxBytes != 64<<20 || cache.ttl != 5*time.Minute {
+		t.Errorf("expected a 64Mi cache for 5m, got %+v %v", cache, err)
+	}
+	t.Setenv(responseCacheSizeEnv, "lots")
+	if _, err := loadResponseCache(st.config, st.cacheMetrics); err == nil {
+		t.Error("expected an invalid size to fail")
+	}
+}
@@ -0,0 +1,365 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	chaosConfigEnv = "CHAOS_CONFIG"
+
+	chaosLatency = "latency"
+	chaosErrors  = "errors"
+	chaosFlap    = "flap"
+
+	defaultChaosInterval = time.Minute
+	defaultChaosPeriod   = time.Hour
+	chaosHistorySize     = 20
+)
+
+// chaosMetrics count the actions of the chaos monkey.
+type chaosMetrics struct {
+	chaosActionsTotal *prometheus.CounterVec
+}
+
+func newChaosMetrics(registry prometheus.Registerer) chaosMetrics {
+	m := chaosMetrics{
+		chaosActionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chaos_actions_total",
+			Help: "Actions taken by the background chaos by type.",
+		}, []string{"action"}),
+	}
+	registry.MustRegister(m.chaosActionsTotal)
+	return m
+}
+
+// chaosAction is a fault the background chaos may pick: a latency spike,
+// an error burst or a probe flapping, each lasting its duration.
+type chaosAction struct {
+	Type        string        `yaml:"type"`
+	Weight      float64       `yaml:"weight"`
+	Duration    time.Duration `yaml:"duration"`
+	Latency     time.Duration `yaml:"latency"`
+	ErrorRate   float64       `yaml:"errorRate"`
+	ErrorStatus int           `yaml:"errorStatus"`
+	Probe       string        `yaml:"probe"`
+}
+
+// chaosWindow allows chaos between start and end, "15:04" local times
+// possibly spanning midnight, on the given days or every day.
+type chaosWindow struct {
+	Days  []string `yaml:"days"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+
+	start, end time.Duration
+	days       map[time.Weekday]bool
+}
+
+type chaosBudget struct {
+	// MaxActions caps the actions taken in any period, 0 for no limit.
+	MaxActions int           `yaml:"maxActions"`
+	Period     time.Duration `yaml:"period"`
+}
+
+// chaosConfig rolls the dice every interval, acting with the probability
+// while in a window and within budget.
+type chaosConfig struct {
+	Interval    time.Duration `yaml:"interval"`
+	Probability float64       `yaml:"probability"`
+	Windows     []chaosWindow `yaml:"windows"`
+	Budget      chaosBudget   `yaml:"budget"`
+	Actions     []chaosAction `yaml:"actions"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *chaosWindow) validate() error {
+	var err error
+	if w.start, err = parseTimeOfDay(w.Start); err != nil {
+		return err
+	}
+	if w.end, err = parseTimeOfDay(w.End); err != nil {
+		return err
+	}
+	w.days = make(map[time.Weekday]bool, len(w.Days))
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		w.days[weekday] = true
+	}
+	return nil
+}
+
+// contains tells whether t is in the window, a window spanning midnight
+// belonging to the day it starts on.
+func (w chaosWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset, day := t.Sub(midnight), t.Weekday()
+	if w.end <= w.start {
+		if offset < w.end {
+			day, offset = (day+6)%7, offset+24*time.Hour
+		}
+		if offset < w.start || offset >= w.end+24*time.Hour {
+			return false
+		}
+	} else if offset < w.start || offset >= w.end {
+		return false
+	}
+	return len(w.days) == 0 || w.days[day]
+}
+
+func loadChaosConfig(path string) (chaosConfig, error) {
+	var config chaosConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultChaosInterval
+	}
+	if config.Budget.Period == 0 {
+		config.Budget.Period = defaultChaosPeriod
+	}
+	if config.Interval < 0 || config.Budget.Period < 0 || config.Budget.MaxActions < 0 {
+		return config, errors.New("interval, period and maxActions must not be negative")
+	}
+	if config.Probability <= 0 || config.Probability > 1 {
+		return config, errors.New("probability must be above 0 and at most 1")
+	}
+	for i := range config.Windows {
+		if err := config.Windows[i].validate(); err != nil {
+			return config, err
+		}
+	}
+	if len(config.Actions) == 0 {
+		return config, errors.New("chaos without actions")
+	}
+	for i := range config.Actions {
+		action := &config.Actions[i]
+		if action.Weight == 0 {
+			action.Weight = 1
+		}
+		if action.Weight < 0 || action.Duration <= 0 {
+			return config, fmt.Errorf("action %d needs a positive weight and duration", i)
+		}
+		switch action.Type {
+		case chaosLatency:
+			if action.Latency <= 0 {
+				return config, fmt.Errorf("action %d needs a latency", i)
+			}
+		case chaosErrors:
+			if err := (faultProfile{ErrorRate: action.ErrorRate, ErrorStatus: action.ErrorStatus}).validate(); err != nil || action.ErrorRate == 0 {
+				return config, fmt.Errorf("action %d needs an error rate between 0 and 1", i)
+			}
+		case chaosFlap:
+			if action.Probe != "startup" && action.Probe != "readiness" && action.Probe != "liveness" {
+				return config, fmt.Errorf("action %d flaps unknown probe %q", i, action.Probe)
+			}
+		default:
+			return config, fmt.Errorf("action %d has unknown type %q", i, action.Type)
+		}
+	}
+	return config, nil
+}
+
+type chaosEvent struct {
+	Type     string    `json:"type"`
+	Detail   string    `json:"detail"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+}
+
+// chaosMonkey applies the background chaos, one action at a time. It skips
+// its turns while a scenario runs, not to fight over the same settings.
+type chaosMonkey struct {
+	st     *state
+	config chaosConfig
+
+	mu      sync.Mutex
+	active  *chaosEvent
+	history []chaosEvent
+	taken   []time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// loadChaosMonkey returns nil when CHAOS_CONFIG is unset.
+func (st *state) loadChaosMonkey() (*chaosMonkey, error) {
+	path := st.config.get(chaosConfigEnv)
+	if path == "" {
+		return nil, nil
+	}
+	config, err := loadChaosConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return newChaosMonkey(st, config), nil
+}
+
+func newChaosMonkey(st *state, config chaosConfig) *chaosMonkey {
+	return &chaosMonkey{st: st, config: config, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (m *chaosMonkey) run() {
+	defer close(m.done)
+	for {
+		if !m.st.proberClock.waitUntil(m.st.proberClock.Now().Add(m.config.Interval), m.stop) {
+			return
+		}
+		now := m.st.proberClock.Now()
+		if !m.allowed(now) || m.st.random.Float64() >= m.config.Probability {
+			continue
+		}
+		if status, ok := m.st.scenarios.status(now); ok && status.State == scenarioRunning {
+			continue
+		}
+		m.act(m.pick(), now)
+	}
+}
+
+// allowed tells whether an action may start now, in a window and within
+// budget.
+func (m *chaosMonkey) allowed(now time.Time) bool {
+	if len(m.config.Windows) > 0 {
+		inWindow := false
+		for _, window := range m.config.Windows {
+			inWindow = inWindow || window.contains(now)
+		}
+		if !inWindow {
+			return false
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config.Budget.MaxActions == 0 || m.budgetUsed(now) < m.config.Budget.MaxActions
+}
+
+// budgetUsed forgets the actions older than the period and returns how
+// were taken since. It must be called with the lock held.
+func (m *chaosMonkey) budgetUsed(now time.Time) int {
+	recent := m.taken[:0]
+	for _, taken := range m.taken {
+		if now.Sub(taken) < m.config.Budget.Period {
+			recent = append(recent, taken)
+		}
+	}
+	m.taken = recent
+	return len(m.taken)
+}
+
+func (m *chaosMonkey) pick() chaosAction {
+	total := 0.0
+	for _, action := range m.config.Actions {
+		total += action.Weight
+	}
+	draw := m.st.random.Float64() * total
+	for _, action := range m.config.Actions {
+		if draw < action.Weight {
+			return action
+		}
+		draw -= action.Weight
+	}
+	return m.config.Actions[len(m.config.Actions)-1]
+}
+
+// act applies the action for its duration then reverts it, sooner when
+// the chaos is closed.
+func (m *chaosMonkey) act(action chaosAction, now time.Time) {
+	var detail string
+	var revert func()
+	switch action.Type {
+	case chaosLatency, chaosErrors:
+		profile := faultProfile{Latency: action.Latency, ErrorRate: action.ErrorRate, ErrorStatus: action.ErrorStatus}
+		previous := m.st.runtimeFaults.Load()
+		m.st.setRuntimeFaults(&profile)
+		revert = func() { m.st.setRuntimeFaults(previous) }
+		detail = fmt.Sprintf("latency %v, error rate %v", action.Latency, action.ErrorRate)
+	case chaosFlap:
+		m.st.setProbeOverride(action.Probe, http.StatusServiceUnavailable)
+		revert = func() { m.st.setProbeOverride(action.Probe, 0) }
+		detail = action.Probe + " probe failing"
+	}
+
+	event := chaosEvent{Type: action.Type, Detail: detail, Started: now, Duration: action.Duration.String()}
+	m.mu.Lock()
+	m.active = &event
+	m.taken = append(m.taken, now)
+	m.history = append([]chaosEvent{event}, m.history[:min(len(m.history), chaosHistorySize-1)]...)
+	m.mu.Unlock()
+	m.st.chaosActionsTotal.WithLabelValues(action.Type).Inc()
+	m.st.configChangesTotal.Inc()
+	slog.Warn("Chaos action", "type", action.Type, "detail", detail, "duration", action.Duration.String())
+	m.st.kubeEvents.emit(eventTypeWarning, "ChaosAction", fmt.Sprintf("Chaos %s for %v: %s", action.Type, action.Duration, detail))
+
+	m.st.proberClock.waitUntil(now.Add(action.Duration), m.stop)
+	revert()
+	m.mu.Lock()
+	m.active = nil
+	m.mu.Unlock()
+	slog.Info("Chaos action reverted", "type", action.Type)
+}
+
+func (m *chaosMonkey) Close() {
+	close(m.stop)
+	<-m.done
+}
+
+type chaosStatus struct {
+	Interval    string       `json:"interval"`
+	Probability float64      `json:"probability"`
+	Active      *chaosEvent  `json:"active,omitempty"`
+	Recent      []chaosEvent `json:"recent"`
+	BudgetLeft  *int         `json:"budgetLeft,omitempty"`
+}
+
+func (m *chaosMonkey) status() chaosStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := chaosStatus{
+		Interval:    m.config.Interval.String(),
+		Probability: m.config.Probability,
+		Active:      m.active,
+		Recent:      append([]chaosEvent{}, m.history...),
+	}
+	if m.config.Budget.MaxActions > 0 {
+		left := max(0, m.config.Budget.MaxActions-m.budgetUsed(m.st.proberClock.Now()))
+		status.BudgetLeft = &left
+	}
+	return status
+}
+
+func (st *state) chaosHandler(c *web.Context) {
+	if st.chaos == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Background chaos disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.chaos.status())
+}
@@ -0,0 +1,111 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestChaosWindow(t *testing.T) {
+	office := chaosWindow{Days: []string{"Mon", "tuesday"}, Start: "09:00", End: "17:00"}
+	night := chaosWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	for _, window := range []*chaosWindow{&office, &night} {
+		if err := window.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := func(day int, hour int, minute int) time.Time {
+		// 2024-05-06 is a Monday
+		return time.Date(2024, 5, 6+day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window   chaosWindow
+		at       time.Time
+		contains bool
+	}{
+		{office, at(0, 9, 0), true},
+		{office, at(1, 16, 59), true},
+		{office, at(0, 17, 0), false},
+		{office, at(2, 12, 0), false},
+		{night, at(4, 23, 0), true},
+		{night, at(5, 1, 30), true},
+		{night, at(5, 23, 0), false},
+		{night, at(4, 1, 30), false},
+	}
+	for _, test := range tests {
+		if contains := test.window.contains(test.at); contains != test.contains {
+			t.Errorf("%s-%s at %v: expected %v, got %v", test.window.Start, test.window.End, test.at, test.contains, contains)
+		}
+	}
+}
+
+func TestLoadChaosConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"probability: 0.5\n",
+		"probability: 0\nactions: [{type: flap, probe: readiness, duration: 1m}]\n",
+		"probability: 0.5\nactions: [{type: flap, probe: warmup, duration: 1m}]\n",
+		"probability: 0.5\nactions: [{type: latency, duration: 1m}]\n",
+		"probability: 0.5\nactions: [{type: errors, errorRate: 2, duration: 1m}]\n",
+		"probability: 0.5\nactions: [{type: reboot, duration: 1m}]\n",
+		"probability: 0.5\nactions: [{type: flap, probe: readiness}]\n",
+		"probability: 0.5\nwindows: [{start: \"9am\", end: \"17:00\"}]\nactions: [{type: flap, probe: readiness, duration: 1m}]\n",
+	} {
+		if _, err := loadChaosConfig(writeChecksConfig(t, config)); err == nil {
+			t.Errorf("expected an error for %q", config)
+		}
+	}
+}
+
+func TestChaosMonkey(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+
+	config, err := loadChaosConfig(writeChecksConfig(t, `
+interval: 10ms
+probability: 1
+budget:
+  maxActions: 1
+  period: 1h
+actions:
+  - type: flap
+    probe: readiness
+    duration: 100ms
+`))
+	if err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+	st.chaos = newChaosMonkey(st, config)
+	go st.chaos.run()
+	defer st.chaos.Close()
+
+	router := st.newRouter(nil, listenerConfig{})
+	readiness := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		return w.Code
+	}
+	deadline := time.Now().Add(time.Second)
+	for readiness() != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the chaos to flap readiness")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for readiness() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the flap to be reverted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The budget of a single action is spent
+	time.Sleep(50 * time.Millisecond)
+	status := st.chaos.status()
+	if len(status.Recent) != 1 || status.Recent[0].Type != chaosFlap || status.BudgetLeft == nil || *status.BudgetLeft != 0 || status.Active != nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
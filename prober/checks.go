@@ -0,0 +1,610 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	checksConfigEnv      = "CHECKS_CONFIG"
+	checksHistorySizeEnv = "CHECKS_HISTORY_SIZE"
+
+	checkTypeHTTP = "http"
+	checkTypeTCP  = "tcp"
+	checkTypeDNS  = "dns"
+	checkTypeICMP = "icmp"
+	checkTypeGRPC = "grpc"
+
+	checkTypeRedis    = "redis"
+	checkTypePostgres = "postgres"
+	checkTypeMySQL    = "mysql"
+	checkTypeKafka    = "kafka"
+	checkTypeAMQP     = "amqp"
+
+	defaultCheckInterval = 30 * time.Second
+	defaultCheckTimeout  = 5 * time.Second
+
+	defaultChecksHistorySize = 100
+
+	backoffConstant    = "constant"
+	backoffExponential = "exponential"
+
+	defaultRetryBackoff = time.Second
+)
+
+// checkMetrics expose the results of the outbound checks, blackbox_exporter
+// style.
+type checkMetrics struct {
+	checkSuccess           *prometheus.GaugeVec
+	checkDuration          *prometheus.GaugeVec
+	checkHTTPStatusCode    *prometheus.GaugeVec
+	checkRunsTotal         *prometheus.CounterVec
+	checkGRPCStatusCode    *prometheus.GaugeVec
+	checkAssertionSuccess  *prometheus.GaugeVec
+	checkHTTPPhaseDuration *prometheus.HistogramVec
+}
+
+func newCheckMetrics(registry prometheus.Registerer, buckets []float64) checkMetrics {
+	m := checkMetrics{
+		checkSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the last check of the target succeeded.",
+		}, []string{"target", "type"}),
+
+		checkDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Duration of the last check of the target.",
+		}, []string{"target", "type"}),
+
+		checkHTTPStatusCode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_http_status_code",
+			Help: "Response status code of the last HTTP check of the target.",
+		}, []string{"target"}),
+
+		checkRunsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_runs_total",
+			Help: "Checks run by target, type and result.",
+		}, []string{"target", "type", "result"}),
+
+		checkGRPCStatusCode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_grpc_status_code",
+			Help: "Status code of the last gRPC check of the target.",
+		}, []string{"target"}),
+
+		checkAssertionSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_assertion_success",
+			Help: "Whether an assertion of the last HTTP check of the target passed.",
+		}, []string{"target", "assertion"}),
+
+		checkHTTPPhaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "probe_http_phase_duration_seconds",
+			Help:    "Duration of each phase of the HTTP checks by target and phase.",
+			Buckets: buckets,
+		}, []string{"target", "phase"}),
+	}
+	registry.MustRegister(m.checkSuccess, m.checkDuration, m.checkHTTPStatusCode, m.checkRunsTotal,
+		m.checkGRPCStatusCode, m.checkAssertionSuccess, m.checkHTTPPhaseDuration)
+	return m
+}
+
+// checkConfig describes an outbound target prober checks periodically,
+// blackbox_exporter style.
+type checkConfig struct {
+	Name           string           `yaml:"name"`
+	Type           string           `yaml:"type"`
+	URL            string           `yaml:"url"`
+	Method         string           `yaml:"method"`
+	ExpectedStatus []int            `yaml:"expectedStatus"`
+	Assertions     []checkAssertion `yaml:"assertions"`
+	Address        string           `yaml:"address"`
+	Host           string           `yaml:"host"`
+	RecordType     string           `yaml:"recordType"`
+	Server         string           `yaml:"server"`
+	Service        string           `yaml:"service"`
+	DSN            string           `yaml:"dsn"`
+	Brokers        []string         `yaml:"brokers"`
+	Username       string           `yaml:"username"`
+	Password       string           `yaml:"password"`
+	SASLMechanism  string           `yaml:"saslMechanism"`
+	TLS            bool             `yaml:"tls"`
+	ExpectFailure  bool             `yaml:"expectFailure"`
+	Interval       time.Duration    `yaml:"interval"`
+	Schedule       string           `yaml:"schedule"`
+	Timeout        time.Duration    `yaml:"timeout"`
+
+	Retries          int           `yaml:"retries"`
+	RetryBackoff     time.Duration `yaml:"retryBackoff"`
+	BackoffStrategy  string        `yaml:"backoffStrategy"`
+	FailureThreshold int           `yaml:"failureThreshold"`
+	SuccessThreshold int           `yaml:"successThreshold"`
+
+	schedule cron.Schedule
+}
+
+type checkResult struct {
+	Target     string            `json:"target"`
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	Success    bool              `json:"success"`
+	Skipped    bool              `json:"skipped,omitempty"`
+	StatusCode int               `json:"statusCode,omitempty"`
+	GRPCStatus string            `json:"grpcStatus,omitempty"`
+	Phases     *httpPhases       `json:"phases,omitempty"`
+	Assertions []assertionResult `json:"assertions,omitempty"`
+	Records    []string          `json:"records,omitempty"`
+	Attempts   int               `json:"attempts,omitempty"`
+	Up         *bool             `json:"up,omitempty"`
+	Duration   string            `json:"duration"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"errorClass,omitempty"`
+
+	elapsed  time.Duration
+	grpcCode codes.Code
+}
+
+// checkTypes runs a single check of each supported type. The returned
+// result only needs the type specific fields, the rest is filled by runCheck.
+var checkTypes = map[string]func(ctx context.Context, config checkConfig) checkResult{
+	checkTypeHTTP: httpCheck,
+	checkTypeTCP:  tcpCheck,
+	checkTypeDNS:  dnsCheck,
+	checkTypeICMP: icmpCheck,
+	checkTypeGRPC: grpcCheck,
+
+	checkTypeRedis:    redisCheck,
+	checkTypePostgres: postgresCheck,
+	checkTypeMySQL:    mysqlCheck,
+	checkTypeKafka:    kafkaCheck,
+	checkTypeAMQP:     amqpCheck,
+}
+
+type checksFile struct {
+	Checks []checkConfig `yaml:"checks"`
+}
+
+func loadChecksConfig(path string) ([]checkConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file checksFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(file.Checks))
+	for i := range file.Checks {
+		check := &file.Checks[i]
+		if check.Name == "" {
+			return nil, errors.New("check without name")
+		}
+		if names[check.Name] {
+			return nil, fmt.Errorf("duplicated check %q", check.Name)
+		}
+		names[check.Name] = true
+
+		if err := check.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return file.Checks, nil
+}
+
+// validate checks the target settings and fills in the defaults.
+func (c *checkConfig) validate() error {
+	if c.Type == "" {
+		c.Type = checkTypeHTTP
+	}
+	if _, ok := checkTypes[c.Type]; !ok {
+		return fmt.Errorf("check %q has unknown type %q", c.Name, c.Type)
+	}
+	switch {
+	case c.Type == checkTypeHTTP && c.URL == "":
+		return fmt.Errorf("check %q without url", c.Name)
+	case (c.Type == checkTypeTCP || c.Type == checkTypeGRPC) && c.Address == "":
+		return fmt.Errorf("check %q without address", c.Name)
+	case (c.Type == checkTypeDNS || c.Type == checkTypeICMP) && c.Host == "":
+		return fmt.Errorf("check %q without host", c.Name)
+	case (c.Type == checkTypeRedis || c.Type == checkTypePostgres || c.Type == checkTypeMySQL || c.Type == checkTypeAMQP) && c.DSN == "":
+		return fmt.Errorf("check %q without dsn", c.Name)
+	case c.Type == checkTypeKafka && len(c.Brokers) == 0:
+		return fmt.Errorf("check %q without brokers", c.Name)
+	}
+	if c.Type == checkTypeKafka {
+		if _, err := kafkaMechanism(*c); err != nil {
+			return fmt.Errorf("check %q: %w", c.Name, err)
+		}
+	}
+	if len(c.Assertions) > 0 && c.Type != checkTypeHTTP {
+		return fmt.Errorf("check %q: assertions are only supported by http checks", c.Name)
+	}
+	for i := range c.Assertions {
+		if err := c.Assertions[i].validate(); err != nil {
+			return fmt.Errorf("check %q: %w", c.Name, err)
+		}
+	}
+	if err := validateDSN(c.Type, c.DSN); err != nil {
+		return fmt.Errorf("check %q has invalid dsn: %w", c.Name, err)
+	}
+	if c.Type == checkTypeDNS {
+		if c.RecordType == "" {
+			c.RecordType = "A"
+		}
+		if _, ok := dns.StringToType[strings.ToUpper(c.RecordType)]; !ok {
+			return fmt.Errorf("check %q has unknown recordType %q", c.Name, c.RecordType)
+		}
+	}
+	if c.Schedule != "" {
+		if c.Interval > 0 {
+			return fmt.Errorf("check %q can't set both interval and schedule", c.Name)
+		}
+		schedule, err := cron.ParseStandard(c.Schedule)
+		if err != nil {
+			return fmt.Errorf("check %q has invalid schedule: %w", c.Name, err)
+		}
+		c.schedule = schedule
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultCheckInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultCheckTimeout
+	}
+	switch c.BackoffStrategy {
+	case "":
+		c.BackoffStrategy = backoffConstant
+	case backoffConstant, backoffExponential:
+	default:
+		return fmt.Errorf("check %q has unknown backoffStrategy %q", c.Name, c.BackoffStrategy)
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("check %q has negative retries", c.Name)
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultRetryBackoff
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 1
+	}
+	if c.SuccessThreshold <= 0 {
+		c.SuccessThreshold = 1
+	}
+	return nil
+}
+
+// runCheck performs the check of the target, retrying failed attempts up
+// to retries times with a backoff in between. Each attempt has its own
+// timeout and the result is the one of the last attempt, skipped results
+// are not retried.
+func runCheck(ctx context.Context, config checkConfig) checkResult {
+	start := time.Now()
+	var result checkResult
+	for attempt := 0; ; attempt++ {
+		result = runAttempt(ctx, config)
+		result.Attempts = attempt + 1
+		if result.Success || result.Skipped || attempt >= config.Retries {
+			break
+		}
+
+		timer := time.NewTimer(config.retryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			continue
+		}
+		break
+	}
+	result.Time = start
+	return result
+}
+
+// retryDelay returns the backoff after the failed attempt, counted from 0.
+func (config checkConfig) retryDelay(attempt int) time.Duration {
+	if config.BackoffStrategy == backoffExponential {
+		return config.RetryBackoff << attempt
+	}
+	return config.RetryBackoff
+}
+
+func runAttempt(ctx context.Context, config checkConfig) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := checkTypes[config.Type](ctx, config)
+	result.Target = config.Name
+	result.Type = config.Type
+	result.elapsed = time.Since(start)
+	result.Duration = result.elapsed.String()
+	return result
+}
+
+// httpCheck succeeds when the target answers with one of the expected
+// status codes, any 2xx by default, and every assertion passes. Status
+// assertions replace the default 2xx expectation.
+func httpCheck(ctx context.Context, config checkConfig) checkResult {
+	method := config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var timer phaseTimer
+	req, err := http.NewRequestWithContext(timer.withTrace(ctx), method, config.URL, nil)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	statusAsserted := false
+	for _, assertion := range config.Assertions {
+		statusAsserted = statusAsserted || assertion.Status != ""
+		if assertion.needsBody() && body == nil {
+			body, _ = io.ReadAll(io.LimitReader(resp.Body, maxAssertionBodyBytes))
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	latency := time.Since(start)
+
+	result := checkResult{StatusCode: resp.StatusCode, Phases: timer.phases(time.Now())}
+	if len(config.ExpectedStatus) == 0 {
+		result.Success = statusAsserted || (resp.StatusCode >= 200 && resp.StatusCode < 300)
+	}
+	for _, status := range config.ExpectedStatus {
+		if resp.StatusCode == status {
+			result.Success = true
+		}
+	}
+	if !result.Success {
+		result.Error = "unexpected status " + strconv.Itoa(resp.StatusCode)
+	}
+
+	for _, assertion := range config.Assertions {
+		assertionResult := assertion.evaluate(resp, body, latency)
+		result.Assertions = append(result.Assertions, assertionResult)
+		if !assertionResult.Passed && result.Success {
+			result.Success = false
+			result.Error = "assertion failed: " + assertion.Name
+		}
+	}
+	return result
+}
+
+// checkState marks a check up or down like kubelet does for probes: it
+// takes failureThreshold consecutive failures to go down and
+// successThreshold consecutive successes to come back. The first result
+// sets the state directly.
+type checkState struct {
+	known, up           bool
+	successes, failures int
+}
+
+func (s *checkState) update(config checkConfig, success bool) bool {
+	if success {
+		s.successes, s.failures = s.successes+1, 0
+	} else {
+		s.successes, s.failures = 0, s.failures+1
+	}
+	switch {
+	case !s.known:
+		s.known, s.up = true, success
+	case s.up && s.failures >= config.FailureThreshold:
+		s.up = false
+	case !s.up && s.successes >= config.SuccessThreshold:
+		s.up = true
+	}
+	return s.up
+}
+
+// checker runs every configured check on its own interval or cron schedule
+// and keeps the last results and state of each.
+type checker struct {
+	st          *state
+	checks      []checkConfig
+	historySize int
+
+	mu      sync.RWMutex
+	history map[string][]checkResult
+	states  map[string]*checkState
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (st *state) newChecker(checks []checkConfig) *checker {
+	c := &checker{
+		st:          st,
+		checks:      checks,
+		historySize: st.config.getInt(checksHistorySizeEnv, defaultChecksHistorySize),
+		history:     make(map[string][]checkResult),
+		states:      make(map[string]*checkState),
+		stop:        make(chan struct{}),
+	}
+	for _, check := range checks {
+		c.history[check.Name] = []checkResult{}
+		c.states[check.Name] = &checkState{}
+	}
+	return c
+}
+
+// next returns when the check must run after now. Interval checks also run
+// right away at startup, scheduled ones wait for their first slot.
+func (config checkConfig) next(now time.Time) time.Time {
+	if config.schedule != nil {
+		return config.schedule.Next(now)
+	}
+	return now.Add(config.Interval)
+}
+
+func (c *checker) run() {
+	for _, check := range c.checks {
+		c.wg.Add(1)
+		go func(check checkConfig) {
+			defer c.wg.Done()
+			if check.schedule == nil {
+				c.check(check)
+			}
+
+			for {
+				if !c.st.proberClock.waitUntil(check.next(c.st.proberClock.Now()), c.stop) {
+					return
+				}
+				c.check(check)
+			}
+		}(check)
+	}
+}
+
+func (c *checker) check(config checkConfig) checkResult {
+	return c.record(config, runCheck(context.Background(), config))
+}
+
+// record updates the state, metrics and history of the check with a result.
+// probe_success and c.st.webhooks follow the thresholded state.
+func (c *checker) record(config checkConfig, result checkResult) checkResult {
+	if !result.Success {
+		slog.Warn("Check failed", "target", config.Name, "type", config.Type, "attempts", result.Attempts, "error", result.Error)
+	}
+
+	c.mu.Lock()
+	state, ok := c.states[config.Name]
+	if !ok {
+		state = &checkState{}
+		c.states[config.Name] = state
+	}
+	up := state.update(config, result.Success)
+	c.mu.Unlock()
+	result.Up = &up
+
+	success := 0.0
+	if up {
+		success = 1
+	}
+	c.st.checkSuccess.WithLabelValues(config.Name, config.Type).Set(success)
+	c.st.checkRunsTotal.WithLabelValues(config.Name, config.Type, outcome(result.Success)).Inc()
+	c.st.checkDuration.WithLabelValues(config.Name, config.Type).Set(result.elapsed.Seconds())
+	if config.Type == checkTypeHTTP {
+		c.st.checkHTTPStatusCode.WithLabelValues(config.Name).Set(float64(result.StatusCode))
+	}
+	if config.Type == checkTypeGRPC {
+		c.st.checkGRPCStatusCode.WithLabelValues(config.Name).Set(float64(result.grpcCode))
+	}
+	for _, assertion := range result.Assertions {
+		passed := 0.0
+		if assertion.Passed {
+			passed = 1
+		}
+		c.st.checkAssertionSuccess.WithLabelValues(config.Name, assertion.Name).Set(passed)
+	}
+	if result.Phases != nil {
+		for phase, duration := range result.Phases.durations {
+			c.st.checkHTTPPhaseDuration.WithLabelValues(config.Name, phase).Observe(duration.Seconds())
+		}
+	}
+
+	c.st.webhooks.observe(webhookEventCheck, config.Name, up, result.Error)
+
+	c.mu.Lock()
+	history := append(c.history[config.Name], result)
+	if c.historySize > 0 && len(history) > c.historySize {
+		history = history[len(history)-c.historySize:]
+	}
+	c.history[config.Name] = history
+	c.mu.Unlock()
+	return result
+}
+
+// list returns the last result of every check.
+func (c *checker) list() []checkResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := make([]checkResult, 0, len(c.history))
+	for _, history := range c.history {
+		if len(history) > 0 {
+			results = append(results, history[len(history)-1])
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Target < results[j].Target })
+	return results
+}
+
+// results returns the kept results of a check, newest first.
+func (c *checker) results(name string) ([]checkResult, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	history, ok := c.history[name]
+	results := make([]checkResult, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		results = append(results, history[i])
+	}
+	return results, ok
+}
+
+// failing returns the checks among names that are down, including unknown
+// checks and ones that didn't run yet. Every name fails on a nil checker.
+func (c *checker) failing(names []string) []string {
+	if c == nil {
+		return names
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var failing []string
+	for _, name := range names {
+		if state, ok := c.states[name]; !ok || !state.known || !state.up {
+			failing = append(failing, name)
+		}
+	}
+	return failing
+}
+
+func (c *checker) Close() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (st *state) checksHandler(c *web.Context) {
+	if st.targetChecker == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Checks are not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.targetChecker.list())
+}
+
+func (st *state) checkHistoryHandler(c *web.Context) {
+	if st.targetChecker == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Checks are not enabled"})
+		return
+	}
+	results, ok := st.targetChecker.results(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, web.H{"error": "Unknown check"})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,317 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeChecksConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "checks.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadChecksConfig(t *testing.T) {
+	path := writeChecksConfig(t, `
+checks:
+  - name: api
+    url: http://api.default.svc/healthz
+    expectedStatus: [200, 204]
+    interval: 10s
+`)
+
+	checks, err := loadChecksConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checks) != 1 {
+		t.Fatalf("expected 1 check, got %d", len(checks))
+	}
+	check := checks[0]
+	if check.Type != checkTypeHTTP || check.Interval != 10*time.Second || check.Timeout != defaultCheckTimeout {
+		t.Errorf("unexpected check defaults: %+v", check)
+	}
+}
+
+func TestLoadChecksConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing name": `
+checks:
+  - url: http://api
+`,
+		"duplicated name": `
+checks:
+  - name: api
+    url: http://api
+  - name: api
+    url: http://api
+`,
+		"unknown type": `
+checks:
+  - name: api
+    type: carrier-pigeon
+`,
+		"missing url": `
+checks:
+  - name: api
+    type: http
+`,
+		"missing address": `
+checks:
+  - name: db
+    type: tcp
+`,
+		"unknown record type": `
+checks:
+  - name: db
+    type: dns
+    host: db.example.internal
+    recordType: BOGUS
+`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadChecksConfig(writeChecksConfig(t, content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	st := newState(Options{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	ch := st.newChecker(nil)
+	tests := []struct {
+		config  checkConfig
+		success bool
+		status  int
+	}{
+		{checkConfig{Name: "up", Type: checkTypeHTTP, URL: target.URL + "/up", Timeout: time.Second}, true, 200},
+		{checkConfig{Name: "down", Type: checkTypeHTTP, URL: target.URL + "/down", Timeout: time.Second}, false, 503},
+		{checkConfig{Name: "expected-down", Type: checkTypeHTTP, URL: target.URL + "/down", ExpectedStatus: []int{503}, Timeout: time.Second}, true, 503},
+		{checkConfig{Name: "unreachable", Type: checkTypeHTTP, URL: "http://127.0.0.1:1", Timeout: time.Second}, false, 0},
+	}
+	for _, test := range tests {
+		result := ch.check(test.config)
+		if result.Success != test.success || result.StatusCode != test.status {
+			t.Errorf("%s: expected success=%v status=%d, got %+v", test.config.Name, test.success, test.status, result)
+		}
+
+		expected := 0.0
+		if test.success {
+			expected = 1
+		}
+		if got := testutil.ToFloat64(st.checkSuccess.WithLabelValues(test.config.Name, checkTypeHTTP)); got != expected {
+			t.Errorf("%s: expected probe_success %v, got %v", test.config.Name, expected, got)
+		}
+	}
+}
+
+func TestChecksHandler(t *testing.T) {
+	st := newState(Options{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	st.targetChecker = st.newChecker([]checkConfig{{Name: "api", Type: checkTypeHTTP, URL: target.URL, Interval: time.Hour, Timeout: time.Second}})
+	st.targetChecker.run()
+	defer func() {
+		st.targetChecker.Close()
+		st.targetChecker = nil
+	}()
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/checks", st.checksHandler)
+
+	var results []checkResult
+	for deadline := time.Now().Add(2 * time.Second); len(results) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		req, _ := http.NewRequest("GET", "/checks", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+	}
+
+	if len(results) != 1 || results[0].Target != "api" || !results[0].Success {
+		t.Errorf("expected a successful api check, got %+v", results)
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	checks, err := loadChecksConfig(writeChecksConfig(t, `
+checks:
+  - name: nightly
+    url: http://api
+    schedule: "30 2 * * *"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if next := checks[0].next(now); !next.Equal(time.Date(2024, 5, 2, 2, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected next run at 02:30 the day after, got %v", next)
+	}
+
+	interval := checkConfig{Interval: time.Minute}
+	if next := interval.next(now); !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected next run after the interval, got %v", next)
+	}
+
+	for _, content := range []string{`
+checks:
+  - name: nightly
+    url: http://api
+    schedule: "every night"
+`, `
+checks:
+  - name: nightly
+    url: http://api
+    schedule: "@hourly"
+    interval: 10s
+`} {
+		if _, err := loadChecksConfig(writeChecksConfig(t, content)); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestCheckHistory(t *testing.T) {
+	t.Setenv(checksHistorySizeEnv, "2")
+	st := newState(Options{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	config := checkConfig{Name: "history", Type: checkTypeHTTP, URL: target.URL, Timeout: time.Second}
+	st.targetChecker = st.newChecker([]checkConfig{config, {Name: "pending", Type: checkTypeHTTP}})
+
+	runs := testutil.ToFloat64(st.checkRunsTotal.WithLabelValues("history", checkTypeHTTP, "success"))
+	for i := 0; i < 3; i++ {
+		st.targetChecker.check(config)
+	}
+	if got := testutil.ToFloat64(st.checkRunsTotal.WithLabelValues("history", checkTypeHTTP, "success")); got != runs+3 {
+		t.Errorf("expected 3 more successful runs, got %v -> %v", runs, got)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/checks/:name/history", st.checkHistoryHandler)
+
+	tests := []struct {
+		name    string
+		status  int
+		results int
+	}{
+		{"history", http.StatusOK, 2},
+		{"pending", http.StatusOK, 0},
+		{"unknown", http.StatusNotFound, 0},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/checks/"+test.name+"/history", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, w.Code)
+		}
+		var results []checkResult
+		if test.status == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatalf("invalid response body: %v", err)
+			}
+		}
+		if len(results) != test.results {
+			t.Errorf("%s: expected %d results, got %d", test.name, test.results, len(results))
+		}
+	}
+}
+
+func TestCheckRetries(t *testing.T) {
+	var requests atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	config := checkConfig{Name: "flaky", URL: target.URL, Retries: 2, RetryBackoff: time.Millisecond, BackoffStrategy: backoffExponential}
+	if err := config.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := runCheck(context.Background(), config)
+	if !result.Success || result.Attempts != 3 {
+		t.Errorf("expected success on the third attempt, got %+v", result)
+	}
+
+	requests.Store(0)
+	config.Retries = 1
+	result = runCheck(context.Background(), config)
+	if result.Success || result.Attempts != 2 {
+		t.Errorf("expected failure after 2 attempts, got %+v", result)
+	}
+
+	if got := config.retryDelay(3); got != 8*time.Millisecond {
+		t.Errorf("expected exponential delay 8ms, got %v", got)
+	}
+	config.BackoffStrategy = backoffConstant
+	if got := config.retryDelay(3); got != time.Millisecond {
+		t.Errorf("expected constant delay 1ms, got %v", got)
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	st := newState(Options{})
+	config := checkConfig{Name: "thresholds", Type: checkTypeTCP, FailureThreshold: 3, SuccessThreshold: 2}
+	c := st.newChecker([]checkConfig{config})
+
+	outcomes := []struct {
+		success bool
+		up      bool
+	}{
+		{true, true},
+		{false, true},
+		{false, true},
+		{false, false},
+		{true, false},
+		{false, false},
+		{true, false},
+		{true, true},
+	}
+	for i, outcome := range outcomes {
+		result := c.record(config, checkResult{Success: outcome.success})
+		if *result.Up != outcome.up {
+			t.Errorf("run %d: expected up %v, got %v", i, outcome.up, *result.Up)
+		}
+		expected := 0.0
+		if outcome.up {
+			expected = 1
+		}
+		if got := testutil.ToFloat64(st.checkSuccess.WithLabelValues("thresholds", checkTypeTCP)); got != expected {
+			t.Errorf("run %d: expected probe_success %v, got %v", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// command is a subcommand of the prober binary. It returns the exit code:
+// 0 on success, 1 on failure and 2 on usage or configuration errors.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+var commands = []command{
+	{"serve", "Run the server, the default without a command", runServe},
+	{"check", "Run one check and print its result, like a container HEALTHCHECK", runCheckCommand},
+	{"init", "Run the checks of a checks file once, to gate a pod as an init container", runInit},
+	{"load", "Send a steady rate of requests and print the latency percentiles", runLoad},
+	{"replay", "Replay the requests of a recording against another prober", runReplay},
+	{"validate-config", "Validate the configuration of the server without serving", runValidateConfig},
+	{"version", "Print the version and the enabled features", runVersion},
+}
+
+// newFlagSet returns the flags of a command, which print their usage to
+// stderr on errors and -h.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: prober %s [flags]\n\nFlags:\n", name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// envUsage documents the variable a flag defaults to, the flags and the
+// environment configuring the same settings.
+func envUsage(usage string, env string) string {
+	return usage + " (env " + env + ")"
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: prober [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun prober [command] -h for the flags of a command.\n")
+}
+
+// Main runs the prober binary: a command, or the server when the first
+// argument is a flag or missing. It returns the exit code.
+func Main(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		return runServe(args, stdout, stderr)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(stdout)
+		return 0
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runServe(args, stdout, stderr)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+	printUsage(stderr)
+	return 2
+}
+
+// runServe implements `prober serve`, which runs the server until it is
+// shut down.
+func runServe(args []string, stdout io.Writer, stderr io.Writer) int {
+	opts, err := parseServerFlags("serve", args, stderr)
+	if err != nil {
+		return 2
+	}
+	setupLogging(opts)
+	if err := tuneRuntime(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return 1
+	}
+
+	srv, err := NewServer(opts)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return 1
+	}
+	defer srv.Close()
+	if err := srv.Run(context.Background()); err != nil {
+		slog.Error("Failed to serve", "error", err)
+		return 1
+	}
+	if srv.successor != nil {
+		// The workers of the successor take over.
+		srv.Close()
+		heapBallast = nil
+		return srv.successor.supervise()
+	}
+	slog.Info("Server exiting")
+	return 0
+}
+
+// tuneRuntime sizes GOMAXPROCS and allocates the heap ballast, which apply
+// to the whole process, so the serve command does it before NewServer.
+func tuneRuntime() error {
+	root := getEnvString(cgroupRootEnv, defaultCgroupRoot)
+	setMaxProcs(root)
+	var err error
+	if heapBallast, err = loadHeapBallast(root); err != nil {
+		return fmt.Errorf("invalid heap ballast: %w", err)
+	}
+	return nil
+}
+
+// runCheckCommand implements `prober check`, which runs one check described
+// by its flags and prints its result as JSON, for the exec probes and
+// HEALTHCHECK of images without curl.
+func runCheckCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("check", stderr)
+	config := checkConfig{Name: "check"}
+	flags.StringVar(&config.Type, "type", checkTypeHTTP, "type of the check, like in the checks file")
+	flags.StringVar(&config.URL, "url", "", "URL of the http checks")
+	flags.StringVar(&config.Method, "method", "", "method of the http checks")
+	expected := flags.String("expected-status", "", "comma separated statuses expected by the http checks, 2xx by default")
+	flags.StringVar(&config.Address, "address", "", "host:port of the tcp and grpc checks")
+	flags.StringVar(&config.Host, "host", "", "host of the dns and icmp checks")
+	flags.StringVar(&config.DSN, "dsn", "", "DSN of the redis, postgres, mysql and amqp checks")
+	flags.DurationVar(&config.Timeout, "timeout", defaultCheckTimeout, "time limit of the check")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	for _, value := range splitList(*expected) {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid --expected-status %q\n", value)
+			return 2
+		}
+		config.ExpectedStatus = append(config.ExpectedStatus, status)
+	}
+	if err := config.validate(); err != nil {
+		fmt.Fprintf(stderr, "invalid check: %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	result := runCheck(ctx, config)
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+	if !result.Success {
+		return 1
+	}
+	return 0
+}
+
+type configReport struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// loadError drops the loaded value of a loader.
+func loadError[T any](_ T, err error) error {
+	return err
+}
+
+// loadPath runs the loader of a file when its setting is set.
+func loadPath[T any](config *settings, env string, load func(string) (T, error)) func() error {
+	return func() error {
+		path := config.get(env)
+		if path == "" {
+			return nil
+		}
+		return loadError(load(path))
+	}
+}
+
+// validateServerConfig loads the configuration like NewServer does, without
+// starting anything nor reaching the cluster, and returns all the errors
+// rather than the first.
+func validateServerConfig(opts Options) []string {
+	st := newState(opts)
+	config := st.config
+	root := config.getString(cgroupRootEnv, defaultCgroupRoot)
+	var timeouts handlerTimeouts
+	var headers *responseHeaders
+	var limits *bodyLimits
+	validations := []struct {
+		name     string
+		validate func() error
+	}{
+		{"heap ballast", func() error { return loadError(loadHeapBallast(root)) }},
+		{"server limits", func() error { return loadServerLimits(config).validate() }},
+		{"stress pool configuration", func() error { return loadError(loadWorkerPool(config, st.stressMetrics)) }},
+		{"admin configuration", func() error { return loadError(loadAdminNetworks(config)) }},
+		{"headers configuration", func() (err error) { headers, err = loadResponseHeaders(config); return err }},
+		{"body size limits", func() (err error) { limits, err = loadBodyLimits(config); return err }},
+		{"JWT configuration", func() error { return loadError(loadJWTValidator(config)) }},
+		{"OIDC configuration", func() error { return loadError(loadRelyingParty(config, st.oidcMetrics)) }},
+		{"response cache configuration", func() error { return loadError(loadResponseCache(config, st.cacheMetrics)) }},
+		{"handler timeouts", func() (err error) { timeouts, err = loadHandlerTimeouts(config); return err }},
+		{"TLS configuration", func() error { return loadError(loadCertReloader(config)) }},
+		{"bind configuration", func() error { return loadError(loadBindConfig(config)) }},
+		{"listeners configuration", loadPath(config, listenersConfigEnv, loadListenersConfig)},
+		{"scenario library", loadPath(config, scenarioLibraryEnv, loadScenarioLibrary)},
+		{"chaos configuration", func() error { return loadError(st.loadChaosMonkey()) }},
+		{"webhooks configuration", loadPath(config, webhooksConfigEnv, loadWebhooksConfig)},
+		{"checks configuration", loadPath(config, checksConfigEnv, loadChecksConfig)},
+		{"egress configuration", loadPath(config, egressConfigEnv, loadChecksConfig)},
+		{"scripts configuration", loadPath(config, scriptsConfigEnv, loadScriptsConfig)},
+		{"mocks configuration", loadPath(config, mocksConfigEnv, func(path string) ([]*mockRoute, error) { return loadMocksConfig(path, st.counters) })},
+		{"relay configuration", loadPath(config, relayConfigEnv, loadRelayConfig)},
+		{"probe modules configuration", func() error { return loadError(loadProbeModules(config.get(probeModulesConfigEnv))) }},
+		{"OpenAPI spec", func() error {
+			if opts.OpenAPI == "" {
+				return nil
+			}
+			return loadError(loadOpenAPIStubs(opts.OpenAPI, opts.openAPIFaults(), st.counters))
+		}},
+	}
+	var errs []string
+	for _, validation := range validations {
+		if err := validation.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s: %v", validation.name, err))
+		}
+	}
+
+	web.SetMode(web.ReleaseMode)
+	routes := st.newRouter(nil, listenerConfig{Name: "default"}).Routes()
+	if err := timeouts.validate(routes); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid handler timeouts: %v", err))
+	}
+	if err := headers.validate(routes); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid headers configuration: %v", err))
+	}
+	if err := limits.validate(routes); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid body size limits: %v", err))
+	}
+	return errs
+}
+
+// runValidateConfig implements `prober validate-config`, which checks the
+// environment and files the server would load, taking the serve flags, and
+// prints a JSON report, to validate a configuration in CI before rolling it
+// out.
+func runValidateConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	opts, err := parseServerFlags("validate-config", args, stderr)
+	if err != nil {
+		return 2
+	}
+	report := configReport{Errors: validateServerConfig(opts)}
+	if report.Errors == nil {
+		report.Errors = []string{}
+	}
+	report.Valid = len(report.Errors) == 0
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+// runVersion implements `prober version`, which prints what /version
+// answers.
+func runVersion(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("version", stderr)
+	short := flags.Bool("short", false, "print the version only")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	info := getVersionInfo(envSettings)
+	if *short {
+		fmt.Fprintln(stdout, info.Version)
+		return 0
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(info)
+	return 0
+}
@@ -0,0 +1,87 @@
+package prober
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMainCommands(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"help"}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "validate-config") {
+		t.Errorf("expected the usage with the commands, got %d %s", code, stdout.String())
+	}
+	stdout.Reset()
+	if code := Main([]string{"serve-forever"}, &stdout, &stderr); code != 2 || !strings.Contains(stderr.String(), `unknown command "serve-forever"`) {
+		t.Errorf("expected an unknown command to be refused, got %d %s", code, stderr.String())
+	}
+	if code := Main([]string{"--seed=forty-two"}, io.Discard, io.Discard); code != 2 {
+		t.Errorf("expected the flags without command to be the serve ones, got %d", code)
+	}
+	stderr.Reset()
+	if code := Main([]string{"serve", "--log-level=loud"}, io.Discard, &stderr); code != 2 || !strings.Contains(stderr.String(), "-log-level") {
+		t.Errorf("expected an invalid log level to be refused, got %d %s", code, stderr.String())
+	}
+
+	if code := Main([]string{"version", "--short"}, &stdout, io.Discard); code != 0 || stdout.String() != version+"\n" {
+		t.Errorf("expected the version, got %d %q", code, stdout.String())
+	}
+	stdout.Reset()
+	var info versionInfo
+	if code := Main([]string{"version"}, &stdout, io.Discard); code != 0 || json.Unmarshal(stdout.Bytes(), &info) != nil || info.GoVersion == "" {
+		t.Errorf("expected the version as JSON, got %d %s", code, stdout.String())
+	}
+}
+
+func TestRunCheckCommand(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer target.Close()
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--url", target.URL, "--expected-status=418"}, 0},
+		{[]string{"--url", target.URL}, 1},
+		{[]string{"--type=tcp", "--address", strings.TrimPrefix(target.URL, "http://")}, 0},
+		{[]string{"--type=tcp"}, 2},
+		{[]string{"--url", target.URL, "--expected-status=teapot"}, 2},
+	}
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		if code := runCheckCommand(test.args, &stdout, io.Discard); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d", test.args, test.code, code)
+		}
+		var result checkResult
+		if test.code != 2 && (json.Unmarshal(stdout.Bytes(), &result) != nil || result.Success != (test.code == 0)) {
+			t.Errorf("%v: unexpected result %s", test.args, stdout.String())
+		}
+	}
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	captureLogs(t)
+	var stdout bytes.Buffer
+	if code := runValidateConfig(nil, &stdout, io.Discard); code != 0 {
+		t.Errorf("expected the default configuration to be valid, got %d %s", code, stdout.String())
+	}
+
+	t.Setenv(maxBodySizeEnv, "lots")
+	t.Setenv(handlerTimeoutRoutesEnv, "/nope=1s")
+	t.Setenv(checksConfigEnv, "/nonexistent/checks.yaml")
+	stdout.Reset()
+	if code := runValidateConfig(nil, &stdout, io.Discard); code != 1 {
+		t.Errorf("expected an invalid configuration, got %d", code)
+	}
+	var report configReport
+	json.Unmarshal(stdout.Bytes(), &report)
+	if report.Valid || len(report.Errors) != 3 {
+		t.Errorf("expected the 3 errors reported, got %+v", report)
+	}
+}
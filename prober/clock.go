@@ -0,0 +1,166 @@
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ntpServerEnv = "NTP_SERVER"
+	clockSkewEnv = "CLOCK_SKEW"
+
+	ntpTimeout = 2 * time.Second
+	// ntpEpochOffset is the seconds from the NTP epoch, 1900, to the Unix
+	// epoch.
+	ntpEpochOffset = 2208988800
+)
+
+// clockMetrics expose the drift of the node clock.
+type clockMetrics struct {
+	ntpOffset prometheus.Gauge
+}
+
+func newClockMetrics(registry prometheus.Registerer) clockMetrics {
+	m := clockMetrics{
+		ntpOffset: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ntp_offset_seconds",
+			Help: "Offset of the local clock to the NTP server at the last /time request, positive when the local clock is behind.",
+		}),
+	}
+	registry.MustRegister(m.ntpOffset)
+	return m
+}
+
+// processStart carries the monotonic reading uptime is measured from,
+// which steps of the wall clock do not affect.
+var processStart = time.Now()
+
+type ntpResult struct {
+	Server        string  `json:"server"`
+	OffsetSeconds float64 `json:"offsetSeconds,omitempty"`
+	DelaySeconds  float64 `json:"delaySeconds,omitempty"`
+	Stratum       int     `json:"stratum,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+type clockInfo struct {
+	WallClock     time.Time  `json:"wallClock"`
+	UptimeSeconds float64    `json:"uptimeSeconds"`
+	InjectedSkew  string     `json:"injectedSkew,omitempty"`
+	NTP           *ntpResult `json:"ntp,omitempty"`
+}
+
+func ntpTime(data []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(data[0:4])
+	fraction := binary.BigEndian.Uint32(data[4:8])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+func putNTPTime(data []byte, t time.Time) {
+	binary.BigEndian.PutUint32(data[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(data[4:8], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+// queryNTP measures the offset of the local clock to an NTP server with a
+// single SNTP exchange, as in RFC 4330.
+func queryNTP(ctx context.Context, server string) (offset time.Duration, delay time.Duration, stratum int, err error) {
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ntpTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	request := make([]byte, 48)
+	// Leap indicator 0, version 4, client mode.
+	request[0] = 0x23
+	sent := time.Now()
+	putNTPTime(request[40:], sent)
+	if _, err := conn.Write(request); err != nil {
+		return 0, 0, 0, err
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	received := time.Now()
+	switch {
+	case err != nil:
+		return 0, 0, 0, err
+	case n < 48 || response[0]&0x07 != 4:
+		return 0, 0, 0, errors.New("invalid NTP response")
+	case response[1] == 0:
+		return 0, 0, 0, errors.New("NTP server sent a kiss of death")
+	case string(response[24:32]) != string(request[40:48]):
+		return 0, 0, 0, errors.New("NTP response does not match the request")
+	}
+
+	serverReceived, serverSent := ntpTime(response[32:40]), ntpTime(response[40:48])
+	offset = (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	delay = received.Sub(sent) - serverSent.Sub(serverReceived)
+	return offset, delay, int(response[1]), nil
+}
+
+// loadClockSkew reads CLOCK_SKEW, which can be negative unlike the other
+// durations of the environment.
+func loadClockSkew(config *settings) time.Duration {
+	value := config.get(clockSkewEnv)
+	if value == "" {
+		return 0
+	}
+	skew, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration value", "env", clockSkewEnv, "value", value)
+		return 0
+	}
+	return skew
+}
+
+// clockSkewMiddleware sends a Date header shifted by the skew, to test how
+// clients and caches cope with a server whose clock is off.
+func clockSkewMiddleware(skew time.Duration) web.HandlerFunc {
+	return func(c *web.Context) {
+		c.Header("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		c.Next()
+	}
+}
+
+// timeHandler answers GET /time with the wall clock, the monotonic uptime
+// and, with NTP_SERVER, the offset to the NTP server.
+func (st *state) timeHandler(c *web.Context) {
+	info := clockInfo{WallClock: time.Now(), UptimeSeconds: time.Since(processStart).Seconds()}
+	if skew := loadClockSkew(st.config); skew != 0 {
+		info.InjectedSkew = skew.String()
+	}
+
+	if server := st.config.get(ntpServerEnv); server != "" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), ntpTimeout)
+		defer cancel()
+		info.NTP = &ntpResult{Server: server}
+		offset, delay, stratum, err := queryNTP(ctx, server)
+		if err != nil {
+			info.NTP.Error = err.Error()
+			c.JSON(http.StatusBadGateway, info)
+			return
+		}
+		info.NTP.OffsetSeconds, info.NTP.DelaySeconds, info.NTP.Stratum = offset.Seconds(), delay.Seconds(), stratum
+		st.ntpOffset.Set(offset.Seconds())
+	}
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,128 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// startTestNTP answers SNTP requests with a clock offset from the local one.
+func startTestNTP(t *testing.T, offset time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		request := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			response := make([]byte, 48)
+			response[0], response[1] = 0x24, stratum
+			copy(response[24:32], request[40:48])
+			putNTPTime(response[32:], time.Now().Add(offset))
+			putNTPTime(response[40:], time.Now().Add(offset))
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQueryNTP(t *testing.T) {
+	server := startTestNTP(t, 3*time.Second, 2)
+
+	offset, delay, stratum, err := queryNTP(context.Background(), server)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if offset < 2900*time.Millisecond || offset > 3100*time.Millisecond {
+		t.Errorf("expected an offset of about 3s, got %v", offset)
+	}
+	if delay < 0 || delay > time.Second {
+		t.Errorf("expected a small delay, got %v", delay)
+	}
+	if stratum != 2 {
+		t.Errorf("expected stratum 2, got %d", stratum)
+	}
+
+	if _, _, _, err := queryNTP(context.Background(), startTestNTP(t, 0, 0)); err == nil {
+		t.Errorf("expected a kiss of death to fail")
+	}
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	now := time.Now()
+	data := make([]byte, 8)
+	putNTPTime(data, now)
+	if diff := ntpTime(data).Sub(now); diff < -time.Microsecond || diff > time.Microsecond {
+		t.Errorf("expected the same time back, got a difference of %v", diff)
+	}
+}
+
+func TestTimeHandler(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(ntpServerEnv, startTestNTP(t, -time.Minute, 1))
+	t.Setenv(clockSkewEnv, "-1h")
+
+	router := web.New()
+	router.Use(clockSkewMiddleware(loadClockSkew(st.config)))
+	router.GET("/time", st.timeHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/time", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var info clockInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.UptimeSeconds <= 0 || info.InjectedSkew != "-1h0m0s" {
+		t.Errorf("expected the uptime and skew, got %+v", info)
+	}
+	if info.NTP == nil || info.NTP.OffsetSeconds > -59 || info.NTP.OffsetSeconds < -61 {
+		t.Errorf("expected an offset of about -60s, got %+v", info.NTP)
+	}
+
+	date, err := http.ParseTime(w.Header().Get("Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skew := time.Until(date); skew > -59*time.Minute || skew < -61*time.Minute {
+		t.Errorf("expected a Date header 1h behind, got %v", skew)
+	}
+}
+
+func TestTimeHandlerNTPUnreachable(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv(ntpServerEnv, conn.LocalAddr().String())
+	t.Setenv(clockSkewEnv, "")
+
+	router := web.New()
+	router.GET("/time", st.timeHandler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/time", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", w.Code)
+	}
+}
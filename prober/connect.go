@@ -0,0 +1,110 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const defaultConnectTimeout = 2 * time.Second
+
+// Dial error classes, to tell a NetworkPolicy drop (timeout) from a closed
+// port (refused) or a missing route.
+const (
+	dialErrorTimeout     = "timeout"
+	dialErrorRefused     = "refused"
+	dialErrorReset       = "reset"
+	dialErrorUnreachable = "unreachable"
+	dialErrorDNS         = "dns"
+	dialErrorOther       = "other"
+)
+
+type connectResponse struct {
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	LocalAddr  string `json:"localAddr,omitempty"`
+	Success    bool   `json:"success"`
+	Latency    string `json:"latency"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+func classifyDialError(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return dialErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return dialErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialErrorRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return dialErrorReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return dialErrorUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return dialErrorTimeout
+	}
+	return dialErrorOther
+}
+
+// tcpConnect opens and closes a TCP connection to addr.
+func tcpConnect(ctx context.Context, addr string) (net.Addr, net.Addr, time.Duration, error) {
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, nil, latency, err
+	}
+	defer conn.Close()
+	return conn.RemoteAddr(), conn.LocalAddr(), latency, nil
+}
+
+// connectRequest answers GET /connect/:host/:port?timeout=2s with 200 when
+// the connection was established and 502 otherwise.
+func connectRequest(c *web.Context) {
+	timeout := defaultConnectTimeout
+	if value := c.Query("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid timeout value"})
+			return
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	response := connectResponse{Host: c.Param("host"), Port: c.Param("port")}
+	remote, local, latency, err := tcpConnect(ctx, net.JoinHostPort(response.Host, response.Port))
+	response.Latency = latency.String()
+	if err != nil {
+		response.Error = err.Error()
+		response.ErrorClass = classifyDialError(err)
+		c.JSON(http.StatusBadGateway, response)
+		return
+	}
+	response.Success = true
+	response.RemoteAddr = remote.String()
+	response.LocalAddr = local.String()
+	c.JSON(http.StatusOK, response)
+}
+
+// tcpCheck succeeds when a TCP connection to the address is established.
+func tcpCheck(ctx context.Context, config checkConfig) checkResult {
+	if _, _, _, err := tcpConnect(ctx, config.Address); err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	return checkResult{Success: true}
+}
@@ -0,0 +1,71 @@
+package prober
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestConnectRequest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, openPort, _ := net.SplitHostPort(listener.Addr().String())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/connect/:host/:port", connectRequest)
+
+	tests := []struct {
+		path   string
+		status int
+		class  string
+	}{
+		{"/connect/127.0.0.1/" + openPort, http.StatusOK, ""},
+		{"/connect/127.0.0.1/" + closedPort, http.StatusBadGateway, dialErrorRefused},
+		{"/connect/missing.invalid/80", http.StatusBadGateway, dialErrorDNS},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, w.Code)
+		}
+		var response connectResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if response.ErrorClass != test.class {
+			t.Errorf("%s: expected error class %q, got %+v", test.path, test.class, response)
+		}
+	}
+}
+
+func TestConnectRequestInvalidTimeout(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/connect/:host/:port", connectRequest)
+
+	req, _ := http.NewRequest("GET", "/connect/127.0.0.1/80?timeout=soon", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
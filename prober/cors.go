@@ -0,0 +1,201 @@
+package prober
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	corsAllowedOriginsEnv   = "CORS_ALLOWED_ORIGINS"
+	corsAllowedMethodsEnv   = "CORS_ALLOWED_METHODS"
+	corsAllowedHeadersEnv   = "CORS_ALLOWED_HEADERS"
+	corsExposedHeadersEnv   = "CORS_EXPOSED_HEADERS"
+	corsMaxAgeEnv           = "CORS_MAX_AGE"
+	corsAllowCredentialsEnv = "CORS_ALLOW_CREDENTIALS"
+
+	defaultCORSMethods = "GET,HEAD,POST,PUT,PATCH,DELETE"
+	defaultCORSMaxAge  = 10 * time.Minute
+)
+
+// corsConfig is what browsers are allowed to do cross-origin. Origins are
+// exact, "*" or wildcard subdomains like "https://*.example.com", and the
+// requested headers are all allowed when none are set.
+type corsConfig struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	exposed     []string
+	maxAge      time.Duration
+	credentials bool
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func loadCORSConfig(config *settings) corsConfig {
+	cors := corsConfig{
+		origins:     splitList(config.get(corsAllowedOriginsEnv)),
+		methods:     splitList(strings.ToUpper(config.getString(corsAllowedMethodsEnv, defaultCORSMethods))),
+		headers:     splitList(config.get(corsAllowedHeadersEnv)),
+		exposed:     splitList(config.get(corsExposedHeadersEnv)),
+		maxAge:      config.getDuration(corsMaxAgeEnv, defaultCORSMaxAge),
+		credentials: config.getBool(corsAllowCredentialsEnv, false),
+	}
+	for i, header := range cors.headers {
+		cors.headers[i] = http.CanonicalHeaderKey(header)
+	}
+	return cors
+}
+
+func (config corsConfig) enabled() bool {
+	return len(config.origins) > 0
+}
+
+func (config corsConfig) allowOrigin(origin string) bool {
+	for _, allowed := range config.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsDecision is what prober answers to a request from an origin, the
+// Allow headers being empty when it is refused.
+type corsDecision struct {
+	Origin           string   `json:"origin"`
+	Method           string   `json:"method,omitempty"`
+	Headers          []string `json:"headers,omitempty"`
+	Allowed          bool     `json:"allowed"`
+	Reason           string   `json:"reason,omitempty"`
+	AllowOrigin      string   `json:"allowOrigin,omitempty"`
+	AllowMethods     []string `json:"allowMethods,omitempty"`
+	AllowHeaders     []string `json:"allowHeaders,omitempty"`
+	ExposeHeaders    []string `json:"exposeHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty"`
+	MaxAge           int      `json:"maxAgeSeconds,omitempty"`
+}
+
+// decide applies the configuration to an origin and, for preflights, the
+// method and headers requested.
+func (config corsConfig) decide(origin string, method string, headers []string) corsDecision {
+	decision := corsDecision{Origin: origin, Method: method, Headers: headers}
+	switch {
+	case !config.enabled():
+		decision.Reason = "CORS disabled"
+		return decision
+	case origin == "":
+		decision.Reason = "no origin"
+		return decision
+	case !config.allowOrigin(origin):
+		decision.Reason = "origin not allowed"
+		return decision
+	case method != "" && !slices.Contains(config.methods, strings.ToUpper(method)):
+		decision.Reason = "method not allowed"
+		return decision
+	}
+	for _, header := range headers {
+		if len(config.headers) > 0 && !slices.Contains(config.headers, http.CanonicalHeaderKey(header)) {
+			decision.Reason = "header " + header + " not allowed"
+			return decision
+		}
+	}
+
+	decision.Allowed = true
+	// Browsers refuse credentials with the "*" origin, so the origin is
+	// reflected.
+	decision.AllowOrigin = origin
+	if slices.Contains(config.origins, "*") && !config.credentials {
+		decision.AllowOrigin = "*"
+	}
+	decision.AllowMethods = config.methods
+	decision.AllowHeaders = config.headers
+	if len(decision.AllowHeaders) == 0 {
+		decision.AllowHeaders = headers
+	}
+	decision.ExposeHeaders = config.exposed
+	decision.AllowCredentials = config.credentials
+	decision.MaxAge = int(config.maxAge.Seconds())
+	return decision
+}
+
+func requestedHeaders(c *web.Context) []string {
+	return splitList(c.GetHeader("Access-Control-Request-Headers"))
+}
+
+// corsMiddleware adds the CORS headers to the answers to allowed origins
+// and answers the preflights itself, with 204 when allowed and 403 when not.
+func corsMiddleware(config corsConfig) web.HandlerFunc {
+	return func(c *web.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		requestMethod := c.GetHeader("Access-Control-Request-Method")
+		preflight := c.Request.Method == http.MethodOptions && requestMethod != ""
+		if !preflight {
+			decision := config.decide(origin, "", nil)
+			if decision.Allowed {
+				c.Header("Access-Control-Allow-Origin", decision.AllowOrigin)
+				if decision.AllowCredentials {
+					c.Header("Access-Control-Allow-Credentials", "true")
+				}
+				if len(decision.ExposeHeaders) > 0 {
+					c.Header("Access-Control-Expose-Headers", strings.Join(decision.ExposeHeaders, ", "))
+				}
+			}
+			c.Next()
+			return
+		}
+
+		decision := config.decide(origin, requestMethod, requestedHeaders(c))
+		if !decision.Allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, web.H{"error": "CORS request not allowed", "detail": decision.Reason})
+			return
+		}
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Origin", decision.AllowOrigin)
+		c.Header("Access-Control-Allow-Methods", strings.Join(decision.AllowMethods, ", "))
+		if len(decision.AllowHeaders) > 0 {
+			c.Header("Access-Control-Allow-Headers", strings.Join(decision.AllowHeaders, ", "))
+		}
+		if decision.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if decision.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(decision.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// corsHandler answers GET /cors?origin=&method=&headers= with what a
+// browser from the origin, the Origin header by default, would be allowed
+// to do, without needing a browser.
+func corsHandler(config corsConfig) web.HandlerFunc {
+	return func(c *web.Context) {
+		origin := c.DefaultQuery("origin", c.GetHeader("Origin"))
+		c.JSON(http.StatusOK, config.decide(origin, c.Query("method"), splitList(c.Query("headers"))))
+	}
+}
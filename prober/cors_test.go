@@ -0,0 +1,97 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	t.Setenv(corsAllowedOriginsEnv, "https://dashboard.example.com, https://*.test.example.com")
+	t.Setenv(corsAllowedMethodsEnv, "GET,POST")
+	t.Setenv(corsAllowedHeadersEnv, "content-type,x-token")
+	t.Setenv(corsMaxAgeEnv, "1h")
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+
+	tests := []struct {
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"https://dashboard.example.com", "POST", "Content-Type, X-Token", http.StatusNoContent},
+		{"https://a.test.example.com", "GET", "", http.StatusNoContent},
+		{"https://test.example.com", "GET", "", http.StatusForbidden},
+		{"https://evil.example.org", "GET", "", http.StatusForbidden},
+		{"https://dashboard.example.com", "DELETE", "", http.StatusForbidden},
+		{"https://dashboard.example.com", "POST", "Authorization", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		// /counters has no OPTIONS route of its own.
+		req, _ := http.NewRequest("OPTIONS", "/counters", nil)
+		req.Header.Set("Origin", test.origin)
+		req.Header.Set("Access-Control-Request-Method", test.method)
+		if test.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", test.headers)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.origin, test.method, test.status, w.Code)
+		}
+		if test.status == http.StatusNoContent {
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.origin {
+				t.Errorf("%s: expected the origin to be allowed, got %q", test.origin, got)
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != "3600" {
+				t.Errorf("%s: expected a max age of 3600, got %q", test.origin, got)
+			}
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/echo", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.example.com" {
+		t.Errorf("expected the simple request to be allowed, got %d %q", w.Code, w.Header())
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	config := corsConfig{origins: []string{"*"}, methods: []string{"GET"}}
+	if decision := config.decide("https://any.example.com", "GET", []string{"X-Custom"}); decision.AllowOrigin != "*" || len(decision.AllowHeaders) != 1 {
+		t.Errorf("expected any origin and the requested headers, got %+v", decision)
+	}
+	config.credentials = true
+	if decision := config.decide("https://any.example.com", "", nil); decision.AllowOrigin != "https://any.example.com" {
+		t.Errorf("expected the origin reflected with credentials, got %+v", decision)
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	t.Setenv(corsAllowedOriginsEnv, "https://dashboard.example.com")
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+
+	for origin, allowed := range map[string]bool{"https://dashboard.example.com": true, "https://evil.example.org": false} {
+		req, _ := http.NewRequest("GET", "/cors?method=PUT&headers=Content-Type&origin="+origin, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var decision corsDecision
+		json.Unmarshal(w.Body.Bytes(), &decision)
+		if w.Code != http.StatusOK || decision.Allowed != allowed || decision.Origin != origin {
+			t.Errorf("%s: expected allowed %t, got %d %s", origin, allowed, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package prober
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// counterStore holds named counters shared by the API, the mock templates
+// and the scripts, to model stateful behaviors like a warm-up failing the
+// first requests. Unknown counters read as 0.
+type counterStore struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (s *counterStore) add(name string, delta int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] += delta
+	return s.values[name]
+}
+
+func (s *counterStore) get(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[name]
+}
+
+func (s *counterStore) reset(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, name)
+}
+
+func (s *counterStore) resetAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]int64)
+}
+
+func (s *counterStore) snapshot() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]int64, len(s.values))
+	for name, value := range s.values {
+		values[name] = value
+	}
+	return values
+}
+
+type counterValue struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+func (st *state) listCounters(c *web.Context) {
+	values := st.counters.snapshot()
+	list := make([]counterValue, 0, len(values))
+	for name, value := range values {
+		list = append(list, counterValue{Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, web.H{"counters": list})
+}
+
+func (st *state) getCounter(c *web.Context) {
+	c.JSON(http.StatusOK, counterValue{Name: c.Param("name"), Value: st.counters.get(c.Param("name"))})
+}
+
+// incrementCounter adds the by query parameter, 1 by default and possibly
+// negative, to the counter.
+func (st *state) incrementCounter(c *web.Context) {
+	by, err := strconv.ParseInt(c.DefaultQuery("by", "1"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid increment " + c.Query("by")})
+		return
+	}
+	c.JSON(http.StatusOK, counterValue{Name: c.Param("name"), Value: st.counters.add(c.Param("name"), by)})
+}
+
+func (st *state) resetCounter(c *web.Context) {
+	st.counters.reset(c.Param("name"))
+	c.JSON(http.StatusOK, counterValue{Name: c.Param("name")})
+}
+
+func (st *state) resetCounters(c *web.Context) {
+	st.counters.resetAll()
+	c.JSON(http.StatusOK, web.H{"message": "Counters reset"})
+}
@@ -0,0 +1,91 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestCountersAPI(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string) counterValue {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		var value counterValue
+		if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil || w.Code != http.StatusOK {
+			t.Errorf("%s %s: unexpected answer %d %s", method, path, w.Code, w.Body.String())
+		}
+		return value
+	}
+
+	if value := request(http.MethodGet, "/counters/logins"); value.Value != 0 {
+		t.Errorf("expected an unknown counter to read 0, got %d", value.Value)
+	}
+	request(http.MethodPost, "/counters/logins/increment")
+	if value := request(http.MethodPost, "/counters/logins/increment?by=4"); value.Value != 5 {
+		t.Errorf("expected 5 after two increments, got %d", value.Value)
+	}
+	if value := request(http.MethodPost, "/counters/logins/increment?by=-2"); value.Value != 3 {
+		t.Errorf("expected a negative increment to decrement, got %d", value.Value)
+	}
+	request(http.MethodPost, "/counters/errors/increment")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/counters", nil))
+	if w.Body.String() != `{"counters":[{"name":"errors","value":1},{"name":"logins","value":3}]}` {
+		t.Errorf("unexpected counters %s", w.Body.String())
+	}
+
+	if value := request(http.MethodPost, "/counters/logins/reset"); value.Value != 0 || st.counters.get("logins") != 0 {
+		t.Errorf("expected the counter to be reset, got %d", st.counters.get("logins"))
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/counters/logins/increment?by=many", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid increment, got %d", w.Code)
+	}
+}
+
+func TestMockCounters(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	configured, err := loadMocksConfig(writeChecksConfig(t, `
+mocks:
+  - name: warming-up
+    request:
+      path: /api
+    increment: [calls]
+    failUntil:
+      counter: calls
+      value: 3
+    response:
+      body: 'call {{ counter "calls" }}, visit {{ increment "visits" }}'
+`), st.counters)
+	if err != nil {
+		t.Fatalf("expected valid mocks, got %v", err)
+	}
+	st.mocks.set(configured)
+	router := st.newRouter(nil, listenerConfig{})
+
+	var statuses []int
+	var body string
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+		statuses = append(statuses, w.Code)
+		body = w.Body.String()
+	}
+	if statuses[0] != http.StatusServiceUnavailable || statuses[1] != http.StatusServiceUnavailable || statuses[2] != http.StatusOK || statuses[3] != http.StatusOK {
+		t.Errorf("expected two failures while warming up, got %v", statuses)
+	}
+	if body != "call 4, visit 2" {
+		t.Errorf("expected the counters in the template, got %q", body)
+	}
+}
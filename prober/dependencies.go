@@ -0,0 +1,110 @@
+package prober
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/jackc/pgx/v5"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const readinessChecksEnv = "READINESS_CHECKS"
+
+// The DSN of each dependency check carries its TLS options: rediss:// for
+// Redis, sslmode for PostgreSQL and tls for MySQL.
+
+// redisCheck sends a PING to the redis:// or rediss:// URL.
+func redisCheck(ctx context.Context, config checkConfig) checkResult {
+	options, err := redis.ParseURL(config.DSN)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	options.MaxRetries = -1
+	client := redis.NewClient(options)
+	defer client.Close()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	return checkResult{Success: true}
+}
+
+// postgresCheck connects and authenticates to the postgres:// URL or
+// key=value DSN, then pings the server.
+func postgresCheck(ctx context.Context, config checkConfig) checkResult {
+	conn, err := pgx.Connect(ctx, config.DSN)
+	if err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	defer conn.Close(context.Background())
+
+	if err := conn.Ping(ctx); err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	return checkResult{Success: true}
+}
+
+// mysqlCheck connects and authenticates with the go-sql-driver DSN, like
+// "user:password@tcp(db:3306)/app?tls=true".
+func mysqlCheck(ctx context.Context, config checkConfig) checkResult {
+	dsn, err := mysql.ParseDSN(config.DSN)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	return checkResult{Success: true}
+}
+
+// validateDSN rejects a malformed DSN when the configuration is loaded.
+func validateDSN(checkType string, dsn string) error {
+	var err error
+	switch checkType {
+	case checkTypeRedis:
+		_, err = redis.ParseURL(dsn)
+	case checkTypePostgres:
+		_, err = pgx.ParseConfig(dsn)
+	case checkTypeMySQL:
+		_, err = mysql.ParseDSN(dsn)
+	case checkTypeAMQP:
+		_, err = amqp.ParseURI(dsn)
+	}
+	return err
+}
+
+// readinessGate fails /readiness with 503 while one of the READINESS_CHECKS
+// outbound checks isn't passing, modeling a pod that is only ready when its
+// dependencies are reachable.
+func (st *state) readinessGate() web.HandlerFunc {
+	var names []string
+	for _, name := range strings.Split(st.config.get(readinessChecksEnv), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return func(c *web.Context) {
+		if len(names) == 0 {
+			c.Next()
+			return
+		}
+		if failing := st.targetChecker.failing(names); len(failing) > 0 {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, web.H{"error": "Dependencies not ready", "checks": failing})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,147 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// startTestRedis answers PING with PONG, HELLO with an error so clients
+// fall back to RESP2, and anything else with OK.
+func startTestRedis(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					var args []string
+					for i := 0; i < n; i++ {
+						reader.ReadString('\n')
+						arg, _ := reader.ReadString('\n')
+						args = append(args, strings.TrimSpace(arg))
+					}
+					switch strings.ToUpper(args[0]) {
+					case "PING":
+						conn.Write([]byte("+PONG\r\n"))
+					case "HELLO":
+						conn.Write([]byte("-ERR unknown command 'HELLO'\r\n"))
+					default:
+						conn.Write([]byte("+OK\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestDependencyChecks(t *testing.T) {
+	redisAddr := startTestRedis(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		config  checkConfig
+		success bool
+	}{
+		{checkConfig{Type: checkTypeRedis, DSN: "redis://" + redisAddr + "/0"}, true},
+		{checkConfig{Type: checkTypeRedis, DSN: "redis://" + closedAddr}, false},
+		{checkConfig{Type: checkTypePostgres, DSN: "postgres://prober:secret@" + closedAddr + "/app?sslmode=disable"}, false},
+		{checkConfig{Type: checkTypeMySQL, DSN: "prober:secret@tcp(" + closedAddr + ")/app"}, false},
+	}
+
+	for _, test := range tests {
+		test.config.Name = test.config.Type
+		if err := test.config.validate(); err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.config.DSN, err)
+		}
+		test.config.Timeout = time.Second
+		result := runCheck(context.Background(), test.config)
+
+		if result.Success != test.success {
+			t.Errorf("expected success %v for %s, got %+v", test.success, test.config.DSN, result)
+		}
+		if !test.success && result.ErrorClass != dialErrorRefused {
+			t.Errorf("expected a refused connection for %s, got %+v", test.config.DSN, result)
+		}
+	}
+}
+
+func TestDependencyChecksValidation(t *testing.T) {
+	invalid := []checkConfig{
+		{Name: "redis", Type: checkTypeRedis},
+		{Name: "redis", Type: checkTypeRedis, DSN: "http://cache:6379"},
+		{Name: "postgres", Type: checkTypePostgres, DSN: "postgres://db:notaport/app"},
+		{Name: "mysql", Type: checkTypeMySQL, DSN: "prober@db:3306"},
+	}
+	for _, config := range invalid {
+		if err := config.validate(); err == nil {
+			t.Errorf("expected an error for %s dsn %q", config.Type, config.DSN)
+		}
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(readinessChecksEnv, "db, cache")
+	st := newState(Options{})
+	db := checkConfig{Name: "db", Type: checkTypeTCP, FailureThreshold: 1, SuccessThreshold: 1}
+	cache := checkConfig{Name: "cache", Type: checkTypeTCP, FailureThreshold: 1, SuccessThreshold: 1}
+	st.targetChecker = st.newChecker([]checkConfig{db, cache})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/readiness", st.readinessGate(), st.probeHandler(readinessProbeDelayEnv, "readiness"))
+
+	serve := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/readiness", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"checks":["db","cache"]`) {
+		t.Errorf("expected 503 before any check ran, got %d: %s", w.Code, w.Body.String())
+	}
+
+	st.targetChecker.record(db, checkResult{Success: true})
+	st.targetChecker.record(cache, checkResult{Success: false})
+	if w := serve(); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"checks":["cache"]`) {
+		t.Errorf("expected 503 with the failing cache, got %d: %s", w.Code, w.Body.String())
+	}
+
+	st.targetChecker.record(cache, checkResult{Success: true})
+	if w := serve(); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
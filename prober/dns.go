@@ -0,0 +1,141 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	dnsAddrEnv   = "DNS_ADDR"
+	dnsConfigEnv = "DNS_CONFIG"
+)
+
+// dnsConfig is loaded from DNS_CONFIG. Records use the zone file syntax,
+// e.g. "db.example.internal. 30 IN A 10.0.0.5".
+type dnsConfig struct {
+	Latency      time.Duration `yaml:"latency"`
+	FailureRate  float64       `yaml:"failureRate"`
+	FailureRcode string        `yaml:"failureRcode"`
+	Records      []string      `yaml:"records"`
+}
+
+type dnsStub struct {
+	latency      time.Duration
+	failureRate  float64
+	failureRcode int
+	records      map[string][]dns.RR
+	random       *seededRand
+}
+
+func loadDNSConfig(path string) (dnsConfig, error) {
+	var config dnsConfig
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	return config, err
+}
+
+func newDNSStub(config dnsConfig, random *seededRand) (*dnsStub, error) {
+	if config.FailureRate < 0 || config.FailureRate > 1 {
+		return nil, fmt.Errorf("failureRate must be between 0 and 1")
+	}
+
+	stub := &dnsStub{
+		latency:      config.Latency,
+		failureRate:  config.FailureRate,
+		failureRcode: dns.RcodeServerFailure,
+		records:      make(map[string][]dns.RR),
+		random:       random,
+	}
+	if config.FailureRcode != "" {
+		rcode, ok := dns.StringToRcode[strings.ToUpper(config.FailureRcode)]
+		if !ok {
+			return nil, fmt.Errorf("unknown failureRcode %q", config.FailureRcode)
+		}
+		stub.failureRcode = rcode
+	}
+
+	for _, record := range config.Records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %q: %w", record, err)
+		}
+		if rr == nil {
+			continue
+		}
+		name := strings.ToLower(rr.Header().Name)
+		stub.records[name] = append(stub.records[name], rr)
+	}
+	return stub, nil
+}
+
+// ServeDNS answers from the configured records. Names without records get
+// NXDOMAIN and known names queried for another type get an empty answer.
+func (s *dnsStub) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+	}
+
+	resp := new(dns.Msg)
+	if s.failureRate > 0 && s.random.Float64() < s.failureRate {
+		resp.SetRcode(req, s.failureRcode)
+		w.WriteMsg(resp)
+		return
+	}
+
+	resp.SetReply(req)
+	resp.Authoritative = true
+	for _, question := range req.Question {
+		records, ok := s.records[strings.ToLower(question.Name)]
+		if !ok {
+			resp.SetRcode(req, dns.RcodeNameError)
+			continue
+		}
+		for _, rr := range records {
+			if question.Qtype == dns.TypeANY || rr.Header().Rrtype == question.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+	}
+	w.WriteMsg(resp)
+}
+
+// dnsServer serves the stub over both UDP and TCP.
+type dnsServer struct {
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+func newDNSServer(addr string, handler dns.Handler) *dnsServer {
+	return &dnsServer{
+		udp: &dns.Server{Addr: addr, Net: "udp", Handler: handler},
+		tcp: &dns.Server{Addr: addr, Net: "tcp", Handler: handler},
+	}
+}
+
+func (s *dnsServer) ListenAndServe() error {
+	errs := make(chan error, 2)
+	go func() { errs <- s.udp.ListenAndServe() }()
+	go func() { errs <- s.tcp.ListenAndServe() }()
+	return <-errs
+}
+
+func (s *dnsServer) Shutdown(ctx context.Context) error {
+	udpErr := s.udp.ShutdownContext(ctx)
+	tcpErr := s.tcp.ShutdownContext(ctx)
+	if udpErr != nil {
+		return udpErr
+	}
+	return tcpErr
+}
@@ -0,0 +1,90 @@
+package prober
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func startTestDNS(t *testing.T, config dnsConfig) string {
+	t.Helper()
+
+	stub, err := newDNSStub(config, newSeededRand(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: conn, Handler: stub, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	<-started
+	return conn.LocalAddr().String()
+}
+
+func queryTestDNS(t *testing.T, addr string, name string, qtype uint16) (*dns.Msg, time.Duration) {
+	t.Helper()
+
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	resp, rtt, err := new(dns.Client).Exchange(req, addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resp, rtt
+}
+
+func TestDNSStubRecords(t *testing.T) {
+	addr := startTestDNS(t, dnsConfig{Records: []string{
+		"db.example.internal. 30 IN A 10.0.0.5",
+		"db.example.internal. 30 IN AAAA fd00::5",
+	}})
+
+	resp, _ := queryTestDNS(t, addr, "DB.example.internal.", dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("expected one answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); a.A.String() != "10.0.0.5" {
+		t.Errorf("expected 10.0.0.5, got %s", a.A)
+	}
+
+	resp, _ = queryTestDNS(t, addr, "missing.example.internal.", dns.TypeA)
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDNSStubFaults(t *testing.T) {
+	addr := startTestDNS(t, dnsConfig{
+		Latency:      100 * time.Millisecond,
+		FailureRate:  1,
+		FailureRcode: "refused",
+		Records:      []string{"db.example.internal. 30 IN A 10.0.0.5"},
+	})
+
+	resp, rtt := queryTestDNS(t, addr, "db.example.internal.", dns.TypeA)
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("expected REFUSED, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if rtt < 100*time.Millisecond {
+		t.Errorf("expected latency of at least 100ms, got %v", rtt)
+	}
+}
+
+func TestNewDNSStubInvalid(t *testing.T) {
+	invalid := []dnsConfig{
+		{FailureRate: 1.5},
+		{FailureRcode: "BROKEN"},
+		{Records: []string{"db.example.internal. IN A not-an-ip"}},
+	}
+	for _, config := range invalid {
+		if _, err := newDNSStub(config, newSeededRand(1)); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package prober
+
+import (
+	"net/http"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+type echoResponse struct {
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      map[string][]string `json:"query"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remoteAddr"`
+	IPFamily   string              `json:"ipFamily"`
+	TLS        bool                `json:"tls"`
+	Headers    map[string][]string `json:"headers"`
+}
+
+func (st *state) echoRequest(c *web.Context) {
+	c.JSON(http.StatusOK, echoResponse{
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		Query:      c.Request.URL.Query(),
+		Proto:      c.Request.Proto,
+		Host:       c.Request.Host,
+		RemoteAddr: c.Request.RemoteAddr,
+		IPFamily:   connFamily(c.Request),
+		TLS:        c.Request.TLS != nil,
+		Headers:    st.sensitiveHeaders.redacted(c.Request.Header),
+	})
+}
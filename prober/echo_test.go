@@ -0,0 +1,40 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestEchoRequest(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Any("/echo", st.echoRequest)
+
+	req, _ := http.NewRequest("PUT", "/echo?foo=bar", nil)
+	req.Header.Set("X-Test", "prober")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var echo echoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &echo); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if echo.Method != "PUT" || echo.Path != "/echo" || echo.Proto != "HTTP/1.1" {
+		t.Errorf("unexpected request line echoed: %+v", echo)
+	}
+	if echo.Query["foo"][0] != "bar" {
+		t.Errorf("expected query foo=bar, got %v", echo.Query)
+	}
+	if echo.Headers["X-Test"][0] != "prober" {
+		t.Errorf("expected header X-Test=prober, got %v", echo.Headers)
+	}
+}
@@ -0,0 +1,100 @@
+package prober
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const egressConfigEnv = "EGRESS_CONFIG"
+
+type egressResult struct {
+	checkResult
+	ExpectFailure bool `json:"expectFailure,omitempty"`
+	Passed        bool `json:"passed"`
+}
+
+type egressReport struct {
+	Passed   bool           `json:"passed"`
+	Total    int            `json:"total"`
+	Failed   int            `json:"failed"`
+	Skipped  int            `json:"skipped"`
+	Duration string         `json:"duration"`
+	Results  []egressResult `json:"results"`
+}
+
+// runEgressSuite runs every check concurrently. A check passes when its
+// outcome matches expectFailure, so egress that a NetworkPolicy must block
+// can be asserted as well. Skipped checks, like ICMP without permission,
+// don't fail the suite.
+func runEgressSuite(ctx context.Context, checks []checkConfig) egressReport {
+	start := time.Now()
+	results := make([]egressResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check checkConfig) {
+			defer wg.Done()
+			result := runCheck(ctx, check)
+			results[i] = egressResult{
+				checkResult:   result,
+				ExpectFailure: check.ExpectFailure,
+				Passed:        result.Skipped || result.Success != check.ExpectFailure,
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := egressReport{Passed: true, Total: len(results), Results: results}
+	for _, result := range results {
+		if result.Skipped {
+			report.Skipped++
+		}
+		if !result.Passed {
+			report.Failed++
+			report.Passed = false
+		}
+	}
+	report.Duration = time.Since(start).String()
+	return report
+}
+
+// egressRunHandler answers POST /egress/run with 200 when the suite passed
+// and 502 otherwise. checks restricts the run to a comma separated list of
+// check names.
+func (st *state) egressRunHandler(c *web.Context) {
+	if len(st.egressChecks) == 0 {
+		c.JSON(http.StatusNotFound, web.H{"error": "Egress checks are not configured"})
+		return
+	}
+
+	checks := st.egressChecks
+	if names := c.Query("checks"); names != "" {
+		checks = nil
+		for _, name := range strings.Split(names, ",") {
+			found := false
+			for _, check := range st.egressChecks {
+				if check.Name == name {
+					checks = append(checks, check)
+					found = true
+				}
+			}
+			if !found {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Unknown egress check " + name})
+				return
+			}
+		}
+	}
+
+	report := runEgressSuite(c.Request.Context(), checks)
+	if !report.Passed {
+		c.JSON(http.StatusBadGateway, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestEgressRun(t *testing.T) {
+	st := newState(Options{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	dnsAddr := startTestDNS(t, dnsConfig{Records: []string{"db.example.internal. 30 IN A 10.0.0.5"}})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	st.egressChecks = []checkConfig{
+		{Name: "api", Type: checkTypeHTTP, URL: target.URL, Timeout: time.Second},
+		{Name: "api-tcp", Type: checkTypeTCP, Address: target.Listener.Addr().String(), Timeout: time.Second},
+		{Name: "db-dns", Type: checkTypeDNS, Host: "db.example.internal", RecordType: "A", Server: dnsAddr, Timeout: time.Second},
+		{Name: "blocked", Type: checkTypeTCP, Address: closedAddr, ExpectFailure: true, Timeout: time.Second},
+		{Name: "loopback-ping", Type: checkTypeICMP, Host: "127.0.0.1", Timeout: time.Second},
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.POST("/egress/run", st.egressRunHandler)
+
+	req, _ := http.NewRequest("POST", "/egress/run", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var report egressReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if !report.Passed || report.Total != 5 || report.Failed != 0 {
+		t.Errorf("expected every check to pass, got %+v", report)
+	}
+	if blocked := report.Results[3]; blocked.Success || blocked.ErrorClass != dialErrorRefused {
+		t.Errorf("expected the blocked check to be refused, got %+v", blocked)
+	}
+
+	st.egressChecks[3].ExpectFailure = false
+	req, _ = http.NewRequest("POST", "/egress/run?checks=blocked", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/egress/run?checks=unknown", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestEgressRunNotConfigured(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.POST("/egress/run", st.egressRunHandler)
+
+	req, _ := http.NewRequest("POST", "/egress/run", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
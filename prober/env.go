@@ -0,0 +1,146 @@
+package prober
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// settings are looked up by the name of their environment variable, in the
+// values of Options.Config when set and in the environment otherwise, so a
+// program embedding a Server can configure it without touching the
+// environment.
+type settings struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// envSettings reads the environment, for the commands configured by their
+// flags rather than a Server.
+var envSettings = &settings{}
+
+func newSettings(values map[string]string) *settings {
+	if values == nil {
+		return envSettings
+	}
+	return &settings{values: maps.Clone(values)}
+}
+
+func (s *settings) lookup(name string) (string, bool) {
+	if s.values == nil {
+		return os.LookupEnv(name)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.values[name]
+	return value, exists
+}
+
+func (s *settings) get(name string) string {
+	value, _ := s.lookup(name)
+	return value
+}
+
+// set changes a setting at runtime, like the probe delays posted to /config.
+func (s *settings) set(name string, value string) {
+	if s.values == nil {
+		os.Setenv(name, value)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] = value
+}
+
+func (s *settings) unset(name string) {
+	if s.values == nil {
+		os.Unsetenv(name)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, name)
+}
+
+func (s *settings) getString(name string, defaultValue string) string {
+	value, exists := s.lookup(name)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func (s *settings) getBool(name string, defaultValue bool) bool {
+	value, exists := s.lookup(name)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean value", "env", name, "error", err)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (s *settings) getDuration(name string, defaultValue time.Duration) time.Duration {
+	value, exists := s.lookup(name)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		slog.Warn("Invalid duration value", "env", name, "value", value)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (s *settings) getInt(name string, defaultValue int) int {
+	value, exists := s.lookup(name)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		slog.Warn("Invalid integer value", "env", name, "value", value)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (s *settings) getFloat(name string, defaultValue float64) float64 {
+	value, exists := s.lookup(name)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		slog.Warn("Invalid number value", "env", name, "value", value)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvString(name string, defaultValue string) string {
+	return envSettings.getString(name, defaultValue)
+}
+
+func getEnvBool(name string, defaultValue bool) bool {
+	return envSettings.getBool(name, defaultValue)
+}
+
+func getEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	return envSettings.getDuration(name, defaultValue)
+}
+
+func getEnvInt(name string, defaultValue int) int {
+	return envSettings.getInt(name, defaultValue)
+}
+
+func getEnvFloat(name string, defaultValue float64) float64 {
+	return envSettings.getFloat(name, defaultValue)
+}
@@ -0,0 +1,219 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	kubeEventsEnv = "KUBE_EVENTS"
+	podUIDEnv     = "POD_UID"
+
+	eventTypeNormal  = "Normal"
+	eventTypeWarning = "Warning"
+
+	eventsQueueSize = 100
+	eventsComponent = "prober"
+)
+
+// eventMetrics count the Kubernetes events emitted.
+type eventMetrics struct {
+	kubeEventsTotal *prometheus.CounterVec
+}
+
+func newEventMetrics(registry prometheus.Registerer) eventMetrics {
+	m := eventMetrics{
+		kubeEventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_events_total",
+			Help: "Kubernetes Events emitted on the pod by result.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.kubeEventsTotal)
+	return m
+}
+
+type eventObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type eventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+}
+
+// kubeEvent is a core/v1 Event.
+type kubeEvent struct {
+	APIVersion         string               `json:"apiVersion"`
+	Kind               string               `json:"kind"`
+	Metadata           kubeObjectMeta       `json:"metadata"`
+	InvolvedObject     eventObjectReference `json:"involvedObject"`
+	Reason             string               `json:"reason"`
+	Message            string               `json:"message"`
+	Type               string               `json:"type"`
+	Source             eventSource          `json:"source"`
+	FirstTimestamp     time.Time            `json:"firstTimestamp"`
+	LastTimestamp      time.Time            `json:"lastTimestamp"`
+	Count              int                  `json:"count"`
+	ReportingComponent string               `json:"reportingComponent"`
+	ReportingInstance  string               `json:"reportingInstance"`
+}
+
+// eventRecorder emits Kubernetes Events on the pod for the transitions
+// worth seeing in kubectl describe pod: simulated probes starting or
+// stopping to fail, faults being enabled and shutdown. Events are sent in
+// the background and dropped when the queue is full. A nil recorder does
+// nothing.
+type eventRecorder struct {
+	client *kubeClient
+	pod    eventObjectReference
+	node   string
+
+	mu     sync.Mutex
+	probes map[string]bool
+
+	queue chan kubeEvent
+	done  chan struct{}
+
+	eventMetrics
+}
+
+// loadEventRecorder returns nil outside of a cluster or when KUBE_EVENTS is
+// false. The pod UID, needed for kubectl describe to list the events, comes
+// from POD_UID or the pod object.
+func (st *state) loadEventRecorder(client *kubeClient) *eventRecorder {
+	if client == nil || !st.config.getBool(kubeEventsEnv, true) {
+		return nil
+	}
+	pod := eventObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  st.config.getString(podNamespaceEnv, client.namespace),
+		Name:       st.config.get(podNameEnv),
+		UID:        st.config.get(podUIDEnv),
+	}
+	if pod.Name == "" {
+		pod.Name, _ = os.Hostname()
+	}
+	if pod.UID == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), kubeTimeout)
+		defer cancel()
+		if object, err := client.getPod(ctx, pod.Namespace, pod.Name); err == nil {
+			pod.UID = object.Metadata.UID
+		} else {
+			slog.Warn("Failed to get the pod UID, events may not show up in kubectl describe", "error", err)
+		}
+	}
+	return newEventRecorder(client, pod, st.config.get(nodeNameEnv), st.eventMetrics)
+}
+
+func newEventRecorder(client *kubeClient, pod eventObjectReference, node string, metrics eventMetrics) *eventRecorder {
+	r := &eventRecorder{
+		client: client,
+		pod:    pod,
+		node:   node,
+		probes: make(map[string]bool),
+		queue:  make(chan kubeEvent, eventsQueueSize),
+		done:   make(chan struct{}),
+
+		eventMetrics: metrics,
+	}
+	go r.run()
+	return r
+}
+
+// emit queues an event on the pod.
+func (r *eventRecorder) emit(eventType string, reason string, message string) {
+	if r == nil {
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	event := kubeEvent{
+		APIVersion:         "v1",
+		Kind:               "Event",
+		Metadata:           kubeObjectMeta{GenerateName: r.pod.Name + ".", Namespace: r.pod.Namespace},
+		InvolvedObject:     r.pod,
+		Reason:             reason,
+		Message:            message,
+		Type:               eventType,
+		Source:             eventSource{Component: eventsComponent, Host: r.node},
+		FirstTimestamp:     now,
+		LastTimestamp:      now,
+		Count:              1,
+		ReportingComponent: eventsComponent,
+		ReportingInstance:  r.pod.Name,
+	}
+	select {
+	case r.queue <- event:
+	default:
+		r.kubeEventsTotal.WithLabelValues("dropped").Inc()
+		slog.Warn("Kubernetes Events queue full, dropping event", "reason", reason)
+	}
+}
+
+// probe records the outcome of a simulated probe, emitting an event when
+// it starts or stops failing. The first outcome only sets the state.
+func (r *eventRecorder) probe(probe string, success bool, errMsg string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	previous, seen := r.probes[probe]
+	r.probes[probe] = success
+	r.mu.Unlock()
+	if !seen || previous == success {
+		return
+	}
+
+	name := strings.ToUpper(probe[:1]) + probe[1:]
+	if success {
+		r.emit(eventTypeNormal, "ProbeRecovered", fmt.Sprintf("%s probe succeeds again", name))
+		return
+	}
+	r.emit(eventTypeWarning, "ProbeFailing", fmt.Sprintf("%s probe started failing: %s", name, errMsg))
+}
+
+// faults emits an event when a fault profile is enabled or removed.
+func (r *eventRecorder) faults(listener string, profile faultProfile) {
+	if !profile.enabled() {
+		r.emit(eventTypeNormal, "FaultsDisabled", fmt.Sprintf("Faults disabled on %s", listener))
+		return
+	}
+	r.emit(eventTypeWarning, "FaultsEnabled", fmt.Sprintf("Faults enabled on %s: latency %v, error rate %v, reset rate %v",
+		listener, profile.Latency, profile.ErrorRate, profile.ResetRate))
+}
+
+func (r *eventRecorder) run() {
+	defer close(r.done)
+	for event := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), kubeTimeout)
+		err := r.client.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/events", event.Metadata.Namespace), "", event, nil)
+		cancel()
+		if err != nil {
+			r.kubeEventsTotal.WithLabelValues("failure").Inc()
+			slog.Warn("Failed to emit Kubernetes Event", "reason", event.Reason, "error", err)
+			continue
+		}
+		r.kubeEventsTotal.WithLabelValues("success").Inc()
+	}
+}
+
+// Close sends the queued events, like the one of the shutdown.
+func (r *eventRecorder) Close() {
+	if r == nil {
+		return
+	}
+	close(r.queue)
+	<-r.done
+}
@@ -0,0 +1,70 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestEventRecorder(t *testing.T) {
+	st := newState(Options{})
+	var mu sync.Mutex
+	var events []kubeEvent
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/default/events" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var event kubeEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	recorder := newEventRecorder(client, eventObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "prober-0", UID: "uid-0"}, "node-a", st.eventMetrics)
+	recorder.probe("readiness", true, "")
+	recorder.probe("readiness", false, "Service Unavailable")
+	recorder.probe("readiness", false, "Service Unavailable")
+	recorder.probe("readiness", true, "")
+	recorder.faults("default", faultProfile{ErrorRate: 0.5})
+	recorder.emit(eventTypeNormal, "ShutdownStarted", "Graceful shutdown started: terminated")
+	recorder.Close()
+
+	expected := []struct{ reason, eventType string }{
+		{"ProbeFailing", eventTypeWarning},
+		{"ProbeRecovered", eventTypeNormal},
+		{"FaultsEnabled", eventTypeWarning},
+		{"ShutdownStarted", eventTypeNormal},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i, e := range expected {
+		if events[i].Reason != e.reason || events[i].Type != e.eventType {
+			t.Errorf("event %d: expected %s %s, got %s %s", i, e.eventType, e.reason, events[i].Type, events[i].Reason)
+		}
+	}
+	event := events[0]
+	if event.InvolvedObject.UID != "uid-0" || event.Metadata.GenerateName != "prober-0." || event.Source.Host != "node-a" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Message != "Readiness probe started failing: Service Unavailable" {
+		t.Errorf("unexpected message %q", event.Message)
+	}
+}
+
+func TestEventRecorderDisabled(t *testing.T) {
+	st := newState(Options{})
+	var recorder *eventRecorder
+	recorder.probe("liveness", false, "")
+	recorder.emit(eventTypeNormal, "ShutdownStarted", "")
+	recorder.Close()
+
+	t.Setenv(kubeEventsEnv, "false")
+	if st.loadEventRecorder(&kubeClient{}) != nil {
+		t.Error("expected no recorder with KUBE_EVENTS=false")
+	}
+}
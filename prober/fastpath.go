@@ -0,0 +1,245 @@
+package prober
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const fastPathEnv = "FAST_PATH"
+
+// fastPathMetrics count the requests of the fast path, the counters being
+// resolved once so it doesn't hash labels.
+type fastPathMetrics struct {
+	fastPathBytes  prometheus.Counter
+	fastPathStatus prometheus.Counter
+	fastPathEcho   prometheus.Counter
+}
+
+func newFastPathMetrics(registry prometheus.Registerer) fastPathMetrics {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fast_path_requests_total",
+		Help: "Requests served by the fast path, bypassing the middlewares, by endpoint.",
+	}, []string{"endpoint"})
+	registry.MustRegister(requests)
+	return fastPathMetrics{
+		fastPathBytes:  requests.WithLabelValues("bytes"),
+		fastPathStatus: requests.WithLabelValues("status"),
+		fastPathEcho:   requests.WithLabelValues("echo"),
+	}
+}
+
+var (
+	jsonContentType   = []string{"application/json; charset=utf-8"}
+	binaryContentType = []string{"application/octet-stream"}
+)
+
+// statusBody is the pre-rendered {"status":N} answer of /status/:code with
+// its Content-Length.
+type statusBody struct {
+	body   []byte
+	length []string
+}
+
+var statusBodies = func() map[int]statusBody {
+	bodies := make(map[int]statusBody, 400)
+	for code := 200; code < 600; code++ {
+		body := []byte(`{"status":` + strconv.Itoa(code) + `}`)
+		bodies[code] = statusBody{body: body, length: []string{strconv.Itoa(len(body))}}
+	}
+	return bodies
+}()
+
+// writeStatus answers with the code and its pre-rendered body, left out for
+// the statuses which can't have one.
+func writeStatus(w http.ResponseWriter, value string) bool {
+	code, err := strconv.Atoi(value)
+	status, ok := statusBodies[code]
+	if err != nil || !ok {
+		return false
+	}
+	header := w.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		w.WriteHeader(code)
+		return true
+	}
+	header["Content-Type"] = jsonContentType
+	header["Content-Length"] = status.length
+	w.WriteHeader(code)
+	w.Write(status.body)
+	return true
+}
+
+// writeBytes streams n zeroes from the download payload.
+func writeBytes(w http.ResponseWriter, value string) bool {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > maxBandwidthBytes {
+		return false
+	}
+	header := w.Header()
+	header["Content-Type"] = binaryContentType
+	header["Content-Length"] = []string{strconv.FormatInt(n, 10)}
+	w.WriteHeader(http.StatusOK)
+	for remaining := n; remaining > 0; {
+		chunk := min(remaining, bandwidthChunk)
+		if _, err := w.Write(bandwidthPayload[:chunk]); err != nil {
+			return true
+		}
+		remaining -= chunk
+	}
+	return true
+}
+
+func bytesRequest(c *web.Context) {
+	if !writeBytes(c.Writer, c.Param("n")) {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid bytes value"})
+	}
+}
+
+func statusRequest(c *web.Context) {
+	if !writeStatus(c.Writer, c.Param("code")) {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid status code"})
+	}
+}
+
+// echoBuffer is reused across fast /echo answers.
+type echoBuffer struct {
+	data []byte
+	keys []string
+}
+
+var echoBuffers = sync.Pool{New: func() any { return &echoBuffer{data: make([]byte, 0, 2048)} }}
+
+// appendEcho renders the echoResponse of the request without reflection,
+// keys sorted like encoding/json does.
+func appendEcho(buf *echoBuffer, r *http.Request, redactor headerRedactor) []byte {
+	b := append(buf.data[:0], `{"method":`...)
+	b = appendJSONString(b, r.Method)
+	b = append(b, `,"path":`...)
+	b = appendJSONString(b, r.URL.Path)
+	b = append(b, `,"query":`...)
+	if r.URL.RawQuery == "" {
+		b = append(b, `{}`...)
+	} else {
+		b = appendJSONValues(b, buf, r.URL.Query())
+	}
+	b = append(b, `,"proto":`...)
+	b = appendJSONString(b, r.Proto)
+	b = append(b, `,"host":`...)
+	b = appendJSONString(b, r.Host)
+	b = append(b, `,"remoteAddr":`...)
+	b = appendJSONString(b, r.RemoteAddr)
+	b = append(b, `,"ipFamily":`...)
+	b = appendJSONString(b, connFamily(r))
+	b = append(b, `,"tls":`...)
+	b = strconv.AppendBool(b, r.TLS != nil)
+	b = append(b, `,"headers":`...)
+	b = appendJSONValues(b, buf, redactor.redacted(r.Header))
+	b = append(b, '}')
+	buf.data = b
+	return b
+}
+
+func appendJSONValues(b []byte, buf *echoBuffer, values map[string][]string) []byte {
+	if values == nil {
+		return append(b, `null`...)
+	}
+	buf.keys = buf.keys[:0]
+	for key := range values {
+		buf.keys = append(buf.keys, key)
+	}
+	sort.Strings(buf.keys)
+	b = append(b, '{')
+	for i, key := range buf.keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, key)
+		b = append(b, ':')
+		if values[key] == nil {
+			b = append(b, `null`...)
+			continue
+		}
+		b = append(b, '[')
+		for j, value := range values[key] {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, value)
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s as a JSON string, replacing invalid UTF-8 like
+// encoding/json does.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				b = append(b, "\ufffd"...)
+			} else {
+				b = append(b, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20:
+			b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			b = append(b, c)
+		}
+		i++
+	}
+	return append(b, '"')
+}
+
+// fastPathHandler serves /bytes/:n, /status/:code and /echo straight from
+// net/http, skipping the gin middlewares, logs and metrics included, with
+// pre-rendered and pooled buffers, so prober can sink load tests without
+// becoming the bottleneck. Other requests go to next.
+func (st *state) fastPathHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case strings.HasPrefix(path, "/bytes/"):
+			if writeBytes(w, path[len("/bytes/"):]) {
+				st.fastPathBytes.Inc()
+				return
+			}
+		case strings.HasPrefix(path, "/status/"):
+			if writeStatus(w, path[len("/status/"):]) {
+				st.fastPathStatus.Inc()
+				return
+			}
+		case path == "/echo":
+			buf := echoBuffers.Get().(*echoBuffer)
+			body := appendEcho(buf, r, st.sensitiveHeaders)
+			header := w.Header()
+			header["Content-Type"] = jsonContentType
+			header["Content-Length"] = []string{strconv.Itoa(len(body))}
+			w.WriteHeader(http.StatusOK)
+			w.Write(body)
+			echoBuffers.Put(buf)
+			st.fastPathEcho.Inc()
+			return
+		}
+		// Invalid values get the regular error answers.
+		next.ServeHTTP(w, r)
+	})
+}
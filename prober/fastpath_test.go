@@ -0,0 +1,53 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestFastPath(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	fast := st.fastPathHandler(router)
+
+	request := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Quoted", "say \"hi\"\t\xff")
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/echo", "/echo?b=2&a=1&a=<3>"} {
+		var slow, quick echoResponse
+		json.Unmarshal(request(router, path).Body.Bytes(), &slow)
+		// The fast path skips the middleware adding the request ID.
+		delete(slow.Headers, http.CanonicalHeaderKey(requestIDHeader))
+		if err := json.Unmarshal(request(fast, path).Body.Bytes(), &quick); err != nil || !reflect.DeepEqual(slow, quick) {
+			t.Errorf("%s: expected the fast echo to match %+v, got %+v (%v)", path, slow, quick, err)
+		}
+	}
+
+	for _, handler := range []http.Handler{router, fast} {
+		if w := request(handler, "/status/503"); w.Code != http.StatusServiceUnavailable || w.Body.String() != `{"status":503}` {
+			t.Errorf("expected a pre-rendered 503, got %d %s", w.Code, w.Body.String())
+		}
+		if w := request(handler, "/status/204"); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("expected an empty 204, got %d %s", w.Code, w.Body.String())
+		}
+		if w := request(handler, "/bytes/100000"); w.Code != http.StatusOK || w.Body.Len() != 100000 || w.Header().Get("Content-Length") != "100000" {
+			t.Errorf("expected 100000 bytes, got %d %d", w.Code, w.Body.Len())
+		}
+		for _, path := range []string{"/status/42", "/status/teapot", "/bytes/-1"} {
+			if w := request(handler, path); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", path, w.Code)
+			}
+		}
+	}
+}
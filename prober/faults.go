@@ -0,0 +1,163 @@
+package prober
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// faultProfile describes the chaos applied to every request served by a
+// listener. The zero value injects nothing.
+type faultProfile struct {
+	Latency     time.Duration `yaml:"latency"`
+	ErrorRate   float64       `yaml:"errorRate"`
+	ErrorStatus int           `yaml:"errorStatus"`
+	ResetRate   float64       `yaml:"resetRate"`
+}
+
+// faultProfileJSON is the JSON form of faultProfile, with the latency as a
+// duration string like in YAML.
+type faultProfileJSON struct {
+	Latency     string  `json:"latency,omitempty"`
+	ErrorRate   float64 `json:"errorRate,omitempty"`
+	ErrorStatus int     `json:"errorStatus,omitempty"`
+	ResetRate   float64 `json:"resetRate,omitempty"`
+}
+
+func (p faultProfile) MarshalJSON() ([]byte, error) {
+	profile := faultProfileJSON{ErrorRate: p.ErrorRate, ErrorStatus: p.ErrorStatus, ResetRate: p.ResetRate}
+	if p.Latency > 0 {
+		profile.Latency = p.Latency.String()
+	}
+	return json.Marshal(profile)
+}
+
+func (p *faultProfile) UnmarshalJSON(data []byte) error {
+	var profile faultProfileJSON
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return err
+	}
+	*p = faultProfile{ErrorRate: profile.ErrorRate, ErrorStatus: profile.ErrorStatus, ResetRate: profile.ResetRate}
+	if profile.Latency != "" {
+		latency, err := time.ParseDuration(profile.Latency)
+		if err != nil || latency < 0 {
+			return fmt.Errorf("invalid latency %q", profile.Latency)
+		}
+		p.Latency = latency
+	}
+	return nil
+}
+
+func (p faultProfile) enabled() bool {
+	return p.Latency > 0 || p.ErrorRate > 0 || p.ResetRate > 0
+}
+
+func (p faultProfile) validate() error {
+	if p.ErrorRate < 0 || p.ErrorRate > 1 || p.ResetRate < 0 || p.ResetRate > 1 {
+		return errors.New("rates must be between 0 and 1")
+	}
+	if p.ErrorStatus != 0 && (p.ErrorStatus < 100 || p.ErrorStatus > 599) {
+		return fmt.Errorf("invalid error status %d", p.ErrorStatus)
+	}
+	return nil
+}
+
+// faultMiddleware injects the profile faults on every request of the
+// listener, publishing the active profile and counting injected faults.
+func (st *state) faultMiddleware(listener string, profile faultProfile) web.HandlerFunc {
+	st.publishFaults(listener, profile)
+
+	return func(c *web.Context) {
+		if st.injectFaults(c, listener, profile) {
+			c.Next()
+		}
+	}
+}
+
+// runtimeFaultsListener labels the faults applied at runtime, like the ones
+// of a ProberConfig resource.
+const runtimeFaultsListener = "runtime"
+
+func (st *state) setRuntimeFaults(profile *faultProfile) {
+	var current faultProfile
+	if profile != nil {
+		current = *profile
+	}
+	st.publishFaults(runtimeFaultsListener, current)
+	var previous faultProfile
+	if old := st.runtimeFaults.Swap(profile); old != nil {
+		previous = *old
+	}
+	if current != previous && (current.enabled() || previous.enabled()) {
+		st.kubeEvents.faults(runtimeFaultsListener, current)
+	}
+}
+
+// runtimeFaultMiddleware injects the faults of runtimeFaults.
+func (st *state) runtimeFaultMiddleware() web.HandlerFunc {
+	return func(c *web.Context) {
+		if profile := st.runtimeFaults.Load(); profile != nil && !st.injectFaults(c, runtimeFaultsListener, *profile) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func (st *state) publishFaults(listener string, profile faultProfile) {
+	st.faultLatency.WithLabelValues(listener).Set(profile.Latency.Seconds())
+	st.faultErrorRate.WithLabelValues(listener).Set(profile.ErrorRate)
+	st.faultResetRate.WithLabelValues(listener).Set(profile.ResetRate)
+}
+
+// injectFaults applies the profile to the request, returning false when
+// the request was aborted.
+func (st *state) injectFaults(c *web.Context, listener string, profile faultProfile) bool {
+	injected := func(fault string) {
+		addFault(c, fault)
+		st.faultsInjectedTotal.WithLabelValues(listener, fault).Inc()
+	}
+
+	if profile.Latency > 0 {
+		injected("latency")
+		if st.sleepRequest(c.Request.Context(), profile.Latency) != nil {
+			c.Abort()
+			return false
+		}
+	}
+	if profile.ResetRate > 0 && st.random.Float64() < profile.ResetRate {
+		injected("reset")
+		// net/http closes the connection without writing a response.
+		panic(http.ErrAbortHandler)
+	}
+	if profile.ErrorRate > 0 && st.random.Float64() < profile.ErrorRate {
+		injected("error")
+		errorStatus := profile.ErrorStatus
+		if errorStatus == 0 {
+			errorStatus = http.StatusServiceUnavailable
+		}
+		c.AbortWithStatusJSON(errorStatus, web.H{"error": "Injected fault"})
+		return false
+	}
+	return true
+}
+
+// routeFilter only lets through requests matching one of the given route
+// templates. An empty list enables every route.
+func routeFilter(routes []string) web.HandlerFunc {
+	allowed := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		allowed[route] = true
+	}
+
+	return func(c *web.Context) {
+		if len(allowed) > 0 && !allowed[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusNotFound, web.H{"error": "Route not enabled on this listener"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,141 @@
+package prober
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRouteFilter(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{Routes: []string{"/liveness"}})
+
+	tests := map[string]int{
+		"/liveness":  http.StatusOK,
+		"/readiness": http.StatusNotFound,
+		"/delay/0":   http.StatusNotFound,
+	}
+	for path, status := range tests {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, w.Code)
+		}
+	}
+}
+
+func TestFaultMiddlewareErrors(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.faultMiddleware("test", faultProfile{ErrorRate: 1, ErrorStatus: http.StatusBadGateway}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestFaultMiddlewareLatency(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.faultMiddleware("test", faultProfile{Latency: 100 * time.Millisecond}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if duration := time.Since(start); duration < 100*time.Millisecond {
+		t.Errorf("expected delay of at least 100ms, got %v", duration)
+	}
+}
+
+func TestFaultMiddlewareLatencyInterrupted(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.faultMiddleware("test", faultProfile{Latency: 10 * time.Second}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/liveness", nil)
+	w := httptest.NewRecorder()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	if duration := time.Since(start); duration > 5*time.Second || w.Body.Len() != 0 {
+		t.Errorf("expected the canceled request to be given up, got %v %s", duration, w.Body.String())
+	}
+
+	cancelled := testutil.ToFloat64(st.requestsCancelledOnShutdown)
+	req, _ = http.NewRequest("GET", "/liveness", nil)
+	w = httptest.NewRecorder()
+	time.AfterFunc(100*time.Millisecond, st.serverShutdown.begin)
+	start = time.Now()
+	router.ServeHTTP(w, req)
+	if duration := time.Since(start); duration > 5*time.Second || w.Code != http.StatusOK {
+		t.Errorf("expected the shutdown to cut the latency short, got %v %d", duration, w.Code)
+	}
+	if got := testutil.ToFloat64(st.requestsCancelledOnShutdown) - cancelled; got != 1 {
+		t.Errorf("expected 1 cancelled request, got %v", got)
+	}
+}
+
+func TestFaultMiddlewareReset(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{Faults: faultProfile{ResetRate: 1}})
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	srv.Config.ErrorLog = nil
+
+	if resp, err := http.Get(srv.URL + "/liveness"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected connection reset, got status %d", resp.StatusCode)
+	}
+}
+
+func TestRuntimeFaultMiddleware(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+
+	serve := func() int {
+		req, _ := http.NewRequest("GET", "/liveness", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected status %d without runtime faults, got %d", http.StatusOK, code)
+	}
+	st.setRuntimeFaults(&faultProfile{ErrorRate: 1, ErrorStatus: http.StatusTeapot})
+	if code := serve(); code != http.StatusTeapot {
+		t.Errorf("expected status %d with runtime faults, got %d", http.StatusTeapot, code)
+	}
+}
@@ -0,0 +1,248 @@
+package prober
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	heapBallastEnv = "HEAP_BALLAST"
+
+	// recentGCPauses is how many of the last pauses gcStats reports.
+	recentGCPauses = 10
+)
+
+// gcMetrics expose the heap ballast.
+type gcMetrics struct {
+	heapBallastBytes prometheus.Gauge
+}
+
+func newGCMetrics(registry prometheus.Registerer) gcMetrics {
+	m := gcMetrics{
+		heapBallastBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "heap_ballast_bytes",
+			Help: "Size of the heap ballast allocated at startup.",
+		}),
+	}
+	registry.MustRegister(m.heapBallastBytes)
+	return m
+}
+
+// heapBallast is never read: it only counts in the live heap, so the GC
+// targets a larger heap and runs less often. Never touched, its pages are
+// not backed by memory and don't add to the RSS.
+var heapBallast []byte
+
+// loadHeapBallast allocates HEAP_BALLAST, a quantity like "1Gi" or a share
+// of the memory request or limit like "50%", as /load reads it.
+func loadHeapBallast(root string) ([]byte, error) {
+	value := os.Getenv(heapBallastEnv)
+	if value == "" {
+		return nil, nil
+	}
+	size, err := parseMemoryLoad(value, loadResources(root))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Heap ballast allocated", "bytes", size)
+	return make([]byte, size), nil
+}
+
+type gcSettings struct {
+	// GOGC is the GC percent, -1 when the GC is off.
+	GOGC int64 `json:"gogc"`
+	// MemoryLimitBytes is the soft memory limit, math.MaxInt64 when unset.
+	MemoryLimitBytes int64 `json:"memoryLimitBytes"`
+}
+
+type gcStats struct {
+	gcSettings
+	BallastBytes  int       `json:"ballastBytes"`
+	NumGC         int64     `json:"numGC"`
+	LastGC        time.Time `json:"lastGC"`
+	PauseTotal    string    `json:"pauseTotal"`
+	RecentPauses  []string  `json:"recentPauses"`
+	CPUFraction   float64   `json:"cpuFraction"`
+	HeapAlloc     uint64    `json:"heapAllocBytes"`
+	HeapInuse     uint64    `json:"heapInuseBytes"`
+	HeapIdle      uint64    `json:"heapIdleBytes"`
+	HeapReleased  uint64    `json:"heapReleasedBytes"`
+	HeapObjects   uint64    `json:"heapObjects"`
+	NextGC        uint64    `json:"nextGCBytes"`
+	Sys           uint64    `json:"sysBytes"`
+	NumForcedGC   uint32    `json:"numForcedGC"`
+	NumGoroutines int       `json:"numGoroutines"`
+}
+
+// readGCSettings reads GOGC and the memory limit without changing them,
+// which debug.SetGCPercent can't do.
+func readGCSettings() gcSettings {
+	samples := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+	metrics.Read(samples)
+	settings := gcSettings{GOGC: -1, MemoryLimitBytes: math.MaxInt64}
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		settings.GOGC = int64(samples[0].Value.Uint64())
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		settings.MemoryLimitBytes = int64(samples[1].Value.Uint64())
+	}
+	return settings
+}
+
+func readGCStats() gcStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	var stats debug.GCStats
+	debug.ReadGCStats(&stats)
+
+	pauses := make([]string, 0, min(len(stats.Pause), recentGCPauses))
+	for _, pause := range stats.Pause[:min(len(stats.Pause), recentGCPauses)] {
+		pauses = append(pauses, pause.String())
+	}
+	return gcStats{
+		gcSettings:    readGCSettings(),
+		BallastBytes:  len(heapBallast),
+		NumGC:         stats.NumGC,
+		LastGC:        stats.LastGC,
+		PauseTotal:    stats.PauseTotal.String(),
+		RecentPauses:  pauses,
+		CPUFraction:   memStats.GCCPUFraction,
+		HeapAlloc:     memStats.HeapAlloc,
+		HeapInuse:     memStats.HeapInuse,
+		HeapIdle:      memStats.HeapIdle,
+		HeapReleased:  memStats.HeapReleased,
+		HeapObjects:   memStats.HeapObjects,
+		NextGC:        memStats.NextGC,
+		Sys:           memStats.Sys,
+		NumForcedGC:   memStats.NumForcedGC,
+		NumGoroutines: runtime.NumGoroutine(),
+	}
+}
+
+// gcStatsHandler answers GET /debug/gc with the GC settings, counters,
+// recent pauses, newest first, and the heap sizes.
+func gcStatsHandler(c *web.Context) {
+	c.JSON(http.StatusOK, readGCStats())
+}
+
+// forceGC answers POST /debug/gc by running a collection, returning the
+// memory to the OS too with free=true, then the stats after it.
+func forceGC(c *web.Context) {
+	free, err := strconv.ParseBool(c.DefaultQuery("free", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid free value"})
+		return
+	}
+	started := time.Now()
+	if free {
+		debug.FreeOSMemory()
+	} else {
+		runtime.GC()
+	}
+	c.JSON(http.StatusOK, web.H{"duration": time.Since(started).String(), "stats": readGCStats()})
+}
+
+// gcConfig is the body of POST /debug/gc/config. GOGC is a percent or "off"
+// and MemoryLimit a quantity like "256Mi", a share of the memory limit of
+// the container like "90%", or "off".
+type gcConfig struct {
+	GOGC        *string `json:"gogc,omitempty"`
+	MemoryLimit *string `json:"memoryLimit,omitempty"`
+}
+
+var (
+	errInvalidGOGC        = errors.New("invalid gogc value")
+	errInvalidMemoryLimit = errors.New("invalid memory limit")
+)
+
+func parseGOGC(value string) (int, error) {
+	if value == "off" {
+		return -1, nil
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil || percent < 0 {
+		return 0, errInvalidGOGC
+	}
+	return percent, nil
+}
+
+func parseMemoryLimit(value string, res resources) (int64, error) {
+	if value == "off" {
+		return math.MaxInt64, nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		share, err := strconv.ParseFloat(percent, 64)
+		if err != nil || share <= 0 || res.Memory.LimitBytes == nil {
+			return 0, errInvalidMemoryLimit
+		}
+		return int64(float64(*res.Memory.LimitBytes) * share / 100), nil
+	}
+	limit, err := parseMemoryLoad(value, resources{})
+	if err != nil || limit == 0 {
+		return 0, errInvalidMemoryLimit
+	}
+	return limit, nil
+}
+
+// apply sets the fields present once they are all valid, the memory limit
+// percentages being of the limit of the cgroup under root.
+func (config gcConfig) apply(root string) error {
+	gogc, limit := 0, int64(0)
+	var err error
+	if config.GOGC != nil {
+		if gogc, err = parseGOGC(*config.GOGC); err != nil {
+			return err
+		}
+	}
+	if config.MemoryLimit != nil {
+		res := loadResources(root)
+		if limit, err = parseMemoryLimit(*config.MemoryLimit, res); err != nil {
+			return err
+		}
+	}
+
+	if config.GOGC != nil {
+		debug.SetGCPercent(gogc)
+	}
+	if config.MemoryLimit != nil {
+		debug.SetMemoryLimit(limit)
+	}
+	return nil
+}
+
+func getGCConfig(c *web.Context) {
+	c.JSON(http.StatusOK, readGCSettings())
+}
+
+// postGCConfig changes GOGC and GOMEMLIMIT at runtime, like the environment
+// variables do at startup, leaving the fields absent unchanged.
+func (st *state) postGCConfig(c *web.Context) {
+	var config gcConfig
+	if err := c.BindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := config.apply(st.config.getString(cgroupRootEnv, defaultCgroupRoot)); err != nil {
+		message := "Invalid memory limit"
+		if errors.Is(err, errInvalidGOGC) {
+			message = "Invalid gogc value"
+		}
+		c.JSON(http.StatusBadRequest, web.H{"error": message})
+		return
+	}
+	st.configChangesTotal.Inc()
+	getGCConfig(c)
+}
@@ -0,0 +1,119 @@
+package prober
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestGCStats(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	router := st.newAdminRouter()
+
+	req, _ := http.NewRequest("POST", "/debug/gc?free=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var forced struct {
+		Stats gcStats `json:"stats"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &forced)
+	if forced.Stats.NumForcedGC == 0 || forced.Stats.NumGC == 0 {
+		t.Errorf("expected a forced GC, got %s", w.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/gc", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var stats gcStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if stats.NumGC < forced.Stats.NumGC || len(stats.RecentPauses) == 0 || stats.HeapAlloc == 0 {
+		t.Errorf("expected GC stats, got %s", w.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/debug/gc?free=maybe", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGCConfig(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(cgroupRootEnv, writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"memory.max":         "1073741824",
+	}))
+	previous := readGCSettings()
+	t.Cleanup(func() {
+		debug.SetGCPercent(int(previous.GOGC))
+		debug.SetMemoryLimit(previous.MemoryLimitBytes)
+	})
+	router := st.newAdminRouter()
+
+	tests := []struct {
+		body     string
+		status   int
+		settings gcSettings
+	}{
+		{`{"gogc":"50","memoryLimit":"256Mi"}`, http.StatusOK, gcSettings{GOGC: 50, MemoryLimitBytes: 256 << 20}},
+		{`{"memoryLimit":"90%"}`, http.StatusOK, gcSettings{GOGC: 50, MemoryLimitBytes: 966367641}},
+		{`{"gogc":"off","memoryLimit":"off"}`, http.StatusOK, gcSettings{GOGC: -1, MemoryLimitBytes: math.MaxInt64}},
+		{`{"gogc":"-5","memoryLimit":"1Gi"}`, http.StatusBadRequest, gcSettings{GOGC: -1, MemoryLimitBytes: math.MaxInt64}},
+		{`{"memoryLimit":"lots"}`, http.StatusBadRequest, gcSettings{GOGC: -1, MemoryLimitBytes: math.MaxInt64}},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/debug/gc/config", strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.body, test.status, w.Code)
+		}
+		if got := readGCSettings(); got != test.settings {
+			t.Errorf("%s: expected settings %+v, got %+v", test.body, test.settings, got)
+		}
+	}
+}
+
+func TestHeapBallast(t *testing.T) {
+	captureLogs(t)
+	root := writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"memory.max":         "1073741824",
+	})
+
+	tests := []struct {
+		value string
+		size  int
+		err   bool
+	}{
+		{"", 0, false},
+		{"64Mi", 64 << 20, false},
+		{"25%", 256 << 20, false},
+		{"lots", 0, true},
+	}
+	for _, test := range tests {
+		t.Setenv(heapBallastEnv, test.value)
+		ballast, err := loadHeapBallast(root)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected error %t, got %v", test.value, test.err, err)
+		}
+		if len(ballast) != test.size {
+			t.Errorf("%q: expected %d bytes, got %d", test.value, test.size, len(ballast))
+		}
+	}
+}
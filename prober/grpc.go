@@ -0,0 +1,149 @@
+package prober
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	proberv1 "github.com/hpettenuci/probe/proto/prober/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const grpcAddrEnv = "GRPC_ADDR"
+
+type proberService struct {
+	proberv1.UnimplementedProberServer
+	st *state
+}
+
+func newEchoResponse(ctx context.Context, message string, redactor headerRedactor) *proberv1.EchoResponse {
+	resp := &proberv1.EchoResponse{Message: message, Metadata: map[string]string{}}
+	if p, ok := peer.FromContext(ctx); ok {
+		resp.Peer = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if len(values) > 0 {
+				resp.Metadata[key] = redactor.value(key, values[0])
+			}
+		}
+	}
+	return resp
+}
+
+func (s *proberService) Echo(ctx context.Context, req *proberv1.EchoRequest) (*proberv1.EchoResponse, error) {
+	return newEchoResponse(ctx, req.GetMessage(), s.st.sensitiveHeaders), nil
+}
+
+func (s *proberService) EchoStream(stream proberv1.Prober_EchoStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(newEchoResponse(stream.Context(), req.GetMessage(), s.st.sensitiveHeaders)); err != nil {
+			return err
+		}
+	}
+}
+
+// waitSeconds sleeps one second at a time, like graceDelayRequest, calling
+// tick after each elapsed second. It returns how many seconds were waited.
+func (s *proberService) waitSeconds(ctx context.Context, seconds int64, grace bool, tick func(int64) error) (int64, error) {
+	var shutdown <-chan struct{}
+	if grace {
+		shutdown = s.st.serverShutdown.done()
+	}
+	var elapsed int64
+	for elapsed < seconds {
+		select {
+		case <-ctx.Done():
+			return elapsed, status.FromContextError(ctx.Err()).Err()
+		case <-shutdown:
+			s.st.requestsCancelledOnShutdown.Inc()
+			return elapsed, nil
+		case <-time.After(time.Second):
+		}
+		elapsed++
+		if tick != nil {
+			if err := tick(elapsed); err != nil {
+				return elapsed, err
+			}
+		}
+	}
+	return elapsed, nil
+}
+
+func (s *proberService) Delay(ctx context.Context, req *proberv1.DelayRequest) (*proberv1.DelayResponse, error) {
+	if req.GetSeconds() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid delay value")
+	}
+	elapsed, err := s.waitSeconds(ctx, req.GetSeconds(), req.GetGrace(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &proberv1.DelayResponse{Seconds: elapsed}, nil
+}
+
+func (s *proberService) DelayStream(req *proberv1.DelayRequest, stream proberv1.Prober_DelayStreamServer) error {
+	if req.GetSeconds() < 0 {
+		return status.Error(codes.InvalidArgument, "Invalid delay value")
+	}
+	_, err := s.waitSeconds(stream.Context(), req.GetSeconds(), req.GetGrace(), func(elapsed int64) error {
+		return stream.Send(&proberv1.DelayResponse{Seconds: elapsed})
+	})
+	return err
+}
+
+// grpcServer adapts *grpc.Server to the shutdowner used by gracefulShutdown.
+type grpcServer struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+func (st *state) newGRPCServer() *grpcServer {
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+
+	healthpb.RegisterHealthServer(server, healthServer)
+	proberv1.RegisterProberServer(server, &proberService{st: st})
+	reflection.Register(server)
+
+	healthServer.SetServingStatus(proberv1.Prober_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return &grpcServer{server: server, health: healthServer}
+}
+
+func (s *grpcServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown reports NOT_SERVING to health checkers and drains in-flight RPCs
+// until ctx expires.
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
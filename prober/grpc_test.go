@@ -0,0 +1,141 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	proberv1 "github.com/hpettenuci/probe/proto/prober/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	st := newState(Options{})
+
+	listener := bufconn.Listen(1 << 20)
+	srv := st.newGRPCServer()
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCEcho(t *testing.T) {
+	client := proberv1.NewProberClient(newTestGRPCClient(t))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-test", "prober", "authorization", "Bearer secret-token")
+	resp, err := client.Echo(ctx, &proberv1.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetMessage() != "hello" {
+		t.Errorf("expected message hello, got %s", resp.GetMessage())
+	}
+	if resp.GetMetadata()["x-test"] != "prober" {
+		t.Errorf("expected metadata x-test=prober, got %v", resp.GetMetadata())
+	}
+	if got := resp.GetMetadata()["authorization"]; got != "Bearer [REDACTED]" {
+		t.Errorf("expected the authorization metadata redacted, got %q", got)
+	}
+}
+
+func TestGRPCEchoStreamRedacted(t *testing.T) {
+	client := proberv1.NewProberClient(newTestGRPCClient(t))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "cookie", "session=secret; theme=dark")
+	stream, err := client.EchoStream(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := stream.Send(&proberv1.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stream.CloseSend()
+	if got := resp.GetMetadata()["cookie"]; got != "session=[REDACTED]; theme=[REDACTED]" {
+		t.Errorf("expected the cookie metadata redacted, got %q", got)
+	}
+}
+
+func TestGRPCDelay(t *testing.T) {
+	client := proberv1.NewProberClient(newTestGRPCClient(t))
+
+	start := time.Now()
+	resp, err := client.Delay(context.Background(), &proberv1.DelayRequest{Seconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetSeconds() != 1 {
+		t.Errorf("expected 1 second, got %d", resp.GetSeconds())
+	}
+	if duration := time.Since(start); duration < time.Second {
+		t.Errorf("expected delay of at least 1 second, got %v", duration)
+	}
+
+	_, err = client.Delay(context.Background(), &proberv1.DelayRequest{Seconds: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestGRPCDelayDeadline(t *testing.T) {
+	client := proberv1.NewProberClient(newTestGRPCClient(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.Delay(ctx, &proberv1.DelayRequest{Seconds: 5})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestGRPCDelayStream(t *testing.T) {
+	client := proberv1.NewProberClient(newTestGRPCClient(t))
+
+	stream, err := client.DelayStream(context.Background(), &proberv1.DelayRequest{Seconds: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for expected := int64(1); expected <= 2; expected++ {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetSeconds() != expected {
+			t.Errorf("expected %d seconds, got %d", expected, resp.GetSeconds())
+		}
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	client := healthpb.NewHealthClient(newTestGRPCClient(t))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "prober.v1.Prober"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected SERVING, got %v", resp.GetStatus())
+	}
+}
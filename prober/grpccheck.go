@@ -0,0 +1,55 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// grpcCheck calls the standard health service of the target, for service
+// when set, and succeeds on SERVING. With method set, e.g.
+// "/prober.v1.Prober/Echo", an unary call with an empty request is made
+// instead and any OK status succeeds.
+func grpcCheck(ctx context.Context, config checkConfig) checkResult {
+	creds := insecure.NewCredentials()
+	if config.TLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient("passthrough:///"+config.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	defer conn.Close()
+
+	if config.Method != "" {
+		err = conn.Invoke(ctx, config.Method, &emptypb.Empty{}, &emptypb.Empty{})
+		return grpcResult(err)
+	}
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: config.Service})
+	result := grpcResult(err)
+	if err == nil && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		result.Success = false
+		result.Error = "health status " + resp.GetStatus().String()
+	}
+	return result
+}
+
+func grpcResult(err error) checkResult {
+	code := status.Code(err)
+	result := checkResult{GRPCStatus: code.String(), Success: code == codes.OK, grpcCode: code}
+	if err != nil {
+		result.Error = status.Convert(err).Message()
+		if code == codes.DeadlineExceeded {
+			result.ErrorClass = dialErrorTimeout
+		}
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCCheck(t *testing.T) {
+	st := newState(Options{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := st.newGRPCServer()
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	srv.health.SetServingStatus("draining", healthpb.HealthCheckResponse_NOT_SERVING)
+	addr := listener.Addr().String()
+
+	tests := []struct {
+		name     string
+		config   checkConfig
+		success  bool
+		expected string
+	}{
+		{"health", checkConfig{Address: addr}, true, "OK"},
+		{"service", checkConfig{Address: addr, Service: "prober.v1.Prober"}, true, "OK"},
+		{"not serving", checkConfig{Address: addr, Service: "draining"}, false, "OK"},
+		{"unknown service", checkConfig{Address: addr, Service: "missing"}, false, "NotFound"},
+		{"unary", checkConfig{Address: addr, Method: "/prober.v1.Prober/Echo"}, true, "OK"},
+		{"unimplemented", checkConfig{Address: addr, Method: "/prober.v1.Prober/Missing"}, false, "Unimplemented"},
+	}
+
+	for _, test := range tests {
+		test.config.Name = test.name
+		test.config.Type = checkTypeGRPC
+		if err := test.config.validate(); err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		test.config.Timeout = time.Second
+		result := runCheck(context.Background(), test.config)
+
+		if result.Success != test.success {
+			t.Errorf("expected success %v for %s, got %+v", test.success, test.name, result)
+		}
+		if result.GRPCStatus != test.expected {
+			t.Errorf("expected status %s for %s, got %s", test.expected, test.name, result.GRPCStatus)
+		}
+	}
+}
+
+func TestGRPCCheckValidation(t *testing.T) {
+	config := checkConfig{Name: "grpc", Type: checkTypeGRPC}
+	if err := config.validate(); err == nil {
+		t.Errorf("expected an error for a gRPC check without address")
+	}
+}
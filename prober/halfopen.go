@@ -0,0 +1,105 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+const (
+	listenerModeHTTP     = "http"
+	listenerModeHalfOpen = "half-open"
+
+	defaultHalfOpenMaxConnections = 1024
+)
+
+// halfOpenServer accepts TCP connections and never answers on them,
+// simulating a wedged backend at the socket level. What the peers send is
+// never read, so their writes end up blocking, and the slot of those which
+// close is freed. Connections beyond maxConns are closed right after being
+// accepted.
+type halfOpenServer struct {
+	maxConns int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+func newHalfOpenServer(maxConns int) *halfOpenServer {
+	if maxConns <= 0 {
+		maxConns = defaultHalfOpenMaxConnections
+	}
+	return &halfOpenServer{maxConns: maxConns, conns: make(map[net.Conn]struct{})}
+}
+
+func (s *halfOpenServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if len(s.conns) >= s.maxConns {
+			s.mu.Unlock()
+			slog.Debug("Half-open listener at capacity, closing connection", "maxConnections", s.maxConns, "remoteAddr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.hold(conn)
+	}
+}
+
+// hold waits for the peer to close the connection, or Shutdown to.
+func (s *halfOpenServer) hold(conn net.Conn) {
+	if !awaitPeerClose(conn) {
+		return
+	}
+	conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *halfOpenServer) held() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Shutdown closes the listener and every held connection. There is nothing
+// to drain since no request is ever processed.
+func (s *halfOpenServer) Shutdown(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = make(map[net.Conn]struct{})
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
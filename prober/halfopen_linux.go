@@ -0,0 +1,34 @@
+//go:build linux
+
+package prober
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// awaitPeerClose returns once the peer closed or reset the connection, or
+// Shutdown closed it. It polls for the hang-up instead of reading, so what
+// the peer sends stays unread and fills its window like a wedged backend.
+// It reports false when the connection cannot be watched.
+func awaitPeerClose(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	raw.Read(func(fd uintptr) bool {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLRDHUP}}
+		if n, err := unix.Poll(fds, 0); err != nil || n == 0 {
+			// Wait for the next readiness, new data or the hang-up.
+			return false
+		}
+		return fds[0].Revents&(unix.POLLRDHUP|unix.POLLHUP|unix.POLLERR) != 0
+	})
+	return true
+}
@@ -0,0 +1,54 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHalfOpenServerFreesClosedConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHalfOpenServer(2)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	waitHeld := func(expected int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for srv.held() != expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if held := srv.held(); held != expected {
+			t.Fatalf("expected %d held connections, got %d", expected, held)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitHeld(1)
+		// The close is seen past the unread data too.
+		if i%2 == 1 {
+			conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		}
+		conn.Close()
+		waitHeld(0)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the connection to be held after the others closed, got %v", err)
+	}
+}
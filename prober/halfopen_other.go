@@ -0,0 +1,11 @@
+//go:build !linux
+
+package prober
+
+import "net"
+
+// awaitPeerClose cannot tell the peer closing without reading from the
+// connection outside Linux, so the connections stay held until Shutdown.
+func awaitPeerClose(net.Conn) bool {
+	return false
+}
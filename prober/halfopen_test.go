@@ -0,0 +1,89 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHalfOpenServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHalfOpenServer(2)
+	go srv.Serve(listener)
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		// Give the accept loop time to register connections in order.
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	buf := make([]byte, 1)
+	for _, conn := range conns[:2] {
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("expected held connection to time out, got %v", err)
+		}
+	}
+
+	conns[2].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[2].Read(buf); err != io.EOF {
+		t.Errorf("expected connection over capacity to be closed, got %v", err)
+	}
+	if held := srv.held(); held != 2 {
+		t.Errorf("expected 2 held connections, got %d", held)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The peer sees a FIN, or a reset when its data was still unread.
+	conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[0].Read(buf); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected held connection to be closed on shutdown, got %v", err)
+	}
+}
+
+func TestHalfOpenServerStallsWrites(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHalfOpenServer(1)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Nothing is read, so the buffers fill up and the writes block.
+	chunk := make([]byte, 64<<10)
+	written := 0
+	for written < 256<<20 {
+		conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+		n, err := conn.Write(chunk)
+		written += n
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error after %d bytes: %v", written, err)
+		}
+	}
+	t.Errorf("expected the writes to block, %d bytes went through", written)
+}
@@ -0,0 +1,216 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// inheritedListenersEnv lists the keys of the listeners a new process
+	// inherits, their files starting at fd 3, followed by the pipe it writes
+	// to once serving.
+	inheritedListenersEnv = "PROBER_INHERITED_LISTENERS"
+	handoffTimeoutEnv     = "HANDOFF_TIMEOUT"
+
+	defaultHandoffTimeout = 30 * time.Second
+)
+
+// handoff keeps the listening sockets of the process so a new prober can
+// take them over on handoffSignals without a connection being refused: the
+// kernel queues the connections on the shared sockets while the new process
+// starts and the old one drains.
+type handoff struct {
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	inherited map[string]*os.File
+	ready     *os.File
+}
+
+var listenerHandoff = loadHandoff()
+
+// loadHandoff picks the listeners passed by the previous process, if any.
+func loadHandoff() *handoff {
+	h := &handoff{listeners: make(map[string]net.Listener), inherited: make(map[string]*os.File)}
+	value := os.Getenv(inheritedListenersEnv)
+	if value == "" {
+		return h
+	}
+	os.Unsetenv(inheritedListenersEnv)
+	keys := strings.Split(value, ",")
+	for i, key := range keys {
+		h.inherited[key] = os.NewFile(uintptr(3+i), key)
+	}
+	h.ready = os.NewFile(uintptr(3+len(keys)), "handoff-ready")
+	return h
+}
+
+// listen returns the listener inherited for the key, or opens it.
+func (h *handoff) listen(key string, open func() (net.Listener, error)) (net.Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if file, ok := h.inherited[key]; ok {
+		delete(h.inherited, key)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", key, err)
+		}
+		h.listeners[key] = listener
+		slog.Info("Listener inherited", "listener", key)
+		return listener, nil
+	}
+	listener, err := open()
+	if err != nil {
+		return nil, err
+	}
+	h.listeners[key] = listener
+	return listener, nil
+}
+
+// serving tells the previous process that every listener is served, so it
+// can drain, closing the inherited sockets not configured anymore.
+func (h *handoff) serving() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, file := range h.inherited {
+		slog.Warn("Inherited listener not configured anymore", "listener", key)
+		file.Close()
+	}
+	h.inherited = nil
+	if h.ready != nil {
+		h.ready.Write([]byte{1})
+		h.ready.Close()
+		h.ready = nil
+	}
+}
+
+// successor is the process the listeners were handed off to.
+type successor struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// start runs a new process of the same binary, arguments and environment
+// with the listeners, and waits until it serves them.
+func (h *handoff) start(timeout time.Duration) (*successor, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for key, listener := range h.listeners {
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", key, err)
+		}
+		keys, files = append(keys, key), append(files, file)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), inheritedListenersEnv+"="+strings.Join(keys, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	// The new process holds its own copies, so the pipe reaches EOF when it
+	// exits only once the write end of this one is closed.
+	for _, file := range files {
+		file.Close()
+	}
+	files = nil
+	if err != nil {
+		return nil, err
+	}
+	next := &successor{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(next.exited)
+	}()
+
+	// The new process writes a byte once serving; the pipe reaching EOF
+	// first means it exited.
+	ready := make(chan bool, 1)
+	go func() {
+		n, _ := readyReader.Read(make([]byte, 1))
+		ready <- n == 1
+	}()
+	select {
+	case ok := <-ready:
+		if !ok {
+			<-next.exited
+			return nil, errors.New("new process exited before serving")
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-next.exited
+		return nil, fmt.Errorf("new process not serving after %s", timeout)
+	}
+
+	// Closing the sockets now handed over must not remove the Unix ones.
+	for _, listener := range h.listeners {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	slog.Info("Listeners handed off", "pid", cmd.Process.Pid, "listeners", len(keys))
+	return next, nil
+}
+
+// supervise keeps the drained process alive until the one it handed off to
+// exits, forwarding the signals to it, so the container lives on when
+// prober is its PID 1. It returns the exit code of the successor.
+func (s *successor) supervise() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, handoffSignals...)...)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case sig := <-signals:
+			s.cmd.Process.Signal(sig)
+		case <-s.exited:
+			code := s.cmd.ProcessState.ExitCode()
+			slog.Info("Successor exited", "pid", s.cmd.Process.Pid, "code", code)
+			if code < 0 {
+				// Killed by a signal.
+				return 1
+			}
+			return code
+		}
+	}
+}
+
+func handoffKey(network string, addr string) string {
+	return network + ":" + addr
+}
+
+func handoffTimeout() time.Duration {
+	return getEnvDuration(handoffTimeoutEnv, defaultHandoffTimeout)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package prober
+
+import "os"
+
+// handoffSignals are only supported on Unix, where listeners can be passed
+// to a new process.
+var handoffSignals []os.Signal
@@ -0,0 +1,106 @@
+package prober
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandoffInheritsListener(t *testing.T) {
+	previous := &handoff{listeners: make(map[string]net.Listener)}
+	listener, err := previous.listen(handoffKey("tcp", "127.0.0.1:0"), func() (net.Listener, error) {
+		return net.Listen("tcp", "127.0.0.1:0")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readyReader.Close()
+
+	next := &handoff{
+		listeners: make(map[string]net.Listener),
+		inherited: map[string]*os.File{handoffKey("tcp", "127.0.0.1:0"): file},
+		ready:     readyWriter,
+	}
+	inherited, err := next.listen(handoffKey("tcp", "127.0.0.1:0"), func() (net.Listener, error) {
+		t.Errorf("expected the inherited listener to be used")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if inherited.Addr().String() != listener.Addr().String() {
+		t.Errorf("expected the same address, got %s and %s", inherited.Addr(), listener.Addr())
+	}
+
+	// Once the previous process closed its socket, the new one still serves.
+	listener.Close()
+	go http.Serve(inherited, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	resp, err := http.Get("http://" + inherited.Addr().String())
+	if err != nil {
+		t.Fatalf("expected the inherited listener to serve, got %v", err)
+	}
+	resp.Body.Close()
+
+	next.serving()
+	if n, _ := readyReader.Read(make([]byte, 1)); n != 1 {
+		t.Errorf("expected serving to notify the previous process")
+	}
+}
+
+// handoffHelperEnv makes the new process of TestHandoffStart, the test
+// binary itself, serve or exit before serving.
+const handoffHelperEnv = "PROBER_HANDOFF_HELPER"
+
+func TestHandoffStart(t *testing.T) {
+	if listenerHandoff.ready != nil {
+		// Running as the new process.
+		if os.Getenv(handoffHelperEnv) == "serve" {
+			listenerHandoff.serving()
+			os.Exit(0)
+		}
+		os.Exit(3)
+	}
+	captureLogs(t)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHandoffStart$"}
+	defer func() { os.Args = args }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	h := &handoff{listeners: map[string]net.Listener{handoffKey("tcp", "127.0.0.1:0"): listener}}
+
+	t.Setenv(handoffHelperEnv, "serve")
+	next, err := h.start(10 * time.Second)
+	if err != nil {
+		t.Fatalf("expected the new process to serve, got %v", err)
+	}
+	<-next.exited
+	if code := next.cmd.ProcessState.ExitCode(); code != 0 {
+		t.Errorf("expected the new process to exit with 0, got %d", code)
+	}
+
+	// A new process exiting before serving fails the handoff right away.
+	t.Setenv(handoffHelperEnv, "exit")
+	started := time.Now()
+	if _, err := h.start(10 * time.Second); err == nil || !strings.Contains(err.Error(), "exited before serving") {
+		t.Errorf("expected the exit to be reported, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the exit to be noticed before the timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package prober
+
+import (
+	"os"
+	"syscall"
+)
+
+// handoffSignals make prober hand its listeners off to a new process.
+var handoffSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,141 @@
+package prober
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	headersConfigEnv   = "HEADERS_CONFIG"
+	securityHeadersEnv = "SECURITY_HEADERS"
+)
+
+// securityHeaders is the baseline SECURITY_HEADERS adds, the one security
+// scanners expect from an API. HSTS is only sent over TLS, where browsers
+// honor it.
+var securityHeaders = map[string]string{
+	"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+}
+
+const (
+	hstsHeader = "Strict-Transport-Security"
+	hstsValue  = "max-age=31536000; includeSubDomains"
+)
+
+// headersConfig is the HEADERS_CONFIG file: headers added to every
+// response, and by route template, an empty value removing a header.
+type headersConfig struct {
+	Headers map[string]string            `yaml:"headers"`
+	Routes  map[string]map[string]string `yaml:"routes"`
+}
+
+// responseHeaders are the headers injected in the answers of a router,
+// the ones of the route winning over the global ones.
+type responseHeaders struct {
+	global http.Header
+	hsts   bool
+	routes map[string]map[string]string
+}
+
+func canonicalHeaders(headers map[string]string) (map[string]string, error) {
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("invalid header %q", name)
+		}
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical, nil
+}
+
+// loadResponseHeaders reads SECURITY_HEADERS and HEADERS_CONFIG, nil when
+// neither is set.
+func loadResponseHeaders(config *settings) (*responseHeaders, error) {
+	path := config.get(headersConfigEnv)
+	security := config.getBool(securityHeadersEnv, false)
+	if path == "" && !security {
+		return nil, nil
+	}
+
+	var file headersConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := &responseHeaders{global: make(http.Header), routes: make(map[string]map[string]string)}
+	if security {
+		for name, value := range securityHeaders {
+			headers.global.Set(name, value)
+		}
+		headers.hsts = true
+	}
+	global, err := canonicalHeaders(file.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range global {
+		if name == hstsHeader {
+			headers.hsts = false
+		}
+		headers.global.Set(name, value)
+	}
+	for route, routeHeaders := range file.Routes {
+		if headers.routes[route], err = canonicalHeaders(routeHeaders); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route, err)
+		}
+	}
+	return headers, nil
+}
+
+// validate checks the routes of the configuration exist in the router.
+func (h *responseHeaders) validate(routes web.RoutesInfo) error {
+	if h == nil {
+		return nil
+	}
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		known[route.Path] = true
+	}
+	for route := range h.routes {
+		if !known[route] {
+			return fmt.Errorf("unknown route %s in %s", route, headersConfigEnv)
+		}
+	}
+	return nil
+}
+
+// middleware sets the headers before the handler runs, so the ones it
+// sets itself, like Content-Type, win.
+func (h *responseHeaders) middleware() web.HandlerFunc {
+	return func(c *web.Context) {
+		header := c.Writer.Header()
+		for name, values := range h.global {
+			header[name] = values
+		}
+		if h.hsts && c.Request.TLS != nil {
+			header.Set(hstsHeader, hstsValue)
+		}
+		for name, value := range h.routes[c.FullPath()] {
+			if value == "" {
+				header.Del(name)
+				continue
+			}
+			header.Set(name, value)
+		}
+		c.Next()
+	}
+}
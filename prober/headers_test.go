@@ -0,0 +1,93 @@
+package prober
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "headers.yaml")
+	config := `
+headers:
+  x-served-by: prober
+  referrer-policy: same-origin
+routes:
+  /echo:
+    Cache-Control: no-store
+    X-Frame-Options: ""
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(headersConfigEnv, path)
+	t.Setenv(securityHeadersEnv, "true")
+	headers, err := loadResponseHeaders(st.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.injectedHeaders = headers
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	if err := headers.validate(router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		tls      bool
+		expected map[string]string
+	}{
+		{"/version", false, map[string]string{
+			"X-Served-By":               "prober",
+			"Referrer-Policy":           "same-origin",
+			"X-Frame-Options":           "DENY",
+			"X-Content-Type-Options":    "nosniff",
+			"Strict-Transport-Security": "",
+			"Content-Type":              "application/json; charset=utf-8",
+		}},
+		{"/version", true, map[string]string{"Strict-Transport-Security": hstsValue}},
+		{"/echo", false, map[string]string{"Cache-Control": "no-store", "X-Frame-Options": "", "X-Served-By": "prober"}},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		for name, value := range test.expected {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("%s: expected %s %q, got %q", test.path, name, value, got)
+			}
+		}
+	}
+}
+
+func TestResponseHeadersInvalid(t *testing.T) {
+	for name, config := range map[string]string{
+		"invalid name":  "headers:\n  \"X Bad\": value\n",
+		"invalid value": "routes:\n  /echo:\n    X-Bad: \"a\\nb\"\n",
+		"invalid yaml":  "headers: [",
+	} {
+		path := filepath.Join(t.TempDir(), "headers.yaml")
+		os.WriteFile(path, []byte(config), 0o600)
+		t.Setenv(headersConfigEnv, path)
+		if _, err := loadResponseHeaders(envSettings); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	headers := &responseHeaders{routes: map[string]map[string]string{"/nope": {}}}
+	if err := headers.validate(web.RoutesInfo{{Path: "/echo"}}); err == nil {
+		t.Error("expected an unknown route to be refused")
+	}
+}
@@ -0,0 +1,108 @@
+package prober
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	healthMaxGoroutinesEnv = "HEALTH_MAX_GOROUTINES"
+
+	defaultHealthMaxGoroutines = 10000
+)
+
+// selfHealth tracks the state of prober itself, as opposed to the simulated
+// probes whose failures are intentional.
+type selfHealth struct {
+	mu        sync.Mutex
+	listeners map[string]error
+}
+
+func (h *selfHealth) serving(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners[name] = nil
+}
+
+// stopped records why a listener stopped serving. A nil err still marks the
+// listener as down.
+func (h *selfHealth) stopped(name string, err error) {
+	if err == nil {
+		err = http.ErrServerClosed
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners[name] = err
+}
+
+type healthResponse struct {
+	Status     string            `json:"status"`
+	Listeners  map[string]string `json:"listeners"`
+	Goroutines int               `json:"goroutines"`
+	Config     []string          `json:"configErrors"`
+}
+
+func (h *selfHealth) check(config *settings, maxGoroutines int) (healthResponse, bool) {
+	healthy := true
+	response := healthResponse{
+		Status:     "ok",
+		Listeners:  make(map[string]string),
+		Goroutines: runtime.NumGoroutine(),
+		Config:     validateConfig(config),
+	}
+
+	h.mu.Lock()
+	for name, err := range h.listeners {
+		if err != nil {
+			response.Listeners[name] = err.Error()
+			healthy = false
+			continue
+		}
+		response.Listeners[name] = "up"
+	}
+	h.mu.Unlock()
+
+	if maxGoroutines > 0 && response.Goroutines > maxGoroutines {
+		healthy = false
+	}
+	if len(response.Config) > 0 {
+		healthy = false
+	}
+	if !healthy {
+		response.Status = "unhealthy"
+	}
+	return response, healthy
+}
+
+// validateConfig reports the settings that can be changed at runtime and
+// are silently ignored when invalid, like probe delays posted to /config.
+func validateConfig(config *settings) []string {
+	errs := []string{}
+	for _, env := range []string{startupProbeDelayEnv, readinessProbeDelayEnv, livenessProbeDelayEnv} {
+		value := config.getString(env, "0")
+		if delay, err := strconv.ParseInt(value, 10, 64); err != nil || delay < 0 {
+			errs = append(errs, fmt.Sprintf("%s: invalid delay %q", env, value))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (st *state) healthzHandler() web.HandlerFunc {
+	maxGoroutines := st.config.getInt(healthMaxGoroutinesEnv, defaultHealthMaxGoroutines)
+
+	return func(c *web.Context) {
+		response, healthy := st.serverHealth.check(st.config, maxGoroutines)
+		if !healthy {
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
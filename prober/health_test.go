@@ -0,0 +1,80 @@
+package prober
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestHealthz(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	h := st.serverHealth
+	h.serving(":8080")
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/healthz", st.healthzHandler())
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if response.Listeners[":8080"] != "up" {
+		t.Errorf("expected listener up, got %v", response.Listeners)
+	}
+	if response.Goroutines == 0 {
+		t.Errorf("expected goroutine count, got %d", response.Goroutines)
+	}
+}
+
+func TestHealthzUnhealthy(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, h *selfHealth)
+	}{
+		{"listener down", func(t *testing.T, h *selfHealth) {
+			h.stopped(":8080", errors.New("address already in use"))
+		}},
+		{"invalid config", func(t *testing.T, h *selfHealth) {
+			t.Setenv(livenessProbeDelayEnv, "soon")
+		}},
+		{"too many goroutines", func(t *testing.T, h *selfHealth) {
+			t.Setenv(healthMaxGoroutinesEnv, "1")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(livenessProbeDelayEnv, "0")
+			st := newState(Options{})
+			h := st.serverHealth
+			h.serving(":8080")
+			tt.setup(t, h)
+
+			web.SetMode(web.ReleaseMode)
+			router := web.Default()
+			router.GET("/healthz", st.healthzHandler())
+
+			req, _ := http.NewRequest("GET", "/healthz", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+		})
+	}
+}
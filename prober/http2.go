@@ -0,0 +1,22 @@
+package prober
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	http2EnabledEnv = "HTTP2_ENABLED"
+	h2cEnabledEnv   = "H2C_ENABLED"
+)
+
+// plaintextHandler wraps the router with h2c when enabled, so the plaintext
+// listener accepts both HTTP/1.1 and prior-knowledge/upgraded HTTP/2.
+func plaintextHandler(config *settings, handler http.Handler) http.Handler {
+	if !config.getBool(h2cEnabledEnv, false) {
+		return handler
+	}
+	return h2c.NewHandler(handler, &http2.Server{})
+}
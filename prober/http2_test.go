@@ -0,0 +1,57 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
+)
+
+func TestH2CEcho(t *testing.T) {
+	t.Setenv(h2cEnabledEnv, "true")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+	router.Any("/echo", st.echoRequest)
+	counter := st.httpRequestsTotal.WithLabelValues("GET", "/echo", "200", "HTTP/2.0", ipFamilyIPv4)
+	before := testutil.ToFloat64(counter)
+
+	srv := httptest.NewServer(plaintextHandler(st.config, router))
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get(srv.URL + "/echo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var echo echoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if echo.Proto != "HTTP/2.0" {
+		t.Errorf("expected proto HTTP/2.0, got %s", echo.Proto)
+	}
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("expected the request counted with proto HTTP/2.0, got %v -> %v", before, after)
+	}
+}
@@ -0,0 +1,79 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// httpPhases splits the latency of an outbound request. DNS, connect and
+// TLS are empty when a kept-alive connection was reused.
+type httpPhases struct {
+	DNS      string `json:"dns,omitempty"`
+	Connect  string `json:"connect,omitempty"`
+	TLS      string `json:"tls,omitempty"`
+	TTFB     string `json:"ttfb"`
+	Transfer string `json:"transfer"`
+
+	durations map[string]time.Duration
+}
+
+// phaseTimer records the httptrace events of one request.
+type phaseTimer struct {
+	mu                       sync.Mutex
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	wroteRequest, firstByte  time.Time
+}
+
+func (p *phaseTimer) set(at *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*at = time.Now()
+}
+
+// withTrace returns a context recording the phases of the request made
+// with it. Only the first connection attempt is kept when several
+// addresses are dialed.
+func (p *phaseTimer) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.set(&p.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.set(&p.dnsDone) },
+		ConnectStart: func(string, string) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.connectStart.IsZero() {
+				p.connectStart = time.Now()
+			}
+		},
+		ConnectDone:          func(string, string, error) { p.set(&p.connectEnd) },
+		TLSHandshakeStart:    func() { p.set(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.set(&p.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.set(&p.wroteRequest) },
+		GotFirstResponseByte: func() { p.set(&p.firstByte) },
+	})
+}
+
+// phases computes the breakdown once the body was read, at done.
+func (p *phaseTimer) phases(done time.Time) *httpPhases {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	phases := &httpPhases{durations: make(map[string]time.Duration)}
+	record := func(name string, start time.Time, end time.Time, field *string) {
+		if start.IsZero() || end.IsZero() {
+			return
+		}
+		phases.durations[name] = end.Sub(start)
+		*field = end.Sub(start).String()
+	}
+	record("dns", p.dnsStart, p.dnsDone, &phases.DNS)
+	record("connect", p.connectStart, p.connectEnd, &phases.Connect)
+	record("tls", p.tlsStart, p.tlsDone, &phases.TLS)
+	record("ttfb", p.wroteRequest, p.firstByte, &phases.TTFB)
+	record("transfer", p.firstByte, done, &phases.Transfer)
+	return phases
+}
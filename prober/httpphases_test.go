@@ -0,0 +1,39 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHTTPCheckPhases(t *testing.T) {
+	st := newState(Options{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	ch := st.newChecker(nil)
+	config := checkConfig{Name: "phases", Type: checkTypeHTTP, URL: target.URL, Timeout: time.Second}
+	result := ch.check(config)
+
+	if result.Phases == nil {
+		t.Fatalf("expected phases, got %+v", result)
+	}
+	ttfb, err := time.ParseDuration(result.Phases.TTFB)
+	if err != nil || ttfb < 50*time.Millisecond {
+		t.Errorf("expected a TTFB of at least 50ms, got %q", result.Phases.TTFB)
+	}
+	if result.Phases.Transfer == "" {
+		t.Errorf("expected the transfer phase, got %+v", result.Phases)
+	}
+
+	histogram := st.checkHTTPPhaseDuration.MustCurryWith(prometheus.Labels{"target": "phases"})
+	if count := histogramCount(t, histogram.(*prometheus.HistogramVec), "ttfb"); count != 1 {
+		t.Errorf("expected 1 ttfb observation, got %d", count)
+	}
+}
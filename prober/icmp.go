@@ -0,0 +1,79 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpCheck sends one echo request to the host. Unprivileged ping sockets
+// are tried first, then raw sockets; when neither is allowed in the pod
+// (no net.ipv4.ping_group_range nor NET_RAW) the check is skipped instead
+// of failed.
+func icmpCheck(ctx context.Context, config checkConfig) checkResult {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, config.Host)
+	if err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	ip := addrs[0].IP
+
+	unprivileged, privileged, proto := "udp4", "ip4:icmp", 1
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	var reply icmp.Type = ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		unprivileged, privileged, proto = "udp6", "ip6:ipv6-icmp", 58
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket(unprivileged, "")
+	if err != nil {
+		dst = &net.IPAddr{IP: ip}
+		conn, err = icmp.ListenPacket(privileged, "")
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EPROTONOSUPPORT) {
+			return checkResult{Skipped: true, Error: "ICMP not permitted: " + err.Error()}
+		}
+		return checkResult{Error: err.Error()}
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultCheckTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	seq := int(time.Now().UnixNano() & 0xffff)
+	message, _ := (&icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("prober")},
+	}).Marshal(nil)
+	if _, err := conn.WriteTo(message, dst); err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+		}
+		parsed, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || parsed.Type != reply {
+			continue
+		}
+		// Ping sockets rewrite the ID, so only the sequence is matched.
+		if echo, ok := parsed.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return checkResult{Success: true}
+		}
+	}
+}
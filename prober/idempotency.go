@@ -0,0 +1,200 @@
+package prober
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	idempotencyMaxKeysEnv = "IDEMPOTENCY_MAX_KEYS"
+
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	defaultIdempotencyMaxKeys = 10000
+	// maxIdempotencyBodies is the distinct bodies kept for a key, each cut
+	// to maxIdempotencyBodyBytes.
+	maxIdempotencyBodies    = 10
+	maxIdempotencyBodyBytes = 4096
+)
+
+// idempotencyMetrics count the requests by idempotency outcome.
+type idempotencyMetrics struct {
+	idempotencyRequestsTotal *prometheus.CounterVec
+}
+
+func newIdempotencyMetrics(registry prometheus.Registerer) idempotencyMetrics {
+	m := idempotencyMetrics{
+		idempotencyRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "idempotency_requests_total",
+			Help: "Requests to /idempotency by result: first, duplicate or conflict when the body differs.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.idempotencyRequestsTotal)
+	return m
+}
+
+type idempotentBody struct {
+	Body      string `json:"body"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// idempotencyRecord is what prober saw of a key: every delivery of a
+// request retried by a client or proxy.
+type idempotencyRecord struct {
+	Key       string           `json:"key"`
+	Header    string           `json:"header"`
+	Count     int              `json:"count"`
+	FirstSeen time.Time        `json:"firstSeen"`
+	LastSeen  time.Time        `json:"lastSeen"`
+	Methods   map[string]int   `json:"methods"`
+	Bodies    []idempotentBody `json:"bodies"`
+}
+
+// idempotencyStore keeps the records of the last keys, forgetting the
+// oldest ones past its size.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	size    int
+	records map[string]*idempotencyRecord
+	order   []string
+}
+
+func newIdempotencyStore(size int) *idempotencyStore {
+	return &idempotencyStore{size: size, records: make(map[string]*idempotencyRecord)}
+}
+
+// observe records a delivery of the key and returns the record as it was
+// before, nil for the first one, and after.
+func (s *idempotencyStore) observe(key string, header string, method string, body string, truncated bool, now time.Time) (*idempotencyRecord, idempotencyRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, seen := s.records[key]
+	var previous *idempotencyRecord
+	if seen {
+		snapshot := record.clone()
+		previous = &snapshot
+	} else {
+		record = &idempotencyRecord{Key: key, Header: header, FirstSeen: now, Methods: make(map[string]int)}
+		s.records[key] = record
+		s.order = append(s.order, key)
+		for len(s.order) > s.size && s.size > 0 {
+			delete(s.records, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+	record.Count++
+	record.LastSeen = now
+	record.Methods[method]++
+	for i := range record.Bodies {
+		if record.Bodies[i].Body == body {
+			record.Bodies[i].Count++
+			return previous, record.clone()
+		}
+	}
+	if len(record.Bodies) < maxIdempotencyBodies {
+		record.Bodies = append(record.Bodies, idempotentBody{Body: body, Truncated: truncated, Count: 1})
+	}
+	return previous, record.clone()
+}
+
+func (s *idempotencyStore) get(key string) (idempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	if !ok {
+		return idempotencyRecord{}, false
+	}
+	return record.clone(), true
+}
+
+func (s *idempotencyStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records, s.order = make(map[string]*idempotencyRecord), nil
+}
+
+func (r *idempotencyRecord) clone() idempotencyRecord {
+	clone := *r
+	clone.Methods = make(map[string]int, len(r.Methods))
+	for method, count := range r.Methods {
+		clone.Methods[method] = count
+	}
+	clone.Bodies = append([]idempotentBody{}, r.Bodies...)
+	return clone
+}
+
+type idempotencyAnswer struct {
+	Seen     bool              `json:"seen"`
+	Conflict bool              `json:"conflict"`
+	Record   idempotencyRecord `json:"record"`
+}
+
+// idempotencyHandler records the Idempotency-Key of the request, or the
+// X-Request-ID the client sent, and tells whether it was seen before and
+// whether the body differs from the first delivery. Repeated keys are
+// answered with the duplicateStatus query parameter, 200 by default, to
+// mimic servers rejecting them.
+func (st *state) idempotencyHandler(c *web.Context) {
+	header := idempotencyKeyHeader
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" && !c.GetBool(generatedRequestIDKey) {
+		header, key = requestIDHeader, c.GetHeader(requestIDHeader)
+	}
+	if key == "" {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Missing Idempotency-Key or X-Request-ID header"})
+		return
+	}
+	duplicateStatus := http.StatusOK
+	if value := c.Query("duplicateStatus"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid duplicate status " + value})
+			return
+		}
+		duplicateStatus = status
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotencyBodyBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid body"})
+		return
+	}
+	truncated := len(data) > maxIdempotencyBodyBytes
+	body := string(data[:min(len(data), maxIdempotencyBodyBytes)])
+
+	previous, record := st.idempotencyKeys.observe(key, header, c.Request.Method, body, truncated, time.Now())
+	if previous == nil {
+		st.idempotencyRequestsTotal.WithLabelValues("first").Inc()
+		c.JSON(http.StatusOK, idempotencyAnswer{Record: record})
+		return
+	}
+	conflict := len(previous.Bodies) > 0 && previous.Bodies[0].Body != body
+	result := "duplicate"
+	if conflict {
+		result = "conflict"
+	}
+	st.idempotencyRequestsTotal.WithLabelValues(result).Inc()
+	c.JSON(duplicateStatus, idempotencyAnswer{Seen: true, Conflict: conflict, Record: record})
+}
+
+func (st *state) idempotencyKeyHandler(c *web.Context) {
+	record, ok := st.idempotencyKeys.get(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, web.H{"error": "Key never seen"})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+func (st *state) resetIdempotencyKeys(c *web.Context) {
+	st.idempotencyKeys.reset()
+	c.JSON(http.StatusOK, web.H{"message": "Idempotency keys forgotten"})
+}
@@ -0,0 +1,79 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string, headers map[string]string) (*httptest.ResponseRecorder, idempotencyAnswer) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		router.ServeHTTP(w, req)
+		var answer idempotencyAnswer
+		json.Unmarshal(w.Body.Bytes(), &answer)
+		return w, answer
+	}
+	key := map[string]string{"Idempotency-Key": "order-1"}
+
+	if w, answer := request(http.MethodPost, "/idempotency", `{"amount": 10}`, key); w.Code != http.StatusOK || answer.Seen || answer.Record.Count != 1 {
+		t.Errorf("expected the first delivery, got %d %s", w.Code, w.Body.String())
+	}
+	if w, answer := request(http.MethodPost, "/idempotency?duplicateStatus=409", `{"amount": 10}`, key); w.Code != http.StatusConflict || !answer.Seen || answer.Conflict || answer.Record.Count != 2 {
+		t.Errorf("expected a duplicate answered with 409, got %d %s", w.Code, w.Body.String())
+	}
+	_, answer := request(http.MethodPut, "/idempotency", `{"amount": 20}`, key)
+	if !answer.Conflict || len(answer.Record.Bodies) != 2 || answer.Record.Bodies[0].Count != 2 || answer.Record.Methods["PUT"] != 1 {
+		t.Errorf("expected a conflicting body, got %+v", answer)
+	}
+
+	if _, answer := request(http.MethodPost, "/idempotency", "", map[string]string{"X-Request-ID": "abc"}); answer.Record.Header != "X-Request-ID" || answer.Record.Key != "abc" {
+		t.Errorf("expected the request ID as key, got %+v", answer.Record)
+	}
+	if w, _ := request(http.MethodPost, "/idempotency", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without key, the generated request ID not counting, got %d", w.Code)
+	}
+	if w, _ := request(http.MethodPost, "/idempotency?duplicateStatus=9000", "", key); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid duplicate status, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/idempotency/keys/order-1", nil))
+	var record idempotencyRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &record); err != nil || record.Count != 3 {
+		t.Errorf("expected the record of the key, got %d %s", w.Code, w.Body.String())
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/idempotency/keys", nil))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/idempotency/keys/order-1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 once forgotten, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyStoreEviction(t *testing.T) {
+	store := newIdempotencyStore(2)
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		store.observe(key, idempotencyKeyHeader, http.MethodPost, "", false, now)
+	}
+	if _, ok := store.get("a"); ok {
+		t.Errorf("expected the oldest key to be forgotten")
+	}
+	if _, ok := store.get("c"); !ok {
+		t.Errorf("expected the newest key to be kept")
+	}
+}
@@ -0,0 +1,90 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultInitTimeout = time.Minute
+
+type initReport struct {
+	Success bool          `json:"success"`
+	Results []checkResult `json:"results"`
+}
+
+// runInit implements `prober init`, which runs the checks of a checks file
+// once in parallel and prints their results as JSON, to gate the startup of
+// a pod on its dependencies as an init container. It returns the exit
+// code: 0 when every check passed, 1 when one failed and 2 on usage or
+// configuration errors.
+func runInit(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("init", stderr)
+	path := flags.String("checks", os.Getenv(checksConfigEnv), envUsage("checks file", checksConfigEnv))
+	only := flags.String("only", "", "comma separated names of the checks to run, all by default")
+	timeout := flags.Duration("timeout", defaultInitTimeout, "time limit of the whole run")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(stderr, "missing --checks")
+		return 2
+	}
+
+	checks, err := loadChecksConfig(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid checks configuration: %v\n", err)
+		return 2
+	}
+	if *only != "" {
+		selected := make(map[string]bool)
+		for _, name := range strings.Split(*only, ",") {
+			selected[strings.TrimSpace(name)] = true
+		}
+		var filtered []checkConfig
+		for _, check := range checks {
+			if selected[check.Name] {
+				filtered = append(filtered, check)
+				delete(selected, check.Name)
+			}
+		}
+		for name := range selected {
+			fmt.Fprintf(stderr, "unknown check %q\n", name)
+			return 2
+		}
+		checks = filtered
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report := initReport{Success: true, Results: make([]checkResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, check.Timeout)
+			defer cancel()
+			report.Results[i] = runCheck(checkCtx, check)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Results {
+		if !result.Success {
+			report.Success = false
+		}
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if !report.Success {
+		return 1
+	}
+	return 0
+}
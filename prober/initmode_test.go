@@ -0,0 +1,50 @@
+package prober
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunInit(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	path := writeChecksConfig(t, `
+checks:
+  - name: api
+    url: `+target.URL+`
+  - name: db
+    type: tcp
+    address: 127.0.0.1:1
+    timeout: 1s
+`)
+
+	tests := []struct {
+		args    []string
+		code    int
+		results int
+	}{
+		{[]string{"--checks", path}, 1, 2},
+		{[]string{"--checks", path, "--only", "api"}, 0, 1},
+		{[]string{"--checks", path, "--only", "cache"}, 2, 0},
+		{[]string{}, 2, 0},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runInit(test.args, &stdout, &stderr); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d (%s)", test.args, test.code, code, stderr.String())
+		}
+		if test.results == 0 {
+			continue
+		}
+		var report initReport
+		if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+			t.Fatalf("invalid output: %v", err)
+		}
+		if len(report.Results) != test.results || report.Success != (test.code == 0) {
+			t.Errorf("%v: unexpected report %+v", test.args, report)
+		}
+	}
+}
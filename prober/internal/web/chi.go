@@ -0,0 +1,358 @@
+//go:build chi
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Router names the implementation the binary was built with.
+const Router = "chi"
+
+// ReleaseMode is accepted by SetMode like with gin.
+const ReleaseMode = "release"
+
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodHead, http.MethodOptions, http.MethodDelete, http.MethodConnect,
+	http.MethodTrace,
+}
+
+var (
+	default404Body = []byte("404 page not found")
+	mimePlain      = []string{"text/plain"}
+
+	regSafePrefix         = regexp.MustCompile("[^a-zA-Z0-9/-]+")
+	regRemoveRepeatedChar = regexp.MustCompile("/{2,}")
+)
+
+// RouteInfo describes a route of the engine.
+type RouteInfo struct {
+	Method      string
+	Path        string
+	Handler     string
+	HandlerFunc HandlerFunc
+}
+
+// RoutesInfo are the routes of the engine, in the order they were added.
+type RoutesInfo []RouteInfo
+
+// RecoveryFunc handles the panics recovered by CustomRecoveryWithWriter.
+type RecoveryFunc func(c *Context, err any)
+
+// RouterGroup adds routes under a path prefix, behind its middlewares.
+type RouterGroup struct {
+	Handlers []HandlerFunc
+	basePath string
+	engine   *Engine
+}
+
+// route is a route of the engine, found by the method and chi pattern of
+// its template.
+type route struct {
+	path     string
+	handlers []HandlerFunc
+	// catchAll is the name of the *param ending the template.
+	catchAll string
+}
+
+// Engine routes the requests with a chi tree: the templates keep the gin
+// :param and *param syntax, the static segments win over the parameters,
+// the paths differing by a trailing slash from a route are redirected to
+// it and the others get a 404, after the middlewares.
+type Engine struct {
+	RouterGroup
+
+	mux        *chi.Mux
+	routes     map[string]*route
+	infos      RoutesInfo
+	noRoute    []HandlerFunc
+	allNoRoute []HandlerFunc
+}
+
+// New returns an engine without middleware.
+func New() *Engine {
+	engine := &Engine{mux: chi.NewRouter(), routes: make(map[string]*route)}
+	engine.RouterGroup = RouterGroup{basePath: "/", engine: engine}
+	return engine
+}
+
+// Default returns an engine with the recovery middleware.
+func Default() *Engine {
+	engine := New()
+	engine.Use(CustomRecoveryWithWriter(os.Stderr, func(c *Context, err any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	return engine
+}
+
+// SetMode is kept for gin compatibility, the chi engine having no debug
+// output to turn off.
+func SetMode(string) {}
+
+// WrapF and WrapH adapt net/http handlers.
+func WrapF(f http.HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		f(c.Writer, c.Request)
+	}
+}
+
+func WrapH(h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// CustomRecoveryWithWriter recovers the panics of the handlers, passing them
+// to handle. The ones of broken connections only abort, the answer having
+// nowhere to go.
+func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
+	var logger *log.Logger
+	if out != nil {
+		logger = log.New(out, "", log.LstdFlags)
+	}
+	return func(c *Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			brokenPipe := false
+			var opErr *net.OpError
+			var syscallErr *os.SyscallError
+			if e, ok := err.(error); ok && errors.As(e, &opErr) && errors.As(opErr, &syscallErr) {
+				message := strings.ToLower(syscallErr.Error())
+				brokenPipe = strings.Contains(message, "broken pipe") || strings.Contains(message, "connection reset by peer")
+			}
+			if logger != nil {
+				logger.Printf("[Recovery] panic recovered:\n%v\n%s", err, debug.Stack())
+			}
+			if brokenPipe {
+				c.Abort()
+				return
+			}
+			handle(c, err)
+		}()
+		c.Next()
+	}
+}
+
+// Use adds middlewares to the routes added next and to the requests
+// matching no route.
+func (engine *Engine) Use(middleware ...HandlerFunc) {
+	engine.RouterGroup.Use(middleware...)
+	engine.allNoRoute = engine.combineHandlers(engine.noRoute)
+}
+
+// NoRoute sets the handlers of the requests matching no route.
+func (engine *Engine) NoRoute(handlers ...HandlerFunc) {
+	engine.noRoute = handlers
+	engine.allNoRoute = engine.combineHandlers(engine.noRoute)
+}
+
+// Routes returns the routes of the engine.
+func (engine *Engine) Routes() RoutesInfo {
+	return append(RoutesInfo(nil), engine.infos...)
+}
+
+// Use adds middlewares to the routes of the group added next.
+func (group *RouterGroup) Use(middleware ...HandlerFunc) {
+	group.Handlers = append(group.Handlers, middleware...)
+}
+
+// Group returns a group of routes under the path, behind the middlewares
+// of this one and the handlers.
+func (group *RouterGroup) Group(relativePath string, handlers ...HandlerFunc) *RouterGroup {
+	return &RouterGroup{
+		Handlers: group.combineHandlers(handlers),
+		basePath: joinPaths(group.basePath, relativePath),
+		engine:   group.engine,
+	}
+}
+
+// Handle adds a route for the method.
+func (group *RouterGroup) Handle(method string, relativePath string, handlers ...HandlerFunc) {
+	group.engine.addRoute(method, joinPaths(group.basePath, relativePath), group.combineHandlers(handlers))
+}
+
+func (group *RouterGroup) GET(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+func (group *RouterGroup) POST(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+func (group *RouterGroup) PUT(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodPut, relativePath, handlers...)
+}
+
+func (group *RouterGroup) PATCH(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodPatch, relativePath, handlers...)
+}
+
+func (group *RouterGroup) DELETE(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodDelete, relativePath, handlers...)
+}
+
+func (group *RouterGroup) HEAD(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodHead, relativePath, handlers...)
+}
+
+func (group *RouterGroup) OPTIONS(relativePath string, handlers ...HandlerFunc) {
+	group.Handle(http.MethodOptions, relativePath, handlers...)
+}
+
+// Any adds the route for every standard method.
+func (group *RouterGroup) Any(relativePath string, handlers ...HandlerFunc) {
+	for _, method := range anyMethods {
+		group.Handle(method, relativePath, handlers...)
+	}
+}
+
+func (group *RouterGroup) combineHandlers(handlers []HandlerFunc) []HandlerFunc {
+	combined := make([]HandlerFunc, 0, len(group.Handlers)+len(handlers))
+	combined = append(combined, group.Handlers...)
+	return append(combined, handlers...)
+}
+
+func joinPaths(absolutePath string, relativePath string) string {
+	if relativePath == "" {
+		return absolutePath
+	}
+	joined := path.Join(absolutePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(joined, "/") {
+		return joined + "/"
+	}
+	return joined
+}
+
+// addRoute registers the template in the chi tree, its :param segments as
+// {param} and its *param one as the * wildcard.
+func (engine *Engine) addRoute(method string, template string, handlers []HandlerFunc) {
+	if len(handlers) == 0 {
+		panic("web: a route needs at least one handler")
+	}
+	r := &route{path: template, handlers: handlers}
+	segments := strings.Split(template, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = "{" + segment[1:] + "}"
+		case strings.HasPrefix(segment, "*"):
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("web: catch-all parameter %s must end the path %s", segment, template))
+			}
+			r.catchAll = segment[1:]
+			segments[i] = "*"
+		}
+	}
+	pattern := strings.Join(segments, "/")
+	engine.mux.Method(method, pattern, http.NotFoundHandler())
+	engine.routes[method+" "+pattern] = r
+
+	last := handlers[len(handlers)-1]
+	engine.infos = append(engine.infos, RouteInfo{
+		Method:      method,
+		Path:        template,
+		Handler:     runtime.FuncForPC(reflect.ValueOf(last).Pointer()).Name(),
+		HandlerFunc: last,
+	})
+}
+
+// match returns the route of the method and path, with its parameters.
+func (engine *Engine) match(method string, path string) (*route, Params) {
+	rctx := chi.NewRouteContext()
+	if !engine.mux.Match(rctx, method, path) || len(rctx.RoutePatterns) == 0 {
+		return nil, nil
+	}
+	r := engine.routes[method+" "+rctx.RoutePatterns[len(rctx.RoutePatterns)-1]]
+	if r == nil {
+		return nil, nil
+	}
+	var params Params
+	for i, key := range rctx.URLParams.Keys {
+		value := rctx.URLParams.Values[i]
+		if key == "*" {
+			// Like gin, the catch-all value starts with the slash.
+			key, value = r.catchAll, "/"+value
+		}
+		params = append(params, Param{Key: key, Value: value})
+	}
+	return r, params
+}
+
+func (engine *Engine) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c := newContext(w, req)
+	method, requestPath := req.Method, req.URL.Path
+	if r, params := engine.match(method, requestPath); r != nil {
+		c.Params, c.handlers, c.fullPath = params, r.handlers, r.path
+		c.Next()
+		c.writermem.WriteHeaderNow()
+		return
+	}
+	if method != http.MethodConnect && requestPath != "/" {
+		alternative := requestPath + "/"
+		if strings.HasSuffix(requestPath, "/") {
+			alternative = requestPath[:len(requestPath)-1]
+		}
+		if r, _ := engine.match(method, alternative); r != nil {
+			redirectTrailingSlash(c)
+			return
+		}
+	}
+	c.handlers = engine.allNoRoute
+	serveError(c, http.StatusNotFound, default404Body)
+}
+
+// serveError runs the middlewares of the requests matching no route,
+// answering the default body when none answered.
+func serveError(c *Context, code int, defaultMessage []byte) {
+	c.writermem.status = code
+	c.Next()
+	if c.writermem.Written() {
+		return
+	}
+	if c.writermem.Status() == code {
+		c.writermem.Header()["Content-Type"] = mimePlain
+		c.Writer.Write(defaultMessage)
+		return
+	}
+	c.writermem.WriteHeaderNow()
+}
+
+// redirectTrailingSlash redirects to the path with the trailing slash
+// added or removed, under the X-Forwarded-Prefix sent by a proxy.
+func redirectTrailingSlash(c *Context) {
+	req := c.Request
+	p := req.URL.Path
+	if prefix := path.Clean(req.Header.Get("X-Forwarded-Prefix")); prefix != "." {
+		prefix = regSafePrefix.ReplaceAllString(prefix, "")
+		prefix = regRemoveRepeatedChar.ReplaceAllString(prefix, "/")
+		p = prefix + "/" + req.URL.Path
+	}
+	req.URL.Path = p + "/"
+	if length := len(p); length > 1 && p[length-1] == '/' {
+		req.URL.Path = p[:length-1]
+	}
+	code := http.StatusMovedPermanently
+	if req.Method != http.MethodGet {
+		code = http.StatusTemporaryRedirect
+	}
+	http.Redirect(c.Writer, req, req.URL.String(), code)
+	c.writermem.WriteHeaderNow()
+}
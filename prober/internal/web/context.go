@@ -0,0 +1,412 @@
+//go:build chi
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const abortIndex = math.MaxInt32
+
+var (
+	jsonContentType  = []string{"application/json; charset=utf-8"}
+	plainContentType = []string{"text/plain; charset=utf-8"}
+)
+
+// H is a shortcut for the JSON objects of the answers.
+type H map[string]any
+
+// HandlerFunc is a handler or middleware of a route.
+type HandlerFunc func(*Context)
+
+// Param is a parameter of the route template, like :name or *path.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params are the parameters of the route, in the order of the template.
+type Params []Param
+
+// Get returns the value of the parameter and whether it exists.
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ByName returns the value of the parameter, empty when it doesn't exist.
+func (ps Params) ByName(name string) string {
+	value, _ := ps.Get(name)
+	return value
+}
+
+// Context carries a request through the middlewares and handler of its
+// route, like the gin one.
+type Context struct {
+	Request *http.Request
+	Writer  ResponseWriter
+	Params  Params
+
+	// Keys are the values the middlewares and handlers share, through Set
+	// and Get.
+	Keys map[string]any
+	mu   sync.RWMutex
+
+	writermem  responseWriter
+	handlers   []HandlerFunc
+	index      int
+	fullPath   string
+	queryCache url.Values
+}
+
+func newContext(w http.ResponseWriter, req *http.Request) *Context {
+	c := &Context{Request: req, index: -1}
+	c.writermem.reset(w)
+	c.Writer = &c.writermem
+	return c
+}
+
+// Next runs the next handlers of the chain, returning once they did.
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// IsAborted tells whether the handlers left are skipped.
+func (c *Context) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// Abort skips the handlers left, the current one running on.
+func (c *Context) Abort() {
+	c.index = abortIndex
+}
+
+// AbortWithStatus writes the header with the status and aborts.
+func (c *Context) AbortWithStatus(code int) {
+	c.Status(code)
+	c.Writer.WriteHeaderNow()
+	c.Abort()
+}
+
+// AbortWithStatusJSON aborts with the status and the object as JSON body.
+func (c *Context) AbortWithStatusJSON(code int, obj any) {
+	c.Abort()
+	c.JSON(code, obj)
+}
+
+// FullPath returns the template of the matched route, empty for the
+// requests matching none.
+func (c *Context) FullPath() string {
+	return c.fullPath
+}
+
+// Set stores a value for the next handlers of the request.
+func (c *Context) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Keys == nil {
+		c.Keys = make(map[string]any)
+	}
+	c.Keys[key] = value
+}
+
+// Get returns the value stored for the key and whether it exists.
+func (c *Context) Get(key string) (value any, exists bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, exists = c.Keys[key]
+	return value, exists
+}
+
+// GetString returns the value of the key as a string.
+func (c *Context) GetString(key string) (s string) {
+	if value, ok := c.Get(key); ok && value != nil {
+		s, _ = value.(string)
+	}
+	return s
+}
+
+// GetBool returns the value of the key as a boolean.
+func (c *Context) GetBool(key string) (b bool) {
+	if value, ok := c.Get(key); ok && value != nil {
+		b, _ = value.(bool)
+	}
+	return b
+}
+
+// GetStringSlice returns the value of the key as a slice of strings.
+func (c *Context) GetStringSlice(key string) (ss []string) {
+	if value, ok := c.Get(key); ok && value != nil {
+		ss, _ = value.([]string)
+	}
+	return ss
+}
+
+// Param returns the value of a parameter of the route template.
+func (c *Context) Param(key string) string {
+	return c.Params.ByName(key)
+}
+
+func (c *Context) initQueryCache() {
+	if c.queryCache != nil {
+		return
+	}
+	if c.Request != nil {
+		c.queryCache = c.Request.URL.Query()
+	} else {
+		c.queryCache = url.Values{}
+	}
+}
+
+// GetQuery returns the first value of the query parameter and whether it
+// was sent.
+func (c *Context) GetQuery(key string) (string, bool) {
+	c.initQueryCache()
+	if values := c.queryCache[key]; len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+// Query returns the first value of the query parameter, empty when absent.
+func (c *Context) Query(key string) string {
+	value, _ := c.GetQuery(key)
+	return value
+}
+
+// DefaultQuery returns the first value of the query parameter, or the
+// default when it was not sent.
+func (c *Context) DefaultQuery(key string, defaultValue string) string {
+	if value, ok := c.GetQuery(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// GetHeader returns the value of a request header.
+func (c *Context) GetHeader(key string) string {
+	return c.Request.Header.Get(key)
+}
+
+// Header sets a response header, or deletes it when the value is empty.
+func (c *Context) Header(key string, value string) {
+	if value == "" {
+		c.Writer.Header().Del(key)
+		return
+	}
+	c.Writer.Header().Set(key, value)
+}
+
+// Status sets the status of the answer, until its header is written.
+func (c *Context) Status(code int) {
+	c.Writer.WriteHeader(code)
+}
+
+// Cookie returns the unescaped value of a request cookie.
+func (c *Context) Cookie(name string) (string, error) {
+	cookie, err := c.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	value, _ := url.QueryUnescape(cookie.Value)
+	return value, nil
+}
+
+// SetCookie adds a Set-Cookie header with the escaped value, for the root
+// path when none is given.
+func (c *Context) SetCookie(name string, value string, maxAge int, path string, domain string, secure bool, httpOnly bool) {
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    url.QueryEscape(value),
+		MaxAge:   maxAge,
+		Path:     path,
+		Domain:   domain,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+	})
+}
+
+// GetRawData reads the request body.
+func (c *Context) GetRawData() ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, errors.New("invalid request")
+	}
+	return io.ReadAll(c.Request.Body)
+}
+
+// ShouldBindJSON decodes the JSON body into obj.
+func (c *Context) ShouldBindJSON(obj any) error {
+	if c.Request == nil || c.Request.Body == nil {
+		return errors.New("invalid request")
+	}
+	return json.NewDecoder(c.Request.Body).Decode(obj)
+}
+
+// BindJSON decodes the JSON body into obj, aborting with a 400 when it is
+// invalid.
+func (c *Context) BindJSON(obj any) error {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return err
+	}
+	return nil
+}
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func writeContentType(w http.ResponseWriter, value []string) {
+	header := w.Header()
+	if len(header["Content-Type"]) == 0 {
+		header["Content-Type"] = value
+	}
+}
+
+// render writes the status and, when the status allows one, the body.
+func (c *Context) render(code int, contentType []string, body func(w ResponseWriter) error) {
+	c.Status(code)
+	if !bodyAllowedForStatus(code) {
+		writeContentType(c.Writer, contentType)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	writeContentType(c.Writer, contentType)
+	if err := body(c.Writer); err != nil {
+		c.Abort()
+	}
+}
+
+// JSON answers the object as JSON.
+func (c *Context) JSON(code int, obj any) {
+	c.render(code, jsonContentType, func(w ResponseWriter) error {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// String answers the formatted text.
+func (c *Context) String(code int, format string, values ...any) {
+	c.render(code, plainContentType, func(w ResponseWriter) error {
+		if len(values) == 0 {
+			_, err := w.WriteString(format)
+			return err
+		}
+		_, err := fmt.Fprintf(w, format, values...)
+		return err
+	})
+}
+
+// Data answers the bytes with their content type.
+func (c *Context) Data(code int, contentType string, data []byte) {
+	c.render(code, []string{contentType}, func(w ResponseWriter) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Redirect answers a redirection to the location.
+func (c *Context) Redirect(code int, location string) {
+	if (code < http.StatusMultipleChoices || code > http.StatusPermanentRedirect) && code != http.StatusCreated {
+		panic(fmt.Sprintf("Cannot redirect with status code %d", code))
+	}
+	http.Redirect(c.Writer, c.Request, location, code)
+}
+
+// RemoteIP returns the IP of the peer of the connection.
+func (c *Context) RemoteIP() string {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+// ClientIP returns the IP of the client, from X-Forwarded-For or X-Real-IP
+// when sent since every proxy is trusted like with gin by default.
+func (c *Context) ClientIP() string {
+	remoteIP := net.ParseIP(c.RemoteIP())
+	if remoteIP == nil {
+		return ""
+	}
+	for _, name := range []string{"X-Forwarded-For", "X-Real-IP"} {
+		if ip, ok := firstForwardedIP(c.GetHeader(name)); ok {
+			return ip
+		}
+	}
+	return remoteIP.String()
+}
+
+// firstForwardedIP returns the first IP of the header, when every IP it
+// lists is valid.
+func firstForwardedIP(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	items := strings.Split(header, ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(items[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if i == 0 {
+			return ip, true
+		}
+	}
+	return "", false
+}
+
+// Deadline, Done and Err make the Context a context.Context without
+// deadline nor cancellation, the one of the request being its Request's.
+func (c *Context) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c *Context) Done() <-chan struct{} {
+	return nil
+}
+
+func (c *Context) Err() error {
+	return nil
+}
+
+// Value returns the values stored with Set.
+func (c *Context) Value(key any) any {
+	if name, ok := key.(string); ok {
+		if value, exists := c.Get(name); exists {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+// Package web routes prober's endpoints with gin, or with chi and net/http
+// when built with the chi tag, for the images which must not carry the gin
+// dependency tree. The chi build implements the part of the gin API prober
+// uses with the same routing rules, so the handlers compile unchanged and
+// answer alike with either router.
+package web
@@ -0,0 +1,57 @@
+//go:build !chi
+
+package web
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Router names the implementation the binary was built with.
+const Router = "gin"
+
+// ReleaseMode turns off the debug output of gin.
+const ReleaseMode = gin.ReleaseMode
+
+type (
+	Context        = gin.Context
+	Engine         = gin.Engine
+	RouterGroup    = gin.RouterGroup
+	HandlerFunc    = gin.HandlerFunc
+	RecoveryFunc   = gin.RecoveryFunc
+	ResponseWriter = gin.ResponseWriter
+	RouteInfo      = gin.RouteInfo
+	RoutesInfo     = gin.RoutesInfo
+	H              = gin.H
+)
+
+// New returns an engine without middleware.
+func New() *Engine {
+	return gin.New()
+}
+
+// Default returns an engine with the logger and recovery middlewares.
+func Default() *Engine {
+	return gin.Default()
+}
+
+func SetMode(mode string) {
+	gin.SetMode(mode)
+}
+
+// WrapF and WrapH adapt net/http handlers.
+func WrapF(f http.HandlerFunc) HandlerFunc {
+	return gin.WrapF(f)
+}
+
+func WrapH(h http.Handler) HandlerFunc {
+	return gin.WrapH(h)
+}
+
+// CustomRecoveryWithWriter recovers the panics of the handlers, passing them
+// to handle.
+func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
+	return gin.CustomRecoveryWithWriter(out, handle)
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The tests run with both routers, `go test -tags chi` covering the chi one.
+
+func newTestEngine() *Engine {
+	SetMode(ReleaseMode)
+	router := New()
+	router.Use(CustomRecoveryWithWriter(io.Discard, func(c *Context, err any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.Use(func(c *Context) {
+		c.Header("X-Route", "route="+c.FullPath())
+	})
+	router.GET("/status/:code", func(c *Context) {
+		c.String(http.StatusOK, "code %s", c.Param("code"))
+	})
+	router.GET("/status/teapot", func(c *Context) {
+		c.String(http.StatusTeapot, "teapot")
+	})
+	router.GET("/files/*path", func(c *Context) {
+		c.JSON(http.StatusOK, H{"path": c.Param("path"), "q": c.DefaultQuery("q", "none")})
+	})
+	api := router.Group("/api")
+	api.POST("/items/", func(c *Context) {
+		var item map[string]string
+		if err := c.ShouldBindJSON(&item); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": "Invalid JSON"})
+			return
+		}
+		c.JSON(http.StatusCreated, item)
+	})
+	router.GET("/panic", func(c *Context) {
+		panic("boom")
+	})
+	router.GET("/wrapped", WrapF(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return router
+}
+
+func TestRouting(t *testing.T) {
+	router := newTestEngine()
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		route, answer      string
+	}{
+		{"GET", "/status/503", "", http.StatusOK, "/status/:code", "code 503"},
+		{"GET", "/status/teapot", "", http.StatusTeapot, "/status/teapot", "teapot"},
+		{"GET", "/files/a/b.txt?q=x", "", http.StatusOK, "/files/*path", `{"path":"/a/b.txt","q":"x"}`},
+		{"GET", "/files/", "", http.StatusOK, "/files/*path", `{"path":"/","q":"none"}`},
+		{"POST", "/api/items/", `{"name":"a"}`, http.StatusCreated, "/api/items/", `{"name":"a"}`},
+		{"POST", "/api/items/", `{`, http.StatusBadRequest, "/api/items/", `{"error":"Invalid JSON"}`},
+		{"POST", "/api/items", `{}`, http.StatusTemporaryRedirect, "", ""},
+		{"GET", "/status/503/", "", http.StatusMovedPermanently, "", ""},
+		{"GET", "/nope", "", http.StatusNotFound, "", "404 page not found"},
+		{"DELETE", "/status/503", "", http.StatusNotFound, "", "404 page not found"},
+		{"GET", "/panic", "", http.StatusInternalServerError, "/panic", ""},
+		{"GET", "/wrapped", "", http.StatusAccepted, "/wrapped", ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.code, w.Code)
+		}
+		if test.answer != "" && w.Body.String() != test.answer {
+			t.Errorf("%s %s: expected body %q, got %q", test.method, test.path, test.answer, w.Body.String())
+		}
+		if w.Code != http.StatusMovedPermanently && w.Code != http.StatusTemporaryRedirect && w.Code != http.StatusInternalServerError {
+			if route := w.Header().Get("X-Route"); route != "route="+test.route {
+				t.Errorf("%s %s: expected route %q, got %q", test.method, test.path, test.route, route)
+			}
+		}
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	router := newTestEngine()
+	req, _ := http.NewRequest("GET", "/status/503/", nil)
+	req.Header.Set("X-Forwarded-Prefix", "/prober")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if location := w.Header().Get("Location"); location != "/prober/status/503" {
+		t.Errorf("expected the redirect under the prefix, got %q", location)
+	}
+}
+
+func TestNoRoute(t *testing.T) {
+	router := newTestEngine()
+	router.NoRoute(func(c *Context) {
+		c.JSON(http.StatusNotFound, H{"error": "Not found", "path": c.Request.URL.Path})
+	})
+	req, _ := http.NewRequest("GET", "/nope", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var body map[string]string
+	if w.Code != http.StatusNotFound || json.Unmarshal(w.Body.Bytes(), &body) != nil || body["path"] != "/nope" {
+		t.Errorf("expected the NoRoute answer, got %d %s", w.Code, w.Body.String())
+	}
+	if route := w.Header().Get("X-Route"); route != "route=" {
+		t.Errorf("expected the middlewares to run without route, got %v", w.Header())
+	}
+}
+
+func TestContext(t *testing.T) {
+	router := New()
+	router.GET("/ctx", func(c *Context) {
+		c.SetCookie("session", "a b", 60, "", "", false, true)
+		cookie, _ := c.Cookie("flavor")
+		c.Header("X-Empty", "")
+		c.JSON(http.StatusOK, H{
+			"clientIp": c.ClientIP(),
+			"remoteIp": c.RemoteIP(),
+			"cookie":   cookie,
+			"header":   c.GetHeader("X-Custom"),
+		})
+	})
+	req, _ := http.NewRequest("GET", "/ctx", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+	req.Header.Set("X-Custom", "value")
+	req.AddCookie(&http.Cookie{Name: "flavor", Value: "oat%20meal"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := `{"clientIp":"203.0.113.7","cookie":"oat meal","header":"value","remoteIp":"10.0.0.1"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.Body.String())
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "session=a+b; Path=/; Max-Age=60; HttpOnly" {
+		t.Errorf("expected the escaped cookie on the root path, got %q", cookie)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Errorf("expected the JSON content type, got %q", contentType)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	router := newTestEngine()
+	var routes []string
+	for _, route := range router.Routes() {
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+	for _, expected := range []string{"GET /status/:code", "GET /files/*path", "POST /api/items/"} {
+		found := false
+		for _, route := range routes {
+			found = found || route == expected
+		}
+		if !found {
+			t.Errorf("expected route %s, got %v", expected, routes)
+		}
+	}
+}
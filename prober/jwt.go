@@ -0,0 +1,538 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	jwtJWKSURLEnv     = "JWT_JWKS_URL"
+	jwtKeyFileEnv     = "JWT_KEY_FILE"
+	jwtIssuerEnv      = "JWT_ISSUER"
+	jwtAudienceEnv    = "JWT_AUDIENCE"
+	jwtLeewayEnv      = "JWT_LEEWAY"
+	jwtJWKSRefreshEnv = "JWT_JWKS_REFRESH"
+
+	defaultJWTLeeway   = time.Minute
+	defaultJWKSRefresh = 5 * time.Minute
+	// jwksMinRefresh limits the fetches of the JWKS triggered by tokens
+	// signed with an unknown key.
+	jwksMinRefresh = 10 * time.Second
+	jwksTimeout    = 5 * time.Second
+)
+
+// jwtMetrics count the token validations.
+type jwtMetrics struct {
+	jwtValidationsTotal *prometheus.CounterVec
+}
+
+func newJWTMetrics(registry prometheus.Registerer) jwtMetrics {
+	m := jwtMetrics{
+		jwtValidationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jwt_validations_total",
+			Help: "Tokens validated by /jwt, by result.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.jwtValidationsTotal)
+	return m
+}
+
+// jsonWebKey is a key of a JWKS, the fields used depending on its type.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type verificationKey struct {
+	id  string
+	key any
+}
+
+// jwtValidator checks the tokens presented to /jwt against a static key or
+// the keys of a JWKS, fetched again every refresh or when a token names an
+// unknown one. The JWKS is fetched by one goroutine at a time, outside of
+// the lock, so a slow issuer only holds the tokens needing its new keys.
+type jwtValidator struct {
+	static    any
+	jwksURL   string
+	issuer    string
+	audiences []string
+	leeway    time.Duration
+	refresh   time.Duration
+	client    *http.Client
+
+	mu      sync.Mutex
+	keys    []verificationKey
+	fetched time.Time
+	// fetching is closed once the fetch in flight completes, nil without.
+	fetching  chan struct{}
+	lastError error
+}
+
+// loadJWTValidator reads the JWT_ variables, nil when no key is configured.
+func loadJWTValidator(config *settings) (*jwtValidator, error) {
+	v := &jwtValidator{
+		jwksURL:   config.getString(jwtJWKSURLEnv, ""),
+		issuer:    config.getString(jwtIssuerEnv, ""),
+		audiences: splitList(config.getString(jwtAudienceEnv, "")),
+		leeway:    config.getDuration(jwtLeewayEnv, defaultJWTLeeway),
+		refresh:   config.getDuration(jwtJWKSRefreshEnv, defaultJWKSRefresh),
+		client:    &http.Client{Timeout: jwksTimeout},
+	}
+	path := config.getString(jwtKeyFileEnv, "")
+	switch {
+	case path == "" && v.jwksURL == "":
+		return nil, nil
+	case path != "" && v.jwksURL != "":
+		return nil, fmt.Errorf("%s and %s are exclusive", jwtKeyFileEnv, jwtJWKSURLEnv)
+	case v.leeway < 0 || v.refresh <= 0:
+		return nil, fmt.Errorf("invalid %s or %s", jwtLeewayEnv, jwtJWKSRefreshEnv)
+	}
+	if path != "" {
+		key, err := loadJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", jwtKeyFileEnv, err)
+		}
+		v.static = key
+	}
+	return v, nil
+}
+
+// loadJWTKey reads a PEM public key or certificate, the content of other
+// files being the secret of the HMAC algorithms.
+func loadJWTKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, errors.New("empty key")
+		}
+		return secret, nil
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// parseJWK returns the public key of a JWK, nil for the types and uses that
+// can't verify signatures.
+func parseJWK(jwk jsonWebKey) (any, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key on %q", jwk.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(k) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+		return k, nil
+	}
+	return nil, nil
+}
+
+func (v *jwtValidator) fetchJWKS(ctx context.Context) ([]verificationKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS answered %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	var keys []verificationKey
+	for _, jwk := range set.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			slog.Warn("Skipping invalid JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		if key != nil {
+			keys = append(keys, verificationKey{id: jwk.Kid, key: key})
+		}
+	}
+	return keys, nil
+}
+
+// refreshKeys fetches the JWKS and closes done. It doesn't use the context
+// of the request which started it, the others waiting for it too.
+func (v *jwtValidator) refreshKeys(done chan struct{}) {
+	keys, err := v.fetchJWKS(context.Background())
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		if !v.fetched.IsZero() {
+			slog.Warn("JWKS refresh failed, keeping the previous keys", "url", v.jwksURL, "error", err)
+		}
+		v.lastError = err
+	} else {
+		v.keys, v.fetched, v.lastError = keys, time.Now(), nil
+	}
+	v.fetching = nil
+	close(done)
+}
+
+// keysFor returns the keys a token signed with the key id may be verified
+// with, all of them without id.
+func (v *jwtValidator) keysFor(ctx context.Context, kid string) ([]verificationKey, error) {
+	if v.static != nil {
+		return []verificationKey{{key: v.static}}, nil
+	}
+	v.mu.Lock()
+	known := slices.ContainsFunc(v.keys, func(key verificationKey) bool { return key.id == kid })
+	age := time.Since(v.fetched)
+	var wait chan struct{}
+	if v.fetched.IsZero() || age > v.refresh || (kid != "" && !known && age > jwksMinRefresh) {
+		if v.fetching == nil {
+			v.fetching = make(chan struct{})
+			go v.refreshKeys(v.fetching)
+		}
+		// The tokens the current keys can check don't wait for the refresh.
+		if v.fetched.IsZero() || (kid != "" && !known) {
+			wait = v.fetching
+		}
+	}
+	v.mu.Unlock()
+	if wait != nil {
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("fetching the JWKS: %w", ctx.Err())
+		}
+	}
+
+	v.mu.Lock()
+	keys, fetched, lastError := v.keys, v.fetched, v.lastError
+	v.mu.Unlock()
+	if fetched.IsZero() {
+		return nil, fmt.Errorf("fetching the JWKS: %w", lastError)
+	}
+	if kid == "" {
+		return keys, nil
+	}
+	var matching []verificationKey
+	for _, key := range keys {
+		if key.id == kid {
+			matching = append(matching, key)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no key %q in the JWKS", kid)
+	}
+	return matching, nil
+}
+
+var errJWTKeyType = errors.New("key type doesn't match the algorithm")
+
+// verifyJWTSignature checks the signature of the signing input, the
+// encoded header and claims, with a key of the type the algorithm needs.
+func verifyJWTSignature(alg string, key any, input []byte, signature []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errJWTKeyType
+		}
+		if !ed25519.Verify(pub, input, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	hash, ok := hashes[strings.TrimLeft(alg, "HRSPE")]
+	if !ok || len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errJWTKeyType
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(input)
+		valid = hmac.Equal(mac.Sum(nil), signature)
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errJWTKeyType
+		}
+		if alg[0] == 'R' {
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		} else {
+			valid = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errJWTKeyType
+		}
+		// The signature is r and s side by side, not ASN.1.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a part of a token, keeping the numbers as they are.
+func decodeJWTPart(part string) (map[string]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// claimTime returns a NumericDate claim, false when it is missing.
+func claimTime(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	return time.Unix(int64(seconds), 0).UTC(), true, nil
+}
+
+// jwtAnswer is what /jwt tells about a token, its header and claims being
+// decoded even when it is refused, to help tell why.
+type jwtAnswer struct {
+	Valid     bool           `json:"valid"`
+	Error     string         `json:"error,omitempty"`
+	Detail    string         `json:"detail,omitempty"`
+	Header    map[string]any `json:"header,omitempty"`
+	Claims    map[string]any `json:"claims,omitempty"`
+	KeyID     string         `json:"keyId,omitempty"`
+	ExpiresIn string         `json:"expiresIn,omitempty"`
+}
+
+// validate checks the signature, the times and the issuer and audiences of
+// the token, as of now.
+func (v *jwtValidator) validate(ctx context.Context, token string, now time.Time) (jwtAnswer, error) {
+	var answer jwtAnswer
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return answer, errors.New("malformed token, expected 3 parts")
+	}
+	var err error
+	if answer.Header, err = decodeJWTPart(parts[0]); err != nil {
+		return answer, fmt.Errorf("malformed header: %w", err)
+	}
+	if answer.Claims, err = decodeJWTPart(parts[1]); err != nil {
+		return answer, fmt.Errorf("malformed claims: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return answer, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	alg, _ := answer.Header["alg"].(string)
+	kid, _ := answer.Header["kid"].(string)
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return answer, errors.New("unsigned tokens are refused")
+	}
+	keys, err := v.keysFor(ctx, kid)
+	if err != nil {
+		return answer, err
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	err = errors.New("no key to verify the signature")
+	for _, key := range keys {
+		if err = verifyJWTSignature(alg, key.key, input, signature); err == nil {
+			answer.KeyID = key.id
+			break
+		}
+	}
+	if err != nil {
+		return answer, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	expiry, hasExpiry, err := claimTime(answer.Claims, "exp")
+	if err != nil {
+		return answer, err
+	}
+	if hasExpiry && now.After(expiry.Add(v.leeway)) {
+		return answer, fmt.Errorf("token expired at %s", expiry.Format(time.RFC3339))
+	}
+	if notBefore, ok, err := claimTime(answer.Claims, "nbf"); err != nil {
+		return answer, err
+	} else if ok && now.Add(v.leeway).Before(notBefore) {
+		return answer, fmt.Errorf("token not valid before %s", notBefore.Format(time.RFC3339))
+	}
+	if issued, ok, err := claimTime(answer.Claims, "iat"); err != nil {
+		return answer, err
+	} else if ok && now.Add(v.leeway).Before(issued) {
+		return answer, fmt.Errorf("token issued in the future, at %s", issued.Format(time.RFC3339))
+	}
+
+	if issuer, _ := answer.Claims["iss"].(string); v.issuer != "" && issuer != v.issuer {
+		return answer, fmt.Errorf("issuer %q is not %q", issuer, v.issuer)
+	}
+	if len(v.audiences) > 0 {
+		var audiences jwtAudience
+		data, _ := json.Marshal(answer.Claims["aud"])
+		json.Unmarshal(data, &audiences)
+		if !slices.ContainsFunc(audiences, func(audience string) bool { return slices.Contains(v.audiences, audience) }) {
+			return answer, fmt.Errorf("audiences %q don't include any of %q", []string(audiences), v.audiences)
+		}
+	}
+
+	answer.Valid = true
+	if hasExpiry {
+		answer.ExpiresIn = expiry.Sub(now).Truncate(time.Second).String()
+	}
+	return answer, nil
+}
+
+// bearerToken returns the token of the Authorization header, or of the
+// token query parameter.
+func bearerToken(c *web.Context) string {
+	if scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return c.Query("token")
+}
+
+// jwtHandler answers GET /jwt with the claims of the token presented, or
+// with why it is refused.
+func (st *state) jwtHandler(c *web.Context) {
+	if st.tokenValidator == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "JWT validation disabled"})
+		return
+	}
+	token := bearerToken(c)
+	if token == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, web.H{"error": "Missing token"})
+		return
+	}
+	answer, err := st.tokenValidator.validate(c.Request.Context(), token, time.Now())
+	if err != nil {
+		st.jwtValidationsTotal.WithLabelValues("invalid").Inc()
+		answer.Error, answer.Detail = "Invalid token", err.Error()
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, answer)
+		return
+	}
+	st.jwtValidationsTotal.WithLabelValues("valid").Inc()
+	c.JSON(http.StatusOK, answer)
+}
@@ -0,0 +1,304 @@
+package prober
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func signTestJWT(t *testing.T, header map[string]any, claims map[string]any, key any) string {
+	t.Helper()
+	encode := func(value map[string]any) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTValidatorJWKS(t *testing.T) {
+	captureLogs(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "RSA", "kid": "encryption", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+	}}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+
+	t.Setenv(jwtJWKSURLEnv, server.URL)
+	t.Setenv(jwtIssuerEnv, "https://idp.example.com")
+	t.Setenv(jwtAudienceEnv, "prober,other")
+	validator, err := loadJWTValidator(envSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(changes map[string]any) map[string]any {
+		values := map[string]any{"iss": "https://idp.example.com", "aud": []string{"prober"}, "sub": "alice", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()}
+		for name, value := range changes {
+			values[name] = value
+		}
+		return values
+	}
+	tests := []struct {
+		name   string
+		token  string
+		detail string
+	}{
+		{"rsa", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims(nil), rsaKey), ""},
+		{"ec", signTestJWT(t, map[string]any{"alg": "ES256", "kid": "ec"}, claims(map[string]any{"aud": "other"}), ecKey), ""},
+		{"no kid", signTestJWT(t, map[string]any{"alg": "ES256"}, claims(nil), ecKey), ""},
+		{"expired", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()}), rsaKey), "token expired"},
+		{"not yet", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), rsaKey), "not valid before"},
+		{"issuer", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims(map[string]any{"iss": "https://evil.example.com"}), rsaKey), "issuer"},
+		{"audience", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims(map[string]any{"aud": "someone"}), rsaKey), "audiences"},
+		{"wrong key", signTestJWT(t, map[string]any{"alg": "ES256", "kid": "rsa"}, claims(nil), ecKey), "signature verification failed"},
+		{"encryption key", signTestJWT(t, map[string]any{"alg": "RS256", "kid": "encryption"}, claims(nil), rsaKey), `no key "encryption"`},
+		{"unsigned", signTestJWT(t, map[string]any{"alg": "none"}, claims(nil), nil), "unsigned"},
+		{"malformed", "not.a-token", "malformed"},
+	}
+	for _, test := range tests {
+		answer, err := validator.validate(context.Background(), test.token, now)
+		if test.detail == "" {
+			if err != nil || !answer.Valid || answer.Claims["sub"] != "alice" {
+				t.Errorf("%s: expected a valid token, got %v %+v", test.name, err, answer)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.detail) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.detail, err)
+		}
+	}
+	// The unknown key ids don't fetch the JWKS again within jwksMinRefresh.
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch of the JWKS, got %d", fetches)
+	}
+}
+
+func TestJWTHandler(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(jwtKeyFileEnv, path)
+	validator, err := loadJWTValidator(st.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.tokenValidator = validator
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	valid := signTestJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "bob"}, []byte("s3cr3t"))
+	forged := signTestJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "bob"}, []byte("guess"))
+
+	tests := []struct {
+		path          string
+		authorization string
+		status        int
+	}{
+		{"/jwt", "Bearer " + valid, http.StatusOK},
+		{"/jwt?token=" + valid, "", http.StatusOK},
+		{"/jwt", "Bearer " + forged, http.StatusUnauthorized},
+		{"/jwt", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var answer jwtAnswer
+		json.Unmarshal(w.Body.Bytes(), &answer)
+		if w.Code != test.status || answer.Valid != (test.status == http.StatusOK) {
+			t.Errorf("%s: expected status %d, got %d %s", test.authorization, test.status, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusOK && answer.Claims["sub"] != "bob" {
+			t.Errorf("expected the claims of bob, got %v", answer.Claims)
+		}
+	}
+
+	st.tokenValidator = nil
+	req, _ := http.NewRequest("GET", "/jwt", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a key, got %d", w.Code)
+	}
+}
+
+func TestJWTValidatorAttacks(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, publicPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(jwtKeyFileEnv, path)
+	validator, err := loadJWTValidator(envSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{"sub": "mallory"}
+	unsigned := func(header string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "."
+	}
+	tests := []struct {
+		name   string
+		token  string
+		detail string
+	}{
+		{"alg none", unsigned(`{"alg":"none"}`), "unsigned"},
+		{"alg None", unsigned(`{"alg":"None"}`), "unsigned"},
+		{"no alg", unsigned(`{"typ":"JWT"}`), "unsigned"},
+		{"alg not a string", unsigned(`{"alg":["RS256"]}`), "unsigned"},
+		// The public key, which anyone has, used as the HMAC secret.
+		{"HS256 with the public key", signTestJWT(t, map[string]any{"alg": "HS256"}, claims, publicPEM), "key type"},
+		{"HS256 with the PEM bytes", signTestJWT(t, map[string]any{"alg": "HS256"}, claims, der), "key type"},
+		{"unknown alg", signTestJWT(t, map[string]any{"alg": "RS999"}, claims, rsaKey), "unsupported algorithm"},
+		{"RS256 without signature", unsigned(`{"alg":"RS256"}`), "invalid signature"},
+	}
+	for _, test := range tests {
+		if _, err := validator.validate(context.Background(), test.token, time.Now()); err == nil || !strings.Contains(err.Error(), test.detail) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.detail, err)
+		}
+	}
+	if answer, err := validator.validate(context.Background(), signTestJWT(t, map[string]any{"alg": "RS256"}, claims, rsaKey), time.Now()); err != nil || !answer.Valid {
+		t.Errorf("expected the token signed with the private key to be valid, got %v", err)
+	}
+}
+
+func TestJWTValidatorTimes(t *testing.T) {
+	secret := []byte("s3cr3t")
+	validator := &jwtValidator{static: secret, leeway: time.Minute}
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		claims map[string]any
+		detail string
+	}{
+		{"no times", map[string]any{}, ""},
+		{"exp now", map[string]any{"exp": now.Unix()}, ""},
+		{"exp within the leeway", map[string]any{"exp": now.Add(-time.Minute).Unix()}, ""},
+		{"exp past the leeway", map[string]any{"exp": now.Add(-time.Minute - time.Second).Unix()}, "token expired"},
+		{"exp fraction", map[string]any{"exp": float64(now.Unix()) + 0.5}, ""},
+		{"exp string", map[string]any{"exp": "tomorrow"}, "not a number"},
+		{"exp null", map[string]any{"exp": nil}, "not a number"},
+		{"nbf within the leeway", map[string]any{"nbf": now.Add(time.Minute).Unix()}, ""},
+		{"nbf past the leeway", map[string]any{"nbf": now.Add(time.Minute + time.Second).Unix()}, "not valid before"},
+		{"nbf string", map[string]any{"nbf": "now"}, "not a number"},
+		{"iat in the future", map[string]any{"iat": now.Add(time.Hour).Unix()}, "issued in the future"},
+	}
+	for _, test := range tests {
+		token := signTestJWT(t, map[string]any{"alg": "HS256"}, test.claims, secret)
+		_, err := validator.validate(context.Background(), token, now)
+		if test.detail == "" && err != nil {
+			t.Errorf("%s: expected a valid token, got %v", test.name, err)
+		}
+		if test.detail != "" && (err == nil || !strings.Contains(err.Error(), test.detail)) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.detail, err)
+		}
+	}
+}
+
+func TestJWTValidatorSlowJWKS(t *testing.T) {
+	captureLogs(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	validator := &jwtValidator{jwksURL: server.URL, leeway: time.Minute, refresh: time.Minute, client: http.DefaultClient}
+	token := signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rsa"}, map[string]any{"sub": "alice"}, rsaKey)
+	if _, err := validator.validate(context.Background(), token, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the refresh, the tokens of known keys don't wait for the JWKS.
+	validator.mu.Lock()
+	validator.fetched = time.Now().Add(-time.Hour)
+	validator.mu.Unlock()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := validator.validate(context.Background(), token, time.Now()); err != nil {
+			t.Errorf("expected the current keys to be used during the refresh, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the validations not to wait for the JWKS, took %s", elapsed)
+	}
+
+	// The tokens of unknown keys wait for the single fetch in flight, as
+	// long as their request lasts.
+	unknown := signTestJWT(t, map[string]any{"alg": "RS256", "kid": "rotated"}, map[string]any{"sub": "alice"}, rsaKey)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := validator.validate(ctx, unknown, time.Now()); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected the request to give up waiting for the JWKS, got %v", err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("expected a single refresh in flight, got %d fetches", got)
+	}
+}
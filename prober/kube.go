@@ -0,0 +1,248 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	kubeServiceAccountDirEnv = "KUBE_SERVICE_ACCOUNT_DIR"
+
+	defaultKubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeTimeout                  = 10 * time.Second
+)
+
+// kubeClient is a minimal client of the Kubernetes API for the in-cluster
+// features, authenticated with the pod service account. The token is read
+// on every request since projected tokens are rotated by kubelet.
+type kubeClient struct {
+	host      string
+	tokenFile string
+	namespace string
+	client    *http.Client
+	// stream has no timeout, for watches.
+	stream *http.Client
+}
+
+// kubeStatusError is a non 2xx answer of the API server.
+type kubeStatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *kubeStatusError) Error() string {
+	return fmt.Sprintf("kubernetes API returned %d: %s", e.Code, e.Message)
+}
+
+func isKubeStatus(err error, code int) bool {
+	var statusErr *kubeStatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+// loadKubeClient returns nil, nil outside of a cluster.
+func loadKubeClient(config *settings) (*kubeClient, error) {
+	host, port := config.get("KUBERNETES_SERVICE_HOST"), config.get("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, nil
+	}
+	dir := config.getString(kubeServiceAccountDirEnv, defaultKubeServiceAccountDir)
+
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+	namespace, err := os.ReadFile(filepath.Join(dir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+
+	return newKubeClient("https://"+net.JoinHostPort(host, port), filepath.Join(dir, "token"), strings.TrimSpace(string(namespace)), &tls.Config{RootCAs: roots}), nil
+}
+
+func newKubeClient(host string, tokenFile string, namespace string, tlsConfig *tls.Config) *kubeClient {
+	transport := &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}
+	return &kubeClient{
+		host:      host,
+		tokenFile: tokenFile,
+		namespace: namespace,
+		client:    &http.Client{Timeout: kubeTimeout, Transport: transport},
+		stream:    &http.Client{Transport: transport},
+	}
+}
+
+// do sends body as JSON when not nil and decodes the answer into out when
+// not nil.
+func (k *kubeClient) do(ctx context.Context, method string, path string, contentType string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := k.newRequest(ctx, method, path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k *kubeClient) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, k.host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token, err := os.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return req, nil
+}
+
+// statusError turns a non 2xx answer into a kubeStatusError.
+func statusError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var status struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &status) != nil || status.Message == "" {
+		status.Message = strings.TrimSpace(string(data))
+	}
+	return &kubeStatusError{Code: resp.StatusCode, Message: status.Message}
+}
+
+func (k *kubeClient) get(ctx context.Context, path string, out any) error {
+	return k.do(ctx, http.MethodGet, path, "", nil, out)
+}
+
+// kubeWatchEvent is an event of a watch stream, its object being a Status
+// when the type is ERROR.
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watch streams the changes of the collection at path after
+// resourceVersion to handle until ctx is done, handle fails or the API
+// server ends the watch, which happens every few minutes. path may carry
+// selectors in its query. An expired
+// resourceVersion is reported as a 410 kubeStatusError.
+func (k *kubeClient) watch(ctx context.Context, path string, resourceVersion string, handle func(kubeWatchEvent) error) error {
+	query := url.Values{"watch": {"true"}, "allowWatchBookmarks": {"true"}}
+	if resourceVersion != "" {
+		query.Set("resourceVersion", resourceVersion)
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	req, err := k.newRequest(ctx, http.MethodGet, path+separator+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event kubeWatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			return &kubeStatusError{Code: status.Code, Message: status.Message}
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
+
+// kubeObjectMeta is the part of metadata prober reads and writes.
+type kubeObjectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	GenerateName    string            `json:"generateName,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+
+	DeletionTimestamp          *time.Time `json:"deletionTimestamp,omitempty"`
+	DeletionGracePeriodSeconds *int64     `json:"deletionGracePeriodSeconds,omitempty"`
+}
+
+// kubePod is the part of a Pod prober reads.
+type kubePod struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName           string `json:"nodeName"`
+		ServiceAccountName string `json:"serviceAccountName"`
+	} `json:"spec"`
+	Status struct {
+		PodIP  string `json:"podIP"`
+		HostIP string `json:"hostIP"`
+		Phase  string `json:"phase"`
+	} `json:"status"`
+}
+
+func (k *kubeClient) getPod(ctx context.Context, namespace string, name string) (kubePod, error) {
+	var pod kubePod
+	err := k.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name), &pod)
+	return pod, err
+}
@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKubeClient returns a client of a fake API server answering with
+// handler, authenticated with the token "test-token".
+func newTestKubeClient(t *testing.T, handler http.Handler) *kubeClient {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return newKubeClient(server.URL, tokenFile, "default", server.Client().Transport.(*http.Transport).TLSClientConfig)
+}
+
+func TestKubeClient(t *testing.T) {
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/default/pods/prober-0" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","message":"pods \"missing\" not found","code":404}`))
+			return
+		}
+		w.Write([]byte(`{"metadata":{"name":"prober-0","labels":{"app":"prober"}},"spec":{"nodeName":"node-a"}}`))
+	}))
+
+	pod, err := client.getPod(context.Background(), "default", "prober-0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Metadata.Labels["app"] != "prober" || pod.Spec.NodeName != "node-a" {
+		t.Errorf("unexpected pod: %+v", pod)
+	}
+
+	_, err = client.getPod(context.Background(), "default", "missing")
+	var statusErr *kubeStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound || statusErr.Message != `pods "missing" not found` {
+		t.Errorf("expected a not found status error, got %v", err)
+	}
+}
+
+func TestLoadKubeClientOutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	client, err := loadKubeClient(envSettings)
+	if client != nil || err != nil {
+		t.Errorf("expected no client and no error, got %v %v", client, err)
+	}
+}
+
+func TestKubeWatch(t *testing.T) {
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resourceVersion") == "1" {
+			w.Write([]byte(`{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old resource version"}}`))
+			return
+		}
+		w.Write([]byte(`{"type":"ADDED","object":{"metadata":{"name":"a"}}}` + "\n" + `{"type":"DELETED","object":{"metadata":{"name":"a"}}}`))
+	}))
+
+	var events []string
+	err := client.watch(context.Background(), "/things", "", func(event kubeWatchEvent) error {
+		events = append(events, event.Type)
+		return nil
+	})
+	if err != nil || len(events) != 2 || events[0] != "ADDED" || events[1] != "DELETED" {
+		t.Errorf("expected ADDED and DELETED events, got %v %v", events, err)
+	}
+
+	err = client.watch(context.Background(), "/things", "1", func(kubeWatchEvent) error { return nil })
+	if !isKubeStatus(err, http.StatusGone) {
+		t.Errorf("expected a 410 status error, got %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	leaderElectionLeaseEnv     = "LEADER_ELECTION_LEASE"
+	leaderElectionNamespaceEnv = "LEADER_ELECTION_NAMESPACE"
+	leaderElectionReadinessEnv = "LEADER_ELECTION_READINESS"
+	leaderLeaseDurationEnv     = "LEADER_LEASE_DURATION"
+	leaderRenewDeadlineEnv     = "LEADER_RENEW_DEADLINE"
+	leaderRetryPeriodEnv       = "LEADER_RETRY_PERIOD"
+
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+
+	// microTimeFormat is the layout of the Lease times.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// leaderMetrics expose whether the replica leads.
+type leaderMetrics struct {
+	leaderIsLeader prometheus.Gauge
+}
+
+func newLeaderMetrics(registry prometheus.Registerer) leaderMetrics {
+	m := leaderMetrics{
+		leaderIsLeader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "leader_is_leader",
+			Help: "Whether this replica holds the leader election Lease.",
+		}),
+	}
+	registry.MustRegister(m.leaderIsLeader)
+	return m
+}
+
+// microTime marshals like the metav1.MicroTime of the Lease spec.
+type microTime struct {
+	time.Time
+}
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(microTimeFormat) + `"`), nil
+}
+
+func (t *microTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse(`"`+microTimeFormat+`"`, string(data))
+	if err != nil {
+		parsed, err = time.Parse(`"`+time.RFC3339Nano+`"`, string(data))
+	}
+	t.Time = parsed
+	return err
+}
+
+type leaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity"`
+	LeaseDurationSeconds int        `json:"leaseDurationSeconds"`
+	AcquireTime          *microTime `json:"acquireTime,omitempty"`
+	RenewTime            *microTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int        `json:"leaseTransitions"`
+}
+
+type kubeLease struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   kubeObjectMeta `json:"metadata"`
+	Spec       leaseSpec      `json:"spec"`
+}
+
+// expired tells whether the holder stopped renewing the lease.
+func (l kubeLease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" || l.Spec.RenewTime == nil {
+		return true
+	}
+	return now.After(l.Spec.RenewTime.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// leaderElector competes for a coordination.k8s.io Lease like client-go
+// leader election does, to rehearse the failover of leader-elected
+// workloads. The Lease is released on shutdown so another replica takes
+// over right away.
+type leaderElector struct {
+	client        *kubeClient
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	mu        sync.RWMutex
+	lease     kubeLease
+	isLeader  bool
+	lastRenew time.Time
+
+	stop chan struct{}
+	done chan struct{}
+
+	leaderMetrics
+}
+
+type leaderStatus struct {
+	Lease            string    `json:"lease"`
+	Identity         string    `json:"identity"`
+	Leader           string    `json:"leader"`
+	IsLeader         bool      `json:"isLeader"`
+	LeaseTransitions int       `json:"leaseTransitions"`
+	RenewTime        time.Time `json:"renewTime,omitempty"`
+}
+
+// loadLeaderElector returns nil when LEADER_ELECTION_LEASE is unset.
+func (st *state) loadLeaderElector(client *kubeClient) (*leaderElector, error) {
+	name := st.config.get(leaderElectionLeaseEnv)
+	if name == "" {
+		return nil, nil
+	}
+	if client == nil {
+		return nil, errors.New("leader election needs to run in a cluster")
+	}
+	identity := st.config.get(podNameEnv)
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	e := newLeaderElector(client, st.config.getString(leaderElectionNamespaceEnv, client.namespace), name, identity, st.leaderMetrics)
+	e.leaseDuration = st.config.getDuration(leaderLeaseDurationEnv, defaultLeaseDuration)
+	e.renewDeadline = st.config.getDuration(leaderRenewDeadlineEnv, defaultRenewDeadline)
+	e.retryPeriod = st.config.getDuration(leaderRetryPeriodEnv, defaultRetryPeriod)
+	if e.renewDeadline >= e.leaseDuration || e.retryPeriod >= e.renewDeadline {
+		return nil, fmt.Errorf("%s must be shorter than %s, itself shorter than %s", leaderRetryPeriodEnv, leaderRenewDeadlineEnv, leaderLeaseDurationEnv)
+	}
+	return e, nil
+}
+
+func newLeaderElector(client *kubeClient, namespace string, name string, identity string, metrics leaderMetrics) *leaderElector {
+	return &leaderElector{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: defaultLeaseDuration,
+		renewDeadline: defaultRenewDeadline,
+		retryPeriod:   defaultRetryPeriod,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		leaderMetrics: metrics,
+	}
+}
+
+func (e *leaderElector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", e.namespace, e.name)
+}
+
+func (e *leaderElector) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		e.tryAcquireOrRenew()
+		select {
+		case <-e.stop:
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryAcquireOrRenew does one round of the election: creating the Lease
+// when missing, renewing it when held, or taking it over once expired.
+// Conflicting writes lose the round.
+func (e *leaderElector) tryAcquireOrRenew() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+	defer cancel()
+	now := time.Now()
+
+	var lease kubeLease
+	err := e.client.get(ctx, e.path(), &lease)
+	switch {
+	case isKubeStatus(err, http.StatusNotFound):
+		lease = kubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   kubeObjectMeta{Name: e.name, Namespace: e.namespace},
+			Spec:       e.spec(now, &microTime{now}, 0),
+		}
+		err = e.client.do(ctx, http.MethodPost, fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.namespace), "", lease, &lease)
+	case err != nil:
+	case lease.Spec.HolderIdentity == e.identity:
+		lease.Spec = e.spec(now, lease.Spec.AcquireTime, lease.Spec.LeaseTransitions)
+		err = e.client.do(ctx, http.MethodPut, e.path(), "", lease, &lease)
+	case lease.expired(now):
+		lease.Spec = e.spec(now, &microTime{now}, lease.Spec.LeaseTransitions+1)
+		err = e.client.do(ctx, http.MethodPut, e.path(), "", lease, &lease)
+	default:
+		e.update(lease, false, time.Time{})
+		return
+	}
+
+	if err != nil {
+		if !isKubeStatus(err, http.StatusConflict) {
+			slog.Warn("Failed to acquire or renew the leader Lease", "lease", e.name, "error", err)
+		}
+		e.mu.RLock()
+		current := e.lease
+		stillLeader := e.isLeader && now.Sub(e.lastRenew) < e.renewDeadline
+		e.mu.RUnlock()
+		e.update(current, stillLeader, time.Time{})
+		return
+	}
+	e.update(lease, true, now)
+}
+
+func (e *leaderElector) spec(now time.Time, acquired *microTime, transitions int) leaseSpec {
+	return leaseSpec{
+		HolderIdentity:       e.identity,
+		LeaseDurationSeconds: int(e.leaseDuration.Seconds()),
+		AcquireTime:          acquired,
+		RenewTime:            &microTime{now},
+		LeaseTransitions:     transitions,
+	}
+}
+
+// update records the outcome of a round, renewed being zero when the
+// Lease wasn't written.
+func (e *leaderElector) update(lease kubeLease, isLeader bool, renewed time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if isLeader != e.isLeader {
+		if isLeader {
+			slog.Info("Became the leader", "lease", e.name, "identity", e.identity)
+		} else {
+			slog.Warn("Lost the leadership", "lease", e.name, "identity", e.identity, "leader", lease.Spec.HolderIdentity)
+		}
+	}
+	e.lease = lease
+	e.isLeader = isLeader
+	if !renewed.IsZero() {
+		e.lastRenew = renewed
+	}
+	value := 0.0
+	if isLeader {
+		value = 1
+	}
+	e.leaderIsLeader.Set(value)
+}
+
+// release gives the Lease up by clearing its holder, like client-go does
+// with ReleaseOnCancel.
+func (e *leaderElector) release() {
+	e.mu.RLock()
+	lease, isLeader := e.lease, e.isLeader
+	e.mu.RUnlock()
+	if !isLeader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+	defer cancel()
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	if err := e.client.do(ctx, http.MethodPut, e.path(), "", lease, &lease); err != nil {
+		slog.Warn("Failed to release the leader Lease", "lease", e.name, "error", err)
+	}
+	e.update(lease, false, time.Time{})
+}
+
+func (e *leaderElector) Close() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *leaderElector) status() leaderStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	status := leaderStatus{
+		Lease:            e.namespace + "/" + e.name,
+		Identity:         e.identity,
+		Leader:           e.lease.Spec.HolderIdentity,
+		IsLeader:         e.isLeader,
+		LeaseTransitions: e.lease.Spec.LeaseTransitions,
+	}
+	if e.lease.Spec.RenewTime != nil {
+		status.RenewTime = e.lease.Spec.RenewTime.Time
+	}
+	return status
+}
+
+func (e *leaderElector) leading() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader
+}
+
+// leaderHandler answers GET /leader with the current leader.
+func (st *state) leaderHandler(c *web.Context) {
+	if st.elector == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Leader election is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.elector.status())
+}
+
+// leaderReadiness fails /readiness on the replicas that aren't the leader
+// when LEADER_ELECTION_READINESS is set.
+func (st *state) leaderReadiness() web.HandlerFunc {
+	enabled := st.config.getBool(leaderElectionReadinessEnv, false)
+
+	return func(c *web.Context) {
+		if enabled && st.elector != nil && !st.elector.leading() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, web.H{"error": "Not the leader"})
+			return
+		}
+		c.Next()
+	}
+}
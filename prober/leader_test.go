@@ -0,0 +1,141 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// fakeLeaseServer stores one Lease and rejects writes with a stale
+// resourceVersion, like the API server.
+type fakeLeaseServer struct {
+	mu      sync.Mutex
+	lease   *kubeLease
+	version int
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.lease)
+	case http.MethodPost, http.MethodPut:
+		var lease kubeLease
+		json.NewDecoder(r.Body).Decode(&lease)
+		if (r.Method == http.MethodPost) != (s.lease == nil) ||
+			(s.lease != nil && lease.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.version++
+		lease.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		s.lease = &lease
+		json.NewEncoder(w).Encode(s.lease)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	st := newState(Options{})
+	server := &fakeLeaseServer{}
+	client := newTestKubeClient(t, server)
+
+	a := newLeaderElector(client, "default", "prober", "prober-a", st.leaderMetrics)
+	b := newLeaderElector(client, "default", "prober", "prober-b", st.leaderMetrics)
+
+	a.tryAcquireOrRenew()
+	b.tryAcquireOrRenew()
+	if !a.leading() || b.leading() {
+		t.Fatalf("expected prober-a to lead, got a=%v b=%v", a.leading(), b.leading())
+	}
+	if status := b.status(); status.Leader != "prober-a" || status.Identity != "prober-b" {
+		t.Errorf("expected prober-b to see prober-a leading, got %+v", status)
+	}
+
+	a.tryAcquireOrRenew()
+	if !a.leading() || server.lease.Spec.LeaseTransitions != 0 {
+		t.Errorf("expected prober-a to renew, got %+v", server.lease.Spec)
+	}
+
+	// prober-a stops renewing, its lease expires.
+	server.mu.Lock()
+	server.lease.Spec.RenewTime = &microTime{time.Now().Add(-time.Minute)}
+	server.mu.Unlock()
+	b.tryAcquireOrRenew()
+	if !b.leading() || server.lease.Spec.HolderIdentity != "prober-b" || server.lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("expected prober-b to take over, got %+v", server.lease.Spec)
+	}
+
+	// prober-a comes back and finds prober-b holding the lease.
+	a.tryAcquireOrRenew()
+	if a.leading() {
+		t.Error("expected prober-a to step down")
+	}
+
+	b.release()
+	if b.leading() || server.lease.Spec.HolderIdentity != "" {
+		t.Errorf("expected prober-b to release the lease, got %+v", server.lease.Spec)
+	}
+	a.tryAcquireOrRenew()
+	if !a.leading() || server.lease.Spec.LeaseTransitions != 2 {
+		t.Errorf("expected prober-a to acquire the released lease, got %+v", server.lease.Spec)
+	}
+}
+
+func TestLeaderReadiness(t *testing.T) {
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(leaderElectionReadinessEnv, "true")
+	st := newState(Options{})
+	st.elector = newLeaderElector(nil, "default", "prober", "prober-a", st.leaderMetrics)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/readiness", st.leaderReadiness(), st.probeHandler(readinessProbeDelayEnv, "readiness"))
+	router.GET("/leader", st.leaderHandler)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("/readiness"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d for a follower, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	st.elector.update(kubeLease{Spec: leaseSpec{HolderIdentity: "prober-a"}}, true, time.Now())
+	if w := serve("/readiness"); w.Code != http.StatusOK {
+		t.Errorf("expected status %d for the leader, got %d", http.StatusOK, w.Code)
+	}
+
+	var status leaderStatus
+	if err := json.Unmarshal(serve("/leader").Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if !status.IsLeader || status.Leader != "prober-a" || status.Lease != "default/prober" {
+		t.Errorf("unexpected leader status: %+v", status)
+	}
+}
+
+func TestMicroTime(t *testing.T) {
+	at := microTime{time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)}
+	data, err := json.Marshal(at)
+	if err != nil || string(data) != `"2024-05-01T12:00:00.123456Z"` {
+		t.Errorf("unexpected marshaled time %s: %v", data, err)
+	}
+	var parsed microTime
+	if err := json.Unmarshal(data, &parsed); err != nil || !parsed.Equal(at.Time) {
+		t.Errorf("expected %v, got %v: %v", at, parsed, err)
+	}
+}
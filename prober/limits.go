@@ -0,0 +1,239 @@
+package prober
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/netutil"
+)
+
+const (
+	serverReadTimeoutEnv       = "SERVER_READ_TIMEOUT"
+	serverReadHeaderTimeoutEnv = "SERVER_READ_HEADER_TIMEOUT"
+	serverWriteTimeoutEnv      = "SERVER_WRITE_TIMEOUT"
+	serverIdleTimeoutEnv       = "SERVER_IDLE_TIMEOUT"
+	serverMaxHeaderBytesEnv    = "SERVER_MAX_HEADER_BYTES"
+	serverMaxConnectionsEnv    = "SERVER_MAX_CONNECTIONS"
+	serverMaxConnsPerIPEnv     = "SERVER_MAX_CONNECTIONS_PER_IP"
+	serverConnLimitActionEnv   = "SERVER_CONNECTION_LIMIT_ACTION"
+
+	// connLimitWait leaves the connections over the global limit in the
+	// accept backlog, connLimitReject answers them with a 503 and
+	// connLimitReset resets them.
+	connLimitWait   = "wait"
+	connLimitReject = "503"
+	connLimitReset  = "reset"
+
+	// rejectTimeout bounds the time spent answering a rejected connection.
+	rejectTimeout = time.Second
+)
+
+// limitMetrics count the connections refused over the server limits.
+type limitMetrics struct {
+	connectionsRejectedTotal *prometheus.CounterVec
+}
+
+func newLimitMetrics(registry prometheus.Registerer) limitMetrics {
+	m := limitMetrics{
+		connectionsRejectedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "connections_rejected_total",
+			Help: "Connections refused by the connection limits by listener and limit, global or per_ip.",
+		}, []string{"listener", "limit"}),
+	}
+	registry.MustRegister(m.connectionsRejectedTotal)
+	return m
+}
+
+// rejectedResponse is written on the connections refused with a 503, before
+// any request is read, so it doesn't depend on the one sent.
+var rejectedResponse = func() []byte {
+	body := `{"error":"Too many connections"}`
+	return []byte("HTTP/1.1 503 Service Unavailable\r\n" +
+		"Content-Type: application/json; charset=utf-8\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"Connection: close\r\n\r\n" + body)
+}()
+
+// serverLimits holds the http.Server timeouts and limits. Zero values keep
+// the net/http defaults, which means no timeout and no connection limit.
+type serverLimits struct {
+	ReadTimeout       time.Duration `json:"readTimeout"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `json:"writeTimeout"`
+	IdleTimeout       time.Duration `json:"idleTimeout"`
+	MaxHeaderBytes    int           `json:"maxHeaderBytes"`
+	MaxConnections    int           `json:"maxConnections"`
+	MaxConnsPerIP     int           `json:"maxConnectionsPerIP"`
+	// LimitAction is what happens to the connections over the limits.
+	LimitAction string `json:"limitAction"`
+}
+
+func loadServerLimits(config *settings) serverLimits {
+	return serverLimits{
+		ReadTimeout:       config.getDuration(serverReadTimeoutEnv, 0),
+		ReadHeaderTimeout: config.getDuration(serverReadHeaderTimeoutEnv, 0),
+		WriteTimeout:      config.getDuration(serverWriteTimeoutEnv, 0),
+		IdleTimeout:       config.getDuration(serverIdleTimeoutEnv, 0),
+		MaxHeaderBytes:    config.getInt(serverMaxHeaderBytesEnv, 0),
+		MaxConnections:    config.getInt(serverMaxConnectionsEnv, 0),
+		MaxConnsPerIP:     config.getInt(serverMaxConnsPerIPEnv, 0),
+		LimitAction:       config.getString(serverConnLimitActionEnv, connLimitWait),
+	}
+}
+
+func (l serverLimits) validate() error {
+	switch l.LimitAction {
+	case connLimitWait, connLimitReject, connLimitReset:
+		return nil
+	}
+	return fmt.Errorf("invalid %s %q, expected %s, %s or %s", serverConnLimitActionEnv, l.LimitAction, connLimitWait, connLimitReject, connLimitReset)
+}
+
+func (l serverLimits) apply(srv *http.Server) {
+	srv.ReadTimeout = l.ReadTimeout
+	srv.ReadHeaderTimeout = l.ReadHeaderTimeout
+	srv.WriteTimeout = l.WriteTimeout
+	srv.IdleTimeout = l.IdleTimeout
+	srv.MaxHeaderBytes = l.MaxHeaderBytes
+}
+
+// limitListener caps the concurrently open connections of a listener, in
+// total and by client IP. Over the global limit, clients wait in the accept
+// backlog until a connection is closed, unless the action refuses them like
+// the connections over the limit of their IP, which can't wait without
+// blocking the others. tls tells the 503 can't be written in plaintext, so
+// those connections are closed instead.
+func (l serverLimits) limitListener(listener net.Listener, tls bool, metrics limitMetrics) net.Listener {
+	wait := l.LimitAction == connLimitWait || l.LimitAction == ""
+	if l.MaxConnections > 0 && wait {
+		listener = netutil.LimitListener(listener, l.MaxConnections)
+	}
+	if (l.MaxConnections <= 0 || wait) && l.MaxConnsPerIP <= 0 {
+		return listener
+	}
+	limited := &connLimitListener{
+		Listener: listener,
+		name:     listener.Addr().String(),
+		perIP:    l.MaxConnsPerIP,
+		reset:    l.LimitAction == connLimitReset,
+		tls:      tls,
+		open:     make(map[string]int),
+
+		limitMetrics: metrics,
+	}
+	if !wait {
+		limited.max = l.MaxConnections
+	}
+	return limited
+}
+
+// connLimitListener refuses the connections over max, or over perIP for
+// the same client IP, the limits being disabled when zero.
+type connLimitListener struct {
+	net.Listener
+	name  string
+	max   int
+	perIP int
+	reset bool
+	tls   bool
+
+	mu    sync.Mutex
+	total int
+	open  map[string]int
+
+	limitMetrics
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		raw := conn
+		// The peer is the load balancer with PROXY protocol: reading the
+		// header it conveys would block the accept loop.
+		if proxyConn, ok := conn.(*proxyproto.Conn); ok {
+			raw = proxyConn.Raw()
+		}
+		ip := raw.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		l.mu.Lock()
+		limit := ""
+		switch {
+		case l.max > 0 && l.total >= l.max:
+			limit = "global"
+		case l.perIP > 0 && l.open[ip] >= l.perIP:
+			limit = "per_ip"
+		default:
+			l.total++
+			l.open[ip]++
+		}
+		l.mu.Unlock()
+
+		if limit == "" {
+			return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+		l.connectionsRejectedTotal.WithLabelValues(l.name, limit).Inc()
+		slog.Debug("Connection over the limit", "listener", l.name, "limit", limit, "remoteAddr", ip)
+		go l.reject(raw)
+	}
+}
+
+func (l *connLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.open[ip]--; l.open[ip] <= 0 {
+		delete(l.open, ip)
+	}
+}
+
+// reject resets the connection, or answers it with a 503 and waits for the
+// client to close it, so its unread request doesn't turn the close into a
+// reset.
+func (l *connLimitListener) reject(conn net.Conn) {
+	defer conn.Close()
+	if l.reset {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		return
+	}
+	if l.tls {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	if _, err := conn.Write(rejectedResponse); err != nil {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	io.Copy(io.Discard, conn)
+}
+
+// limitedConn frees its slot once closed, net/http closing connections more
+// than once.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
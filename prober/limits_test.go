@@ -0,0 +1,197 @@
+package prober
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadServerLimits(t *testing.T) {
+	t.Setenv(serverReadHeaderTimeoutEnv, "2s")
+	t.Setenv(serverIdleTimeoutEnv, "1m")
+	t.Setenv(serverMaxHeaderBytesEnv, "4096")
+	t.Setenv(serverMaxConnectionsEnv, "10")
+
+	limits := loadServerLimits(envSettings)
+	srv := &http.Server{}
+	limits.apply(srv)
+
+	if srv.ReadHeaderTimeout != 2*time.Second || srv.IdleTimeout != time.Minute || srv.MaxHeaderBytes != 4096 {
+		t.Errorf("unexpected server configuration: %+v", srv)
+	}
+	if srv.ReadTimeout != 0 || srv.WriteTimeout != 0 {
+		t.Errorf("expected unset timeouts to stay disabled, got read=%v write=%v", srv.ReadTimeout, srv.WriteTimeout)
+	}
+	if limits.MaxConnections != 10 {
+		t.Errorf("expected 10 max connections, got %d", limits.MaxConnections)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	serverLimits{ReadHeaderTimeout: 100 * time.Millisecond}.apply(srv)
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A slow client never finishing its headers gets disconnected.
+	conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("expected connection to be closed by the server, got %v", err)
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	st := newState(Options{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := serverLimits{MaxConnections: 1}.limitListener(listener, false, st.limitMetrics)
+	defer limited.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("expected second connection to wait for a free slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Error("expected second connection to be accepted after the first closed")
+	}
+}
+
+func TestConnectionLimitRejects(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := serverLimits{MaxConnsPerIP: 1, LimitAction: connLimitReject}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(limits.limitListener(listener, false, st.limitMetrics))
+	defer srv.Close()
+
+	// The first connection stays open, keep-alive.
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	defer client.CloseIdleConnections()
+
+	rejected := testutil.ToFloat64(st.connectionsRejectedTotal.WithLabelValues(listener.Addr().String(), "per_ip"))
+	other := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err = other.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != `{"error":"Too many connections"}` {
+		t.Errorf("expected a 503 over the per IP limit, got %d %s", resp.StatusCode, body)
+	}
+	if got := testutil.ToFloat64(st.connectionsRejectedTotal.WithLabelValues(listener.Addr().String(), "per_ip")); got != rejected+1 {
+		t.Errorf("expected %v rejections, got %v", rejected+1, got)
+	}
+
+	// Closing the first connection frees the slot of the IP.
+	client.CloseIdleConnections()
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err = other.Get("http://" + listener.Addr().String())
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			break
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the slot to be freed, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionLimitResets(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := serverLimits{MaxConnections: 1, LimitAction: connLimitReset}.limitListener(listener, false, st.limitMetrics)
+	defer limited.Close()
+	go func() {
+		for {
+			if _, err := limited.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("expected the connection over the limit to be reset, got %v", err)
+	}
+}
+
+func TestServerLimitsValidate(t *testing.T) {
+	for action, valid := range map[string]bool{connLimitWait: true, connLimitReject: true, connLimitReset: true, "drop": false} {
+		if err := (serverLimits{LimitAction: action}).validate(); (err == nil) != valid {
+			t.Errorf("%s: expected valid %t, got %v", action, valid, err)
+		}
+	}
+}
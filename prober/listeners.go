@@ -0,0 +1,76 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const listenersConfigEnv = "LISTENERS_CONFIG"
+
+type listenerTLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// listenerConfig describes an extra named listener with its own routes,
+// TLS settings and fault profile.
+type listenerConfig struct {
+	Name           string             `yaml:"name"`
+	Addr           string             `yaml:"addr"`
+	Mode           string             `yaml:"mode"`
+	MaxConnections int                `yaml:"maxConnections"`
+	ProxyProtocol  bool               `yaml:"proxyProtocol"`
+	Routes         []string           `yaml:"routes"`
+	TLS            *listenerTLSConfig `yaml:"tls"`
+	Faults         faultProfile       `yaml:"faults"`
+}
+
+type listenersFile struct {
+	Listeners []listenerConfig `yaml:"listeners"`
+}
+
+func loadListenersConfig(path string) ([]listenerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file listenersFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(file.Listeners))
+	for _, listener := range file.Listeners {
+		if listener.Name == "" {
+			return nil, errors.New("listener without name")
+		}
+		if names[listener.Name] {
+			return nil, fmt.Errorf("duplicated listener %q", listener.Name)
+		}
+		names[listener.Name] = true
+
+		if listener.Addr == "" {
+			return nil, fmt.Errorf("listener %q without addr", listener.Name)
+		}
+		switch listener.Mode {
+		case "", listenerModeHTTP:
+		case listenerModeHalfOpen:
+			if listener.TLS != nil || listener.ProxyProtocol {
+				return nil, fmt.Errorf("listener %q in %s mode can't use tls or proxyProtocol", listener.Name, listener.Mode)
+			}
+		default:
+			return nil, fmt.Errorf("listener %q has unknown mode %q", listener.Name, listener.Mode)
+		}
+		if listener.TLS != nil && (listener.TLS.CertFile == "" || listener.TLS.KeyFile == "") {
+			return nil, fmt.Errorf("listener %q needs both certFile and keyFile", listener.Name)
+		}
+		if err := listener.Faults.validate(); err != nil {
+			return nil, fmt.Errorf("listener %q faults: %w", listener.Name, err)
+		}
+	}
+	return file.Listeners, nil
+}
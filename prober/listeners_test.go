@@ -0,0 +1,68 @@
+package prober
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeListenersConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "listeners.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadListenersConfig(t *testing.T) {
+	path := writeListenersConfig(t, `
+listeners:
+  - name: healthy
+    addr: ":8081"
+    routes: ["/liveness", "/readiness"]
+  - name: chaotic
+    addr: ":8082"
+    faults:
+      latency: 250ms
+      errorRate: 0.5
+      errorStatus: 502
+`)
+
+	listeners, err := loadListenersConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(listeners))
+	}
+	if len(listeners[0].Routes) != 2 || listeners[0].Faults.enabled() {
+		t.Errorf("unexpected healthy listener: %+v", listeners[0])
+	}
+
+	faults := listeners[1].Faults
+	if faults.Latency != 250*time.Millisecond || faults.ErrorRate != 0.5 || faults.ErrorStatus != 502 {
+		t.Errorf("unexpected chaotic faults: %+v", faults)
+	}
+}
+
+func TestLoadListenersConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing name":   "listeners: [{addr: ':8081'}]",
+		"missing addr":   "listeners: [{name: a}]",
+		"duplicated":     "listeners: [{name: a, addr: ':8081'}, {name: a, addr: ':8082'}]",
+		"partial tls":    "listeners: [{name: a, addr: ':8081', tls: {certFile: tls.crt}}]",
+		"invalid rate":   "listeners: [{name: a, addr: ':8081', faults: {errorRate: 2}}]",
+		"invalid status": "listeners: [{name: a, addr: ':8081', faults: {errorRate: 0.5, errorStatus: 42}}]",
+		"malformed yaml": "listeners: [",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadListenersConfig(writeListenersConfig(t, content)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// loadSlice is the period the CPU load is spread over, short enough for
+	// the usage to look flat to the metrics pipeline.
+	loadSlice = 100 * time.Millisecond
+	pageSize  = 4096
+)
+
+// loadMetrics expose the targets of the synthetic load.
+type loadMetrics struct {
+	loadCPUTarget    prometheus.Gauge
+	loadMemoryTarget prometheus.Gauge
+}
+
+func newLoadMetrics(registry prometheus.Registerer) loadMetrics {
+	m := loadMetrics{
+		loadCPUTarget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "load_cpu_target_cores",
+			Help: "CPU cores the synthetic load keeps busy.",
+		}),
+
+		loadMemoryTarget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "load_memory_target_bytes",
+			Help: "Memory held by the synthetic load.",
+		}),
+	}
+	registry.MustRegister(m.loadCPUTarget, m.loadMemoryTarget)
+	return m
+}
+
+type loadStatus struct {
+	Running     bool      `json:"running"`
+	CPU         string    `json:"cpu,omitempty"`
+	CPUCores    float64   `json:"cpuCores"`
+	Memory      string    `json:"memory,omitempty"`
+	MemoryBytes int64     `json:"memoryBytes"`
+	Started     time.Time `json:"started,omitempty"`
+	Until       time.Time `json:"until,omitempty"`
+}
+
+// loadGenerator holds a steady CPU and memory load until stopped, to drive
+// HorizontalPodAutoscalers up and down in a controlled way. Starting a new
+// load replaces the running one.
+type loadGenerator struct {
+	mu     sync.Mutex
+	status loadStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	loadMetrics
+}
+
+// parseCPULoad accepts a share of the CPU request like "70%", as the HPA
+// computes utilization, or cores like "1.5" or "500m".
+func parseCPULoad(value string, res resources) (float64, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		share, err := strconv.ParseFloat(percent, 64)
+		if err != nil || share < 0 {
+			return 0, errors.New("invalid CPU percentage")
+		}
+		base := res.CPU.RequestCores
+		if base == nil {
+			base = res.CPU.LimitCores
+		}
+		if base == nil {
+			return 0, errors.New("CPU percentage needs a CPU request or limit")
+		}
+		return *base * share / 100, nil
+	}
+	if milli, ok := strings.CutSuffix(value, "m"); ok {
+		cores, err := strconv.ParseFloat(milli, 64)
+		if err != nil || cores < 0 {
+			return 0, errors.New("invalid CPU value")
+		}
+		return cores / 1000, nil
+	}
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil || cores < 0 {
+		return 0, errors.New("invalid CPU value")
+	}
+	return cores, nil
+}
+
+var memoryUnits = map[string]int64{
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30,
+	"k": 1e3, "K": 1e3, "M": 1e6, "G": 1e9,
+}
+
+// parseMemoryLoad accepts a share of the memory request, or of the limit
+// with cgroup v1, like "50%", or a quantity like "256Mi".
+func parseMemoryLoad(value string, res resources) (int64, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		share, err := strconv.ParseFloat(percent, 64)
+		if err != nil || share < 0 {
+			return 0, errors.New("invalid memory percentage")
+		}
+		base := res.Memory.RequestBytes
+		if base == nil {
+			base = res.Memory.LimitBytes
+		}
+		if base == nil {
+			return 0, errors.New("memory percentage needs a memory request or limit")
+		}
+		return int64(float64(*base) * share / 100), nil
+	}
+	number, multiplier := value, int64(1)
+	for suffix, unit := range memoryUnits {
+		if trimmed, ok := strings.CutSuffix(value, suffix); ok {
+			number, multiplier = trimmed, unit
+			break
+		}
+	}
+	quantity, err := strconv.ParseFloat(number, 64)
+	if err != nil || quantity < 0 {
+		return 0, errors.New("invalid memory value")
+	}
+	return int64(quantity * float64(multiplier)), nil
+}
+
+// start replaces the running load, stopping on its own after duration
+// when not zero.
+func (g *loadGenerator) start(status loadStatus, duration time.Duration) {
+	g.stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if duration > 0 {
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), duration)
+		status.Until = status.Started.Add(duration)
+	}
+	done := make(chan struct{})
+	status.Running = true
+
+	g.mu.Lock()
+	g.status, g.cancel, g.done = status, cancel, done
+	g.mu.Unlock()
+	g.loadCPUTarget.Set(status.CPUCores)
+	g.loadMemoryTarget.Set(float64(status.MemoryBytes))
+
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		workers := int(math.Ceil(status.CPUCores))
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dutyCycle(ctx, status.CPUCores/float64(workers))
+			}()
+		}
+		holdMemory(ctx, status.MemoryBytes)
+		wg.Wait()
+
+		g.mu.Lock()
+		if g.done == done {
+			g.status = loadStatus{}
+			g.loadCPUTarget.Set(0)
+			g.loadMemoryTarget.Set(0)
+		}
+		g.mu.Unlock()
+	}()
+}
+
+func (g *loadGenerator) stop() {
+	g.mu.Lock()
+	cancel, done := g.cancel, g.done
+	g.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (g *loadGenerator) current() loadStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// dutyCycle keeps one core busy for the share of every slice.
+func dutyCycle(ctx context.Context, share float64) {
+	busy := time.Duration(share * float64(loadSlice))
+	for ctx.Err() == nil {
+		start := time.Now()
+		for time.Since(start) < busy {
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(loadSlice - time.Since(start)):
+		}
+	}
+}
+
+// holdMemory allocates bytes and touches every page, so the memory counts
+// in the working set, until the context is done.
+func holdMemory(ctx context.Context, bytes int64) {
+	if bytes <= 0 {
+		<-ctx.Done()
+		return
+	}
+	block := make([]byte, bytes)
+	for i := 0; i < len(block); i += pageSize {
+		block[i] = 1
+	}
+	<-ctx.Done()
+	runtime.KeepAlive(block)
+}
+
+// startLoad answers POST /load?cpu=70%&memory=256Mi&duration=10m by
+// replacing the synthetic load.
+func (st *state) startLoad(c *web.Context) {
+	res := loadResources(st.config.getString(cgroupRootEnv, defaultCgroupRoot))
+	status := loadStatus{CPU: c.Query("cpu"), Memory: c.Query("memory"), Started: time.Now()}
+	if status.CPU == "" && status.Memory == "" {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Missing cpu or memory"})
+		return
+	}
+	if status.CPU != "" {
+		cores, err := parseCPULoad(status.CPU, res)
+		if err != nil || cores > float64(res.NumCPU) {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid cpu value"})
+			return
+		}
+		status.CPUCores = cores
+	}
+	if status.Memory != "" {
+		bytes, err := parseMemoryLoad(status.Memory, res)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid memory value"})
+			return
+		}
+		status.MemoryBytes = bytes
+	}
+	var duration time.Duration
+	if value := c.Query("duration"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid duration value"})
+			return
+		}
+		duration = parsed
+	}
+
+	st.syntheticLoad.start(status, duration)
+	st.configChangesTotal.Inc()
+	c.JSON(http.StatusCreated, st.syntheticLoad.current())
+}
+
+func (st *state) getLoad(c *web.Context) {
+	c.JSON(http.StatusOK, st.syntheticLoad.current())
+}
+
+func (st *state) stopLoad(c *web.Context) {
+	st.syntheticLoad.stop()
+	c.JSON(http.StatusOK, st.syntheticLoad.current())
+}
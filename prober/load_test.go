@@ -0,0 +1,91 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseLoad(t *testing.T) {
+	request, limit := 0.5, int64(1<<30)
+	res := resources{}
+	res.CPU.RequestCores = &request
+	res.Memory.LimitBytes = &limit
+
+	cpu := map[string]float64{"70%": 0.35, "200%": 1, "500m": 0.5, "1.5": 1.5}
+	for value, expected := range cpu {
+		if cores, err := parseCPULoad(value, res); err != nil || cores != expected {
+			t.Errorf("%s: expected %v cores, got %v %v", value, expected, cores, err)
+		}
+	}
+	memory := map[string]int64{"50%": 1 << 29, "256Mi": 256 << 20, "1G": 1e9, "1024": 1024}
+	for value, expected := range memory {
+		if bytes, err := parseMemoryLoad(value, res); err != nil || bytes != expected {
+			t.Errorf("%s: expected %d bytes, got %d %v", value, expected, bytes, err)
+		}
+	}
+
+	for _, value := range []string{"abc", "-1", "x%"} {
+		if _, err := parseCPULoad(value, res); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+	if _, err := parseMemoryLoad("50%", resources{}); err == nil {
+		t.Error("expected an error for a percentage without limit")
+	}
+}
+
+func TestLoadHandlers(t *testing.T) {
+	t.Setenv(cgroupRootEnv, t.TempDir())
+	st := newState(Options{})
+	defer st.syntheticLoad.stop()
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/load", st.getLoad)
+	router.POST("/load", st.startLoad)
+	router.DELETE("/load", st.stopLoad)
+
+	serve := func(method string, path string) (int, loadStatus) {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var status loadStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		return w.Code, status
+	}
+
+	if code, _ := serve("POST", "/load"); code != http.StatusBadRequest {
+		t.Errorf("expected status %d without cpu or memory, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := serve("POST", "/load?cpu=70%25"); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a percentage without request, got %d", http.StatusBadRequest, code)
+	}
+
+	code, status := serve("POST", "/load?cpu=100m&memory=1Mi&duration=1h")
+	if code != http.StatusCreated || !status.Running || status.CPUCores != 0.1 || status.MemoryBytes != 1<<20 {
+		t.Errorf("unexpected load %d %+v", code, status)
+	}
+	if got := testutil.ToFloat64(st.loadCPUTarget); got != 0.1 {
+		t.Errorf("expected load_cpu_target_cores 0.1, got %v", got)
+	}
+
+	if code, status := serve("DELETE", "/load"); code != http.StatusOK || status.Running {
+		t.Errorf("expected the load to stop, got %d %+v", code, status)
+	}
+	if got := testutil.ToFloat64(st.loadMemoryTarget); got != 0 {
+		t.Errorf("expected load_memory_target_bytes 0, got %v", got)
+	}
+
+	serve("POST", "/load?cpu=10m&duration=50ms")
+	for deadline := time.Now().Add(2 * time.Second); st.syntheticLoad.current().Running && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, status := serve("GET", "/load"); status.Running {
+		t.Errorf("expected the load to stop after its duration, got %+v", status)
+	}
+}
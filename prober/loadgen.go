@@ -0,0 +1,272 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const grpcTargetScheme = "grpc://"
+
+// loadReport is what `prober load` prints once the run ended.
+type loadReport struct {
+	Target      string         `json:"target"`
+	RPS         float64        `json:"rps"`
+	Duration    string         `json:"duration"`
+	Requests    int            `json:"requests"`
+	Errors      int            `json:"errors"`
+	Dropped     int            `json:"dropped"`
+	AchievedRPS float64        `json:"achievedRps"`
+	Statuses    map[string]int `json:"statuses"`
+	Latency     loadLatency    `json:"latency"`
+}
+
+type loadLatency struct {
+	Min  string `json:"min"`
+	Mean string `json:"mean"`
+	P50  string `json:"p50"`
+	P90  string `json:"p90"`
+	P99  string `json:"p99"`
+	Max  string `json:"max"`
+
+	quantiles map[string]time.Duration
+}
+
+// loadRequest sends one request and returns the status it got, the HTTP
+// code or the gRPC code name, and whether it succeeded.
+type loadRequest func(ctx context.Context) (string, bool)
+
+func httpLoadRequest(client *http.Client, method string, target string) loadRequest {
+	return func(ctx context.Context) (string, bool) {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return "error", false
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "error", false
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return strconv.Itoa(resp.StatusCode), resp.StatusCode < http.StatusInternalServerError
+	}
+}
+
+// grpcLoadRequest calls the health service of the target, or the unary
+// method with an empty request when set, like the gRPC checks.
+func grpcLoadRequest(conn *grpc.ClientConn, method string) loadRequest {
+	health := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) (string, bool) {
+		var err error
+		if method != "" {
+			err = conn.Invoke(ctx, method, &emptypb.Empty{}, &emptypb.Empty{})
+		} else {
+			_, err = health.Check(ctx, &healthpb.HealthCheckRequest{})
+		}
+		code := status.Code(err)
+		return code.String(), err == nil
+	}
+}
+
+// generateLoad sends rps requests per second for the duration, on a fixed
+// schedule whatever the latency of the target, with at most concurrency
+// of them in flight: requests due while all are busy are dropped rather
+// than delayed, so a slow target can't lower the offered load unnoticed.
+func generateLoad(ctx context.Context, send loadRequest, rps float64, duration time.Duration, concurrency int, timeout time.Duration) loadReport {
+	report := loadReport{RPS: rps, Duration: duration.String(), Statuses: make(map[string]int)}
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		wg        sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+	interval := time.Duration(float64(time.Second) / rps)
+	start := time.Now()
+	for i := 0; ; i++ {
+		due := start.Add(time.Duration(i) * interval)
+		if due.Sub(start) >= duration {
+			break
+		}
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			report.Dropped++
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			requestCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			sent := time.Now()
+			code, ok := send(requestCtx)
+			latency := time.Since(sent)
+
+			mu.Lock()
+			defer mu.Unlock()
+			latencies = append(latencies, latency)
+			report.Statuses[code]++
+			if !ok {
+				report.Errors++
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	report.Requests = len(latencies)
+	if elapsed > 0 {
+		report.AchievedRPS = float64(report.Requests) / elapsed.Seconds()
+	}
+	report.Latency = summarizeLatencies(latencies)
+	return report
+}
+
+func summarizeLatencies(latencies []time.Duration) loadLatency {
+	if len(latencies) == 0 {
+		return loadLatency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	quantile := func(q float64) time.Duration {
+		return latencies[min(len(latencies)-1, int(q*float64(len(latencies))))]
+	}
+	summary := loadLatency{quantiles: map[string]time.Duration{
+		"0.5":  quantile(0.5),
+		"0.9":  quantile(0.9),
+		"0.99": quantile(0.99),
+	}}
+	summary.Min = latencies[0].String()
+	summary.Mean = (total / time.Duration(len(latencies))).String()
+	summary.P50 = summary.quantiles["0.5"].String()
+	summary.P90 = summary.quantiles["0.9"].String()
+	summary.P99 = summary.quantiles["0.99"].String()
+	summary.Max = latencies[len(latencies)-1].String()
+	return summary
+}
+
+// pushLoadReport publishes the report as loadgen_* metrics to the
+// Pushgateway or remote-write endpoint configured like for the server.
+func pushLoadReport(report loadReport) {
+	registry := prometheus.NewRegistry()
+	labels := prometheus.Labels{"target": report.Target}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "loadgen_requests_total",
+		Help:        "Requests sent by the load generator by status.",
+		ConstLabels: labels,
+	}, []string{"status"})
+	for code, count := range report.Statuses {
+		requests.WithLabelValues(code).Add(float64(count))
+	}
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "loadgen_dropped_total",
+		Help:        "Requests not sent because the concurrency limit was reached.",
+		ConstLabels: labels,
+	})
+	dropped.Add(float64(report.Dropped))
+	latency := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "loadgen_latency_seconds",
+		Help:        "Latency quantiles of the load generator requests.",
+		ConstLabels: labels,
+	}, []string{"quantile"})
+	for q, value := range report.Latency.quantiles {
+		latency.WithLabelValues(q).Set(value.Seconds())
+	}
+	registry.MustRegister(requests, dropped, latency)
+
+	if pusher := newMetricsPusher(envSettings, registry); pusher != nil {
+		pusher.pushAll()
+	}
+}
+
+// runLoad implements `prober load`, which sends a steady rate of HTTP
+// requests, or gRPC calls to grpc:// targets, and prints a JSON report with
+// the latency percentiles. It returns the exit code: 0 when every request
+// succeeded, 1 when one failed and 2 on usage errors.
+func runLoad(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("load", stderr)
+	target := flags.String("target", "", "URL the requests are sent to, or grpc://host:port for gRPC calls")
+	rps := flags.Float64("rps", 10, "requests sent per second")
+	duration := flags.Duration("duration", 10*time.Second, "length of the run")
+	concurrency := flags.Int("concurrency", 100, "requests in flight at most, the others being dropped")
+	method := flags.String("method", http.MethodGet, "HTTP method of the requests")
+	grpcMethod := flags.String("grpc-method", "", "unary method called with an empty request, like /prober.v1.Prober/Echo, instead of the health check")
+	timeout := flags.Duration("timeout", 10*time.Second, "time limit of each request")
+	push := flags.Bool("push", false, "push the report as metrics to METRICS_PUSH_URL or METRICS_REMOTE_WRITE_URL")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *target == "" {
+		fmt.Fprintln(stderr, "missing --target")
+		return 2
+	}
+	if *rps <= 0 || *duration <= 0 || *concurrency <= 0 || *timeout <= 0 {
+		fmt.Fprintln(stderr, "--rps, --duration, --concurrency and --timeout must be positive")
+		return 2
+	}
+
+	var send loadRequest
+	if address, ok := strings.CutPrefix(*target, grpcTargetScheme); ok {
+		conn, err := grpc.NewClient("passthrough:///"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid target: %v\n", err)
+			return 2
+		}
+		defer conn.Close()
+		send = grpcLoadRequest(conn, *grpcMethod)
+	} else {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = *concurrency
+		client := &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		if _, err := http.NewRequest(*method, *target, nil); err != nil {
+			fmt.Fprintf(stderr, "invalid target: %v\n", err)
+			return 2
+		}
+		send = httpLoadRequest(client, *method, *target)
+	}
+
+	report := generateLoad(context.Background(), send, *rps, *duration, *concurrency, *timeout)
+	report.Target = *target
+	if *push {
+		pushLoadReport(report)
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
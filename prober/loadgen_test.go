@@ -0,0 +1,82 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGenerateLoad(t *testing.T) {
+	var served atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1)%10 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	report := generateLoad(context.Background(), httpLoadRequest(http.DefaultClient, http.MethodGet, target.URL), 200, 250*time.Millisecond, 10, time.Second)
+	if report.Requests != 50 || report.Dropped != 0 {
+		t.Errorf("expected 50 requests at 200 rps for 250ms, got %d and %d dropped", report.Requests, report.Dropped)
+	}
+	if report.Statuses["503"] != 5 || report.Errors != 5 || report.Statuses["200"] != 45 {
+		t.Errorf("expected every tenth request to fail, got %v", report.Statuses)
+	}
+	if report.Latency.P99 == "" || report.Latency.quantiles["0.5"] > report.Latency.quantiles["0.99"] {
+		t.Errorf("unexpected latencies %+v", report.Latency)
+	}
+}
+
+func TestGenerateLoadDrops(t *testing.T) {
+	release := make(chan struct{})
+	send := func(ctx context.Context) (string, bool) {
+		<-release
+		return "200", true
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		close(release)
+	}()
+	report := generateLoad(context.Background(), send, 100, 100*time.Millisecond, 2, time.Second)
+	if report.Requests != 2 || report.Dropped != 8 {
+		t.Errorf("expected the requests past the concurrency to be dropped, got %d sent and %d dropped", report.Requests, report.Dropped)
+	}
+}
+
+func TestGenerateLoadGRPC(t *testing.T) {
+	conn := newTestGRPCClient(t)
+	report := generateLoad(context.Background(), grpcLoadRequest(conn, ""), 100, 50*time.Millisecond, 5, time.Second)
+	if report.Requests != 5 || report.Statuses["OK"] != 5 {
+		t.Errorf("expected 5 health checks, got %+v", report)
+	}
+	report = generateLoad(context.Background(), grpcLoadRequest(conn, "/prober.v1.Prober/Missing"), 100, 10*time.Millisecond, 5, time.Second)
+	if report.Errors != 1 || report.Statuses["Unimplemented"] != 1 {
+		t.Errorf("expected an unknown method to fail, got %+v", report.Statuses)
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	var stdout bytes.Buffer
+	if code := runLoad([]string{"--target=" + target.URL, "--rps=100", "--duration=50ms"}, &stdout, io.Discard); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	var report loadReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil || report.Requests != 5 || report.Target != target.URL {
+		t.Errorf("unexpected report %s", stdout.String())
+	}
+
+	for _, args := range [][]string{nil, {"--target=" + target.URL, "--rps=0"}, {"--target=::"}} {
+		if code := runLoad(args, io.Discard, io.Discard); code != 2 {
+			t.Errorf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
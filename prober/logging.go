@@ -0,0 +1,300 @@
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	logLevelEnv      = "LOG_LEVEL"
+	logFormatEnv     = "LOG_FORMAT"
+	logSampleRateEnv = "LOG_SAMPLE_RATE"
+	logRateLimitEnv  = "LOG_RATE_LIMIT"
+
+	requestIDHeader = "X-Request-ID"
+	// generatedRequestIDKey is set on the requests whose X-Request-ID was
+	// generated by prober rather than sent.
+	generatedRequestIDKey = "generatedRequestID"
+)
+
+func parseLogLevel(value string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// logSettings can be changed at runtime through /config/logging, to quiet
+// down logs in the middle of a load test.
+type logSettings struct {
+	level slog.LevelVar
+	// sampleRate keeps 1 in sampleRate successful access logs.
+	sampleRate atomic.Int64
+	// rateLimit caps the records per second of a same message, 0 disables it.
+	rateLimit atomic.Int64
+
+	sampled atomic.Uint64
+}
+
+var logConfig = &logSettings{}
+
+func (s *logSettings) loadEnv() {
+	s.sampleRate.Store(int64(getEnvInt(logSampleRateEnv, 1)))
+	s.rateLimit.Store(int64(getEnvInt(logRateLimitEnv, 0)))
+}
+
+// sample reports whether a successful access log must be written.
+func (s *logSettings) sample() bool {
+	rate := s.sampleRate.Load()
+	if rate <= 1 {
+		return true
+	}
+	return (s.sampled.Add(1)-1)%uint64(rate) == 0
+}
+
+// rateLimitHandler drops the records of a message logged more than the
+// configured limit within the same second.
+type rateLimitHandler struct {
+	slog.Handler
+	settings *logSettings
+	windows  *messageWindows
+}
+
+type messageWindows struct {
+	mu      sync.Mutex
+	windows map[string]messageWindow
+}
+
+type messageWindow struct {
+	second int64
+	count  int64
+}
+
+func (h *rateLimitHandler) Handle(ctx context.Context, record slog.Record) error {
+	limit := h.settings.rateLimit.Load()
+	if limit <= 0 {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	second := record.Time.Unix()
+	h.windows.mu.Lock()
+	window := h.windows.windows[record.Message]
+	if window.second != second {
+		window = messageWindow{second: second}
+	}
+	window.count++
+	h.windows.windows[record.Message] = window
+	h.windows.mu.Unlock()
+
+	if window.count > limit {
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *rateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &rateLimitHandler{Handler: h.Handler.WithAttrs(attrs), settings: h.settings, windows: h.windows}
+}
+
+func (h *rateLimitHandler) WithGroup(name string) slog.Handler {
+	return &rateLimitHandler{Handler: h.Handler.WithGroup(name), settings: h.settings, windows: h.windows}
+}
+
+// newLogger builds the process logger at the level, in the format. JSON is
+// the default so log pipelines can parse every line, text is kept for local
+// runs. The other log settings are reset from the environment.
+func newLogger(w io.Writer, level string, format string) *slog.Logger {
+	logConfig.loadEnv()
+	logConfig.level.Set(parseLogLevel(level))
+	options := &slog.HandlerOptions{Level: &logConfig.level}
+
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(&rateLimitHandler{
+		Handler:  handler,
+		settings: logConfig,
+		windows:  &messageWindows{windows: make(map[string]messageWindow)},
+	})
+}
+
+func setupLogging(opts Options) {
+	slog.SetDefault(newLogger(os.Stdout, opts.LogLevel, opts.LogFormat))
+}
+
+// logFlag sets the value of a log flag once valid. Unlike the variables,
+// which fall back to the defaults, invalid flags are refused.
+func logFlag(value *string, validate func(string) error) func(string) error {
+	return func(flagValue string) error {
+		if err := validate(flagValue); err != nil {
+			return err
+		}
+		*value = flagValue
+		return nil
+	}
+}
+
+func validateLogLevel(value string) error {
+	var level slog.Level
+	return level.UnmarshalText([]byte(value))
+}
+
+func validateLogFormat(value string) error {
+	if !strings.EqualFold(value, "json") && !strings.EqualFold(value, "text") {
+		return errors.New("expected json or text")
+	}
+	return nil
+}
+
+// newRequestID returns a random 128-bit identifier in hex.
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// requestID reuses the X-Request-ID sent by the client or proxy, generating
+// one when absent, and echoes it back so a call can be correlated across
+// proxy and prober logs.
+func requestID(c *web.Context) string {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		c.Request.Header.Set(requestIDHeader, id)
+		c.Set(generatedRequestIDKey, true)
+	}
+	c.Header(requestIDHeader, id)
+	return id
+}
+
+// accessLog writes one structured line per request. Server errors are logged
+// as errors and client errors as warnings so LOG_LEVEL can silence
+// successful probes, which are also the only ones sampled. With
+// LOG_REQUEST_HEADERS, the lines carry the request headers, redacted.
+func (st *state) accessLog() web.HandlerFunc {
+	logHeaders := st.config.getBool(logRequestHeadersEnv, false)
+	return func(c *web.Context) {
+		start := time.Now()
+		id := requestID(c)
+		c.Next()
+
+		status := answeredStatus(c)
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		if level == slog.LevelInfo && !logConfig.sample() {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("requestId", id),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("clientIp", c.ClientIP()),
+		}
+		if logHeaders {
+			attrs = append(attrs, slog.Any("headers", st.sensitiveHeaders.redacted(c.Request.Header)))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// recovery logs panics as structured errors. http.ErrAbortHandler is
+// re-raised so net/http can drop the connection, which is how resets are
+// injected.
+func recovery() web.HandlerFunc {
+	return web.CustomRecoveryWithWriter(io.Discard, func(c *web.Context, err any) {
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		slog.Error("Panic recovered", "error", err, "path", c.Request.URL.Path)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+type loggingConfig struct {
+	Level      *string `json:"level,omitempty"`
+	SampleRate *int64  `json:"sampleRate,omitempty"`
+	RateLimit  *int64  `json:"rateLimit,omitempty"`
+}
+
+func getLoggingConfig(c *web.Context) {
+	level := logConfig.level.Level().String()
+	sampleRate := logConfig.sampleRate.Load()
+	rateLimit := logConfig.rateLimit.Load()
+	c.JSON(http.StatusOK, loggingConfig{Level: &level, SampleRate: &sampleRate, RateLimit: &rateLimit})
+}
+
+// postLoggingConfig updates the fields present in the body, leaving the
+// others unchanged.
+func (st *state) postLoggingConfig(c *web.Context) {
+	var config loggingConfig
+	if err := c.BindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := config.apply(); err != nil {
+		message := "Invalid sampling or rate limit value"
+		if errors.Is(err, errInvalidLogLevel) {
+			message = "Invalid log level"
+		}
+		c.JSON(http.StatusBadRequest, web.H{"error": message})
+		return
+	}
+	st.configChangesTotal.Inc()
+	getLoggingConfig(c)
+}
+
+var (
+	errInvalidLogLevel  = errors.New("invalid log level")
+	errInvalidLogLimits = errors.New("invalid sampling or rate limit value")
+)
+
+// apply sets the fields present once they are all valid.
+func (config loggingConfig) apply() error {
+	var level slog.Level
+	if config.Level != nil {
+		if err := level.UnmarshalText([]byte(*config.Level)); err != nil {
+			return errInvalidLogLevel
+		}
+	}
+	if (config.SampleRate != nil && *config.SampleRate < 1) || (config.RateLimit != nil && *config.RateLimit < 0) {
+		return errInvalidLogLimits
+	}
+
+	if config.Level != nil {
+		logConfig.level.Set(level)
+	}
+	if config.SampleRate != nil {
+		logConfig.sampleRate.Store(*config.SampleRate)
+	}
+	if config.RateLimit != nil {
+		logConfig.rateLimit.Store(*config.RateLimit)
+	}
+	return nil
+}
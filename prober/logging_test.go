@@ -0,0 +1,194 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, os.Getenv(logLevelEnv), os.Getenv(logFormatEnv)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestAccessLog(t *testing.T) {
+	st := newState(Options{})
+	logs := captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.accessLog())
+	router.GET("/delay/:seconds", st.delayRequest)
+
+	req, _ := http.NewRequest("GET", "/delay/invalid", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get(requestIDHeader) != "abc-123" {
+		t.Errorf("expected request id to be echoed back, got %q", w.Header().Get(requestIDHeader))
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %q", logs.String())
+	}
+	if entry["level"] != "WARN" || entry["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("expected a warning for status 400, got %v", entry)
+	}
+	if entry["requestId"] != "abc-123" || entry["route"] != "/delay/:seconds" || entry["path"] != "/delay/invalid" {
+		t.Errorf("expected request id, route and path to be logged, got %v", entry)
+	}
+	if entry["bytes"] != float64(w.Body.Len()) {
+		t.Errorf("expected %d bytes to be logged, got %v", w.Body.Len(), entry["bytes"])
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Errorf("expected latency to be logged, got %v", entry)
+	}
+}
+
+func TestAccessLogGeneratesRequestID(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.accessLog())
+	router.Any("/echo", st.echoRequest)
+
+	req, _ := http.NewRequest("GET", "/echo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	id := w.Header().Get(requestIDHeader)
+	if len(id) != 32 {
+		t.Fatalf("expected a generated request id, got %q", id)
+	}
+
+	var echo echoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &echo); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if got := http.Header(echo.Headers).Get(requestIDHeader); got != id {
+		t.Errorf("expected handlers to see the generated id %s, got %s", id, got)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Setenv(logLevelEnv, "error")
+	logs := captureLogs(t)
+
+	slog.Warn("Invalid delay value")
+	if logs.Len() != 0 {
+		t.Errorf("expected warning to be filtered, got %q", logs.String())
+	}
+	slog.Error("Failed to listen")
+	if logs.Len() == 0 {
+		t.Error("expected error to be logged")
+	}
+}
+
+func TestLoggerOptions(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var buf bytes.Buffer
+	t.Setenv(logLevelEnv, "error")
+	slog.SetDefault(newLogger(&buf, "debug", "text"))
+
+	slog.Debug("Probe delayed", "probe", "readiness")
+	if !strings.HasPrefix(buf.String(), "time=") || !strings.Contains(buf.String(), "level=DEBUG") {
+		t.Errorf("expected a debug text line despite LOG_LEVEL, got %q", buf.String())
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(recovery())
+	router.GET("/panic", func(c *web.Context) { panic("boom") })
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Setenv(logSampleRateEnv, "3")
+	st := newState(Options{})
+	logs := captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.accessLog())
+	router.GET("/delay/:seconds", st.delayRequest)
+
+	for _, path := range []string{"/delay/0", "/delay/0", "/delay/0", "/delay/0", "/delay/invalid"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if lines := bytes.Count(logs.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("expected 2 sampled successes and 1 warning, got %d lines: %s", lines, logs.String())
+	}
+}
+
+func TestLogRateLimit(t *testing.T) {
+	t.Setenv(logRateLimitEnv, "2")
+	logs := captureLogs(t)
+
+	for i := 0; i < 5; i++ {
+		slog.Warn("Invalid delay value")
+	}
+	slog.Error("Failed to listen")
+
+	if lines := bytes.Count(logs.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("expected 2 limited warnings and 1 error, got %d lines: %s", lines, logs.String())
+	}
+}
+
+func TestPostLoggingConfig(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.POST("/config/logging", st.postLoggingConfig)
+
+	req, _ := http.NewRequest("POST", "/config/logging", bytes.NewBufferString(`{"level": "warn", "sampleRate": 100}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := `{"level":"WARN","sampleRate":100,"rateLimit":0}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("expected info logs to be disabled at runtime")
+	}
+
+	req, _ = http.NewRequest("POST", "/config/logging", bytes.NewBufferString(`{"sampleRate": 0}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package prober
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultLongPollTimeout = 30 * time.Second
+	maxLongPollTimeout     = time.Hour
+	maxLongPollMessage     = 64 * 1024
+
+	defaultLongPollChannel = "default"
+)
+
+// longPollMetrics account the long polls waiting and completed.
+type longPollMetrics struct {
+	longPollWaiting        prometheus.Gauge
+	longPollCompletedTotal *prometheus.CounterVec
+}
+
+func newLongPollMetrics(registry prometheus.Registerer) longPollMetrics {
+	m := longPollMetrics{
+		longPollWaiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "longpoll_waiting",
+			Help: "Long-poll requests being held.",
+		}),
+
+		longPollCompletedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "longpoll_completed_total",
+			Help: "Long-poll requests completed by result: released, timeout, shutdown or canceled.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.longPollWaiting, m.longPollCompletedTotal)
+	return m
+}
+
+// longPollRelease wakes every request waiting on a channel, with the
+// message of the release.
+type longPollRelease struct {
+	done    chan struct{}
+	message string
+	waiting int
+}
+
+// longPollHub holds the long-poll requests by channel until released.
+type longPollHub struct {
+	mu       sync.Mutex
+	channels map[string]*longPollRelease
+}
+
+func (h *longPollHub) wait(channel string) (*longPollRelease, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	release, ok := h.channels[channel]
+	if !ok {
+		release = &longPollRelease{done: make(chan struct{})}
+		h.channels[channel] = release
+	}
+	release.waiting++
+	return release, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		release.waiting--
+		if release.waiting == 0 && h.channels[channel] == release {
+			delete(h.channels, channel)
+		}
+	}
+}
+
+// release wakes the requests waiting on the channel and returns how many
+// there were. Requests arriving afterwards wait for the next release.
+func (h *longPollHub) release(channel string, message string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	release, ok := h.channels[channel]
+	if !ok {
+		return 0
+	}
+	delete(h.channels, channel)
+	release.message = message
+	close(release.done)
+	return release.waiting
+}
+
+type longPollResult struct {
+	Result  string `json:"result"`
+	Channel string `json:"channel"`
+	Waited  string `json:"waited"`
+	Message string `json:"message,omitempty"`
+}
+
+// longPollHandler holds the request until the timeout query parameter,
+// 30s by default, fires, the channel is released or the server shuts down,
+// to test how proxies time out long-poll and webhook patterns.
+func (st *state) longPollHandler(c *web.Context) {
+	timeout := defaultLongPollTimeout
+	if value := c.Query("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxLongPollTimeout {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid timeout " + value})
+			return
+		}
+		timeout = parsed
+	}
+	channel := c.DefaultQuery("channel", defaultLongPollChannel)
+
+	start := time.Now()
+	release, done := st.longPolls.wait(channel)
+	defer done()
+	st.longPollWaiting.Inc()
+	defer st.longPollWaiting.Dec()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	result := longPollResult{Channel: channel}
+	select {
+	case <-release.done:
+		result.Result, result.Message = "released", release.message
+	case <-timer.C:
+		result.Result = "timeout"
+	case <-st.serverShutdown.done():
+		result.Result = "shutdown"
+	case <-c.Request.Context().Done():
+		st.longPollCompletedTotal.WithLabelValues("canceled").Inc()
+		return
+	}
+	st.longPollCompletedTotal.WithLabelValues(result.Result).Inc()
+	result.Waited = time.Since(start).String()
+	c.JSON(http.StatusOK, result)
+}
+
+// releaseLongPolls answers POST /longpoll/release by waking the requests
+// waiting on the channel, handing them the body as message.
+func (st *state) releaseLongPolls(c *web.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxLongPollMessage))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid body"})
+		return
+	}
+	channel := c.DefaultQuery("channel", defaultLongPollChannel)
+	c.JSON(http.StatusOK, web.H{"channel": channel, "released": st.longPolls.release(channel, string(data))})
+}
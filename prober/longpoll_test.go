@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestLongPoll(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	poll := func(query string) chan longPollResult {
+		results := make(chan longPollResult, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/longpoll?"+query, nil))
+			var result longPollResult
+			json.Unmarshal(w.Body.Bytes(), &result)
+			results <- result
+		}()
+		return results
+	}
+	waitForPolls := func(channel string, count int) {
+		for i := 0; i < 100; i++ {
+			st.longPolls.mu.Lock()
+			release := st.longPolls.channels[channel]
+			waiting := release != nil && release.waiting == count
+			st.longPolls.mu.Unlock()
+			if waiting {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected %d requests waiting on %s", count, channel)
+	}
+
+	first, second, other := poll("timeout=5s"), poll("timeout=5s"), poll("timeout=5s&channel=other")
+	waitForPolls(defaultLongPollChannel, 2)
+	waitForPolls("other", 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/longpoll/release", strings.NewReader("go")))
+	if w.Body.String() != `{"channel":"default","released":2}` {
+		t.Errorf("expected 2 requests released, got %s", w.Body.String())
+	}
+	for _, results := range []chan longPollResult{first, second} {
+		select {
+		case result := <-results:
+			if result.Result != "released" || result.Message != "go" {
+				t.Errorf("expected a release with its message, got %+v", result)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the release to answer the request")
+		}
+	}
+	select {
+	case result := <-other:
+		t.Errorf("expected the other channel to keep waiting, got %+v", result)
+	case <-time.After(20 * time.Millisecond):
+	}
+	st.longPolls.release("other", "")
+	<-other
+
+	start := time.Now()
+	if result := <-poll("timeout=50ms"); result.Result != "timeout" || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected a timeout after 50ms, got %+v", result)
+	}
+	held := poll("timeout=5s")
+	waitForPolls(defaultLongPollChannel, 1)
+	st.serverShutdown.begin()
+	if result := <-held; result.Result != "shutdown" {
+		t.Errorf("expected the shutdown to answer the request, got %+v", result)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/longpoll?timeout=2h", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 above the maximum timeout, got %d", w.Code)
+	}
+}
@@ -0,0 +1,292 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	meshServiceEnv  = "MESH_SERVICE"
+	meshPortEnv     = "MESH_PORT"
+	meshIntervalEnv = "MESH_INTERVAL"
+	podIPEnv        = "POD_IP"
+
+	defaultMeshPort     = 8080
+	defaultMeshInterval = 10 * time.Second
+	meshTimeout         = 2 * time.Second
+)
+
+// meshMetrics expose the reachability of the other replicas.
+type meshMetrics struct {
+	meshPeerUp      *prometheus.GaugeVec
+	meshPeerLatency *prometheus.GaugeVec
+}
+
+func newMeshMetrics(registry prometheus.Registerer) meshMetrics {
+	m := meshMetrics{
+		meshPeerUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mesh_peer_up",
+			Help: "Whether the last check from this replica to the peer succeeded.",
+		}, []string{"peer"}),
+
+		meshPeerLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mesh_peer_latency_seconds",
+			Help: "Round trip of the last check from this replica to the peer.",
+		}, []string{"peer"}),
+	}
+	registry.MustRegister(m.meshPeerUp, m.meshPeerLatency)
+	return m
+}
+
+// meshIdentity is what a replica answers on /mesh/ping.
+type meshIdentity struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+type meshPeer struct {
+	Addr    string    `json:"addr"`
+	Name    string    `json:"name,omitempty"`
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Latency string    `json:"latency"`
+	Error   string    `json:"error,omitempty"`
+
+	elapsed time.Duration
+}
+
+type meshRow struct {
+	meshIdentity
+	Peers []meshPeer `json:"peers"`
+}
+
+// meshMonitor discovers the other replicas behind a headless Service and
+// checks each of them every interval, so every replica holds its row of
+// the connectivity matrix.
+type meshMonitor struct {
+	self     meshIdentity
+	interval time.Duration
+	discover func(ctx context.Context) ([]string, error)
+	client   *http.Client
+
+	mu    sync.RWMutex
+	peers map[string]meshPeer
+
+	stop chan struct{}
+	done chan struct{}
+
+	meshMetrics
+}
+
+// loadMeshMonitor returns nil when MESH_SERVICE is unset. The replica
+// address comes from POD_IP, set through the downward API.
+func (st *state) loadMeshMonitor() *meshMonitor {
+	service := st.config.get(meshServiceEnv)
+	if service == "" {
+		return nil
+	}
+	port := strconv.Itoa(st.config.getInt(meshPortEnv, defaultMeshPort))
+	hostname, _ := os.Hostname()
+	self := meshIdentity{Name: hostname, Addr: net.JoinHostPort(st.config.get(podIPEnv), port)}
+
+	return newMeshMonitor(self, st.config.getDuration(meshIntervalEnv, defaultMeshInterval), func(ctx context.Context) ([]string, error) {
+		ips, err := net.DefaultResolver.LookupHost(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+		return addrs, nil
+	}, st.meshMetrics)
+}
+
+func newMeshMonitor(self meshIdentity, interval time.Duration, discover func(ctx context.Context) ([]string, error), metrics meshMetrics) *meshMonitor {
+	if interval <= 0 {
+		interval = defaultMeshInterval
+	}
+	return &meshMonitor{
+		self:     self,
+		interval: interval,
+		discover: discover,
+		client:   &http.Client{Timeout: meshTimeout},
+		peers:    make(map[string]meshPeer),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+
+		meshMetrics: metrics,
+	}
+}
+
+func (m *meshMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.checkPeers()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *meshMonitor) Close() {
+	close(m.stop)
+	<-m.done
+}
+
+// checkPeers checks every discovered replica concurrently. Replicas gone
+// from the Service are forgotten along with their series.
+func (m *meshMonitor) checkPeers() {
+	ctx, cancel := context.WithTimeout(context.Background(), meshTimeout)
+	addrs, err := m.discover(ctx)
+	cancel()
+	if err != nil {
+		slog.Warn("Failed to discover mesh peers", "error", err)
+		return
+	}
+
+	results := make(chan meshPeer, len(addrs))
+	current := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if addr == m.self.Addr {
+			continue
+		}
+		current[addr] = true
+		go func(addr string) { results <- m.ping(addr) }(addr)
+	}
+
+	peers := make(map[string]meshPeer, len(current))
+	for range current {
+		peer := <-results
+		peers[peer.Addr] = peer
+
+		up := 0.0
+		if peer.Success {
+			up = 1
+		}
+		m.meshPeerUp.WithLabelValues(peer.Addr).Set(up)
+		if peer.Success {
+			m.meshPeerLatency.WithLabelValues(peer.Addr).Set(peer.elapsed.Seconds())
+		}
+	}
+
+	m.mu.Lock()
+	for addr := range m.peers {
+		if !current[addr] {
+			m.meshPeerUp.DeleteLabelValues(addr)
+			m.meshPeerLatency.DeleteLabelValues(addr)
+		}
+	}
+	m.peers = peers
+	m.mu.Unlock()
+}
+
+func (m *meshMonitor) ping(addr string) meshPeer {
+	peer := meshPeer{Addr: addr, Time: time.Now()}
+	var identity meshIdentity
+	err := m.getJSON("http://"+addr+"/mesh/ping", &identity)
+	peer.elapsed = time.Since(peer.Time)
+	peer.Latency = peer.elapsed.String()
+	if err != nil {
+		peer.Error = err.Error()
+		return peer
+	}
+	peer.Success = true
+	peer.Name = identity.Name
+	return peer
+}
+
+func (m *meshMonitor) getJSON(url string, v any) error {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// row returns the peers seen from this replica, sorted by address.
+func (m *meshMonitor) row() meshRow {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	row := meshRow{meshIdentity: m.self, Peers: make([]meshPeer, 0, len(m.peers))}
+	for _, peer := range m.peers {
+		row.Peers = append(row.Peers, peer)
+	}
+	sort.Slice(row.Peers, func(i, j int) bool { return row.Peers[i].Addr < row.Peers[j].Addr })
+	return row
+}
+
+// matrix collects the rows of every reachable peer. A peer whose row can't
+// be fetched is listed with its error, which is itself a finding.
+func (m *meshMonitor) matrix() []meshRow {
+	self := m.row()
+	rows := make([]meshRow, len(self.Peers)+1)
+	rows[0] = self
+
+	var wg sync.WaitGroup
+	for i, peer := range self.Peers {
+		wg.Add(1)
+		go func(i int, peer meshPeer) {
+			defer wg.Done()
+			var row meshRow
+			if err := m.getJSON("http://"+peer.Addr+"/mesh", &row); err != nil {
+				row = meshRow{
+					meshIdentity: meshIdentity{Name: peer.Name, Addr: peer.Addr},
+					Peers:        []meshPeer{{Addr: m.self.Addr, Error: "row unavailable: " + err.Error()}},
+				}
+			}
+			rows[i+1] = row
+		}(i, peer)
+	}
+	wg.Wait()
+	return rows
+}
+
+func (st *state) meshPingHandler(c *web.Context) {
+	hostname, _ := os.Hostname()
+	identity := meshIdentity{Name: hostname, Addr: c.Request.Host}
+	if st.replicaMesh != nil {
+		identity = st.replicaMesh.self
+	}
+	c.JSON(http.StatusOK, identity)
+}
+
+func (st *state) meshHandler(c *web.Context) {
+	if st.replicaMesh == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Mesh is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.replicaMesh.row())
+}
+
+func (st *state) meshMatrixHandler(c *web.Context) {
+	if st.replicaMesh == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Mesh is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.replicaMesh.matrix())
+}
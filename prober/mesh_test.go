@@ -0,0 +1,128 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMeshMatrix(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	peerRouter := web.New()
+	peer := httptest.NewServer(peerRouter)
+	defer peer.Close()
+	peerAddr := peer.Listener.Addr().String()
+	peerRouter.GET("/mesh/ping", func(c *web.Context) {
+		c.JSON(http.StatusOK, meshIdentity{Name: "prober-1", Addr: peerAddr})
+	})
+	peerRouter.GET("/mesh", func(c *web.Context) {
+		c.JSON(http.StatusOK, meshRow{
+			meshIdentity: meshIdentity{Name: "prober-1", Addr: peerAddr},
+			Peers:        []meshPeer{{Addr: "self:8080", Name: "prober-0", Success: false, Error: "connection refused"}},
+		})
+	})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	st.replicaMesh = newMeshMonitor(meshIdentity{Name: "prober-0", Addr: "self:8080"}, 0, func(context.Context) ([]string, error) {
+		return []string{"self:8080", peerAddr, closedAddr}, nil
+	}, st.meshMetrics)
+	st.replicaMesh.checkPeers()
+
+	if got := testutil.ToFloat64(st.meshPeerUp.WithLabelValues(peerAddr)); got != 1 {
+		t.Errorf("expected mesh_peer_up 1 for %s, got %v", peerAddr, got)
+	}
+	if got := testutil.ToFloat64(st.meshPeerUp.WithLabelValues(closedAddr)); got != 0 {
+		t.Errorf("expected mesh_peer_up 0 for %s, got %v", closedAddr, got)
+	}
+
+	router := web.New()
+	router.GET("/mesh", st.meshHandler)
+	router.GET("/mesh/matrix", st.meshMatrixHandler)
+
+	req, _ := http.NewRequest("GET", "/mesh", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var row meshRow
+	if err := json.Unmarshal(w.Body.Bytes(), &row); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(row.Peers) != 2 {
+		t.Fatalf("expected 2 peers without self, got %+v", row.Peers)
+	}
+	for _, p := range row.Peers {
+		switch p.Addr {
+		case peerAddr:
+			if !p.Success || p.Name != "prober-1" {
+				t.Errorf("expected %s to be reachable as prober-1, got %+v", peerAddr, p)
+			}
+		case closedAddr:
+			if p.Success || p.Error == "" {
+				t.Errorf("expected %s to fail, got %+v", closedAddr, p)
+			}
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/mesh/matrix", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var matrix []meshRow
+	if err := json.Unmarshal(w.Body.Bytes(), &matrix); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(matrix) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(matrix))
+	}
+	if matrix[0].Name != "prober-0" {
+		t.Errorf("expected own row first, got %s", matrix[0].Name)
+	}
+	for _, r := range matrix[1:] {
+		switch r.Addr {
+		case peerAddr:
+			if len(r.Peers) != 1 || r.Peers[0].Success {
+				t.Errorf("expected the asymmetric failure in the peer row, got %+v", r.Peers)
+			}
+		case closedAddr:
+			if len(r.Peers) != 1 || !strings.HasPrefix(r.Peers[0].Error, "row unavailable") {
+				t.Errorf("expected an unavailable row, got %+v", r.Peers)
+			}
+		}
+	}
+
+	st.replicaMesh.discover = func(context.Context) ([]string, error) { return []string{peerAddr}, nil }
+	st.replicaMesh.checkPeers()
+	if got := testutil.CollectAndCount(st.meshPeerUp); got != 1 {
+		t.Errorf("expected vanished peers to be dropped, got %d series", got)
+	}
+}
+
+func TestMeshDisabled(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/mesh", st.meshHandler)
+
+	req, _ := http.NewRequest("GET", "/mesh", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	saslPlain       = "plain"
+	saslScramSHA256 = "scram-sha-256"
+	saslScramSHA512 = "scram-sha-512"
+)
+
+// kafkaMechanism returns the SASL mechanism of the check, nil without
+// username.
+func kafkaMechanism(config checkConfig) (sasl.Mechanism, error) {
+	if config.Username == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(config.SASLMechanism) {
+	case "", saslPlain:
+		return plain.Mechanism{Username: config.Username, Password: config.Password}, nil
+	case saslScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.Username, config.Password)
+	case saslScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.Username, config.Password)
+	}
+	return nil, fmt.Errorf("unknown SASL mechanism %q", config.SASLMechanism)
+}
+
+// kafkaCheck connects to the first reachable broker, authenticates and
+// sends a metadata request, succeeding when the cluster lists its brokers.
+func kafkaCheck(ctx context.Context, config checkConfig) checkResult {
+	mechanism, err := kafkaMechanism(config)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	dialer := &kafka.Dialer{SASLMechanism: mechanism}
+	if config.TLS {
+		dialer.TLS = &tls.Config{}
+	}
+
+	var errs []error
+	for _, broker := range config.Brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		brokers, err := conn.Brokers()
+		conn.Close()
+		if err != nil {
+			return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+		}
+		result := checkResult{Success: len(brokers) > 0}
+		for _, b := range brokers {
+			result.Records = append(result.Records, fmt.Sprintf("%s:%d", b.Host, b.Port))
+		}
+		return result
+	}
+	err = errors.Join(errs...)
+	return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+}
+
+// amqpCheck opens an AMQP connection to the amqp:// or amqps:// URL, which
+// covers the TCP, TLS, authentication and vhost access steps.
+func amqpCheck(ctx context.Context, config checkConfig) checkResult {
+	dial := func(network string, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// Bounds the handshake, the client clears it once connected.
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		return conn, nil
+	}
+	conn, err := amqp.DialConfig(config.DSN, amqp.Config{Dial: dial})
+	if err != nil {
+		return checkResult{Error: err.Error(), ErrorClass: classifyDialError(err)}
+	}
+	conn.Close()
+	return checkResult{Success: true}
+}
@@ -0,0 +1,50 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMessagingChecks(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []checkConfig{
+		{Type: checkTypeKafka, Brokers: []string{closedAddr}},
+		{Type: checkTypeKafka, Brokers: []string{closedAddr}, Username: "prober", Password: "secret", SASLMechanism: saslScramSHA512},
+		{Type: checkTypeAMQP, DSN: "amqp://guest:guest@" + closedAddr + "/"},
+	}
+
+	for _, config := range tests {
+		config.Name = config.Type
+		if err := config.validate(); err != nil {
+			t.Fatalf("unexpected error for %s: %v", config.Type, err)
+		}
+		config.Timeout = time.Second
+		result := runCheck(context.Background(), config)
+
+		if result.Success || result.ErrorClass != dialErrorRefused {
+			t.Errorf("expected a refused connection for %s, got %+v", config.Type, result)
+		}
+	}
+}
+
+func TestMessagingChecksValidation(t *testing.T) {
+	invalid := []checkConfig{
+		{Name: "kafka", Type: checkTypeKafka},
+		{Name: "kafka", Type: checkTypeKafka, Brokers: []string{"kafka:9092"}, Username: "prober", SASLMechanism: "gssapi"},
+		{Name: "amqp", Type: checkTypeAMQP},
+		{Name: "amqp", Type: checkTypeAMQP, DSN: "http://rabbitmq:5672"},
+	}
+	for _, config := range invalid {
+		if err := config.validate(); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package prober
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsBucketsEnv           = "METRICS_BUCKETS"
+	metricsRuntimeCollectorsEnv = "METRICS_RUNTIME_COLLECTORS"
+	metricsExemplarsEnv         = "METRICS_EXEMPLARS"
+	metricsAddrEnv              = "METRICS_ADDR"
+	metricsMaxRoutesEnv         = "METRICS_MAX_ROUTES"
+
+	defaultMetricsMaxRoutes = 100
+	otherRoute              = "other"
+)
+
+// defaultMetricsBuckets extends the Prometheus defaults to cover the
+// multi-second delays prober is asked to inject.
+var defaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// serverMetrics account the requests, faults, probes and shutdown of the
+// server.
+type serverMetrics struct {
+	httpRequestsTotal           *prometheus.CounterVec
+	httpRequestDuration         *prometheus.HistogramVec
+	httpInFlightRequests        *prometheus.GaugeVec
+	shutdownStartedTimestamp    prometheus.Gauge
+	shutdownDuration            prometheus.Gauge
+	requestsCancelledOnShutdown prometheus.Counter
+	configChangesTotal          prometheus.Counter
+	faultLatency                *prometheus.GaugeVec
+	faultErrorRate              *prometheus.GaugeVec
+	faultResetRate              *prometheus.GaugeVec
+	faultsInjectedTotal         *prometheus.CounterVec
+	probeRequestsTotal          *prometheus.CounterVec
+	probeLastSuccess            *prometheus.GaugeVec
+}
+
+func newServerMetrics(registry prometheus.Registerer, buckets []float64) serverMetrics {
+	m := serverMetrics{
+		httpRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total HTTP requests by method, route template, status code, protocol and IP family.",
+		}, []string{"method", "route", "status", "proto", "family"}),
+
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by method, route template, status code, protocol and IP family.",
+			Buckets: buckets,
+		}, []string{"method", "route", "status", "proto", "family"}),
+
+		httpInFlightRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_in_flight_requests",
+			Help: "HTTP requests currently being served by route template.",
+		}, []string{"route"}),
+
+		shutdownStartedTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shutdown_started_timestamp_seconds",
+			Help: "Unix time when the graceful shutdown started.",
+		}),
+
+		shutdownDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shutdown_duration_seconds",
+			Help: "Time taken to drain the servers during the graceful shutdown.",
+		}),
+
+		requestsCancelledOnShutdown: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "requests_cancelled_on_shutdown_total",
+			Help: "Requests cut short by the shutdown, either by a shutdown-aware handler or the drain timeout.",
+		}),
+
+		configChangesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "config_changes_total",
+			Help: "Runtime configuration changes made through the /config endpoints.",
+		}),
+
+		faultLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fault_latency_seconds",
+			Help: "Latency injected on every request by listener.",
+		}, []string{"listener"}),
+
+		faultErrorRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fault_error_rate",
+			Help: "Share of requests answered with an injected error by listener.",
+		}, []string{"listener"}),
+
+		faultResetRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fault_reset_rate",
+			Help: "Share of connections reset without response by listener.",
+		}, []string{"listener"}),
+
+		faultsInjectedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "faults_injected_total",
+			Help: "Faults injected by listener and fault.",
+		}, []string{"listener", "fault"}),
+
+		probeRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_requests_total",
+			Help: "Total simulated probe requests by probe and outcome.",
+		}, []string{"probe", "outcome"}),
+
+		probeLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful answer of each simulated probe.",
+		}, []string{"probe"}),
+	}
+	registry.MustRegister(
+		m.httpRequestsTotal, m.httpRequestDuration, m.httpInFlightRequests,
+		m.shutdownStartedTimestamp, m.shutdownDuration, m.requestsCancelledOnShutdown,
+		m.configChangesTotal, m.faultLatency, m.faultErrorRate, m.faultResetRate, m.faultsInjectedTotal,
+		m.probeRequestsTotal, m.probeLastSuccess,
+	)
+	return m
+}
+
+// inFlight accounts a request in activeRequests until its handler chain
+// returned. It comes first in the chain and decrements in a defer so early
+// returns, aborts and panics, even the http.ErrAbortHandler ones recovery
+// re-raises, can't leak the count.
+func (st *state) inFlight() web.HandlerFunc {
+	return func(c *web.Context) {
+		st.activeRequests.Add(1)
+		defer st.activeRequests.Add(-1)
+		c.Next()
+	}
+}
+
+// routeLabels bounds the route label cardinality. Requests matching no
+// route template, and templates past the first max ones seen, are counted
+// under "other" so a catch-all route can't create a series per raw path.
+type routeLabels struct {
+	max int
+
+	mu   sync.RWMutex
+	seen map[string]bool
+}
+
+func newRouteLabels(max int) *routeLabels {
+	return &routeLabels{max: max, seen: make(map[string]bool)}
+}
+
+func (r *routeLabels) label(route string) string {
+	if route == "" {
+		return otherRoute
+	}
+
+	r.mu.RLock()
+	known := r.seen[route]
+	r.mu.RUnlock()
+	if known {
+		return route
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[route] {
+		return route
+	}
+	if len(r.seen) >= r.max {
+		return otherRoute
+	}
+	r.seen[route] = true
+	return route
+}
+
+// probeRoutes maps the simulated probe endpoints to their probe label.
+var probeRoutes = map[string]string{
+	"/startup":   "startup",
+	"/readiness": "readiness",
+	"/liveness":  "liveness",
+}
+
+// getMetricsBuckets parses METRICS_BUCKETS as a comma separated list of
+// upper bounds in seconds.
+func getMetricsBuckets(config *settings) []float64 {
+	value, exists := config.lookup(metricsBucketsEnv)
+	if !exists || value == "" {
+		return defaultMetricsBuckets
+	}
+
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bucket <= 0 {
+			slog.Warn("Invalid bucket value", "env", metricsBucketsEnv, "value", value)
+			return defaultMetricsBuckets
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// metricsMiddleware counts and times every request once the handler chain
+// finished, so handlers returning early (like a 400 on an invalid delay) are
+// accounted for as well, and panicking ones as the 500 recovery answers. The
+// in-flight gauge is decremented in the same defer so it can't leak.
+func (st *state) metricsMiddleware() web.HandlerFunc {
+	exemplars := st.config.getBool(metricsExemplarsEnv, false)
+
+	return func(c *web.Context) {
+		start := time.Now()
+		route := st.metricsRoutes.label(c.FullPath())
+		gauge := st.httpInFlightRequests.WithLabelValues(route)
+		gauge.Inc()
+		panicked := true
+		defer func() {
+			gauge.Dec()
+			status := answeredStatus(c)
+			if panicked {
+				status = http.StatusInternalServerError
+			}
+			st.observeRequest(c, route, status, time.Since(start), exemplars)
+		}()
+
+		c.Next()
+		panicked = false
+	}
+}
+
+func (st *state) observeRequest(c *web.Context, route string, code int, elapsed time.Duration, exemplars bool) {
+	status := strconv.Itoa(code)
+	family := connFamily(c.Request)
+	st.httpRequestsTotal.WithLabelValues(c.Request.Method, route, status, c.Request.Proto, family).Inc()
+	statsdTags := []string{"method:" + c.Request.Method, "route:" + route, "status:" + status, "proto:" + c.Request.Proto, "family:" + family}
+	st.statsdSink.count("http.requests", 1, statsdTags...)
+	st.statsdSink.timing("http.request.duration", elapsed, statsdTags...)
+
+	duration := st.httpRequestDuration.WithLabelValues(c.Request.Method, route, status, c.Request.Proto, family)
+	if exemplar := traceExemplar(c.Request, exemplars); exemplar != nil {
+		duration.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), exemplar)
+	} else {
+		duration.Observe(elapsed.Seconds())
+	}
+
+	if probe, ok := probeRoutes[c.FullPath()]; ok {
+		st.observeProbe(probe, code)
+	}
+}
+
+// newProbeDelayCollector exposes the delay currently configured for a
+// simulated probe, read at scrape time so changes made through /config show
+// up right away.
+func (st *state) newProbeDelayCollector(probe string, env string) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "probe_configured_delay_seconds",
+		Help:        "Delay configured for each simulated probe.",
+		ConstLabels: prometheus.Labels{"probe": probe},
+	}, func() float64 { return st.probeDelay(env).Seconds() })
+}
+
+// observeProbe records the outcome kubelet got from a simulated probe. Like
+// kubelet, any status from 200 to 399 counts as success.
+func (st *state) observeProbe(probe string, status int) {
+	if status >= http.StatusOK && status < http.StatusBadRequest {
+		st.probeRequestsTotal.WithLabelValues(probe, "success").Inc()
+		st.statsdSink.count("probe.requests", 1, "probe:"+probe, "outcome:success")
+		st.probeLastSuccess.WithLabelValues(probe).SetToCurrentTime()
+		st.webhooks.observe(webhookEventProbe, probe, true, "")
+		st.kubeEvents.probe(probe, true, "")
+		return
+	}
+	st.webhooks.observe(webhookEventProbe, probe, false, http.StatusText(status))
+	st.kubeEvents.probe(probe, false, http.StatusText(status))
+	st.probeRequestsTotal.WithLabelValues(probe, "failure").Inc()
+	st.statsdSink.count("probe.requests", 1, "probe:"+probe, "outcome:failure")
+}
+
+// traceExemplar links an observation to the sampled trace the request took
+// part in, as propagated by the caller through the traceparent header.
+func traceExemplar(req *http.Request, enabled bool) prometheus.Labels {
+	if !enabled {
+		return nil
+	}
+	trace, ok := parseTraceparent(req.Header.Get(traceparentHeader))
+	if !ok || !trace.Sampled {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": trace.TraceID}
+}
+
+// metricsHandler negotiates the OpenMetrics format when asked, which is the
+// only one carrying exemplars.
+func (st *state) metricsHandler() web.HandlerFunc {
+	return web.WrapH(promhttp.HandlerFor(st.registry, promhttp.HandlerOpts{
+		Registry:          st.registry,
+		EnableOpenMetrics: true,
+	}))
+}
+
+// newMetricsRouter serves /metrics, /healthz and /probe alone, so chaos injected on
+// the traffic listeners never slows down or breaks scraping.
+func (st *state) newMetricsRouter() *web.Engine {
+	router := web.New()
+	router.Use(recovery())
+	router.GET("/metrics", st.metricsHandler())
+	router.GET("/healthz", st.healthzHandler())
+	router.GET("/probe", blackboxProbe(st.probeModules))
+	return router
+}
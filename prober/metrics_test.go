@@ -0,0 +1,381 @@
+package prober
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// histogramCount returns how many observations were made for the labels.
+func histogramCount(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := histogram.WithLabelValues(labels...).(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+	router.GET("/delay/:seconds", st.delayRequest)
+	router.GET("/metrics", st.metricsHandler())
+
+	before := histogramCount(t, st.httpRequestDuration, "GET", "/delay/:seconds", "200", "HTTP/1.1", "unknown")
+
+	req, _ := http.NewRequest("GET", "/delay/0", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if after := histogramCount(t, st.httpRequestDuration, "GET", "/delay/:seconds", "200", "HTTP/1.1", "unknown"); after != before+1 {
+		t.Errorf("expected one more observation, got %d -> %d", before, after)
+	}
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, expected := range []string{
+		`http_request_duration_seconds_bucket{family="unknown",method="GET",proto="HTTP/1.1",route="/delay/:seconds",status="200",le="120"}`,
+		"go_goroutines",
+		"process_open_fds",
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected %s in metrics output, got %s", expected, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsMiddlewareCountsAllRoutes(t *testing.T) {
+	t.Setenv(readinessProbeDelayEnv, "0")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+	router.GET("/readiness", st.probeHandler(readinessProbeDelayEnv, "readiness"))
+	router.GET("/delay/:seconds", st.delayRequest)
+
+	tests := []struct {
+		path   string
+		route  string
+		status string
+	}{
+		{"/readiness", "/readiness", "200"},
+		{"/delay/invalid", "/delay/:seconds", "400"},
+		{"/unknown/path", "other", "404"},
+	}
+	for _, test := range tests {
+		counter := st.httpRequestsTotal.WithLabelValues("GET", test.route, test.status, "HTTP/1.1", "unknown")
+		before := testutil.ToFloat64(counter)
+
+		req, _ := http.NewRequest("GET", test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if after := testutil.ToFloat64(counter); after != before+1 {
+			t.Errorf("%s: expected counter to increase by 1, got %v -> %v", test.path, before, after)
+		}
+	}
+}
+
+func TestRouteLabels(t *testing.T) {
+	labels := newRouteLabels(2)
+
+	tests := []struct {
+		route    string
+		expected string
+	}{
+		{"", "other"},
+		{"/delay/:seconds", "/delay/:seconds"},
+		{"/liveness", "/liveness"},
+		{"/files/*path", "other"},
+		{"/delay/:seconds", "/delay/:seconds"},
+	}
+	for _, test := range tests {
+		if label := labels.label(test.route); label != test.expected {
+			t.Errorf("%q: expected label %s, got %s", test.route, test.expected, label)
+		}
+	}
+}
+
+func TestInFlightRequests(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+
+	release := make(chan struct{})
+	router.GET("/hold", func(c *web.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/delay/:seconds", st.delayRequest)
+
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", "/hold", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	gauge := st.httpInFlightRequests.WithLabelValues("/hold")
+	for i := 0; i < 100 && testutil.ToFloat64(gauge) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if value := testutil.ToFloat64(gauge); value != 1 {
+		t.Errorf("expected 1 in-flight request, got %v", value)
+	}
+	close(release)
+	<-done
+	if value := testutil.ToFloat64(gauge); value != 0 {
+		t.Errorf("expected no in-flight request after completion, got %v", value)
+	}
+
+	req, _ := http.NewRequest("GET", "/delay/invalid", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if value := testutil.ToFloat64(st.httpInFlightRequests.WithLabelValues("/delay/:seconds")); value != 0 {
+		t.Errorf("expected early return not to leak in-flight requests, got %v", value)
+	}
+}
+
+func TestActiveRequestsLeakProof(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.inFlight(), recovery(), st.metricsMiddleware())
+	router.GET("/delay/:seconds", st.delayRequest)
+	router.GET("/panic", func(c *web.Context) { panic("boom") })
+	router.GET("/abort", func(c *web.Context) { panic(http.ErrAbortHandler) })
+
+	active := st.activeRequests.Load()
+	panics := testutil.ToFloat64(st.httpRequestsTotal.WithLabelValues("GET", "/panic", "500", "HTTP/1.1", ipFamilyIPv4))
+	for _, path := range []string{"/delay/invalid", "/panic", "/abort"} {
+		func() {
+			defer func() { recover() }()
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}()
+	}
+	if value := st.activeRequests.Load(); value != active {
+		t.Errorf("expected early returns and panics not to leak active requests, got %d more", value-active)
+	}
+	if value := testutil.ToFloat64(st.httpRequestsTotal.WithLabelValues("GET", "/panic", "500", "HTTP/1.1", ipFamilyIPv4)) - panics; value != 1 {
+		t.Errorf("expected the panic counted as a 500, got %v", value)
+	}
+	if value := testutil.ToFloat64(st.httpInFlightRequests.WithLabelValues("/abort")); value != 0 {
+		t.Errorf("expected aborted handlers not to leak in-flight requests, got %v", value)
+	}
+}
+
+func TestProbeMetrics(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+	router.Use(st.faultMiddleware("test", faultProfile{ErrorRate: 1}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	failures := st.probeRequestsTotal.WithLabelValues("liveness", "failure")
+	before := testutil.ToFloat64(failures)
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if after := testutil.ToFloat64(failures); after != before+1 {
+		t.Errorf("expected injected fault to count as failure, got %v -> %v", before, after)
+	}
+
+	st.observeProbe("liveness", http.StatusOK)
+	if testutil.ToFloat64(st.probeRequestsTotal.WithLabelValues("liveness", "success")) < 1 {
+		t.Error("expected success to be counted")
+	}
+	if testutil.ToFloat64(st.probeLastSuccess.WithLabelValues("liveness")) == 0 {
+		t.Error("expected last success timestamp to be set")
+	}
+}
+
+func TestMetricsExemplars(t *testing.T) {
+	t.Setenv(metricsExemplarsEnv, "true")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.Use(st.metricsMiddleware())
+	router.GET("/graceDelay/:seconds", st.graceDelayRequest)
+	router.GET("/metrics", st.metricsHandler())
+
+	req, _ := http.NewRequest("GET", "/graceDelay/0", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), `route="/graceDelay/:seconds",status="200",le="0.005"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+		t.Errorf("expected exemplar with trace id in metrics output, got %s", w.Body.String())
+	}
+}
+
+func TestMetricsDedicatedListener(t *testing.T) {
+	t.Setenv(metricsAddrEnv, ":9090")
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	st.newRouter(nil, listenerConfig{}).ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected metrics to be removed from the traffic router, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	st.newMetricsRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "http_requests_total") {
+		t.Errorf("expected metrics on the dedicated router, got status %d", w.Code)
+	}
+}
+
+func TestGetMetricsBuckets(t *testing.T) {
+	t.Setenv(metricsBucketsEnv, "5, 0.1,1")
+	buckets := getMetricsBuckets(envSettings)
+	if len(buckets) != 3 || buckets[0] != 0.1 || buckets[2] != 5 {
+		t.Errorf("expected sorted buckets [0.1 1 5], got %v", buckets)
+	}
+
+	t.Setenv(metricsBucketsEnv, "0.1,fast")
+	if buckets := getMetricsBuckets(envSettings); len(buckets) != len(defaultMetricsBuckets) {
+		t.Errorf("expected default buckets for invalid value, got %v", buckets)
+	}
+}
+
+func TestShutdownMetrics(t *testing.T) {
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.metricsMiddleware())
+	router.GET("/graceDelay/:seconds", st.graceDelayRequest)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	cancelled := testutil.ToFloat64(st.requestsCancelledOnShutdown)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(srv.URL + "/graceDelay/10")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	st.gracefulShutdown(srv.Config)("test")
+	<-done
+
+	if got := testutil.ToFloat64(st.requestsCancelledOnShutdown) - cancelled; got != 1 {
+		t.Errorf("expected 1 cancelled request, got %v", got)
+	}
+	if started := testutil.ToFloat64(st.shutdownStartedTimestamp); started < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("expected a recent shutdown start timestamp, got %v", started)
+	}
+	if duration := testutil.ToFloat64(st.shutdownDuration); duration > 0.5 {
+		t.Errorf("expected the request to stop waiting once shutdown started, got %vs", duration)
+	}
+}
+
+func TestChaosMetrics(t *testing.T) {
+	t.Setenv(startupProbeDelayEnv, "0")
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.faultMiddleware("chaotic", faultProfile{Latency: 10 * time.Millisecond, ErrorRate: 1}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if latency := testutil.ToFloat64(st.faultLatency.WithLabelValues("chaotic")); latency != 0.01 {
+		t.Errorf("expected fault latency 0.01, got %v", latency)
+	}
+	if rate := testutil.ToFloat64(st.faultErrorRate.WithLabelValues("chaotic")); rate != 1 {
+		t.Errorf("expected fault error rate 1, got %v", rate)
+	}
+	if injected := testutil.ToFloat64(st.faultsInjectedTotal.WithLabelValues("chaotic", "error")); injected != 1 {
+		t.Errorf("expected 1 injected error, got %v", injected)
+	}
+
+	changes := testutil.ToFloat64(st.configChangesTotal)
+	router = web.New()
+	router.POST("/config", st.postConfigs)
+	router.GET("/metrics", st.metricsHandler())
+
+	req, _ = http.NewRequest("POST", "/config", strings.NewReader(`{"startup": "0", "readiness": "0", "liveness": "7"}`))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(st.configChangesTotal); got != changes+1 {
+		t.Errorf("expected config changes to increase by 1, got %v -> %v", changes, got)
+	}
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `probe_configured_delay_seconds{probe="liveness"} 7`) {
+		t.Errorf("expected configured liveness delay, got %s", w.Body.String())
+	}
+}
+
+func TestMetricsIPFamily(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.metricsMiddleware())
+	router.GET("/liveness", func(c *web.Context) { c.Status(http.StatusOK) })
+
+	for family, address := range map[string]string{ipFamilyIPv4: "127.0.0.1:0", ipFamilyIPv6: "[::1]:0"} {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			t.Logf("%s: no loopback listener: %v", family, err)
+			continue
+		}
+		srv := httptest.NewUnstartedServer(router)
+		srv.Listener.Close()
+		srv.Listener = listener
+		srv.Start()
+
+		counter := st.httpRequestsTotal.WithLabelValues("GET", "/liveness", "200", "HTTP/1.1", family)
+		before := testutil.ToFloat64(counter)
+		resp, err := http.Get(srv.URL + "/liveness")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if after := testutil.ToFloat64(counter); after != before+1 {
+			t.Errorf("%s: expected the request counted with its family, got %v -> %v", family, before, after)
+		}
+	}
+}
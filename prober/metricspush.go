@@ -0,0 +1,241 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	metricsPushURLEnv        = "METRICS_PUSH_URL"
+	metricsPushJobEnv        = "METRICS_PUSH_JOB"
+	metricsRemoteWriteURLEnv = "METRICS_REMOTE_WRITE_URL"
+	metricsPushIntervalEnv   = "METRICS_PUSH_INTERVAL"
+
+	defaultMetricsPushJob      = "prober"
+	defaultMetricsPushInterval = 15 * time.Second
+	metricsPushTimeout         = 10 * time.Second
+)
+
+// metricsPusher periodically publishes the registry to a Pushgateway and/or
+// a Prometheus remote-write endpoint, for runs where prober is a short-lived
+// Job that can't be scraped.
+type metricsPusher struct {
+	interval time.Duration
+	targets  map[string]func(context.Context) error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMetricsPusher(config *settings, gatherer prometheus.Gatherer) *metricsPusher {
+	instance, _ := os.Hostname()
+	targets := make(map[string]func(context.Context) error)
+
+	if url := config.get(metricsPushURLEnv); url != "" {
+		pusher := push.New(url, config.getString(metricsPushJobEnv, defaultMetricsPushJob)).
+			Gatherer(gatherer).
+			Grouping("instance", instance)
+		targets["pushgateway"] = pusher.PushContext
+	}
+	if url := config.get(metricsRemoteWriteURLEnv); url != "" {
+		labels := map[string]string{"job": config.getString(metricsPushJobEnv, defaultMetricsPushJob), "instance": instance}
+		targets["remote-write"] = func(ctx context.Context) error {
+			return remoteWrite(ctx, url, gatherer, labels)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return &metricsPusher{
+		interval: config.getDuration(metricsPushIntervalEnv, defaultMetricsPushInterval),
+		targets:  targets,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *metricsPusher) pushAll() {
+	for name, target := range p.targets {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsPushTimeout)
+		if err := target(ctx); err != nil {
+			slog.Error("Failed to push metrics", "target", name, "error", err)
+		}
+		cancel()
+	}
+}
+
+func (p *metricsPusher) run() {
+	defer close(p.done)
+	if p.interval <= 0 {
+		p.interval = defaultMetricsPushInterval
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.pushAll()
+		}
+	}
+}
+
+// Close stops the periodic push and publishes a last time, so the final
+// state of a finished Job is not lost.
+func (p *metricsPusher) Close() {
+	close(p.stop)
+	<-p.done
+	p.pushAll()
+}
+
+// remoteWrite sends the current values of every metric using the Prometheus
+// remote-write 1.0 protocol (snappy compressed protobuf WriteRequest).
+func remoteWrite(ctx context.Context, url string, gatherer prometheus.Gatherer, labels map[string]string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(families, labels, time.Now().UnixMilli()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote write answered %s", resp.Status)
+	}
+	return nil
+}
+
+type remoteSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// flattenFamily turns a metric family into the series Prometheus would store
+// when scraping it: histograms and summaries expand into _bucket/_sum/_count.
+func flattenFamily(family *dto.MetricFamily, extra map[string]string) []remoteSample {
+	var samples []remoteSample
+	for _, metric := range family.GetMetric() {
+		base := make(map[string]string, len(extra)+len(metric.GetLabel()))
+		for name, value := range extra {
+			base[name] = value
+		}
+		for _, label := range metric.GetLabel() {
+			base[label.GetName()] = label.GetValue()
+		}
+		add := func(suffix string, value float64, more ...string) {
+			labels := make(map[string]string, len(base)+2)
+			for name, value := range base {
+				labels[name] = value
+			}
+			for i := 0; i+1 < len(more); i += 2 {
+				labels[more[i]] = more[i+1]
+			}
+			labels["__name__"] = family.GetName() + suffix
+			samples = append(samples, remoteSample{labels: labels, value: value})
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			add("", metric.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			add("", metric.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			add("", metric.GetUntyped().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			for _, bucket := range histogram.GetBucket() {
+				add("_bucket", float64(bucket.GetCumulativeCount()), "le", formatFloat(bucket.GetUpperBound()))
+			}
+			add("_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+			add("_sum", histogram.GetSampleSum())
+			add("_count", float64(histogram.GetSampleCount()))
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			for _, quantile := range summary.GetQuantile() {
+				add("", quantile.GetValue(), "quantile", formatFloat(quantile.GetQuantile()))
+			}
+			add("_sum", summary.GetSampleSum())
+			add("_count", float64(summary.GetSampleCount()))
+		}
+	}
+	return samples
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest hand-encodes prometheus.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(families []*dto.MetricFamily, extra map[string]string, timestamp int64) []byte {
+	var request []byte
+	for _, family := range families {
+		for _, sample := range flattenFamily(family, extra) {
+			names := make([]string, 0, len(sample.labels))
+			for name := range sample.labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			var series []byte
+			for _, name := range names {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, sample.labels[name])
+
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+
+			var point []byte
+			point = protowire.AppendTag(point, 1, protowire.Fixed64Type)
+			point = protowire.AppendFixed64(point, math.Float64bits(sample.value))
+			point = protowire.AppendTag(point, 2, protowire.VarintType)
+			point = protowire.AppendVarint(point, uint64(timestamp))
+
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, point)
+
+			request = protowire.AppendTag(request, 1, protowire.BytesType)
+			request = protowire.AppendBytes(request, series)
+		}
+	}
+	return request
+}
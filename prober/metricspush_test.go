@@ -0,0 +1,152 @@
+package prober
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest parses the series of a WriteRequest into
+// "name{labels} value" strings, labels sorted as sent.
+func decodeWriteRequest(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	fields := func(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, fixed uint64)) {
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			data = data[n:]
+			switch typ {
+			case protowire.BytesType:
+				value, n := protowire.ConsumeBytes(data)
+				fn(num, typ, value, 0)
+				data = data[n:]
+			case protowire.Fixed64Type:
+				value, n := protowire.ConsumeFixed64(data)
+				fn(num, typ, nil, value)
+				data = data[n:]
+			case protowire.VarintType:
+				value, n := protowire.ConsumeVarint(data)
+				fn(num, typ, nil, value)
+				data = data[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", typ)
+			}
+		}
+	}
+
+	var series []string
+	fields(data, func(_ protowire.Number, _ protowire.Type, ts []byte, _ uint64) {
+		var name, labels string
+		var value float64
+		fields(ts, func(num protowire.Number, _ protowire.Type, msg []byte, _ uint64) {
+			if num == 1 {
+				var labelName, labelValue string
+				fields(msg, func(num protowire.Number, _ protowire.Type, str []byte, _ uint64) {
+					if num == 1 {
+						labelName = string(str)
+					} else {
+						labelValue = string(str)
+					}
+				})
+				if labelName == "__name__" {
+					name = labelValue
+				} else {
+					labels += labelName + "=" + labelValue + ","
+				}
+				return
+			}
+			fields(msg, func(num protowire.Number, _ protowire.Type, _ []byte, fixed uint64) {
+				if num == 1 {
+					value = math.Float64frombits(fixed)
+				}
+			})
+		})
+		series = append(series, name+"{"+strings.TrimSuffix(labels, ",")+"} "+formatFloat(value))
+	})
+	return series
+}
+
+func TestRemoteWrite(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "Test."}, []string{"route"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Test.", Buckets: []float64{1}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("/delay/:seconds").Add(3)
+	histogram.Observe(0.5)
+
+	received := make(chan []string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("expected snappy encoding, got %q", r.Header.Get("Content-Encoding"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("invalid snappy body: %v", err)
+		}
+		received <- decodeWriteRequest(t, data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	if err := remoteWrite(context.Background(), srv.URL, registry, map[string]string{"job": "prober"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	series := strings.Join(<-received, "\n")
+	for _, expected := range []string{
+		"test_total{job=prober,route=/delay/:seconds} 3",
+		"test_seconds_bucket{job=prober,le=1} 1",
+		"test_seconds_bucket{job=prober,le=+Inf} 1",
+		"test_seconds_sum{job=prober} 0.5",
+		"test_seconds_count{job=prober} 1",
+	} {
+		if !strings.Contains(series, expected) {
+			t.Errorf("expected series %s, got:\n%s", expected, series)
+		}
+	}
+}
+
+func TestMetricsPusherFinalPush(t *testing.T) {
+	st := newState(Options{})
+	pushes := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes <- r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	t.Setenv(metricsPushURLEnv, srv.URL)
+	t.Setenv(metricsPushIntervalEnv, "1h")
+	pusher := newMetricsPusher(st.config, st.registry)
+	if pusher == nil {
+		t.Fatal("expected pusher to be configured")
+	}
+	go pusher.run()
+	pusher.Close()
+
+	select {
+	case push := <-pushes:
+		if !strings.HasPrefix(push, "PUT /metrics/job/prober/instance/") {
+			t.Errorf("unexpected push %s", push)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected metrics to be pushed on close")
+	}
+}
+
+func TestMetricsPusherDisabled(t *testing.T) {
+	st := newState(Options{})
+	if pusher := newMetricsPusher(st.config, st.registry); pusher != nil {
+		t.Error("expected no pusher without push URLs")
+	}
+}
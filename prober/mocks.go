@@ -0,0 +1,358 @@
+package prober
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	mocksConfigEnv = "MOCKS_CONFIG"
+
+	maxMockBodyBytes = 1 << 20
+
+	mocksFaultsListener = "mocks"
+)
+
+// mockMetrics count the requests answered by the mocks.
+type mockMetrics struct {
+	mockRequestsTotal *prometheus.CounterVec
+}
+
+func newMockMetrics(registry prometheus.Registerer) mockMetrics {
+	m := mockMetrics{
+		mockRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mock_requests_total",
+			Help: "Requests answered by the mock routes by mock.",
+		}, []string{"mock"}),
+	}
+	registry.MustRegister(m.mockRequestsTotal)
+	return m
+}
+
+// mockRequest matches requests on their method, path and, when set, on
+// the exact value of headers and query parameters.
+type mockRequest struct {
+	Method  string            `yaml:"method" json:"method,omitempty"`
+	Path    string            `yaml:"path" json:"path"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Query   map[string]string `yaml:"query" json:"query,omitempty"`
+}
+
+// mockResponse is templated with text/template over mockTemplateData.
+type mockResponse struct {
+	Status  int               `yaml:"status" json:"status,omitempty"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Body    string            `yaml:"body" json:"body,omitempty"`
+	Delay   string            `yaml:"delay" json:"delay,omitempty"`
+}
+
+// mockRoute stands in for an endpoint of a real backend.
+type mockRoute struct {
+	Name     string       `yaml:"name" json:"name"`
+	Request  mockRequest  `yaml:"request" json:"request"`
+	Response mockResponse `yaml:"response" json:"response"`
+	// Faults are injected before the response, counted under the "mocks"
+	// listener.
+	Faults *faultProfile `yaml:"faults" json:"faults,omitempty"`
+	// Increment lists the counters incremented by every matching request,
+	// before the failure rule and templates read them.
+	Increment []string       `yaml:"increment" json:"increment,omitempty"`
+	FailUntil *mockFailUntil `yaml:"failUntil" json:"failUntil,omitempty"`
+
+	pattern routePattern
+	delay   time.Duration
+	headers map[string]*template.Template
+	body    *template.Template
+}
+
+// mockFailUntil answers with an error while the counter is below the
+// value, like a backend warming up.
+type mockFailUntil struct {
+	Counter string `yaml:"counter" json:"counter"`
+	Value   int64  `yaml:"value" json:"value"`
+	Status  int    `yaml:"status" json:"status,omitempty"`
+}
+
+// mockTemplateFuncs give the templates access to the counters.
+func mockTemplateFuncs(counters *counterStore) template.FuncMap {
+	return template.FuncMap{
+		"counter":   counters.get,
+		"increment": func(name string) int64 { return counters.add(name, 1) },
+	}
+}
+
+type mockTemplateData struct {
+	Method  string
+	Path    string
+	Params  map[string]string
+	Query   url.Values
+	Headers http.Header
+	Body    string
+	Now     time.Time
+}
+
+type mocksFile struct {
+	Mocks []*mockRoute `yaml:"mocks"`
+}
+
+// compile validates the mock and parses its templates, which read and
+// increment counters.
+func (m *mockRoute) compile(counters *counterStore) error {
+	if m.Name == "" {
+		return errors.New("mock without name")
+	}
+	m.Request.Method = strings.ToUpper(m.Request.Method)
+	if m.Request.Method == "" {
+		m.Request.Method = http.MethodGet
+	}
+	pattern, err := parseRoutePattern(m.Request.Path)
+	if err != nil {
+		return fmt.Errorf("mock %q: %w", m.Name, err)
+	}
+	m.pattern = pattern
+	if m.Response.Status == 0 {
+		m.Response.Status = http.StatusOK
+	}
+	if m.Response.Status < 100 || m.Response.Status > 599 {
+		return fmt.Errorf("mock %q has invalid status %d", m.Name, m.Response.Status)
+	}
+	m.delay = 0
+	if m.Response.Delay != "" {
+		m.delay, err = time.ParseDuration(m.Response.Delay)
+		if err != nil || m.delay < 0 {
+			return fmt.Errorf("mock %q has invalid delay %q", m.Name, m.Response.Delay)
+		}
+	}
+	if m.Faults != nil {
+		if err := m.Faults.validate(); err != nil {
+			return fmt.Errorf("mock %q: %w", m.Name, err)
+		}
+	}
+	if rule := m.FailUntil; rule != nil {
+		if rule.Counter == "" {
+			return fmt.Errorf("mock %q fails until no counter", m.Name)
+		}
+		if rule.Status == 0 {
+			rule.Status = http.StatusServiceUnavailable
+		}
+		if rule.Status < 100 || rule.Status > 599 {
+			return fmt.Errorf("mock %q has invalid failure status %d", m.Name, rule.Status)
+		}
+	}
+	funcs := mockTemplateFuncs(counters)
+	if m.body, err = template.New(m.Name).Funcs(funcs).Parse(m.Response.Body); err != nil {
+		return fmt.Errorf("mock %q: %w", m.Name, err)
+	}
+	m.headers = make(map[string]*template.Template, len(m.Response.Headers))
+	for name, value := range m.Response.Headers {
+		if m.headers[name], err = template.New(m.Name + " " + name).Funcs(funcs).Parse(value); err != nil {
+			return fmt.Errorf("mock %q: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *mockRoute) match(c *web.Context) (map[string]string, bool) {
+	if m.Request.Method != c.Request.Method && m.Request.Method != "ANY" {
+		return nil, false
+	}
+	for name, value := range m.Request.Headers {
+		if c.GetHeader(name) != value {
+			return nil, false
+		}
+	}
+	for name, value := range m.Request.Query {
+		if got, ok := c.GetQuery(name); !ok || got != value {
+			return nil, false
+		}
+	}
+	return m.pattern.match(c.Request.URL.Path)
+}
+
+// serveMock renders the templates before the delay, so a template error is
+// answered right away.
+func (st *state) serveMock(c *web.Context, m *mockRoute, params map[string]string) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxMockBodyBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid body"})
+		return
+	}
+	for _, name := range m.Increment {
+		st.counters.add(name, 1)
+	}
+	if rule := m.FailUntil; rule != nil && st.counters.get(rule.Counter) < rule.Value {
+		st.mockRequestsTotal.WithLabelValues(m.Name).Inc()
+		c.JSON(rule.Status, web.H{"error": "Failing until " + rule.Counter + " reaches " + strconv.FormatInt(rule.Value, 10)})
+		return
+	}
+	data := mockTemplateData{
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Params:  params,
+		Query:   c.Request.URL.Query(),
+		Headers: c.Request.Header,
+		Body:    string(body),
+		Now:     st.proberClock.Now(),
+	}
+
+	var rendered bytes.Buffer
+	if err := m.body.Execute(&rendered, data); err != nil {
+		c.JSON(http.StatusInternalServerError, web.H{"error": "Invalid mock template", "detail": err.Error()})
+		return
+	}
+	headers := make(map[string]string, len(m.headers))
+	for name, tmpl := range m.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			c.JSON(http.StatusInternalServerError, web.H{"error": "Invalid mock template", "detail": err.Error()})
+			return
+		}
+		headers[name] = value.String()
+	}
+
+	if m.delay > 0 && st.sleepRequest(c.Request.Context(), m.delay) != nil {
+		return
+	}
+	st.mockRequestsTotal.WithLabelValues(m.Name).Inc()
+	if m.Faults != nil && !st.injectFaults(c, mocksFaultsListener, *m.Faults) {
+		return
+	}
+	for name, value := range headers {
+		c.Header(name, value)
+	}
+	c.Status(m.Response.Status)
+	c.Writer.Write(rendered.Bytes())
+}
+
+// mockStore holds the mocks in the order they are matched.
+type mockStore struct {
+	mu    sync.RWMutex
+	mocks []*mockRoute
+}
+
+func loadMocksConfig(path string, counters *counterStore) ([]*mockRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file mocksFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(file.Mocks))
+	for i, mock := range file.Mocks {
+		if mock == nil {
+			return nil, fmt.Errorf("mock %d is empty", i)
+		}
+		if err := mock.compile(counters); err != nil {
+			return nil, err
+		}
+		if names[mock.Name] {
+			return nil, fmt.Errorf("duplicated mock %q", mock.Name)
+		}
+		names[mock.Name] = true
+	}
+	return file.Mocks, nil
+}
+
+func (s *mockStore) set(mocks []*mockRoute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mocks = mocks
+}
+
+// put replaces the mock of the same name in place, or adds it last.
+func (s *mockStore) put(mock *mockRoute) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.mocks {
+		if existing.Name == mock.Name {
+			s.mocks[i] = mock
+			return false
+		}
+	}
+	s.mocks = append(s.mocks, mock)
+	return true
+}
+
+func (s *mockStore) remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.mocks {
+		if existing.Name == name {
+			s.mocks = append(s.mocks[:i:i], s.mocks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mockStore) list() []*mockRoute {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*mockRoute{}, s.mocks...)
+}
+
+// serveMocks answers with the first matching mock, returning false when
+// none matches.
+func (st *state) serveMocks(c *web.Context) bool {
+	for _, mock := range st.mocks.list() {
+		if params, ok := mock.match(c); ok {
+			st.serveMock(c, mock, params)
+			return true
+		}
+	}
+	return false
+}
+
+func (st *state) listMocks(c *web.Context) {
+	c.JSON(http.StatusOK, web.H{"mocks": st.mocks.list()})
+}
+
+// putMock answers POST /mocks with a mock as YAML or JSON in the body by
+// adding it, or replacing the mock of the same name.
+func (st *state) putMock(c *web.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid mock"})
+		return
+	}
+	var mock mockRoute
+	if err := yaml.Unmarshal(data, &mock); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid mock", "detail": err.Error()})
+		return
+	}
+	if err := mock.compile(st.counters); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid mock", "detail": err.Error()})
+		return
+	}
+	st.configChangesTotal.Inc()
+	if st.mocks.put(&mock) {
+		c.JSON(http.StatusCreated, &mock)
+		return
+	}
+	c.JSON(http.StatusOK, &mock)
+}
+
+func (st *state) deleteMock(c *web.Context) {
+	if !st.mocks.remove(c.Param("name")) {
+		c.JSON(http.StatusNotFound, web.H{"error": "Unknown mock " + c.Param("name")})
+		return
+	}
+	st.configChangesTotal.Inc()
+	c.JSON(http.StatusOK, web.H{"message": "Mock deleted"})
+}
@@ -0,0 +1,108 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestMocks(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	configured, err := loadMocksConfig(writeChecksConfig(t, `
+mocks:
+  - name: order
+    request:
+      path: /orders/:id
+      headers:
+        X-Tenant: acme
+    response:
+      headers:
+        Content-Type: application/json
+        X-Order: "{{ .Params.id }}"
+      body: '{"id": "{{ .Params.id }}", "verbose": "{{ .Query.Get "verbose" }}"}'
+  - name: created
+    request:
+      method: post
+      path: /orders
+    response:
+      status: 201
+      body: "{{ .Body }}"
+      delay: 50ms
+`), st.counters)
+	if err != nil {
+		t.Fatalf("expected valid mocks, got %v", err)
+	}
+	st.mocks.set(configured)
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string, tenant string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/orders/42?verbose=yes", "", "acme")
+	if w.Code != http.StatusOK || w.Body.String() != `{"id": "42", "verbose": "yes"}` || w.Header().Get("X-Order") != "42" {
+		t.Errorf("expected the templated order, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := request(http.MethodGet, "/orders/42", "", "other"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for another tenant, got %d", w.Code)
+	}
+	start := time.Now()
+	if w := request(http.MethodPost, "/orders", "new order", ""); w.Code != http.StatusCreated || w.Body.String() != "new order" {
+		t.Errorf("expected the echoed order, got %d %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the delay, took %v", elapsed)
+	}
+
+	// Replace a mock and add another through the API
+	if w := request(http.MethodPost, "/mocks", "name: order\nrequest:\n  path: /orders/:id\nresponse:\n  status: 410\n", ""); w.Code != http.StatusOK {
+		t.Errorf("expected status 200 replacing a mock, got %d %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodPost, "/mocks", `{"name": "health", "request": {"path": "/health"}, "response": {"body": "up"}}`, ""); w.Code != http.StatusCreated {
+		t.Errorf("expected status 201 adding a mock, got %d %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/orders/42", "", ""); w.Code != http.StatusGone {
+		t.Errorf("expected the replaced mock, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/health", "", ""); w.Body.String() != "up" {
+		t.Errorf("expected the added mock, got %q", w.Body.String())
+	}
+	if w := request(http.MethodPost, "/mocks", "name: bad\nrequest:\n  path: /bad\nresponse:\n  body: '{{ .Missing'\n", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid template, got %d", w.Code)
+	}
+
+	if w := request(http.MethodDelete, "/mocks/health", "", ""); w.Code != http.StatusOK {
+		t.Errorf("expected status 200 deleting a mock, got %d", w.Code)
+	}
+	if w := request(http.MethodDelete, "/mocks/health", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown mock, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/mocks", "", ""); !strings.Contains(w.Body.String(), `"name":"order"`) || strings.Contains(w.Body.String(), "health") {
+		t.Errorf("unexpected mocks %s", w.Body.String())
+	}
+}
+
+func TestLoadMocksConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"mocks:\n  - request:\n      path: /noname\n",
+		"mocks:\n  - name: relative\n    request:\n      path: relative\n",
+		"mocks:\n  - name: status\n    request:\n      path: /status\n    response:\n      status: 700\n",
+		"mocks:\n  - name: delay\n    request:\n      path: /delay\n    response:\n      delay: soon\n",
+		"mocks:\n  - name: twice\n    request:\n      path: /a\n  - name: twice\n    request:\n      path: /b\n",
+	} {
+		if _, err := loadMocksConfig(writeChecksConfig(t, config), &counterStore{values: make(map[string]int64)}); err == nil {
+			t.Errorf("expected an error for %q", config)
+		}
+	}
+}
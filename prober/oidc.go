@@ -0,0 +1,470 @@
+package prober
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	oidcIssuerURLEnv       = "OIDC_ISSUER_URL"
+	oidcClientIDEnv        = "OIDC_CLIENT_ID"
+	oidcClientSecretEnv    = "OIDC_CLIENT_SECRET"
+	oidcRedirectURLEnv     = "OIDC_REDIRECT_URL"
+	oidcScopesEnv          = "OIDC_SCOPES"
+	oidcSessionDurationEnv = "OIDC_SESSION_DURATION"
+
+	defaultOIDCScopes          = "openid,profile,email"
+	defaultOIDCSessionDuration = time.Hour
+	oidcCallbackPath           = "/oidc/callback"
+	oidcSessionCookie          = "prober_session"
+	oidcLoginCookie            = "prober_oidc_login"
+	// oidcLoginTimeout is the time a user has to log in at the provider.
+	oidcLoginTimeout = 10 * time.Minute
+	oidcTimeout      = 10 * time.Second
+)
+
+// oidcMetrics count the OIDC logins.
+type oidcMetrics struct {
+	oidcLoginsTotal *prometheus.CounterVec
+}
+
+func newOIDCMetrics(registry prometheus.Registerer) oidcMetrics {
+	m := oidcMetrics{
+		oidcLoginsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "oidc_logins_total",
+			Help: "Logins completed on the OIDC callback, by result.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.oidcLoginsTotal)
+	return m
+}
+
+// oidcDiscovery is the part of the provider metadata the code flow needs.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcLogin is a code flow in progress, from the redirection to the
+// provider to the callback. It is kept in a signed cookie rather than in
+// memory, so the pending logins cost nothing and the callback only
+// completes the login the same browser started.
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+	ReturnTo string `json:"returnTo"`
+	Started  int64  `json:"started"`
+}
+
+type oidcSession struct {
+	Subject string         `json:"subject"`
+	Claims  map[string]any `json:"claims"`
+	Expiry  time.Time      `json:"expiry"`
+}
+
+// oidcRelyingParty protects /protected with the authorization code flow
+// and PKCE, the sessions being kept in memory behind a cookie. The provider
+// is discovered on the first login, so prober starts without it.
+type oidcRelyingParty struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	duration     time.Duration
+	client       *http.Client
+	// loginKey signs the login cookies, the logins in progress being lost
+	// on restart like the sessions.
+	loginKey []byte
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	tokens    *jwtValidator
+	sessions  map[string]oidcSession
+
+	oidcMetrics
+}
+
+// loadRelyingParty reads the OIDC_ variables, nil without an issuer.
+func loadRelyingParty(config *settings, metrics oidcMetrics) (*oidcRelyingParty, error) {
+	rp := &oidcRelyingParty{
+		issuer:       strings.TrimSuffix(config.getString(oidcIssuerURLEnv, ""), "/"),
+		clientID:     config.getString(oidcClientIDEnv, ""),
+		clientSecret: config.getString(oidcClientSecretEnv, ""),
+		redirectURL:  config.getString(oidcRedirectURLEnv, ""),
+		scopes:       splitList(config.getString(oidcScopesEnv, defaultOIDCScopes)),
+		duration:     config.getDuration(oidcSessionDurationEnv, defaultOIDCSessionDuration),
+		client:       &http.Client{Timeout: oidcTimeout},
+		loginKey:     make([]byte, 32),
+		sessions:     make(map[string]oidcSession),
+
+		oidcMetrics: metrics,
+	}
+	switch {
+	case rp.issuer == "" && rp.clientID == "":
+		return nil, nil
+	case rp.issuer == "" || rp.clientID == "":
+		return nil, fmt.Errorf("%s and %s go together", oidcIssuerURLEnv, oidcClientIDEnv)
+	case rp.duration <= 0:
+		return nil, fmt.Errorf("invalid %s %s", oidcSessionDurationEnv, rp.duration)
+	}
+	if _, err := url.ParseRequestURI(rp.issuer); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", oidcIssuerURLEnv, err)
+	}
+	if rp.redirectURL != "" {
+		if _, err := url.ParseRequestURI(rp.redirectURL); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", oidcRedirectURLEnv, err)
+		}
+	}
+	rand.Read(rp.loginKey)
+	return rp, nil
+}
+
+// discover fetches the provider metadata once, and again after a failure.
+// The fetch happens outside of the lock, the first one to complete being
+// kept, so a slow provider doesn't hold the sessions.
+func (rp *oidcRelyingParty) discover(ctx context.Context) (*oidcDiscovery, *jwtValidator, error) {
+	rp.mu.Lock()
+	known, tokens := rp.discovery, rp.tokens
+	rp.mu.Unlock()
+	if known != nil {
+		return known, tokens, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rp.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("discovery answered %s", resp.Status)
+	}
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	switch {
+	case strings.TrimSuffix(discovery.Issuer, "/") != rp.issuer:
+		return nil, nil, fmt.Errorf("discovery issuer %q is not %q", discovery.Issuer, rp.issuer)
+	case discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "":
+		return nil, nil, errors.New("discovery document misses endpoints")
+	}
+	tokens = &jwtValidator{
+		jwksURL:   discovery.JWKSURI,
+		issuer:    discovery.Issuer,
+		audiences: []string{rp.clientID},
+		leeway:    defaultJWTLeeway,
+		refresh:   defaultJWKSRefresh,
+		client:    rp.client,
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.discovery == nil {
+		rp.discovery, rp.tokens = &discovery, tokens
+	}
+	return rp.discovery, rp.tokens, nil
+}
+
+func randomToken() string {
+	data := make([]byte, 24)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// callbackURL is OIDC_REDIRECT_URL, or the callback on the host the
+// request came to. The forwarding headers are ignored since any client can
+// set them, so prober behind a proxy needs OIDC_REDIRECT_URL.
+func (rp *oidcRelyingParty) callbackURL(c *web.Context) string {
+	if rp.redirectURL != "" {
+		return rp.redirectURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + oidcCallbackPath
+}
+
+func (rp *oidcRelyingParty) loginSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, rp.loginKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// sealLogin encodes the login in the value of its cookie.
+func (rp *oidcRelyingParty) sealLogin(login oidcLogin) string {
+	data, _ := json.Marshal(login)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(rp.loginSignature(payload))
+}
+
+// openLogin decodes the value of a login cookie, checking its signature.
+func (rp *oidcRelyingParty) openLogin(value string) (oidcLogin, error) {
+	var login oidcLogin
+	payload, encoded, _ := strings.Cut(value, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal(signature, rp.loginSignature(payload)) {
+		return login, errors.New("invalid login cookie signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return login, err
+	}
+	return login, json.Unmarshal(data, &login)
+}
+
+// loginCookie carries the login to the callback only, for the time the
+// user has to log in.
+func loginCookie(value string, maxAge int, secure bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    value,
+		Path:     oidcCallbackPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (rp *oidcRelyingParty) session(c *web.Context, now time.Time) (oidcSession, bool) {
+	id, err := c.Cookie(oidcSessionCookie)
+	if err != nil {
+		return oidcSession{}, false
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	session, ok := rp.sessions[id]
+	if ok && !now.Before(session.Expiry) {
+		delete(rp.sessions, id)
+		ok = false
+	}
+	return session, ok
+}
+
+// startLogin redirects the user to the provider, the login being completed
+// on the callback.
+func (rp *oidcRelyingParty) startLogin(c *web.Context, now time.Time) {
+	discovery, _, err := rp.discover(c.Request.Context())
+	if err != nil {
+		slog.Warn("OIDC discovery failed", "issuer", rp.issuer, "error", err)
+		c.JSON(http.StatusBadGateway, web.H{"error": "OIDC discovery failed", "detail": err.Error()})
+		return
+	}
+	login := oidcLogin{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Redirect: rp.callbackURL(c),
+		ReturnTo: c.Request.URL.RequestURI(),
+		Started:  now.Unix(),
+	}
+	secure := strings.HasPrefix(login.Redirect, "https://")
+	http.SetCookie(c.Writer, loginCookie(rp.sealLogin(login), int(oidcLoginTimeout.Seconds()), secure))
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.clientID},
+		"redirect_uri":          {login.Redirect},
+		"scope":                 {strings.Join(rp.scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, discovery.AuthorizationEndpoint+separator+query.Encode())
+}
+
+// exchange trades the code for the tokens and returns the verified claims
+// of the ID token.
+func (rp *oidcRelyingParty) exchange(ctx context.Context, code string, login oidcLogin, now time.Time) (map[string]any, error) {
+	discovery, tokens, err := rp.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.Redirect},
+		"code_verifier": {login.Verifier},
+		"client_id":     {rp.clientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rp.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.clientID), url.QueryEscape(rp.clientSecret))
+	}
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid token answer: %w", err)
+	}
+	switch {
+	case answer.Error != "":
+		return nil, fmt.Errorf("token endpoint refused the code: %s %s", answer.Error, answer.ErrorDescription)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("token endpoint answered %s", resp.Status)
+	case answer.IDToken == "":
+		return nil, errors.New("no ID token in the token answer")
+	}
+	validated, err := tokens.validate(ctx, answer.IDToken, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if nonce, _ := validated.Claims["nonce"].(string); nonce != login.Nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	return validated.Claims, nil
+}
+
+// callback completes the login started by startLogin in the same browser
+// and redirects the user back to where it started with a session cookie.
+func (rp *oidcRelyingParty) callback(c *web.Context, now time.Time) {
+	value, _ := c.Cookie(oidcLoginCookie)
+	// The login is used once, whatever the outcome.
+	http.SetCookie(c.Writer, loginCookie("", -1, false))
+	if reason := c.Query("error"); reason != "" {
+		rp.oidcLoginsTotal.WithLabelValues("failure").Inc()
+		c.JSON(http.StatusUnauthorized, web.H{"error": "Login refused by the provider", "detail": strings.TrimSpace(reason + " " + c.Query("error_description"))})
+		return
+	}
+	login, err := rp.openLogin(value)
+	state := c.Query("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(login.State)) != 1 ||
+		now.Sub(time.Unix(login.Started, 0)) > oidcLoginTimeout {
+		rp.oidcLoginsTotal.WithLabelValues("failure").Inc()
+		c.JSON(http.StatusBadRequest, web.H{"error": "Unknown or expired login state"})
+		return
+	}
+
+	claims, err := rp.exchange(c.Request.Context(), c.Query("code"), login, now)
+	if err != nil {
+		rp.oidcLoginsTotal.WithLabelValues("failure").Inc()
+		slog.Warn("OIDC login failed", "error", err)
+		c.JSON(http.StatusUnauthorized, web.H{"error": "Login failed", "detail": err.Error()})
+		return
+	}
+	session := oidcSession{Claims: claims, Expiry: now.Add(rp.duration)}
+	session.Subject, _ = claims["sub"].(string)
+	id := randomToken()
+	rp.mu.Lock()
+	for key, existing := range rp.sessions {
+		if !now.Before(existing.Expiry) {
+			delete(rp.sessions, key)
+		}
+	}
+	rp.sessions[id] = session
+	rp.mu.Unlock()
+	rp.oidcLoginsTotal.WithLabelValues("success").Inc()
+
+	secure := strings.HasPrefix(login.Redirect, "https://")
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  session.Expiry,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusFound, login.ReturnTo)
+}
+
+// logout ends the session, at the provider too when it supports it.
+func (rp *oidcRelyingParty) logout(c *web.Context) {
+	if id, err := c.Cookie(oidcSessionCookie); err == nil {
+		rp.mu.Lock()
+		delete(rp.sessions, id)
+		rp.mu.Unlock()
+	}
+	http.SetCookie(c.Writer, &http.Cookie{Name: oidcSessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	rp.mu.Lock()
+	discovery := rp.discovery
+	rp.mu.Unlock()
+	if discovery != nil && discovery.EndSessionEndpoint != "" {
+		c.Redirect(http.StatusFound, discovery.EndSessionEndpoint+"?"+url.Values{"client_id": {rp.clientID}}.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, web.H{"message": "Logged out"})
+}
+
+func oidcDisabled(c *web.Context) {
+	c.JSON(http.StatusNotFound, web.H{"error": "OIDC disabled"})
+}
+
+// protectedHandler answers GET /protected with the session of the user,
+// sending the ones without to the provider.
+func (st *state) protectedHandler(c *web.Context) {
+	if st.relyingParty == nil {
+		oidcDisabled(c)
+		return
+	}
+	now := time.Now()
+	session, ok := st.relyingParty.session(c, now)
+	if !ok {
+		st.relyingParty.startLogin(c, now)
+		return
+	}
+	c.JSON(http.StatusOK, web.H{
+		"message":   "Authenticated",
+		"subject":   session.Subject,
+		"claims":    session.Claims,
+		"expiresIn": session.Expiry.Sub(now).Truncate(time.Second).String(),
+	})
+}
+
+func (st *state) oidcCallbackHandler(c *web.Context) {
+	if st.relyingParty == nil {
+		oidcDisabled(c)
+		return
+	}
+	st.relyingParty.callback(c, time.Now())
+}
+
+func (st *state) oidcLogoutHandler(c *web.Context) {
+	if st.relyingParty == nil {
+		oidcDisabled(c)
+		return
+	}
+	st.relyingParty.logout(c)
+}
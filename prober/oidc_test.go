@@ -0,0 +1,211 @@
+package prober
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// fakeProvider is an OIDC provider issuing an ID token for any code, after
+// checking the PKCE verifier.
+func fakeProvider(t *testing.T) *httptest.Server {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	challenges := make(map[string]string)
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	// The tests send the user to /authorize themselves, the code being the
+	// nonce so the token endpoint can put it back.
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		challenges[r.FormValue("nonce")] = r.FormValue("code_challenge")
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		nonce := r.FormValue("code")
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if user, password, _ := r.BasicAuth(); user != "prober" || password != "secret" || challenges[nonce] != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{"iss": issuer, "aud": "prober", "sub": "alice", "nonce": nonce, "exp": time.Now().Add(time.Hour).Unix()}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signTestJWT(t, map[string]any{"alg": "RS256", "kid": "k1"}, claims, key)})
+	})
+	server := httptest.NewServer(mux)
+	issuer = server.URL
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCLogin(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	provider := fakeProvider(t)
+	t.Setenv(oidcIssuerURLEnv, provider.URL+"/")
+	t.Setenv(oidcClientIDEnv, "prober")
+	t.Setenv(oidcClientSecretEnv, "secret")
+	rp, err := loadRelyingParty(st.config, st.oidcMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.relyingParty = rp
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	serve := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Host = "prober.example.com"
+		req.Header.Set("X-Forwarded-Host", "evil.example.com")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	cookieNamed := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		t.Fatalf("expected a %s cookie, got %v", name, w.Result().Cookies())
+		return nil
+	}
+
+	w := serve("/protected?from=test")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || !strings.HasPrefix(location.String(), provider.URL+"/authorize?") {
+		t.Fatalf("expected a redirection to the provider, got %d %s", w.Code, location)
+	}
+	query := location.Query()
+	if query.Get("redirect_uri") != "http://prober.example.com/oidc/callback" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("expected the callback on the request host and PKCE in the redirection, got %s", query)
+	}
+	login := cookieNamed(w, oidcLoginCookie)
+	if !login.HttpOnly || login.Path != oidcCallbackPath || login.MaxAge <= 0 {
+		t.Errorf("expected an HttpOnly login cookie for the callback, got %v", login)
+	}
+	if resp, err := http.Get(location.String()); err == nil {
+		resp.Body.Close()
+	}
+
+	callback := "/oidc/callback?state=" + query.Get("state") + "&code=" + query.Get("nonce")
+	if w := serve("/oidc/callback?state=forged&code="+query.Get("nonce"), login); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown state to be refused, got %d", w.Code)
+	}
+	if w := serve(callback); w.Code != http.StatusBadRequest {
+		t.Errorf("expected the callback of another browser to be refused, got %d", w.Code)
+	}
+	tampered := *login
+	tampered.Value = strings.Replace(login.Value, ".", "x.", 1)
+	if w := serve(callback, &tampered); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a tampered login cookie to be refused, got %d", w.Code)
+	}
+	w = serve(callback, login)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/protected?from=test" {
+		t.Fatalf("expected a redirection back, got %d %s", w.Code, w.Body.String())
+	}
+	cookie := cookieNamed(w, oidcSessionCookie)
+	if !cookie.HttpOnly {
+		t.Errorf("expected an HttpOnly session cookie, got %v", cookie)
+	}
+	if cleared := cookieNamed(w, oidcLoginCookie); cleared.MaxAge >= 0 {
+		t.Errorf("expected the login cookie to be cleared, got %v", cleared)
+	}
+
+	w = serve("/protected", cookie)
+	var session struct {
+		Subject string         `json:"subject"`
+		Claims  map[string]any `json:"claims"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w.Code != http.StatusOK || session.Subject != "alice" || session.Claims["aud"] != "prober" {
+		t.Errorf("expected the session of alice, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve("/oidc/logout", cookie); w.Code != http.StatusOK {
+		t.Errorf("expected the logout to succeed, got %d", w.Code)
+	}
+	if w := serve("/protected", cookie); w.Code != http.StatusFound {
+		t.Errorf("expected the session to end with the logout, got %d", w.Code)
+	}
+}
+
+func TestOIDCLoginFailures(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	provider := fakeProvider(t)
+	rp := &oidcRelyingParty{
+		issuer:   provider.URL,
+		clientID: "prober",
+		duration: time.Hour,
+		client:   http.DefaultClient,
+		loginKey: []byte("key"),
+		sessions: make(map[string]oidcSession),
+
+		oidcMetrics: st.oidcMetrics,
+	}
+	st.relyingParty = rp
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	login := func(started time.Time) string {
+		return rp.sealLogin(oidcLogin{State: "state", Nonce: "n", Verifier: "v", ReturnTo: "/protected", Started: started.Unix()})
+	}
+	for _, test := range []struct {
+		path   string
+		login  string
+		status int
+	}{
+		{"/oidc/callback?error=access_denied", login(time.Now()), http.StatusUnauthorized},
+		{"/oidc/callback?state=state&code=n", login(time.Now()), http.StatusUnauthorized}, // no client secret
+		{"/oidc/callback?state=state&code=n", login(time.Now().Add(-time.Hour)), http.StatusBadRequest},
+		{"/oidc/callback?state=&code=n", rp.sealLogin(oidcLogin{Started: time.Now().Unix()}), http.StatusBadRequest},
+	} {
+		path, status := test.path, test.status
+		req, _ := http.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: oidcLoginCookie, Value: test.login})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d %s", path, status, w.Code, w.Body.String())
+		}
+	}
+
+	for name, env := range map[string]map[string]string{
+		"no client":        {oidcIssuerURLEnv: provider.URL},
+		"invalid issuer":   {oidcIssuerURLEnv: "idp", oidcClientIDEnv: "prober"},
+		"invalid duration": {oidcIssuerURLEnv: provider.URL, oidcClientIDEnv: "prober", oidcSessionDurationEnv: "0s"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			if _, err := loadRelyingParty(st.config, st.oidcMetrics); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
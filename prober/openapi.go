@@ -0,0 +1,313 @@
+package prober
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	openAPISpecEnv      = "OPENAPI_SPEC"
+	openAPILatencyEnv   = "OPENAPI_LATENCY"
+	openAPIErrorRateEnv = "OPENAPI_ERROR_RATE"
+
+	// maxSchemaDepth stops the examples generated from recursive schemas.
+	maxSchemaDepth = 8
+)
+
+// openAPISpec is the part of an OpenAPI 3 document needed to stub its
+// operations.
+type openAPISpec struct {
+	OpenAPI    string                          `yaml:"openapi"`
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas   map[string]*openAPISchema   `yaml:"schemas"`
+		Responses map[string]*openAPIResponse `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `yaml:"operationId"`
+	Responses   map[string]*openAPIResponse `yaml:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string                       `yaml:"$ref"`
+	Content map[string]*openAPIMediaType `yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Example  any                       `yaml:"example"`
+	Examples map[string]openAPIExample `yaml:"examples"`
+	Schema   *openAPISchema            `yaml:"schema"`
+}
+
+type openAPIExample struct {
+	Value any `yaml:"value"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `yaml:"$ref"`
+	Type       string                    `yaml:"type"`
+	Format     string                    `yaml:"format"`
+	Properties map[string]*openAPISchema `yaml:"properties"`
+	Items      *openAPISchema            `yaml:"items"`
+	Example    any                       `yaml:"example"`
+	Default    any                       `yaml:"default"`
+	Enum       []any                     `yaml:"enum"`
+	AllOf      []*openAPISchema          `yaml:"allOf"`
+	OneOf      []*openAPISchema          `yaml:"oneOf"`
+	AnyOf      []*openAPISchema          `yaml:"anyOf"`
+}
+
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// loadOpenAPIStubs returns a mock for every operation of the spec,
+// answering with the example of its first success response, or one
+// generated from the schema, after the given faults, their templates using
+// counters.
+func loadOpenAPIStubs(path string, faults faultProfile, counters *counterStore) ([]*mockRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec openAPISpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, errors.New("only OpenAPI 3 specs are supported")
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var stubs []*mockRoute
+	for _, path := range paths {
+		methods := make([]string, 0, len(spec.Paths[path]))
+		for method := range spec.Paths[path] {
+			if openAPIMethods[method] {
+				methods = append(methods, method)
+			}
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			node := spec.Paths[path][method]
+			var operation openAPIOperation
+			if err := node.Decode(&operation); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			stub, err := spec.stub(strings.ToUpper(method), path, operation)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			if faults.enabled() {
+				stub.Faults = &faults
+			}
+			if err := stub.compile(counters); err != nil {
+				return nil, err
+			}
+			stubs = append(stubs, stub)
+		}
+	}
+	return stubs, nil
+}
+
+func (s *openAPISpec) stub(method string, path string, operation openAPIOperation) (*mockRoute, error) {
+	name := operation.OperationID
+	if name == "" {
+		name = method + " " + path
+	}
+	route, err := openAPIPath(path)
+	if err != nil {
+		return nil, err
+	}
+	stub := &mockRoute{
+		Name:     name,
+		Request:  mockRequest{Method: method, Path: route},
+		Response: mockResponse{Status: http.StatusOK},
+	}
+
+	code, response := s.successResponse(operation.Responses)
+	if code != "" && code != "default" {
+		status, err := strconv.Atoi(strings.Replace(code, "XX", "00", 1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid response code %q", code)
+		}
+		stub.Response.Status = status
+	}
+	if response == nil || len(response.Content) == 0 {
+		if stub.Response.Status == http.StatusOK {
+			stub.Response.Status = http.StatusNoContent
+		}
+		return stub, nil
+	}
+
+	contentType := "application/json"
+	media := response.Content[contentType]
+	if media == nil {
+		types := make([]string, 0, len(response.Content))
+		for name := range response.Content {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		contentType, media = types[0], response.Content[types[0]]
+	}
+	stub.Response.Headers = map[string]string{"Content-Type": contentType}
+	if media == nil {
+		return stub, nil
+	}
+
+	example := media.Example
+	if example == nil && len(media.Examples) > 0 {
+		names := make([]string, 0, len(media.Examples))
+		for name := range media.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		example = media.Examples[names[0]].Value
+	}
+	if example == nil {
+		example = s.example(media.Schema, 0)
+	}
+	if text, ok := example.(string); ok && !strings.Contains(contentType, "json") {
+		stub.Response.Body = templateLiteral(text)
+		return stub, nil
+	}
+	body, err := json.Marshal(example)
+	if err != nil {
+		return nil, err
+	}
+	stub.Response.Body = templateLiteral(string(body))
+	return stub, nil
+}
+
+// successResponse picks the lowest 2xx response, then the default one, then
+// the lowest of any other code.
+func (s *openAPISpec) successResponse(responses map[string]*openAPIResponse) (string, *openAPIResponse) {
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	chosen := ""
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			chosen = code
+			break
+		}
+	}
+	if chosen == "" && responses["default"] != nil {
+		chosen = "default"
+	}
+	if chosen == "" && len(codes) > 0 {
+		chosen = codes[0]
+	}
+	if chosen == "" {
+		return "", nil
+	}
+	response := responses[chosen]
+	if response != nil && response.Ref != "" {
+		response = s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return chosen, response
+}
+
+// example generates a value matching the schema, preferring the examples,
+// defaults and enums it declares.
+func (s *openAPISpec) example(schema *openAPISchema, depth int) any {
+	if schema == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	if schema.Ref != "" {
+		return s.example(s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], depth+1)
+	}
+	switch {
+	case schema.Example != nil:
+		return schema.Example
+	case schema.Default != nil:
+		return schema.Default
+	case len(schema.Enum) > 0:
+		return schema.Enum[0]
+	case len(schema.AllOf) > 0:
+		merged := map[string]any{}
+		for _, part := range schema.AllOf {
+			if object, ok := s.example(part, depth+1).(map[string]any); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	case len(schema.OneOf) > 0:
+		return s.example(schema.OneOf[0], depth+1)
+	case len(schema.AnyOf) > 0:
+		return s.example(schema.AnyOf[0], depth+1)
+	}
+
+	switch schema.Type {
+	case "array":
+		if item := s.example(schema.Items, depth+1); item != nil {
+			return []any{item}
+		}
+		return []any{}
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			return "2024-01-01T00:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "uuid":
+			return "00000000-0000-0000-0000-000000000000"
+		case "email":
+			return "user@example.com"
+		}
+		return "string"
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return true
+	case "object", "":
+		object := map[string]any{}
+		for name, property := range schema.Properties {
+			object[name] = s.example(property, depth+1)
+		}
+		return object
+	}
+	return nil
+}
+
+// openAPIPath turns the {param} segments of an OpenAPI path into the
+// :param ones of the mock routes, which cannot match parts of segments.
+func openAPIPath(path string) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		} else if strings.ContainsAny(segment, "{}") {
+			return "", fmt.Errorf("unsupported path parameter in %q", segment)
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// templateLiteral escapes text so the mock templates render it as is.
+func templateLiteral(text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	return "{{" + strconv.Quote(text) + "}}"
+}
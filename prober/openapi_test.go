@@ -0,0 +1,143 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const testOpenAPISpec = `
+openapi: 3.0.3
+info:
+  title: Orders
+  version: "1"
+paths:
+  /orders:
+    get:
+      operationId: listOrders
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/Order"
+    post:
+      responses:
+        "400":
+          $ref: "#/components/responses/Invalid"
+        "201":
+          content:
+            application/json:
+              example: {id: "new", template: "{{ not a template }}"}
+  /orders/{id}:
+    parameters:
+      - name: id
+        in: path
+    delete:
+      responses:
+        "204":
+          description: Deleted
+  /release:
+    get:
+      responses:
+        default:
+          content:
+            text/plain:
+              examples:
+                current:
+                  value: v1.2.3
+components:
+  responses:
+    Invalid:
+      content:
+        application/json:
+          schema:
+            type: object
+  schemas:
+    Order:
+      allOf:
+        - type: object
+          properties:
+            id: {type: string, format: uuid}
+            status: {type: string, enum: [pending, shipped]}
+        - properties:
+            total: {type: number}
+            createdAt: {type: string, format: date-time}
+            parent: {$ref: "#/components/schemas/Order"}
+`
+
+func TestOpenAPIStubs(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	stubs, err := loadOpenAPIStubs(writeChecksConfig(t, testOpenAPISpec), faultProfile{}, st.counters)
+	if err != nil {
+		t.Fatalf("expected a valid spec, got %v", err)
+	}
+	if len(stubs) != 4 || stubs[0].Name != "listOrders" || stubs[1].Name != "POST /orders" {
+		t.Fatalf("unexpected stubs %+v", stubs)
+	}
+	st.mocks.set(stubs)
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := request(http.MethodGet, "/orders")
+	var orders []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &orders); err != nil || len(orders) != 1 {
+		t.Fatalf("expected a generated list of orders, got %d %s", w.Code, w.Body.String())
+	}
+	order := orders[0]
+	if order["id"] != "00000000-0000-0000-0000-000000000000" || order["status"] != "pending" || order["total"] != 0.0 || order["createdAt"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("unexpected generated order %v", order)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the JSON content type, got %q", w.Header().Get("Content-Type"))
+	}
+
+	if w := request(http.MethodPost, "/orders"); w.Code != http.StatusCreated || w.Body.String() != `{"id":"new","template":"{{ not a template }}"}` {
+		t.Errorf("expected the literal example, got %d %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/orders/42"); w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 without content, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/release"); w.Code != http.StatusOK || w.Body.String() != "v1.2.3" {
+		t.Errorf("expected the named example, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAPIStubFaults(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+
+	stubs, err := loadOpenAPIStubs(writeChecksConfig(t, testOpenAPISpec), faultProfile{ErrorRate: 1}, st.counters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.mocks.set(stubs)
+	w := httptest.NewRecorder()
+	st.newRouter(nil, listenerConfig{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/release", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the injected error, got %d", w.Code)
+	}
+}
+
+func TestLoadOpenAPIStubsErrors(t *testing.T) {
+	for _, spec := range []string{
+		"swagger: \"2.0\"\npaths: {}\n",
+		"openapi: 3.0.0\npaths:\n  /odd/{id}.json:\n    get:\n      responses: {}\n",
+		"openapi: 3.0.0\npaths:\n  /odd:\n    get:\n      responses:\n        \"2xx\": {}\n",
+	} {
+		if _, err := loadOpenAPIStubs(writeChecksConfig(t, spec), faultProfile{}, &counterStore{values: make(map[string]int64)}); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
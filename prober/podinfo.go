@@ -0,0 +1,108 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	podNameEnv           = "POD_NAME"
+	podNamespaceEnv      = "POD_NAMESPACE"
+	nodeNameEnv          = "NODE_NAME"
+	podServiceAccountEnv = "POD_SERVICE_ACCOUNT"
+	podInfoDirEnv        = "PODINFO_DIR"
+
+	defaultPodInfoDir = "/etc/podinfo"
+)
+
+type podInfo struct {
+	Name           string            `json:"name"`
+	Namespace      string            `json:"namespace"`
+	Node           string            `json:"node,omitempty"`
+	PodIP          string            `json:"podIP,omitempty"`
+	HostIP         string            `json:"hostIP,omitempty"`
+	ServiceAccount string            `json:"serviceAccount,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Source         string            `json:"source"`
+}
+
+// readDownwardFile parses the key="value" lines of the labels and
+// annotations files of a downwardAPI volume.
+func readDownwardFile(path string) (map[string]string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		values[key] = value
+	}
+	return values, true
+}
+
+// loadPodInfo reads the Downward API environment and volume, then fills
+// what they miss from the pod object when the API is reachable.
+func loadPodInfo(ctx context.Context, config *settings, client *kubeClient) podInfo {
+	info := podInfo{
+		Name:           config.get(podNameEnv),
+		Namespace:      config.get(podNamespaceEnv),
+		Node:           config.get(nodeNameEnv),
+		PodIP:          config.get(podIPEnv),
+		ServiceAccount: config.get(podServiceAccountEnv),
+		Source:         "downward",
+	}
+	if info.Name == "" {
+		info.Name, _ = os.Hostname()
+	}
+	if info.Namespace == "" && client != nil {
+		info.Namespace = client.namespace
+	}
+	dir := config.getString(podInfoDirEnv, defaultPodInfoDir)
+	labels, hasLabels := readDownwardFile(filepath.Join(dir, "labels"))
+	annotations, hasAnnotations := readDownwardFile(filepath.Join(dir, "annotations"))
+	info.Labels, info.Annotations = labels, annotations
+
+	complete := hasLabels && hasAnnotations && info.Node != "" && info.PodIP != "" && info.ServiceAccount != ""
+	if complete || client == nil {
+		return info
+	}
+
+	pod, err := client.getPod(ctx, info.Namespace, info.Name)
+	if err != nil {
+		slog.Warn("Failed to get pod from the Kubernetes API", "error", err)
+		return info
+	}
+	info.Source = "api"
+	info.Node = pod.Spec.NodeName
+	info.PodIP = pod.Status.PodIP
+	info.HostIP = pod.Status.HostIP
+	info.ServiceAccount = pod.Spec.ServiceAccountName
+	info.Labels = pod.Metadata.Labels
+	info.Annotations = pod.Metadata.Annotations
+	return info
+}
+
+// podInfoHandler answers GET /podinfo with the replica and node serving the
+// request.
+func (st *state) podInfoHandler(c *web.Context) {
+	c.JSON(http.StatusOK, loadPodInfo(c.Request.Context(), st.config, st.kube))
+}
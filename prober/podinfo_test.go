@@ -0,0 +1,74 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestPodInfoDownward(t *testing.T) {
+	st := newState(Options{})
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "labels"), []byte("app=\"prober\"\npod-template-hash=\"7c9d\""), 0o600)
+	os.WriteFile(filepath.Join(dir, "annotations"), []byte("note=\"multi\\nline\""), 0o600)
+	t.Setenv(podInfoDirEnv, dir)
+	t.Setenv(podNameEnv, "prober-7c9d-abcde")
+	t.Setenv(podNamespaceEnv, "probes")
+	t.Setenv(nodeNameEnv, "node-a")
+	t.Setenv(podIPEnv, "10.0.1.12")
+	t.Setenv(podServiceAccountEnv, "prober")
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/podinfo", st.podInfoHandler)
+
+	req, _ := http.NewRequest("GET", "/podinfo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var info podInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if info.Name != "prober-7c9d-abcde" || info.Namespace != "probes" || info.Node != "node-a" || info.Source != "downward" {
+		t.Errorf("unexpected pod info: %+v", info)
+	}
+	if info.Labels["app"] != "prober" || info.Annotations["note"] != "multi\nline" {
+		t.Errorf("expected labels and annotations from the volume, got %v %v", info.Labels, info.Annotations)
+	}
+}
+
+func TestPodInfoAPI(t *testing.T) {
+	t.Setenv(podInfoDirEnv, t.TempDir())
+	t.Setenv(podNameEnv, "prober-0")
+	t.Setenv(podNamespaceEnv, "")
+	t.Setenv(nodeNameEnv, "")
+	t.Setenv(podIPEnv, "")
+	t.Setenv(podServiceAccountEnv, "")
+
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/prober-0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{
+			"metadata": {"name": "prober-0", "labels": {"app": "prober"}, "annotations": {"owner": "sre"}},
+			"spec": {"nodeName": "node-b", "serviceAccountName": "prober"},
+			"status": {"podIP": "10.0.2.7", "hostIP": "192.168.0.4"}
+		}`))
+	}))
+
+	info := loadPodInfo(context.Background(), envSettings, client)
+	if info.Source != "api" || info.Namespace != "default" || info.Node != "node-b" || info.PodIP != "10.0.2.7" || info.HostIP != "192.168.0.4" {
+		t.Errorf("unexpected pod info: %+v", info)
+	}
+	if info.ServiceAccount != "prober" || info.Labels["app"] != "prober" || info.Annotations["owner"] != "sre" {
+		t.Errorf("expected metadata from the API, got %+v", info)
+	}
+}
@@ -0,0 +1,362 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	proberConfigControllerEnv = "PROBER_CONFIG_CONTROLLER"
+	proberConfigNamespaceEnv  = "PROBER_CONFIG_NAMESPACE"
+
+	proberConfigAPI   = "/apis/prober.hpettenuci.io/v1alpha1"
+	proberConfigRetry = 5 * time.Second
+)
+
+type labelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// matches tells whether the labels carry every matchLabels pair. An empty
+// selector matches every pod.
+func (s labelSelector) matches(labels map[string]string) bool {
+	for key, value := range s.MatchLabels {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+type proberConfigFaults struct {
+	Latency     string  `json:"latency,omitempty"`
+	ErrorRate   float64 `json:"errorRate,omitempty"`
+	ErrorStatus int     `json:"errorStatus,omitempty"`
+	ResetRate   float64 `json:"resetRate,omitempty"`
+}
+
+func (f proberConfigFaults) profile() (faultProfile, error) {
+	profile := faultProfile{ErrorRate: f.ErrorRate, ErrorStatus: f.ErrorStatus, ResetRate: f.ResetRate}
+	if f.Latency != "" {
+		latency, err := time.ParseDuration(f.Latency)
+		if err != nil || latency < 0 {
+			return profile, fmt.Errorf("invalid latency %q", f.Latency)
+		}
+		profile.Latency = latency
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.ResetRate < 0 || f.ResetRate > 1 {
+		return profile, errors.New("rates must be between 0 and 1")
+	}
+	if f.ErrorStatus != 0 && (f.ErrorStatus < 100 || f.ErrorStatus > 599) {
+		return profile, fmt.Errorf("invalid error status %d", f.ErrorStatus)
+	}
+	return profile, nil
+}
+
+// proberConfigSpec is the runtime configuration a ProberConfig applies to
+// the pods its selector matches. Probe delays are in seconds like the ones
+// posted to /config, and omitted fields keep their startup value.
+type proberConfigSpec struct {
+	Selector labelSelector       `json:"selector"`
+	Probes   *configs            `json:"probes,omitempty"`
+	Logging  *loggingConfig      `json:"logging,omitempty"`
+	Faults   *proberConfigFaults `json:"faults,omitempty"`
+}
+
+type proberConfig struct {
+	Metadata kubeObjectMeta   `json:"metadata"`
+	Spec     proberConfigSpec `json:"spec"`
+}
+
+type proberConfigList struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Items    []proberConfig `json:"items"`
+}
+
+// runtimeSettings are the settings a ProberConfig changes, saved at startup
+// to revert to them once no ProberConfig matches anymore.
+type runtimeSettings struct {
+	st      *state
+	probes  map[string]*string
+	logging loggingConfig
+}
+
+func (st *state) saveRuntimeSettings() runtimeSettings {
+	settings := runtimeSettings{st: st, probes: make(map[string]*string)}
+	for _, env := range []string{startupProbeDelayEnv, readinessProbeDelayEnv, livenessProbeDelayEnv} {
+		if value, ok := st.config.lookup(env); ok {
+			settings.probes[env] = &value
+		} else {
+			settings.probes[env] = nil
+		}
+	}
+	level := logConfig.level.Level().String()
+	sampleRate := logConfig.sampleRate.Load()
+	rateLimit := logConfig.rateLimit.Load()
+	settings.logging = loggingConfig{Level: &level, SampleRate: &sampleRate, RateLimit: &rateLimit}
+	return settings
+}
+
+func (s runtimeSettings) restore() {
+	for env := range s.probes {
+		s.restoreProbe(env)
+	}
+	s.logging.apply()
+	s.st.setRuntimeFaults(nil)
+}
+
+func (s runtimeSettings) restoreProbe(env string) {
+	if value := s.probes[env]; value != nil {
+		s.st.config.set(env, *value)
+	} else {
+		s.st.config.unset(env)
+	}
+}
+
+// configController watches the ProberConfig resources of its namespace and
+// applies the spec of the one selecting this pod, so the delays, logging
+// and faults of a fleet of probers change with kubectl apply.
+type configController struct {
+	st        *state
+	client    *kubeClient
+	namespace string
+	labels    map[string]string
+	defaults  runtimeSettings
+
+	mu      sync.Mutex
+	configs map[string]proberConfig
+	applied *proberConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type configControllerStatus struct {
+	Namespace       string            `json:"namespace"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Applied         string            `json:"applied,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Spec            *proberConfigSpec `json:"spec,omitempty"`
+}
+
+// loadConfigController returns nil when PROBER_CONFIG_CONTROLLER is unset.
+func (st *state) loadConfigController(client *kubeClient) (*configController, error) {
+	if !st.config.getBool(proberConfigControllerEnv, false) {
+		return nil, nil
+	}
+	if client == nil {
+		return nil, errors.New("the ProberConfig controller needs to run in a cluster")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeTimeout)
+	defer cancel()
+	info := loadPodInfo(ctx, st.config, client)
+	return st.newConfigController(client, st.config.getString(proberConfigNamespaceEnv, client.namespace), info.Labels), nil
+}
+
+func (st *state) newConfigController(client *kubeClient, namespace string, labels map[string]string) *configController {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &configController{
+		st:        st,
+		client:    client,
+		namespace: namespace,
+		labels:    labels,
+		defaults:  st.saveRuntimeSettings(),
+		configs:   make(map[string]proberConfig),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+func (p *configController) path() string {
+	return fmt.Sprintf("%s/namespaces/%s/proberconfigs", proberConfigAPI, p.namespace)
+}
+
+func (p *configController) run() {
+	defer close(p.done)
+	ctx := p.ctx
+
+	for {
+		err := p.sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil || isKubeStatus(err, http.StatusGone) {
+			continue
+		}
+		slog.Warn("Failed to watch ProberConfig resources", "namespace", p.namespace, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(proberConfigRetry):
+		}
+	}
+}
+
+// sync lists the resources then watches them until the watch ends.
+func (p *configController) sync(ctx context.Context) error {
+	listCtx, cancel := context.WithTimeout(ctx, kubeTimeout)
+	var list proberConfigList
+	err := p.client.get(listCtx, p.path(), &list)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.configs = make(map[string]proberConfig, len(list.Items))
+	for _, config := range list.Items {
+		p.configs[config.Metadata.Name] = config
+	}
+	p.mu.Unlock()
+	p.reconcile()
+
+	version := list.Metadata.ResourceVersion
+	return p.client.watch(ctx, p.path(), version, func(event kubeWatchEvent) error {
+		var config proberConfig
+		if err := json.Unmarshal(event.Object, &config); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			p.configs[config.Metadata.Name] = config
+		case "DELETED":
+			delete(p.configs, config.Metadata.Name)
+		default:
+			p.mu.Unlock()
+			return nil
+		}
+		p.mu.Unlock()
+		p.reconcile()
+		return nil
+	})
+}
+
+// reconcile applies the first matching ProberConfig by name, or reverts to
+// the startup settings when none matches.
+func (p *configController) reconcile() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var matching []string
+	for name, config := range p.configs {
+		if config.Spec.Selector.matches(p.labels) {
+			matching = append(matching, name)
+		}
+	}
+	sort.Strings(matching)
+
+	if len(matching) == 0 {
+		if p.applied != nil {
+			slog.Info("No ProberConfig selects this pod anymore, reverting to the startup settings", "previous", p.applied.Metadata.Name)
+			p.defaults.restore()
+			p.applied = nil
+			p.st.configChangesTotal.Inc()
+		}
+		return
+	}
+	if len(matching) > 1 {
+		slog.Warn("Several ProberConfig resources select this pod, applying the first one", "applied", matching[0], "ignored", matching[1:])
+	}
+
+	config := p.configs[matching[0]]
+	if p.applied != nil && p.applied.Metadata.Name == config.Metadata.Name && p.applied.Metadata.ResourceVersion == config.Metadata.ResourceVersion {
+		return
+	}
+	if err := p.apply(config.Spec); err != nil {
+		slog.Warn("Invalid ProberConfig, keeping the current settings", "name", config.Metadata.Name, "error", err)
+		return
+	}
+	slog.Info("Applied ProberConfig", "name", config.Metadata.Name, "resourceVersion", config.Metadata.ResourceVersion)
+	p.applied = &config
+	p.st.configChangesTotal.Inc()
+}
+
+// apply validates the whole spec before changing anything, the fields it
+// omits getting their startup value back.
+func (p *configController) apply(spec proberConfigSpec) error {
+	var probes configs
+	if spec.Probes != nil {
+		probes = *spec.Probes
+	}
+	for _, delay := range []string{probes.Startup, probes.Readiness, probes.Liveness} {
+		if seconds, err := strconv.ParseInt(delay, 10, 64); delay != "" && (err != nil || seconds < 0) {
+			return fmt.Errorf("invalid probe delay %q", delay)
+		}
+	}
+	var faults *faultProfile
+	if spec.Faults != nil {
+		profile, err := spec.Faults.profile()
+		if err != nil {
+			return err
+		}
+		faults = &profile
+	}
+	logging := p.defaults.logging
+	if spec.Logging != nil {
+		if spec.Logging.Level != nil {
+			logging.Level = spec.Logging.Level
+		}
+		if spec.Logging.SampleRate != nil {
+			logging.SampleRate = spec.Logging.SampleRate
+		}
+		if spec.Logging.RateLimit != nil {
+			logging.RateLimit = spec.Logging.RateLimit
+		}
+	}
+	// The logging settings are applied last since they are validated
+	// while applied.
+	if err := logging.apply(); err != nil {
+		return err
+	}
+
+	for env, delay := range map[string]string{startupProbeDelayEnv: probes.Startup, readinessProbeDelayEnv: probes.Readiness, livenessProbeDelayEnv: probes.Liveness} {
+		if delay == "" {
+			p.defaults.restoreProbe(env)
+		} else {
+			p.st.config.set(env, delay)
+		}
+	}
+	p.st.setRuntimeFaults(faults)
+	return nil
+}
+
+func (p *configController) status() configControllerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := configControllerStatus{Namespace: p.namespace, Labels: p.labels}
+	if p.applied != nil {
+		status.Applied = p.applied.Metadata.Name
+		status.ResourceVersion = p.applied.Metadata.ResourceVersion
+		status.Spec = &p.applied.Spec
+	}
+	return status
+}
+
+func (p *configController) Close() {
+	p.cancel()
+	<-p.done
+}
+
+// proberConfigHandler answers GET /proberconfig with the ProberConfig
+// applied to this pod.
+func (st *state) proberConfigHandler(c *web.Context) {
+	if st.proberConfigs == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "ProberConfig controller is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, st.proberConfigs.status())
+}
@@ -0,0 +1,120 @@
+package prober
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func testProberConfig(name string, version string, spec string) proberConfig {
+	var config proberConfig
+	config.Metadata = kubeObjectMeta{Name: name, ResourceVersion: version}
+	if err := json.Unmarshal([]byte(spec), &config.Spec); err != nil {
+		panic(err)
+	}
+	return config
+}
+
+func TestConfigControllerReconcile(t *testing.T) {
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	p := st.newConfigController(nil, "default", map[string]string{"app": "prober", "track": "canary"})
+
+	p.configs["b-stable"] = testProberConfig("b-stable", "1", `{"selector":{"matchLabels":{"track":"stable"}},"probes":{"readiness":"9"}}`)
+	p.configs["c-canary"] = testProberConfig("c-canary", "1", `{"selector":{"matchLabels":{"track":"canary"}},"probes":{"readiness":"5"}}`)
+	p.configs["a-canary"] = testProberConfig("a-canary", "1", `{"selector":{"matchLabels":{"app":"prober","track":"canary"}},"probes":{"readiness":"3"},"faults":{"latency":"100ms","errorRate":0.5}}`)
+	p.reconcile()
+
+	if status := p.status(); status.Applied != "a-canary" {
+		t.Errorf("expected a-canary to be applied, got %+v", status)
+	}
+	if got := os.Getenv(readinessProbeDelayEnv); got != "3" {
+		t.Errorf("expected readiness delay 3, got %q", got)
+	}
+	if profile := st.runtimeFaults.Load(); profile == nil || profile.Latency != 100*time.Millisecond || profile.ErrorRate != 0.5 {
+		t.Errorf("unexpected runtime faults: %+v", profile)
+	}
+
+	p.configs["a-canary"] = testProberConfig("a-canary", "2", `{"selector":{"matchLabels":{"track":"canary"}},"probes":{"readiness":"-1"}}`)
+	p.reconcile()
+	if got := os.Getenv(readinessProbeDelayEnv); got != "3" {
+		t.Errorf("expected the invalid spec to be ignored, got readiness delay %q", got)
+	}
+
+	delete(p.configs, "a-canary")
+	p.reconcile()
+	if got := os.Getenv(readinessProbeDelayEnv); got != "5" {
+		t.Errorf("expected readiness delay 5 from c-canary, got %q", got)
+	}
+	if profile := st.runtimeFaults.Load(); profile != nil {
+		t.Errorf("expected the faults of a-canary to be removed, got %+v", profile)
+	}
+
+	delete(p.configs, "c-canary")
+	p.reconcile()
+	if status := p.status(); status.Applied != "" {
+		t.Errorf("expected no applied ProberConfig, got %+v", status)
+	}
+	if got := os.Getenv(readinessProbeDelayEnv); got != "0" {
+		t.Errorf("expected the startup readiness delay, got %q", got)
+	}
+}
+
+func TestConfigControllerWatch(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	st := newState(Options{})
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/prober.hpettenuci.io/v1alpha1/namespaces/default/proberconfigs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("watch") != "true" {
+			w.Write([]byte(`{"metadata":{"resourceVersion":"10"},"items":[{"metadata":{"name":"slow","resourceVersion":"10"},"spec":{"probes":{"liveness":"2"}}}]}`))
+			return
+		}
+		if r.URL.Query().Get("resourceVersion") != "10" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"type":"MODIFIED","object":{"metadata":{"name":"slow","resourceVersion":"11"},"spec":{"probes":{"liveness":"4"}}}}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+
+	st.proberConfigs = st.newConfigController(client, "default", nil)
+	go st.proberConfigs.run()
+	defer func() {
+		st.proberConfigs.Close()
+		st.proberConfigs = nil
+	}()
+
+	for deadline := time.Now().Add(2 * time.Second); st.proberConfigs.status().ResourceVersion != "11" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := os.Getenv(livenessProbeDelayEnv); got != "4" {
+		t.Errorf("expected liveness delay 4 from the watch event, got %q", got)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/proberconfig", st.proberConfigHandler)
+
+	req, _ := http.NewRequest("GET", "/proberconfig", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var status configControllerStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if w.Code != http.StatusOK || status.Applied != "slow" || status.Spec == nil || status.Spec.Probes.Liveness != "4" {
+		t.Errorf("unexpected status %d %+v", w.Code, status)
+	}
+}
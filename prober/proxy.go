@@ -0,0 +1,156 @@
+package prober
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	proxyAllowedHostsEnv = "PROXY_ALLOWED_HOSTS"
+
+	defaultProxyTimeout = 10 * time.Second
+	maxProxyBodyBytes   = 64 << 10
+)
+
+// proxyAllowlist matches the hosts /proxy may reach: exact names, wildcard
+// suffixes like "*.svc.cluster.local" and CIDRs for IP literals. An empty
+// allowlist denies everything.
+type proxyAllowlist struct {
+	hosts    map[string]bool
+	suffixes []string
+	networks []*net.IPNet
+}
+
+func newProxyAllowlist(entries []string) proxyAllowlist {
+	allowlist := proxyAllowlist{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.HasPrefix(entry, "*."):
+			allowlist.suffixes = append(allowlist.suffixes, entry[1:])
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				allowlist.networks = append(allowlist.networks, network)
+			}
+		default:
+			allowlist.hosts[entry] = true
+		}
+	}
+	return allowlist
+}
+
+func loadProxyAllowlist(config *settings) proxyAllowlist {
+	return newProxyAllowlist(strings.Split(config.get(proxyAllowedHostsEnv), ","))
+}
+
+func (a proxyAllowlist) allows(host string) bool {
+	host = strings.ToLower(host)
+	if a.hosts[host] {
+		return true
+	}
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range a.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type proxyResponse struct {
+	URL           string              `json:"url"`
+	Method        string              `json:"method"`
+	Status        int                 `json:"status,omitempty"`
+	Proto         string              `json:"proto,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Duration      string              `json:"duration"`
+	Phases        *httpPhases         `json:"phases,omitempty"`
+	Body          string              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// proxyClient doesn't follow redirects, so a target can't send prober
+// outside of the allowlist.
+var proxyClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// proxyRequest answers GET /proxy?url=...&method=GET&body=true&timeout=10s
+// by performing the request from the pod. The target status is reported in
+// the body, the response is 502 only when no answer was received.
+func proxyRequest(allowlist proxyAllowlist) web.HandlerFunc {
+	return func(c *web.Context) {
+		target, err := url.Parse(c.Query("url"))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid url"})
+			return
+		}
+		if !allowlist.allows(target.Hostname()) {
+			c.JSON(http.StatusForbidden, web.H{"error": "Host not allowed"})
+			return
+		}
+		timeout := defaultProxyTimeout
+		if value := c.Query("timeout"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Invalid timeout value"})
+				return
+			}
+			timeout = parsed
+		}
+		includeBody, _ := strconv.ParseBool(c.Query("body"))
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		response := proxyResponse{URL: target.String(), Method: strings.ToUpper(c.DefaultQuery("method", http.MethodGet))}
+		var timer phaseTimer
+		req, err := http.NewRequestWithContext(timer.withTrace(ctx), response.Method, target.String(), nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid method"})
+			return
+		}
+
+		start := time.Now()
+		resp, err := proxyClient.Do(req)
+		if err != nil {
+			response.Duration = time.Since(start).String()
+			response.Error = err.Error()
+			c.JSON(http.StatusBadGateway, response)
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProxyBodyBytes+1))
+		io.Copy(io.Discard, resp.Body)
+		response.Duration = time.Since(start).String()
+		response.Phases = timer.phases(time.Now())
+		response.Status = resp.StatusCode
+		response.Proto = resp.Proto
+		response.Headers = resp.Header
+		if includeBody {
+			response.BodyTruncated = len(body) > maxProxyBodyBytes
+			if response.BodyTruncated {
+				body = body[:maxProxyBodyBytes]
+			}
+			response.Body = string(body)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
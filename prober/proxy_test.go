@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestProxyAllowlist(t *testing.T) {
+	allowlist := newProxyAllowlist([]string{"example.com", "*.svc.cluster.local", "10.0.0.0/8", "invalid/cidr"})
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"api.example.com", false},
+		{"api.default.svc.cluster.local", true},
+		{"svc.cluster.local.evil.com", false},
+		{"10.1.2.3", true},
+		{"192.168.0.1", false},
+	}
+	for _, test := range tests {
+		if allowed := allowlist.allows(test.host); allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", test.host, test.allowed, allowed)
+		}
+	}
+
+	if newProxyAllowlist(nil).allows("example.com") {
+		t.Error("expected an empty allowlist to deny every host")
+	}
+}
+
+func TestProxyRequest(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.org/", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Upstream", "api")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	defer target.Close()
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/proxy", proxyRequest(newProxyAllowlist([]string{"127.0.0.1"})))
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+		code   int
+	}{
+		{target.URL + "/tea", http.StatusOK, "short and stout", http.StatusTeapot},
+		{target.URL + "/redirect", http.StatusOK, "", http.StatusFound},
+		{"http://example.org/", http.StatusForbidden, "", 0},
+		{"ftp://127.0.0.1/", http.StatusBadRequest, "", 0},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/proxy?body=true&url="+url.QueryEscape(test.target), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.target, test.status, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var response proxyResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if response.Status != test.code || (test.body != "" && response.Body != test.body) {
+			t.Errorf("%s: expected upstream %d %q, got %+v", test.target, test.code, test.body, response)
+		}
+	}
+}
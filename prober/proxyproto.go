@@ -0,0 +1,93 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/pires/go-proxyproto"
+)
+
+const (
+	proxyProtocolEnv = "PROXY_PROTOCOL"
+
+	proxyHeaderTimeout = 10 * time.Second
+)
+
+type proxyConnKey struct{}
+
+type proxyHeaderInfo struct {
+	Version         byte   `json:"version"`
+	SourceAddr      string `json:"sourceAddr"`
+	DestinationAddr string `json:"destinationAddr"`
+}
+
+type ipResponse struct {
+	IP            string           `json:"ip"`
+	IPFamily      string           `json:"ipFamily"`
+	RemoteAddr    string           `json:"remoteAddr"`
+	ProxyProtocol *proxyHeaderInfo `json:"proxyProtocol,omitempty"`
+}
+
+// listenTCP listens on addr and, when enabled, accepts HAProxy PROXY protocol
+// v1/v2 headers so RemoteAddr reflects the client conveyed by the load
+// balancer. Connections without a header are still accepted.
+func listenTCP(network string, addr string, proxyProtocol bool) (net.Listener, error) {
+	listener, err := listenerHandoff.listen(handoffKey(network, addr), func() (net.Listener, error) {
+		return net.Listen(network, addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !proxyProtocol {
+		return listener, nil
+	}
+	return &proxyproto.Listener{Listener: listener, ReadHeaderTimeout: proxyHeaderTimeout}, nil
+}
+
+// connContext keeps the accepted connection reachable from handlers so the
+// PROXY header can be reported.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if limited, ok := conn.(*limitedConn); ok {
+		conn = limited.Conn
+	}
+	if proxyConn, ok := conn.(*proxyproto.Conn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, proxyConn)
+	}
+	return ctx
+}
+
+func proxyHeader(ctx context.Context) *proxyHeaderInfo {
+	proxyConn, ok := ctx.Value(proxyConnKey{}).(*proxyproto.Conn)
+	if !ok {
+		return nil
+	}
+	header := proxyConn.ProxyHeader()
+	if header == nil {
+		return nil
+	}
+	return &proxyHeaderInfo{
+		Version:         header.Version,
+		SourceAddr:      header.SourceAddr.String(),
+		DestinationAddr: header.DestinationAddr.String(),
+	}
+}
+
+func ipRequest(c *web.Context) {
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+	c.JSON(http.StatusOK, ipResponse{
+		IP:            ip,
+		IPFamily:      connFamily(c.Request),
+		RemoteAddr:    c.Request.RemoteAddr,
+		ProxyProtocol: proxyHeader(c.Request.Context()),
+	})
+}
@@ -0,0 +1,69 @@
+package prober
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestIPRequestWithProxyProtocol(t *testing.T) {
+	listener, err := listenTCP("tcp", "127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/ip", ipRequest)
+
+	srv := &http.Server{Handler: router, ConnContext: connContext}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PROXY TCP4 192.0.2.10 192.0.2.20 40000 80\r\nGET /ip HTTP/1.1\r\nHost: prober\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var ip ipResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ip); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if ip.IP != "192.0.2.10" {
+		t.Errorf("expected conveyed client 192.0.2.10, got %s", ip.IP)
+	}
+	if ip.ProxyProtocol == nil || ip.ProxyProtocol.Version != 1 || ip.ProxyProtocol.DestinationAddr != "192.0.2.20:80" {
+		t.Errorf("expected PROXY v1 header to be reported, got %+v", ip.ProxyProtocol)
+	}
+}
+
+func TestIPRequest(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/ip", ipRequest)
+
+	req, _ := http.NewRequest("GET", "/ip", nil)
+	req.RemoteAddr = "198.51.100.7:51000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := `{"ip":"198.51.100.7","ipFamily":"ipv4","remoteAddr":"198.51.100.7:51000"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
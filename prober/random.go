@@ -0,0 +1,33 @@
+package prober
+
+import (
+	"math/rand"
+	"sync"
+)
+
+const randomSeedEnv = "RANDOM_SEED"
+
+// seededRand is the source of every randomized behavior of prober, like
+// the injected faults, so a run started with the same seed draws the same
+// sequence. Concurrent requests still interleave their draws in any order.
+type seededRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	seed int64
+}
+
+func newSeededRand(seed int64) *seededRand {
+	return &seededRand{rand: rand.New(rand.NewSource(seed)), seed: seed}
+}
+
+func (r *seededRand) Seed() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seed
+}
+
+func (r *seededRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64()
+}
@@ -0,0 +1,41 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestSeededFaultsReplay(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+
+	run := func() []int {
+		st := newState(Options{Seed: 42, Seeded: true})
+		router := web.New()
+		router.Use(st.faultMiddleware("seeded", faultProfile{ErrorRate: 0.5}))
+		router.GET("/", func(c *web.Context) { c.Status(http.StatusOK) })
+		var statuses []int
+		for i := 0; i < 32; i++ {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			statuses = append(statuses, w.Code)
+		}
+		return statuses
+	}
+
+	first, second := run(), run()
+	failures := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same outcomes with the same seed, got %v and %v", first, second)
+		}
+		if first[i] != http.StatusOK {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(first) {
+		t.Errorf("expected a mix of failures and successes, got %v", first)
+	}
+}
@@ -0,0 +1,243 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const recordFileEnv = "RECORD_FILE"
+
+// recordedRequest is a request as captured by the recording mode, one JSON
+// object per line of the recording.
+type recordedRequest struct {
+	Time    time.Time           `json:"time"`
+	Method  string              `json:"method"`
+	URI     string              `json:"uri"`
+	Host    string              `json:"host,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	// Status is 0 for the injected connection resets.
+	Status  int    `json:"status"`
+	Latency string `json:"latency"`
+}
+
+// trafficRecorder appends the requests served to a file, to replay the
+// probes of kubelet or an ingress against another configuration.
+type trafficRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// loadTrafficRecorder returns nil when RECORD_FILE is unset.
+func loadTrafficRecorder(config *settings) (*trafficRecorder, error) {
+	path := config.get(recordFileEnv)
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &trafficRecorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (r *trafficRecorder) write(record recordedRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(record); err != nil {
+		slog.Warn("Failed to record request", "error", err)
+	}
+}
+
+// middleware records every request once served, in a defer like
+// recordRequests so aborted ones are kept as well.
+func (r *trafficRecorder) middleware() web.HandlerFunc {
+	return func(c *web.Context) {
+		start := time.Now()
+		defer func() {
+			status := answeredStatus(c)
+			for _, fault := range c.GetStringSlice(faultsKey) {
+				if fault == "reset" {
+					status = 0
+				}
+			}
+			r.write(recordedRequest{
+				Time:    start,
+				Method:  c.Request.Method,
+				URI:     c.Request.URL.RequestURI(),
+				Host:    c.Request.Host,
+				Headers: c.Request.Header.Clone(),
+				Status:  status,
+				Latency: time.Since(start).String(),
+			})
+		}()
+		c.Next()
+	}
+}
+
+func (r *trafficRecorder) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file.Close()
+}
+
+func readRecording(reader io.Reader) ([]recordedRequest, error) {
+	var records []recordedRequest
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+type replayReport struct {
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	Mismatches int            `json:"mismatches"`
+	Statuses   map[string]int `json:"statuses"`
+	Duration   string         `json:"duration"`
+	Failures   []string       `json:"failures,omitempty"`
+}
+
+// replay re-issues the recorded requests against the target, keeping the
+// gaps between them divided by speed. Requests are sent without waiting
+// for the previous ones, so slow answers do not shift the pacing.
+func replay(ctx context.Context, client *http.Client, target string, records []recordedRequest, speed float64, keepHost bool) replayReport {
+	report := replayReport{Requests: len(records), Statuses: make(map[string]int)}
+	if len(records) == 0 {
+		return report
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start, first := time.Now(), records[0].Time
+	for _, record := range records {
+		at := start.Add(time.Duration(float64(record.Time.Sub(first)) / speed))
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(at)):
+		}
+		if ctx.Err() != nil {
+			mu.Lock()
+			report.Errors++
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := replayRequest(ctx, client, target, record, keepHost)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Errors++
+				report.Failures = append(report.Failures, fmt.Sprintf("%s %s: %v", record.Method, record.URI, err))
+				return
+			}
+			report.Statuses[strconv.Itoa(status)]++
+			if record.Status != 0 && status != record.Status {
+				report.Mismatches++
+				report.Failures = append(report.Failures, fmt.Sprintf("%s %s: got %d, recorded %d", record.Method, record.URI, status, record.Status))
+			}
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start).String()
+	return report
+}
+
+func replayRequest(ctx context.Context, client *http.Client, target string, record recordedRequest, keepHost bool) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, record.Method, strings.TrimSuffix(target, "/")+record.URI, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range record.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade":
+			continue
+		}
+		req.Header[name] = values
+	}
+	if keepHost && record.Host != "" {
+		req.Host = record.Host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// runReplay implements `prober replay`, which re-issues the requests of a
+// recording against a target with their original pacing and prints a
+// JSON report. It returns the exit code: 0 when every request got an
+// answer, 1 when one failed and 2 on usage errors.
+func runReplay(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("replay", stderr)
+	path := flags.String("file", "", "recording, as written by RECORD_FILE")
+	target := flags.String("target", "", "base URL the requests are sent to, like http://prober:8080")
+	speed := flags.Float64("speed", 1, "pacing factor, 2 replaying twice as fast")
+	keepHost := flags.Bool("keep-host", false, "send the recorded Host header instead of the one of the target")
+	timeout := flags.Duration("timeout", 10*time.Second, "time limit of each request")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || *target == "" {
+		fmt.Fprintln(stderr, "missing --file or --target")
+		return 2
+	}
+	if *speed <= 0 {
+		fmt.Fprintln(stderr, "--speed must be positive")
+		return 2
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid recording: %v\n", err)
+		return 2
+	}
+	records, err := readRecording(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid recording: %v\n", err)
+		return 2
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	report := replay(context.Background(), client, *target, records, *speed, *keepHost)
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestTrafficRecorder(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	t.Setenv(recordFileEnv, path)
+	recorder, err := loadTrafficRecorder(envSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := web.New()
+	router.Use(recorder.middleware())
+	router.GET("/readiness", func(c *web.Context) { c.Status(http.StatusServiceUnavailable) })
+	req := httptest.NewRequest(http.MethodGet, "/readiness?verbose=1", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.30")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	recorder.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := readRecording(file)
+	if err != nil {
+		t.Fatalf("expected a valid recording, got %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].URI != "/readiness?verbose=1" || records[0].Status != http.StatusServiceUnavailable || records[0].Headers["User-Agent"][0] != "kube-probe/1.30" {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+	if records[1].Status != http.StatusNotFound {
+		t.Errorf("expected the unknown route to be recorded with 404, got %d", records[1].Status)
+	}
+}
+
+func TestReplayPacing(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	var agents []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		if r.URL.Path == "/liveness" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+
+	first := time.Now().Add(-time.Hour)
+	records := []recordedRequest{
+		{Time: first, Method: http.MethodGet, URI: "/readiness", Headers: map[string][]string{"User-Agent": {"kube-probe/1.30"}}, Status: http.StatusOK},
+		{Time: first.Add(400 * time.Millisecond), Method: http.MethodGet, URI: "/liveness", Status: http.StatusOK},
+	}
+	report := replay(context.Background(), http.DefaultClient, target.URL, records, 2, false)
+
+	if report.Requests != 2 || report.Errors != 0 || report.Mismatches != 1 || report.Statuses["500"] != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(arrivals) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(arrivals))
+	}
+	if gap := arrivals[1].Sub(arrivals[0]); gap < 150*time.Millisecond || gap > 350*time.Millisecond {
+		t.Errorf("expected a gap of about 200ms at twice the speed, got %v", gap)
+	}
+	if agents[0] != "kube-probe/1.30" {
+		t.Errorf("expected the recorded headers, got user agent %q", agents[0])
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	os.WriteFile(path, []byte(`{"time":"2024-05-02T10:00:00Z","method":"GET","uri":"/startup","status":200}`+"\n"), 0o600)
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--file", path, "--target", target.URL}, 0},
+		{[]string{"--file", path, "--target", "http://127.0.0.1:1"}, 1},
+		{[]string{"--file", path}, 2},
+		{[]string{"--file", path, "--target", target.URL, "--speed", "0"}, 2},
+		{[]string{"--file", filepath.Join(t.TempDir(), "missing"), "--target", target.URL}, 2},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runReplay(test.args, &stdout, &stderr); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d (%s)", test.args, test.code, code, stderr.String())
+		}
+		if test.code == 2 {
+			continue
+		}
+		var report replayReport
+		if err := json.Unmarshal(stdout.Bytes(), &report); err != nil || report.Requests != 1 {
+			t.Errorf("%v: unexpected report %s", test.args, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package prober
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	redactHeadersEnv     = "REDACT_HEADERS"
+	logRequestHeadersEnv = "LOG_REQUEST_HEADERS"
+
+	defaultRedactHeaders = "Authorization,Proxy-Authorization,Cookie,Set-Cookie,X-Api-Key,X-Auth-Token,X-Forwarded-Access-Token"
+	redactedValue        = "[REDACTED]"
+)
+
+// headerRedactor hides the values of the sensitive headers in the access
+// logs, /echo, /requests and the gRPC echoes, since prober would otherwise show the tokens
+// of the auth tests to anyone reading them.
+type headerRedactor map[string]bool
+
+// newHeaderRedactor parses a list of header names, "none" disabling the
+// redaction.
+func newHeaderRedactor(value string) headerRedactor {
+	redactor := make(headerRedactor)
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return redactor
+	}
+	for _, name := range splitList(value) {
+		redactor[http.CanonicalHeaderKey(name)] = true
+	}
+	return redactor
+}
+
+func loadHeaderRedactor(config *settings) headerRedactor {
+	return newHeaderRedactor(config.getString(redactHeadersEnv, defaultRedactHeaders))
+}
+
+// redactValue keeps what helps debugging without the secret: the scheme of
+// the credentials and the names of the cookies.
+func redactValue(name string, value string) string {
+	switch name {
+	case "Authorization", "Proxy-Authorization":
+		if scheme, _, ok := strings.Cut(value, " "); ok {
+			return scheme + " " + redactedValue
+		}
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, cookie := range cookies {
+			cookieName, _, _ := strings.Cut(strings.TrimSpace(cookie), "=")
+			cookies[i] = cookieName + "=" + redactedValue
+		}
+		return strings.Join(cookies, "; ")
+	}
+	return redactedValue
+}
+
+// value returns the value of the header, redacted when it is sensitive.
+func (r headerRedactor) value(name string, value string) string {
+	name = http.CanonicalHeaderKey(name)
+	if !r[name] {
+		return value
+	}
+	return redactValue(name, value)
+}
+
+// redacted returns the header, or a copy with the values of the sensitive
+// headers redacted when it has some.
+func (r headerRedactor) redacted(header http.Header) http.Header {
+	sensitive := false
+	for name := range header {
+		if r[name] {
+			sensitive = true
+			break
+		}
+	}
+	if !sensitive {
+		return header
+	}
+	copied := make(http.Header, len(header))
+	for name, values := range header {
+		if !r[name] {
+			copied[name] = values
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			redacted[i] = redactValue(name, value)
+		}
+		copied[name] = redacted
+	}
+	return copied
+}
@@ -0,0 +1,75 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestRedactedHeaders(t *testing.T) {
+	redactor := newHeaderRedactor(defaultRedactHeaders + ",x-tenant-secret")
+	header := http.Header{
+		"Authorization":   {"Bearer eyJhbGciOiJSUzI1NiJ9.claims.signature"},
+		"Cookie":          {"session=abc; theme=dark"},
+		"X-Tenant-Secret": {"hunter2"},
+		"X-Test":          {"prober"},
+	}
+	redacted := redactor.redacted(header)
+
+	expected := map[string]string{
+		"Authorization":   "Bearer [REDACTED]",
+		"Cookie":          "session=[REDACTED]; theme=[REDACTED]",
+		"X-Tenant-Secret": "[REDACTED]",
+		"X-Test":          "prober",
+	}
+	for name, value := range expected {
+		if got := redacted.Get(name); got != value {
+			t.Errorf("expected %s %q, got %q", name, value, got)
+		}
+	}
+	if header.Get("Authorization") == redacted.Get("Authorization") {
+		t.Error("expected the request header to be left untouched")
+	}
+
+	if got := newHeaderRedactor("none").redacted(header); got.Get("Authorization") != header.Get("Authorization") {
+		t.Errorf("expected none to disable the redaction, got %v", got)
+	}
+}
+
+func TestRedactedRequests(t *testing.T) {
+	st := newState(Options{})
+	logs := captureLogs(t)
+	t.Setenv(logRequestHeadersEnv, "true")
+	ring := newRequestRing(10)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.accessLog(), st.recordRequests(ring, "default"))
+	router.Any("/echo", st.echoRequest)
+	fast := st.fastPathHandler(router)
+
+	for name, handler := range map[string]http.Handler{"echo": router, "fast echo": fast} {
+		req, _ := http.NewRequest("GET", "/echo", nil)
+		req.Header.Set("Authorization", "Bearer s3cr3t-token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var echo echoResponse
+		json.Unmarshal(w.Body.Bytes(), &echo)
+		if got := echo.Headers["Authorization"]; len(got) != 1 || got[0] != "Bearer [REDACTED]" {
+			t.Errorf("%s: expected the token redacted, got %v", name, got)
+		}
+	}
+
+	records := ring.list()
+	if len(records) != 1 || records[0].Headers["Authorization"][0] != "Bearer [REDACTED]" {
+		t.Errorf("expected the buffered request redacted, got %+v", records)
+	}
+	if strings.Contains(logs.String(), "s3cr3t") || !strings.Contains(logs.String(), "Bearer [REDACTED]") {
+		t.Errorf("expected the access log redacted, got %s", logs.String())
+	}
+}
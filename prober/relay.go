@@ -0,0 +1,145 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const relayConfigEnv = "RELAY_CONFIG"
+
+// relayMetrics expose the health of the upstreams the probes relay to.
+type relayMetrics struct {
+	relayUpstreamSuccess *prometheus.GaugeVec
+}
+
+func newRelayMetrics(registry prometheus.Registerer) relayMetrics {
+	m := relayMetrics{
+		relayUpstreamSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "relay_upstream_success",
+			Help: "Whether the last check of the relayed container succeeded, by probe.",
+		}, []string{"probe"}),
+	}
+	registry.MustRegister(m.relayUpstreamSuccess)
+	return m
+}
+
+// relayRule translates the outcome of the relayed check into the status of
+// the probe. A rule matches on the upstream HTTP status range, or on the
+// outcome, success or failure, of the check.
+type relayRule struct {
+	Status  string `yaml:"status"`
+	Outcome string `yaml:"outcome"`
+	Respond int    `yaml:"respond"`
+
+	statusMin, statusMax int
+}
+
+func (r relayRule) matches(result checkResult) bool {
+	if r.Status != "" {
+		return result.StatusCode >= r.statusMin && result.StatusCode <= r.statusMax
+	}
+	return r.Outcome == outcome(result.Success)
+}
+
+// relayTarget is a check of another container of the pod whose outcome a
+// simulated probe mirrors.
+type relayTarget struct {
+	checkConfig `yaml:",inline"`
+	Rules       []relayRule `yaml:"rules"`
+}
+
+type relayFile struct {
+	Relay map[string]*relayTarget `yaml:"relay"`
+}
+
+func loadRelayConfig(path string) (map[string]*relayTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file relayFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Relay) == 0 {
+		return nil, errors.New("no relayed probe")
+	}
+
+	for probe, target := range file.Relay {
+		if probe != "startup" && probe != "readiness" && probe != "liveness" {
+			return nil, fmt.Errorf("unknown probe %q", probe)
+		}
+		if target == nil {
+			return nil, fmt.Errorf("probe %q has no target", probe)
+		}
+		target.Name = "relay-" + probe
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+		for i := range target.Rules {
+			rule := &target.Rules[i]
+			switch {
+			case rule.Status != "" && rule.Outcome != "", rule.Status == "" && rule.Outcome == "":
+				return nil, fmt.Errorf("rule %d of %q must set one of status or outcome", i, probe)
+			case rule.Outcome != "" && rule.Outcome != "success" && rule.Outcome != "failure":
+				return nil, fmt.Errorf("rule %d of %q has invalid outcome %q", i, probe, rule.Outcome)
+			case rule.Respond < 100 || rule.Respond > 599:
+				return nil, fmt.Errorf("rule %d of %q has invalid respond status %d", i, probe, rule.Respond)
+			}
+			if rule.Status != "" {
+				min, max, ok := parseStatusRange(rule.Status)
+				if !ok {
+					return nil, fmt.Errorf("rule %d of %q has invalid status range %q", i, probe, rule.Status)
+				}
+				rule.statusMin, rule.statusMax = min, max
+			}
+		}
+	}
+	return file.Relay, nil
+}
+
+// respond returns the status of the probe for the check result, from the
+// first matching rule or 200 on success and 503 on failure.
+func (t *relayTarget) respond(result checkResult) int {
+	for _, rule := range t.Rules {
+		if rule.matches(result) {
+			return rule.Respond
+		}
+	}
+	if result.Success {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+// relayProbe answers the probe with the translated outcome of its relayed
+// check when it has one, so prober retrofits probes onto a legacy
+// container as a sidecar. The relayed check runs on every probe request,
+// like kubelet would run it.
+func (st *state) relayProbe(probe string) web.HandlerFunc {
+	return func(c *web.Context) {
+		target, ok := st.relayTargets[probe]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), target.Timeout)
+		defer cancel()
+		result := runCheck(ctx, target.checkConfig)
+		value := 0.0
+		if result.Success {
+			value = 1
+		}
+		st.relayUpstreamSuccess.WithLabelValues(probe).Set(value)
+
+		c.AbortWithStatusJSON(target.respond(result), web.H{"message": probe, "relay": result})
+	}
+}
@@ -0,0 +1,116 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestLoadRelayConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown probe": `
+relay:
+  warmup:
+    url: http://localhost:8081
+`,
+		"missing url": `
+relay:
+  readiness:
+    type: http
+`,
+		"rule without match": `
+relay:
+  readiness:
+    url: http://localhost:8081
+    rules:
+      - respond: 200
+`,
+		"invalid respond": `
+relay:
+  readiness:
+    url: http://localhost:8081
+    rules:
+      - outcome: failure
+        respond: 42
+`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadRelayConfig(writeChecksConfig(t, content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRelayProbe(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	t.Setenv(readinessProbeDelayEnv, "0")
+	st := newState(Options{})
+	upstreamStatus := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(upstreamStatus)
+	}))
+	defer upstream.Close()
+	address := upstream.Listener.Addr().String()
+
+	targets, err := loadRelayConfig(writeChecksConfig(t, `
+relay:
+  readiness:
+    url: `+upstream.URL+`
+    expectedStatus: [200]
+    timeout: 1s
+    rules:
+      - status: "429"
+        respond: 200
+      - status: 5xx
+        respond: 500
+  liveness:
+    type: tcp
+    address: `+address+`
+    timeout: 1s
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st.relayTargets = targets
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	probe := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		upstream int
+		expected int
+	}{
+		{http.StatusOK, http.StatusOK},
+		{http.StatusTooManyRequests, http.StatusOK},
+		{http.StatusBadGateway, http.StatusInternalServerError},
+		{http.StatusNotFound, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		upstreamStatus = test.upstream
+		if code := probe("/readiness"); code != test.expected {
+			t.Errorf("upstream %d: expected readiness %d, got %d", test.upstream, test.expected, code)
+		}
+	}
+
+	if code := probe("/liveness"); code != http.StatusOK {
+		t.Errorf("expected liveness %d while the port is open, got %d", http.StatusOK, code)
+	}
+	upstream.Close()
+	if code := probe("/liveness"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected liveness %d once the port is closed, got %d", http.StatusServiceUnavailable, code)
+	}
+	if code := probe("/startup"); code != http.StatusOK {
+		t.Errorf("expected the startup probe without relay to answer %d, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,171 @@
+package prober
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	requestsBufferSizeEnv = "REQUESTS_BUFFER_SIZE"
+
+	defaultRequestsBufferSize = 100
+
+	faultsKey = "faults"
+)
+
+type requestRecord struct {
+	Time       time.Time           `json:"time"`
+	RequestID  string              `json:"requestId"`
+	Listener   string              `json:"listener"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Route      string              `json:"route"`
+	RemoteAddr string              `json:"remoteAddr"`
+	Headers    map[string][]string `json:"headers"`
+	Status     int                 `json:"status"`
+	Latency    string              `json:"latency"`
+	Faults     []string            `json:"faults,omitempty"`
+}
+
+// requestRing keeps the last requests served, so what kubelet or a proxy
+// actually sent can be inspected without capturing traffic.
+type requestRing struct {
+	mu      sync.Mutex
+	records []requestRecord
+	next    int
+	full    bool
+}
+
+func newRequestRing(size int) *requestRing {
+	return &requestRing{records: make([]requestRecord, size)}
+}
+
+func (r *requestRing) add(record requestRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.records) == 0 {
+		return
+	}
+	r.records[r.next] = record
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the records newest first.
+func (r *requestRing) list() []requestRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.records)
+	}
+	records := make([]requestRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		records = append(records, r.records[(r.next-i+len(r.records))%len(r.records)])
+	}
+	return records
+}
+
+// addFault notes a fault injected into the request, shown in /requests.
+func addFault(c *web.Context, fault string) {
+	c.Set(faultsKey, append(c.GetStringSlice(faultsKey), fault))
+}
+
+// recordRequests stores every request served by the listener, except the
+// ones reading the buffer. The record is written in a defer so injected
+// resets, which abort the handler with a panic, are kept as well.
+func (st *state) recordRequests(ring *requestRing, listener string) web.HandlerFunc {
+	return func(c *web.Context) {
+		if c.FullPath() == "/requests" {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			status := answeredStatus(c)
+			faults := c.GetStringSlice(faultsKey)
+			for _, fault := range faults {
+				if fault == "reset" {
+					status = 0
+				}
+			}
+			latency := time.Since(start)
+			record := requestRecord{
+				Time:       start,
+				RequestID:  c.GetHeader(requestIDHeader),
+				Listener:   listener,
+				Method:     c.Request.Method,
+				Path:       c.Request.URL.Path,
+				Route:      c.FullPath(),
+				RemoteAddr: c.Request.RemoteAddr,
+				Headers:    st.sensitiveHeaders.redacted(c.Request.Header.Clone()),
+				Status:     status,
+				Latency:    latency.String(),
+				Faults:     faults,
+			}
+			ring.add(record)
+			st.scenarios.observe(record, latency)
+		}()
+
+		c.Next()
+	}
+}
+
+// requestsHandler lists the buffered requests, filtered by the method,
+// path prefix, route, status and listener query parameters and capped by
+// limit.
+func requestsHandler(ring *requestRing) web.HandlerFunc {
+	return func(c *web.Context) {
+		limit := -1
+		if value := c.Query("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Invalid limit value"})
+				return
+			}
+			limit = parsed
+		}
+		status := 0
+		if value := c.Query("status"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Invalid status value"})
+				return
+			}
+			status = parsed
+		}
+
+		records := []requestRecord{}
+		for _, record := range ring.list() {
+			if limit >= 0 && len(records) >= limit {
+				break
+			}
+			if method := c.Query("method"); method != "" && !strings.EqualFold(method, record.Method) {
+				continue
+			}
+			if path := c.Query("path"); path != "" && !strings.HasPrefix(record.Path, path) {
+				continue
+			}
+			if route := c.Query("route"); route != "" && route != record.Route {
+				continue
+			}
+			if listener := c.Query("listener"); listener != "" && listener != record.Listener {
+				continue
+			}
+			if status != 0 && status != record.Status {
+				continue
+			}
+			records = append(records, record)
+		}
+		c.JSON(http.StatusOK, records)
+	}
+}
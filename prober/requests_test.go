@@ -0,0 +1,90 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestRequestRing(t *testing.T) {
+	ring := newRequestRing(2)
+	ring.add(requestRecord{Path: "/first"})
+	ring.add(requestRecord{Path: "/second"})
+	ring.add(requestRecord{Path: "/third"})
+
+	records := ring.list()
+	if len(records) != 2 || records[0].Path != "/third" || records[1].Path != "/second" {
+		t.Errorf("expected the last 2 requests newest first, got %+v", records)
+	}
+
+	if records := newRequestRing(0); len(records.list()) != 0 {
+		t.Errorf("expected a disabled buffer to stay empty")
+	}
+}
+
+func TestRequestsHandler(t *testing.T) {
+	st := newState(Options{})
+	ring := newRequestRing(10)
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.recordRequests(ring, "chaotic"), st.faultMiddleware("test", faultProfile{ErrorRate: 1, ErrorStatus: http.StatusBadGateway}))
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.31")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	router = web.New()
+	router.GET("/requests", requestsHandler(ring))
+
+	req, _ = http.NewRequest("GET", "/requests?path=/liveness&status=502", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var records []requestRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Listener != "chaotic" || record.Route != "/liveness" || record.Status != http.StatusBadGateway {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if len(record.Faults) != 1 || record.Faults[0] != "error" {
+		t.Errorf("expected error fault, got %v", record.Faults)
+	}
+	if http.Header(record.Headers).Get("User-Agent") != "kube-probe/1.31" {
+		t.Errorf("expected request headers, got %v", record.Headers)
+	}
+
+	req, _ = http.NewRequest("GET", "/requests?method=POST", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Body.String() != "[]" {
+		t.Errorf("expected no POST requests, got %s", w.Body.String())
+	}
+}
+
+func TestRequestsHandlerInvalidLimit(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/requests", requestsHandler(newRequestRing(1)))
+
+	req, _ := http.NewRequest("GET", "/requests?limit=-1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
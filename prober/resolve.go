@@ -0,0 +1,169 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+
+	defaultResolveTimeout = 2 * time.Second
+)
+
+type resolveAttempt struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	Rcode    string `json:"rcode,omitempty"`
+	Answers  int    `json:"answers"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type resolveResponse struct {
+	Host     string           `json:"host"`
+	Type     string           `json:"type"`
+	Servers  []string         `json:"servers"`
+	Search   []string         `json:"search"`
+	Ndots    int              `json:"ndots"`
+	Resolver string           `json:"resolver,omitempty"`
+	Name     string           `json:"name,omitempty"`
+	Records  []string         `json:"records"`
+	Attempts []resolveAttempt `json:"attempts"`
+	Duration string           `json:"duration"`
+}
+
+// resolve looks host up the way the libc resolver does: each name of the
+// search list expanded by ndots is tried in order on every nameserver until
+// one answers. Every query is reported, which is what shows ndots costs.
+func resolve(ctx context.Context, config *dns.ClientConfig, host string, qtype uint16) resolveResponse {
+	start := time.Now()
+	response := resolveResponse{
+		Host:     host,
+		Type:     dns.TypeToString[qtype],
+		Servers:  config.Servers,
+		Search:   config.Search,
+		Ndots:    config.Ndots,
+		Records:  []string{},
+		Attempts: []resolveAttempt{},
+	}
+	client := new(dns.Client)
+
+names:
+	for _, name := range config.NameList(host) {
+		for _, server := range config.Servers {
+			addr := net.JoinHostPort(server, config.Port)
+			req := new(dns.Msg)
+			req.SetQuestion(name, qtype)
+
+			resp, rtt, err := client.ExchangeContext(ctx, req, addr)
+			attempt := resolveAttempt{Name: name, Server: addr, Duration: rtt.String()}
+			if err != nil {
+				attempt.Error = err.Error()
+				response.Attempts = append(response.Attempts, attempt)
+				if ctx.Err() != nil {
+					break names
+				}
+				continue
+			}
+			attempt.Rcode = dns.RcodeToString[resp.Rcode]
+			attempt.Answers = len(resp.Answer)
+			response.Attempts = append(response.Attempts, attempt)
+
+			if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+				response.Resolver = addr
+				response.Name = name
+				for _, rr := range resp.Answer {
+					response.Records = append(response.Records, rr.String())
+				}
+				break names
+			}
+			// The server answered, another one won't know better.
+			continue names
+		}
+	}
+
+	response.Duration = time.Since(start).String()
+	return response
+}
+
+// resolverConfig reads resolv.conf, replacing its nameservers with server
+// ("host" or "host:port") when set.
+func resolverConfig(path string, server string) (*dns.ClientConfig, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		if server == "" {
+			return nil, err
+		}
+		config = &dns.ClientConfig{Ndots: 1}
+	}
+	if server != "" {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host, port = server, "53"
+		}
+		config.Servers = []string{host}
+		config.Port = port
+	}
+	return config, nil
+}
+
+// resolveHandler answers GET /resolve/:host?type=A&server=10.0.0.10:53
+// using the pod resolv.conf, or the given server instead of its nameservers.
+func resolveHandler(resolvConf string) web.HandlerFunc {
+	return func(c *web.Context) {
+		qtype, ok := dns.StringToType[strings.ToUpper(c.DefaultQuery("type", "A"))]
+		if !ok {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid record type"})
+			return
+		}
+		timeout := defaultResolveTimeout
+		if value := c.Query("timeout"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Invalid timeout value"})
+				return
+			}
+			timeout = parsed
+		}
+
+		config, err := resolverConfig(resolvConf, c.Query("server"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, web.H{"error": "Failed to read resolver configuration"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.JSON(http.StatusOK, resolve(ctx, config, c.Param("host"), qtype))
+	}
+}
+
+// dnsCheck succeeds when the host resolves to at least one record, through
+// the pod resolv.conf or the configured server.
+func dnsCheck(ctx context.Context, config checkConfig) checkResult {
+	resolver, err := resolverConfig(resolvConfPath, config.Server)
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+
+	response := resolve(ctx, resolver, config.Host, dns.StringToType[strings.ToUpper(config.RecordType)])
+	if len(response.Records) == 0 {
+		result := checkResult{Error: "no records"}
+		if last := len(response.Attempts) - 1; last >= 0 {
+			if response.Attempts[last].Error != "" {
+				result.Error = response.Attempts[last].Error
+			} else {
+				result.Error = "no records, " + response.Attempts[last].Rcode
+			}
+		}
+		return result
+	}
+	return checkResult{Success: true, Records: response.Records}
+}
@@ -0,0 +1,76 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/miekg/dns"
+)
+
+func TestResolveSearchList(t *testing.T) {
+	addr := startTestDNS(t, dnsConfig{Records: []string{
+		"db.prober.svc.cluster.local. 30 IN A 10.0.0.5",
+	}})
+	host, port, _ := net.SplitHostPort(addr)
+	config := &dns.ClientConfig{
+		Servers: []string{host},
+		Port:    port,
+		Search:  []string{"default.svc.cluster.local", "prober.svc.cluster.local"},
+		Ndots:   5,
+	}
+
+	response := resolve(context.Background(), config, "db", dns.TypeA)
+
+	if response.Name != "db.prober.svc.cluster.local." || response.Resolver != addr {
+		t.Errorf("expected db to resolve through the second search domain, got %+v", response)
+	}
+	if len(response.Records) != 1 {
+		t.Errorf("expected 1 record, got %v", response.Records)
+	}
+	if len(response.Attempts) != 2 || response.Attempts[0].Rcode != "NXDOMAIN" {
+		t.Errorf("expected a NXDOMAIN attempt before the answer, got %+v", response.Attempts)
+	}
+}
+
+func TestResolveHandler(t *testing.T) {
+	addr := startTestDNS(t, dnsConfig{Records: []string{
+		"_http._tcp.api.example.internal. 30 IN SRV 10 5 8080 api.example.internal.",
+	}})
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(resolvConf, []byte("nameserver 192.0.2.1\noptions ndots:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/resolve/:host", resolveHandler(resolvConf))
+
+	req, _ := http.NewRequest("GET", "/resolve/_http._tcp.api.example.internal?type=srv&server="+addr, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response resolveResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if response.Type != "SRV" || len(response.Records) != 1 {
+		t.Errorf("expected 1 SRV record, got %+v", response)
+	}
+
+	req, _ = http.NewRequest("GET", "/resolve/api?type=BOGUS", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
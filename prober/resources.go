@@ -0,0 +1,207 @@
+package prober
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	cgroupRootEnv   = "CGROUP_ROOT"
+	autoMaxProcsEnv = "AUTO_GOMAXPROCS"
+	// maxProcsEnv is read by the Go runtime itself.
+	maxProcsEnv = "GOMAXPROCS"
+
+	defaultCgroupRoot = "/sys/fs/cgroup"
+
+	// cgroupUnlimited is above the v1 value of a memory limit left unset,
+	// which is the max int64 rounded down to the page size.
+	cgroupUnlimited = 1 << 62
+)
+
+type cpuResources struct {
+	LimitCores   *float64 `json:"limitCores,omitempty"`
+	RequestCores *float64 `json:"requestCores,omitempty"`
+	QuotaMicros  int64    `json:"quotaMicros,omitempty"`
+	PeriodMicros int64    `json:"periodMicros,omitempty"`
+	Shares       int64    `json:"shares,omitempty"`
+	Weight       int64    `json:"weight,omitempty"`
+	UsageSeconds float64  `json:"usageSeconds"`
+
+	Throttling *cpuThrottling `json:"throttling,omitempty"`
+}
+
+type memoryResources struct {
+	LimitBytes   *int64 `json:"limitBytes,omitempty"`
+	RequestBytes *int64 `json:"requestBytes,omitempty"`
+	UsageBytes   int64  `json:"usageBytes"`
+}
+
+// resources is the ground truth of the container limits as enforced by the
+// cgroups, nil limits meaning unlimited.
+type resources struct {
+	CgroupVersion int             `json:"cgroupVersion"`
+	CPU           cpuResources    `json:"cpu"`
+	Memory        memoryResources `json:"memory"`
+	GOMAXPROCS    int             `json:"gomaxprocs"`
+	// GOMAXPROCSSource is where GOMAXPROCS comes from: env, cgroup or
+	// default, the CPUs of the node.
+	GOMAXPROCSSource string `json:"gomaxprocsSource"`
+	NumCPU           int    `json:"numCPU"`
+}
+
+// readCgroupValue returns the first field of a cgroup file, false when the
+// file is missing or holds "max".
+func readCgroupValue(path string, field int) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) <= field || fields[field] == "max" {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(fields[field], 10, 64)
+	return value, err == nil
+}
+
+// readCgroupStat returns a key of a flat keyed file like cpu.stat.
+func readCgroupStat(path string, key string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(line, " ")
+		if ok && name == key {
+			parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			return parsed, err == nil
+		}
+	}
+	return 0, false
+}
+
+// cpuCores returns the cores allowed by a CFS quota.
+func cpuCores(quota int64, period int64) *float64 {
+	if quota <= 0 || period <= 0 {
+		return nil
+	}
+	cores := float64(quota) / float64(period)
+	return &cores
+}
+
+// sharesCores reverts the cpu.shares kubelet sets from the CPU request.
+// 2 shares is the floor of pods without request.
+func sharesCores(shares int64) *float64 {
+	if shares <= 2 {
+		return nil
+	}
+	cores := float64(shares) / 1024
+	return &cores
+}
+
+// weightShares reverts the conversion of cpu.shares to the cpu.weight of
+// cgroup v2 done by the container runtimes.
+func weightShares(weight int64) int64 {
+	return 2 + ((weight-1)*262142)/9999
+}
+
+// loadResources reads the cgroup of the process, the root being where the
+// container runtime mounts it.
+func loadResources(root string) resources {
+	res := resources{GOMAXPROCS: runtime.GOMAXPROCS(0), GOMAXPROCSSource: maxProcsSource.Load().(string), NumCPU: runtime.NumCPU()}
+	if throttling, ok := readThrottling(root); ok {
+		res.CPU.Throttling = &throttling
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		res.CgroupVersion = 2
+		if quota, ok := readCgroupValue(filepath.Join(root, "cpu.max"), 0); ok {
+			res.CPU.QuotaMicros = quota
+			res.CPU.PeriodMicros, _ = readCgroupValue(filepath.Join(root, "cpu.max"), 1)
+			res.CPU.LimitCores = cpuCores(res.CPU.QuotaMicros, res.CPU.PeriodMicros)
+		}
+		if weight, ok := readCgroupValue(filepath.Join(root, "cpu.weight"), 0); ok {
+			res.CPU.Weight = weight
+			res.CPU.RequestCores = sharesCores(weightShares(weight))
+		}
+		if usage, ok := readCgroupStat(filepath.Join(root, "cpu.stat"), "usage_usec"); ok {
+			res.CPU.UsageSeconds = float64(usage) / 1e6
+		}
+		if limit, ok := readCgroupValue(filepath.Join(root, "memory.max"), 0); ok {
+			res.Memory.LimitBytes = &limit
+		}
+		if request, ok := readCgroupValue(filepath.Join(root, "memory.min"), 0); ok && request > 0 {
+			res.Memory.RequestBytes = &request
+		}
+		res.Memory.UsageBytes, _ = readCgroupValue(filepath.Join(root, "memory.current"), 0)
+		return res
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cpu")); err != nil {
+		return res
+	}
+	res.CgroupVersion = 1
+	if quota, ok := readCgroupValue(filepath.Join(root, "cpu", "cpu.cfs_quota_us"), 0); ok && quota > 0 {
+		res.CPU.QuotaMicros = quota
+		res.CPU.PeriodMicros, _ = readCgroupValue(filepath.Join(root, "cpu", "cpu.cfs_period_us"), 0)
+		res.CPU.LimitCores = cpuCores(res.CPU.QuotaMicros, res.CPU.PeriodMicros)
+	}
+	if shares, ok := readCgroupValue(filepath.Join(root, "cpu", "cpu.shares"), 0); ok {
+		res.CPU.Shares = shares
+		res.CPU.RequestCores = sharesCores(shares)
+	}
+	if usage, ok := readCgroupValue(filepath.Join(root, "cpuacct", "cpuacct.usage"), 0); ok {
+		res.CPU.UsageSeconds = float64(usage) / 1e9
+	}
+	if limit, ok := readCgroupValue(filepath.Join(root, "memory", "memory.limit_in_bytes"), 0); ok && limit < cgroupUnlimited {
+		res.Memory.LimitBytes = &limit
+	}
+	res.Memory.UsageBytes, _ = readCgroupValue(filepath.Join(root, "memory", "memory.usage_in_bytes"), 0)
+	return res
+}
+
+var maxProcsSource atomic.Value
+
+func init() {
+	maxProcsSource.Store("default")
+	if os.Getenv(maxProcsEnv) != "" {
+		maxProcsSource.Store("env")
+	}
+}
+
+// setMaxProcs sizes GOMAXPROCS to the CPU limit of the cgroup, rounded down
+// and at least 1 like automaxprocs, so the scheduler doesn't run more
+// threads than the quota lets run and get throttled, skewing the latencies
+// prober measures. GOMAXPROCS set in the environment wins.
+func setMaxProcs(root string) {
+	if os.Getenv(maxProcsEnv) != "" || !getEnvBool(autoMaxProcsEnv, true) {
+		return
+	}
+	limit := loadResources(root).CPU.LimitCores
+	if limit == nil {
+		return
+	}
+	procs := max(1, int(math.Floor(*limit)))
+	runtime.GOMAXPROCS(procs)
+	maxProcsSource.Store("cgroup")
+	slog.Info("GOMAXPROCS set from the CPU limit", "gomaxprocs", procs, "limitCores", *limit)
+}
+
+// resourcesHandler answers GET /resources with the CPU and memory requests
+// and limits seen from the cgroups, their usage and GOMAXPROCS.
+func (st *state) resourcesHandler(c *web.Context) {
+	res := loadResources(st.config.getString(cgroupRootEnv, defaultCgroupRoot))
+	if previous := st.lastThrottling.Load(); previous != nil && res.CPU.Throttling != nil {
+		res.CPU.Throttling.RecentRatio = previous.RecentRatio
+	}
+	c.JSON(http.StatusOK, res)
+}
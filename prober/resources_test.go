@@ -0,0 +1,121 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestLoadResourcesV2(t *testing.T) {
+	root := writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"cpu.max":            "50000 100000\n",
+		"cpu.weight":         "10\n",
+		"cpu.stat":           "usage_usec 2500000\nuser_usec 2000000\n",
+		"memory.max":         "268435456\n",
+		"memory.current":     "1048576\n",
+	})
+
+	res := loadResources(root)
+	if res.CgroupVersion != 2 || res.CPU.LimitCores == nil || *res.CPU.LimitCores != 0.5 || res.CPU.UsageSeconds != 2.5 {
+		t.Errorf("unexpected CPU resources: %+v", res.CPU)
+	}
+	// 256 shares, the request of 250m, become a weight of 10, losing
+	// precision on the way back.
+	if res.CPU.RequestCores == nil || *res.CPU.RequestCores < 0.2 || *res.CPU.RequestCores > 0.3 {
+		t.Errorf("expected a request around 0.25 cores, got %v", res.CPU.RequestCores)
+	}
+	if res.Memory.LimitBytes == nil || *res.Memory.LimitBytes != 268435456 || res.Memory.UsageBytes != 1048576 {
+		t.Errorf("unexpected memory resources: %+v", res.Memory)
+	}
+}
+
+func TestLoadResourcesV1Unlimited(t *testing.T) {
+	root := writeCgroupFiles(t, map[string]string{
+		"cpu/cpu.cfs_quota_us":         "-1\n",
+		"cpu/cpu.cfs_period_us":        "100000\n",
+		"cpu/cpu.shares":               "2\n",
+		"cpuacct/cpuacct.usage":        "3000000000\n",
+		"memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"memory/memory.usage_in_bytes": "4096\n",
+	})
+
+	res := loadResources(root)
+	if res.CgroupVersion != 1 || res.CPU.LimitCores != nil || res.CPU.RequestCores != nil || res.CPU.UsageSeconds != 3 {
+		t.Errorf("unexpected CPU resources: %+v", res.CPU)
+	}
+	if res.Memory.LimitBytes != nil || res.Memory.UsageBytes != 4096 {
+		t.Errorf("unexpected memory resources: %+v", res.Memory)
+	}
+}
+
+func TestResourcesHandler(t *testing.T) {
+	t.Setenv(cgroupRootEnv, t.TempDir())
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/resources", st.resourcesHandler)
+
+	req, _ := http.NewRequest("GET", "/resources", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var res resources
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if w.Code != http.StatusOK || res.CgroupVersion != 0 || res.GOMAXPROCS < 1 {
+		t.Errorf("unexpected response %d %+v", w.Code, res)
+	}
+}
+
+func TestSetMaxProcs(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	defer maxProcsSource.Store(maxProcsSource.Load())
+	root := writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"cpu.max":            "150000 100000\n",
+	})
+
+	t.Setenv(maxProcsEnv, "")
+	t.Setenv(autoMaxProcsEnv, "false")
+	procs := runtime.GOMAXPROCS(0)
+	setMaxProcs(root)
+	if runtime.GOMAXPROCS(0) != procs {
+		t.Errorf("expected GOMAXPROCS left alone when disabled, got %d", runtime.GOMAXPROCS(0))
+	}
+
+	t.Setenv(autoMaxProcsEnv, "true")
+	setMaxProcs(root)
+	if res := loadResources(root); res.GOMAXPROCS != 1 || res.GOMAXPROCSSource != "cgroup" {
+		t.Errorf("expected 1.5 cores to round down to 1 proc, got %d from %s", res.GOMAXPROCS, res.GOMAXPROCSSource)
+	}
+
+	runtime.GOMAXPROCS(procs)
+	t.Setenv(maxProcsEnv, "3")
+	setMaxProcs(root)
+	if runtime.GOMAXPROCS(0) != procs {
+		t.Errorf("expected the GOMAXPROCS variable to win, got %d", runtime.GOMAXPROCS(0))
+	}
+}
@@ -0,0 +1,435 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	scenarioRunning   = "running"
+	scenarioCompleted = "completed"
+	scenarioStopped   = "stopped"
+	scenarioAborted   = "aborted"
+)
+
+var errScenarioRunning = errors.New("a scenario is already running")
+
+// scenarioMetrics follow the scenario runs and their steps.
+type scenarioMetrics struct {
+	scenarioStepActive *prometheus.GaugeVec
+	scenarioStepsTotal *prometheus.CounterVec
+	scenarioRunsTotal  *prometheus.CounterVec
+}
+
+func newScenarioMetrics(registry prometheus.Registerer) scenarioMetrics {
+	m := scenarioMetrics{
+		scenarioStepActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scenario_step_active",
+			Help: "Whether the step of the scenario is the current one.",
+		}, []string{"scenario", "step"}),
+
+		scenarioStepsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scenario_steps_total",
+			Help: "Steps of scenarios entered.",
+		}, []string{"scenario", "step"}),
+
+		scenarioRunsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scenario_runs_total",
+			Help: "Scenarios ended by result, completed, stopped or aborted.",
+		}, []string{"scenario", "result"}),
+	}
+	registry.MustRegister(m.scenarioStepActive, m.scenarioStepsTotal, m.scenarioRunsTotal)
+	return m
+}
+
+// scenarioStep changes the behavior of prober at its offset from the start
+// of the scenario. The changes add up from step to step: a step only
+// changes what it sets, and recover first reverts everything to the
+// settings from before the scenario.
+type scenarioStep struct {
+	At      time.Duration     `yaml:"at"`
+	Name    string            `yaml:"name"`
+	Probes  map[string]string `yaml:"probes"`
+	Delays  *configs          `yaml:"delays"`
+	Faults  *faultProfile     `yaml:"faults"`
+	Recover bool              `yaml:"recover"`
+
+	// probeStatus is the status forced on each probe, 0 to answer normally.
+	probeStatus map[string]int
+}
+
+// scenario is a timeline of behavior changes, like failing readiness then
+// liveness, to replay the same multi-step drill every time.
+type scenario struct {
+	Name       string               `yaml:"name"`
+	Steps      []scenarioStep       `yaml:"steps"`
+	Assertions []*scenarioAssertion `yaml:"assertions"`
+}
+
+// parseProbeState accepts "ok" to answer the probe normally, "fail" to
+// answer 503, or any HTTP status.
+func parseProbeState(state string) (int, bool) {
+	switch state {
+	case "ok":
+		return 0, true
+	case "fail":
+		return http.StatusServiceUnavailable, true
+	}
+	status, err := strconv.Atoi(state)
+	return status, err == nil && status >= 100 && status <= 599
+}
+
+func parseScenario(data []byte) (scenario, error) {
+	var s scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if s.Name == "" {
+		return s, errors.New("scenario has no name")
+	}
+	if len(s.Steps) == 0 {
+		return s, errors.New("scenario has no step")
+	}
+
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i)
+		}
+		if step.At < 0 || (i > 0 && step.At < s.Steps[i-1].At) {
+			return s, fmt.Errorf("step %q must not start before the previous one", step.Name)
+		}
+		step.probeStatus = make(map[string]int, len(step.Probes))
+		for probe, state := range step.Probes {
+			if probe != "startup" && probe != "readiness" && probe != "liveness" {
+				return s, fmt.Errorf("step %q has unknown probe %q", step.Name, probe)
+			}
+			status, ok := parseProbeState(state)
+			if !ok {
+				return s, fmt.Errorf("step %q has invalid state %q for probe %q", step.Name, state, probe)
+			}
+			step.probeStatus[probe] = status
+		}
+		if step.Delays != nil {
+			for _, delay := range []string{step.Delays.Startup, step.Delays.Readiness, step.Delays.Liveness} {
+				if seconds, err := strconv.ParseInt(delay, 10, 64); delay != "" && (err != nil || seconds < 0) {
+					return s, fmt.Errorf("step %q has invalid probe delay %q", step.Name, delay)
+				}
+			}
+		}
+		if step.Faults != nil {
+			if err := step.Faults.validate(); err != nil {
+				return s, fmt.Errorf("step %q: %w", step.Name, err)
+			}
+		}
+	}
+	for i, assertion := range s.Assertions {
+		if assertion == nil {
+			return s, fmt.Errorf("assertion %d is empty", i)
+		}
+		if err := assertion.validate(s); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// probeOverrideSet are the statuses a scenario forces on the probes.
+type probeOverrideSet struct {
+	sync.Mutex
+	status map[string]int
+}
+
+func (st *state) setProbeOverride(probe string, status int) {
+	st.probeOverrides.Lock()
+	defer st.probeOverrides.Unlock()
+	if st.probeOverrides.status == nil {
+		st.probeOverrides.status = make(map[string]int)
+	}
+	if status == 0 {
+		delete(st.probeOverrides.status, probe)
+	} else {
+		st.probeOverrides.status[probe] = status
+	}
+}
+
+func (st *state) clearProbeOverrides() {
+	st.probeOverrides.Lock()
+	defer st.probeOverrides.Unlock()
+	st.probeOverrides.status = nil
+}
+
+// scenarioProbe answers the probe with the status forced by the running
+// scenario, if any.
+func (st *state) scenarioProbe(probe string) web.HandlerFunc {
+	return func(c *web.Context) {
+		st.probeOverrides.Lock()
+		status, ok := st.probeOverrides.status[probe]
+		st.probeOverrides.Unlock()
+		if ok {
+			c.AbortWithStatusJSON(status, web.H{"message": probe, "scenario": true})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s scenarioStep) apply(st *state, defaults runtimeSettings) {
+	if s.Recover {
+		defaults.restore()
+		st.clearProbeOverrides()
+	}
+	for probe, status := range s.probeStatus {
+		st.setProbeOverride(probe, status)
+	}
+	if s.Delays != nil {
+		for env, delay := range map[string]string{startupProbeDelayEnv: s.Delays.Startup, readinessProbeDelayEnv: s.Delays.Readiness, livenessProbeDelayEnv: s.Delays.Liveness} {
+			if delay == "" {
+				defaults.restoreProbe(env)
+			} else {
+				st.config.set(env, delay)
+			}
+		}
+	}
+	if s.Faults != nil {
+		if s.Faults.enabled() {
+			profile := *s.Faults
+			st.setRuntimeFaults(&profile)
+		} else {
+			st.setRuntimeFaults(nil)
+		}
+	}
+}
+
+// scenarioEngine runs one scenario at a time. Stopping or aborting it
+// reverts to the settings from before it started, while a completed
+// scenario keeps the settings of its last step until stopped.
+type scenarioEngine struct {
+	st       *state
+	mu       sync.Mutex
+	scenario *scenario
+	started  time.Time
+	ended    time.Time
+	step     int
+	state    string
+	reason   string
+	defaults runtimeSettings
+	cancel   context.CancelFunc
+	done     chan struct{}
+	// tallies are indexed like the assertions of the scenario.
+	tallies []assertionTally
+}
+
+func (e *scenarioEngine) start(s scenario) (time.Time, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state == scenarioRunning {
+		return time.Time{}, errScenarioRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.scenario, e.started, e.ended, e.step, e.state, e.reason = &s, e.st.proberClock.Now(), time.Time{}, -1, scenarioRunning, ""
+	e.defaults, e.cancel, e.done = e.st.saveRuntimeSettings(), cancel, make(chan struct{})
+	e.tallies = make([]assertionTally, len(s.Assertions))
+	slog.Info("Scenario started", "scenario", s.Name, "steps", len(s.Steps))
+	go e.run(ctx, s, e.started, e.defaults, e.done)
+	return e.started, nil
+}
+
+func (e *scenarioEngine) run(ctx context.Context, s scenario, started time.Time, defaults runtimeSettings, done chan struct{}) {
+	defer close(done)
+	for i, step := range s.Steps {
+		if !e.st.proberClock.waitUntil(started.Add(step.At), ctx.Done()) {
+			return
+		}
+
+		step.apply(e.st, defaults)
+		e.mu.Lock()
+		if i > 0 {
+			e.st.scenarioStepActive.WithLabelValues(s.Name, s.Steps[i-1].Name).Set(0)
+		}
+		e.st.scenarioStepActive.WithLabelValues(s.Name, step.Name).Set(1)
+		e.st.scenarioStepsTotal.WithLabelValues(s.Name, step.Name).Inc()
+		e.step = i
+		e.mu.Unlock()
+		e.st.configChangesTotal.Inc()
+		slog.Info("Scenario step applied", "scenario", s.Name, "step", step.Name, "at", step.At.String())
+		e.st.kubeEvents.emit(eventTypeNormal, "ScenarioStep", fmt.Sprintf("Scenario %s entered step %s at +%v", s.Name, step.Name, step.At))
+	}
+
+	e.mu.Lock()
+	e.state, e.ended = scenarioCompleted, e.st.proberClock.Now()
+	e.publishReport()
+	e.mu.Unlock()
+	e.st.scenarioRunsTotal.WithLabelValues(s.Name, scenarioCompleted).Inc()
+	slog.Info("Scenario completed", "scenario", s.Name)
+}
+
+// end stops the scenario, stopped or aborted, and reverts its changes. It
+// returns false when there is none to end, aborts only ending a running
+// scenario.
+func (e *scenarioEngine) end(state string, reason string) bool {
+	e.mu.Lock()
+	if e.scenario == nil || e.state == scenarioStopped || e.state == scenarioAborted ||
+		(state == scenarioAborted && e.state != scenarioRunning) {
+		e.mu.Unlock()
+		return false
+	}
+	s, cancel, done, defaults := e.scenario, e.cancel, e.done, e.defaults
+	e.mu.Unlock()
+
+	cancel()
+	<-done
+	defaults.restore()
+	e.st.clearProbeOverrides()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.step >= 0 {
+		e.st.scenarioStepActive.WithLabelValues(s.Name, s.Steps[e.step].Name).Set(0)
+	}
+	running := e.state == scenarioRunning
+	if running {
+		e.st.scenarioRunsTotal.WithLabelValues(s.Name, state).Inc()
+		e.ended = e.st.proberClock.Now()
+	}
+	e.state, e.reason = state, reason
+	if running {
+		e.publishReport()
+	}
+	slog.Info("Scenario ended", "scenario", s.Name, "state", state, "reason", reason)
+	if state == scenarioAborted {
+		e.st.kubeEvents.emit(eventTypeWarning, "ScenarioAborted", fmt.Sprintf("Scenario %s aborted: %s", s.Name, reason))
+	}
+	return true
+}
+
+func (e *scenarioEngine) stop() bool {
+	return e.end(scenarioStopped, "")
+}
+
+// scenarioStatus tells which phase of the scenario prober is in, and when
+// the next one begins.
+type scenarioStatus struct {
+	Scenario       string     `json:"scenario"`
+	State          string     `json:"state"`
+	Reason         string     `json:"reason,omitempty"`
+	Started        time.Time  `json:"started"`
+	Ended          *time.Time `json:"ended,omitempty"`
+	ElapsedSeconds float64    `json:"elapsedSeconds"`
+	Steps          int        `json:"steps"`
+	Step           string     `json:"step,omitempty"`
+	StepIndex      int        `json:"stepIndex"`
+	NextStep       string     `json:"nextStep,omitempty"`
+	NextTransition *time.Time `json:"nextTransition,omitempty"`
+	NextInSeconds  *float64   `json:"nextInSeconds,omitempty"`
+}
+
+// status returns false before the first scenario.
+func (e *scenarioEngine) status(now time.Time) (scenarioStatus, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.scenario == nil {
+		return scenarioStatus{}, false
+	}
+
+	status := scenarioStatus{
+		Scenario:  e.scenario.Name,
+		State:     e.state,
+		Reason:    e.reason,
+		Started:   e.started,
+		Steps:     len(e.scenario.Steps),
+		StepIndex: e.step,
+	}
+	elapsed := now.Sub(e.started)
+	if !e.ended.IsZero() {
+		ended := e.ended
+		status.Ended, elapsed = &ended, ended.Sub(e.started)
+	}
+	status.ElapsedSeconds = elapsed.Seconds()
+	if e.step >= 0 {
+		status.Step = e.scenario.Steps[e.step].Name
+	}
+	if next := e.step + 1; e.state == scenarioRunning && next < len(e.scenario.Steps) {
+		step := e.scenario.Steps[next]
+		transition := e.started.Add(step.At)
+		remaining := transition.Sub(now).Seconds()
+		status.NextStep, status.NextTransition, status.NextInSeconds = step.Name, &transition, &remaining
+	}
+	return status, true
+}
+
+type scenarioStarted struct {
+	Scenario string    `json:"scenario"`
+	Steps    int       `json:"steps"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+}
+
+// startScenario answers POST /scenario with a YAML or JSON scenario in the
+// body by starting it.
+func (st *state) startScenario(c *web.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid scenario"})
+		return
+	}
+	s, err := parseScenario(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid scenario", "detail": err.Error()})
+		return
+	}
+	st.startParsedScenario(c, s)
+}
+
+func (st *state) startParsedScenario(c *web.Context, s scenario) {
+	started, err := st.scenarios.start(s)
+	if err != nil {
+		c.JSON(http.StatusConflict, web.H{"error": "A scenario is already running"})
+		return
+	}
+	c.JSON(http.StatusCreated, scenarioStarted{Scenario: s.Name, Steps: len(s.Steps), Started: started, Duration: s.Steps[len(s.Steps)-1].At.String()})
+}
+
+// stopScenario answers DELETE /scenario by stopping the scenario and
+// reverting its changes.
+func (st *state) stopScenario(c *web.Context) {
+	if !st.scenarios.stop() {
+		c.JSON(http.StatusNotFound, web.H{"error": "No scenario to stop"})
+		return
+	}
+	c.JSON(http.StatusOK, web.H{"message": "Scenario stopped"})
+}
+
+// abortScenario answers POST /scenario/abort?reason=... by ending the
+// running scenario right away and reverting its changes.
+func (st *state) abortScenario(c *web.Context) {
+	reason := c.DefaultQuery("reason", "aborted through the API")
+	if !st.scenarios.end(scenarioAborted, reason) {
+		c.JSON(http.StatusConflict, web.H{"error": "No scenario running"})
+		return
+	}
+	status, _ := st.scenarios.status(st.proberClock.Now())
+	c.JSON(http.StatusOK, status)
+}
+
+// scenarioStatusHandler answers GET /scenario/status with the current step
+// of the last scenario, the time elapsed and the next transition.
+func (st *state) scenarioStatusHandler(c *web.Context) {
+	status, ok := st.scenarios.status(st.proberClock.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, web.H{"error": "No scenario started"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,208 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseScenario(t *testing.T) {
+	s, err := parseScenario([]byte(`
+name: drill
+steps:
+  - at: 0s
+    probes: {readiness: ok, liveness: "500"}
+  - at: 1m
+    name: errors
+    faults: {errorRate: 0.3}
+  - at: 2m
+    probes: {liveness: fail}
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Steps[0].Name != "step-0" || s.Steps[1].Name != "errors" {
+		t.Errorf("expected default and given step names, got %q and %q", s.Steps[0].Name, s.Steps[1].Name)
+	}
+	if s.Steps[0].probeStatus["liveness"] != 500 || s.Steps[2].probeStatus["liveness"] != http.StatusServiceUnavailable {
+		t.Errorf("unexpected probe statuses %v and %v", s.Steps[0].probeStatus, s.Steps[2].probeStatus)
+	}
+
+	for _, invalid := range []string{
+		`steps: [{at: 0s}]`,
+		`name: empty`,
+		`{name: backwards, steps: [{at: 1m}, {at: 30s}]}`,
+		`{name: probe, steps: [{probes: {warmup: fail}}]}`,
+		`{name: state, steps: [{probes: {readiness: broken}}]}`,
+		`{name: delay, steps: [{delays: {readiness: "-1"}}]}`,
+		`{name: rate, steps: [{faults: {errorRate: 2}}]}`,
+	} {
+		if _, err := parseScenario([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestScenarioTimeline(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(startupProbeDelayEnv, "0")
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(livenessProbeDelayEnv, "0")
+	defer st.scenarios.stop()
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := request(http.MethodPost, "/scenario", `
+name: drill
+steps:
+  - at: 0s
+    probes: {readiness: fail}
+  - at: 100ms
+    delays: {readiness: "1"}
+    faults: {latency: 1ms}
+  - at: 200ms
+    recover: true
+`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodPost, "/scenario", `{"name":"other","steps":[{"at":"0s"}]}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 while running, got %d", w.Code)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if w := request(http.MethodGet, "/readiness", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail in the first step, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/liveness", ""); w.Code != http.StatusOK {
+		t.Errorf("expected liveness to be untouched, got %d", w.Code)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := os.Getenv(readinessProbeDelayEnv); got != "1" {
+		t.Errorf("expected readiness delay 1 in the second step, got %q", got)
+	}
+	if profile := st.runtimeFaults.Load(); profile == nil || profile.Latency != time.Millisecond {
+		t.Errorf("expected the faults of the second step, got %+v", profile)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := os.Getenv(readinessProbeDelayEnv); got != "0" {
+		t.Errorf("expected the readiness delay to be recovered, got %q", got)
+	}
+	if profile := st.runtimeFaults.Load(); profile != nil {
+		t.Errorf("expected the faults to be recovered, got %+v", profile)
+	}
+	if w := request(http.MethodGet, "/readiness", ""); w.Code != http.StatusOK {
+		t.Errorf("expected readiness to be recovered, got %d", w.Code)
+	}
+}
+
+func TestScenarioStop(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(livenessProbeDelayEnv, "0")
+
+	router := web.New()
+	router.POST("/scenario", st.startScenario)
+	router.DELETE("/scenario", st.stopScenario)
+	router.GET("/liveness", st.scenarioProbe("liveness"), st.probeHandler(livenessProbeDelayEnv, "liveness"))
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := request(http.MethodPost, "/scenario", `{"name":"broken"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid scenario, got %d", w.Code)
+	}
+	if w := request(http.MethodPost, "/scenario", `{"name":"outage","steps":[{"at":"0s","probes":{"liveness":"fail"},"faults":{"errorRate":0.1}},{"at":"1h","recover":true}]}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+	if w := request(http.MethodGet, "/liveness", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected liveness to fail, got %d", w.Code)
+	}
+
+	if w := request(http.MethodDelete, "/scenario", ""); w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/liveness", ""); w.Code != http.StatusOK {
+		t.Errorf("expected liveness to be reverted, got %d", w.Code)
+	}
+	if profile := st.runtimeFaults.Load(); profile != nil {
+		t.Errorf("expected the faults to be reverted, got %+v", profile)
+	}
+	if w := request(http.MethodDelete, "/scenario", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without scenario, got %d", w.Code)
+	}
+}
+
+func TestScenarioStatusAndAbort(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+	defer st.scenarios.stop()
+
+	router := web.New()
+	router.POST("/scenario", st.startScenario)
+	router.GET("/scenario/status", st.scenarioStatusHandler)
+	router.POST("/scenario/abort", st.abortScenario)
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := request(http.MethodPost, "/scenario", `{"name":"gameday","steps":[{"at":"0s","name":"baseline"},{"at":"1h","name":"outage","probes":{"readiness":"fail"}}]}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	var status scenarioStatus
+	w := request(http.MethodGet, "/scenario/status", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != scenarioRunning || status.Step != "baseline" || status.NextStep != "outage" {
+		t.Errorf("expected to be in baseline before outage, got %+v", status)
+	}
+	if status.NextInSeconds == nil || *status.NextInSeconds < 3500 || status.ElapsedSeconds <= 0 {
+		t.Errorf("expected the next transition in about an hour, got %+v", status)
+	}
+	if got := testutil.ToFloat64(st.scenarioStepActive.WithLabelValues("gameday", "baseline")); got != 1 {
+		t.Errorf("expected the baseline step to be active, got %v", got)
+	}
+
+	w = request(http.MethodPost, "/scenario/abort?reason=pager+went+off", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var aborted scenarioStatus
+	json.Unmarshal(w.Body.Bytes(), &aborted)
+	if status := aborted; status.State != scenarioAborted || status.Reason != "pager went off" || status.Ended == nil || status.NextStep != "" {
+		t.Errorf("expected an aborted scenario, got %+v", status)
+	}
+	if got := testutil.ToFloat64(st.scenarioStepActive.WithLabelValues("gameday", "baseline")); got != 0 {
+		t.Errorf("expected no active step after the abort, got %v", got)
+	}
+	if got := testutil.ToFloat64(st.scenarioRunsTotal.WithLabelValues("gameday", scenarioAborted)); got != 1 {
+		t.Errorf("expected one aborted run, got %v", got)
+	}
+	if w := request(http.MethodPost, "/scenario/abort", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 without running scenario, got %d", w.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package prober
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"gopkg.in/yaml.v3"
+)
+
+const scenarioLibraryEnv = "SCENARIO_LIBRARY"
+
+// scenarioParameter is an argument of a library scenario, checked against
+// its type: string, duration, number, rate (0 to 1) or probe. Parameters
+// without default are required.
+type scenarioParameter struct {
+	Name        string  `yaml:"name" json:"name"`
+	Type        string  `yaml:"type" json:"type"`
+	Default     *string `yaml:"default" json:"default,omitempty"`
+	Description string  `yaml:"description" json:"description,omitempty"`
+}
+
+func (p scenarioParameter) check(value string) error {
+	switch p.Type {
+	case "string":
+		return nil
+	case "duration":
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("parameter %s must be a duration, got %q", p.Name, value)
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("parameter %s must be a number, got %q", p.Name, value)
+		}
+	case "rate":
+		if rate, err := strconv.ParseFloat(value, 64); err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("parameter %s must be between 0 and 1, got %q", p.Name, value)
+		}
+	case "probe":
+		if value != "startup" && value != "readiness" && value != "liveness" {
+			return fmt.Errorf("parameter %s must be a probe, got %q", p.Name, value)
+		}
+	}
+	return nil
+}
+
+// libraryScenario is a reusable experiment: a scenario whose YAML is a Go
+// template over its parameters, like at: "{{ .grace }}".
+type libraryScenario struct {
+	Name        string              `yaml:"name" json:"name"`
+	Description string              `yaml:"description" json:"description,omitempty"`
+	Parameters  []scenarioParameter `yaml:"parameters" json:"parameters"`
+
+	file     string
+	template *template.Template
+}
+
+func parseLibraryScenario(file string, data []byte) (*libraryScenario, error) {
+	var s libraryScenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Name == "" {
+		return nil, errors.New("scenario has no name")
+	}
+	seen := make(map[string]bool, len(s.Parameters))
+	for i := range s.Parameters {
+		param := &s.Parameters[i]
+		if param.Name == "" || param.Name == "name" || seen[param.Name] {
+			return nil, fmt.Errorf("parameter %d has an invalid or duplicated name %q", i, param.Name)
+		}
+		seen[param.Name] = true
+		if param.Type == "" {
+			param.Type = "string"
+		}
+		switch param.Type {
+		case "string", "duration", "number", "rate", "probe":
+		default:
+			return nil, fmt.Errorf("parameter %s has unknown type %q", param.Name, param.Type)
+		}
+		if param.Default != nil {
+			if err := param.check(*param.Default); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tmpl, err := template.New(file).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	s.file, s.template = file, tmpl
+	return &s, nil
+}
+
+// loadScenarioLibrary reads every YAML file of the directory, rendering the
+// scenarios whose parameters all have defaults to validate them early.
+func loadScenarioLibrary(dir string) (map[string]*libraryScenario, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	library := make(map[string]*libraryScenario, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseLibraryScenario(filepath.Base(file), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if library[s.Name] != nil {
+			return nil, fmt.Errorf("%s: duplicated scenario %q", file, s.Name)
+		}
+		if _, err := s.instantiate(nil); err != nil && !errors.Is(err, errMissingParameter) {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		library[s.Name] = s
+	}
+	return library, nil
+}
+
+var errMissingParameter = errors.New("missing parameter")
+
+// instantiate renders the scenario with the arguments over the defaults.
+func (s *libraryScenario) instantiate(args map[string]string) (scenario, error) {
+	values := make(map[string]string, len(s.Parameters))
+	for _, param := range s.Parameters {
+		value, ok := args[param.Name]
+		if !ok {
+			if param.Default == nil {
+				return scenario{}, fmt.Errorf("%w %s", errMissingParameter, param.Name)
+			}
+			value = *param.Default
+		}
+		if err := param.check(value); err != nil {
+			return scenario{}, err
+		}
+		values[param.Name] = value
+	}
+	for name := range args {
+		if _, ok := values[name]; !ok {
+			return scenario{}, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+
+	var rendered bytes.Buffer
+	if err := s.template.Execute(&rendered, values); err != nil {
+		return scenario{}, err
+	}
+	return parseScenario(rendered.Bytes())
+}
+
+func (st *state) listLibraryScenarios(c *web.Context) {
+	list := make([]*libraryScenario, 0, len(st.scenarioLibrary))
+	for _, s := range st.scenarioLibrary {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, web.H{"scenarios": list})
+}
+
+// runLibraryScenario answers POST /scenario/run?name=drain-test&grace=30s
+// by starting the library scenario with the other query parameters as
+// arguments.
+func (st *state) runLibraryScenario(c *web.Context) {
+	name := c.Query("name")
+	s, ok := st.scenarioLibrary[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, web.H{"error": "Unknown scenario " + name})
+		return
+	}
+	args := make(map[string]string)
+	for key, values := range c.Request.URL.Query() {
+		if key != "name" {
+			args[key] = values[0]
+		}
+	}
+	instance, err := s.instantiate(args)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid scenario arguments", "detail": err.Error()})
+		return
+	}
+	st.startParsedScenario(c, instance)
+}
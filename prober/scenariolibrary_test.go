@@ -0,0 +1,117 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const testDrainScenario = `
+name: drain-test
+description: Fail a probe, then recover after the grace period
+parameters:
+  - name: grace
+    type: duration
+    default: 30s
+  - name: probe
+    type: probe
+    default: readiness
+  - name: errorRate
+    type: rate
+steps:
+  - at: 0s
+    probes: {"{{ .probe }}": fail}
+    faults: {errorRate: {{ .errorRate }}}
+  - at: "{{ .grace }}"
+    recover: true
+`
+
+func TestScenarioLibrary(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(livenessProbeDelayEnv, "0")
+	defer st.scenarios.stop()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "drain.yaml"), []byte(testDrainScenario), 0o600)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a scenario"), 0o600)
+	var err error
+	st.scenarioLibrary, err = loadScenarioLibrary(dir)
+	if err != nil {
+		t.Fatalf("expected a valid library, got %v", err)
+	}
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := request(http.MethodGet, "/scenarios"); !strings.Contains(w.Body.String(), `"name":"drain-test"`) || !strings.Contains(w.Body.String(), `"name":"grace","type":"duration","default":"30s"`) {
+		t.Errorf("unexpected library %s", w.Body.String())
+	}
+	if w := request(http.MethodPost, "/scenario/run?name=unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown scenario, got %d", w.Code)
+	}
+	for _, query := range []string{
+		"name=drain-test",
+		"name=drain-test&errorRate=0.5&grace=soon",
+		"name=drain-test&errorRate=0.5&probe=warmup",
+		"name=drain-test&errorRate=0.5&other=1",
+	} {
+		if w := request(http.MethodPost, "/scenario/run?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, w.Code)
+		}
+	}
+
+	w := request(http.MethodPost, "/scenario/run?name=drain-test&errorRate=0&grace=10m&probe=liveness")
+	var started scenarioStarted
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || w.Code != http.StatusCreated || started.Duration != "10m0s" {
+		t.Fatalf("expected the scenario to start, got %d %s", w.Code, w.Body.String())
+	}
+	waitForStep(t, st, 0)
+	if w := request(http.MethodGet, "/liveness"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the probe argument to fail liveness, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/readiness"); w.Code != http.StatusOK {
+		t.Errorf("expected readiness to be left alone, got %d", w.Code)
+	}
+}
+
+// waitForStep waits for the running scenario to enter the step.
+func waitForStep(t *testing.T, st *state, step int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if status, ok := st.scenarios.status(st.proberClock.Now()); ok && status.StepIndex >= step {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected the scenario to enter step %d", step)
+}
+
+func TestLoadScenarioLibraryErrors(t *testing.T) {
+	for _, content := range []string{
+		"description: no name\nsteps: [{at: 0s}]\n",
+		"name: type\nparameters: [{name: x, type: color}]\nsteps: [{at: 0s}]\n",
+		"name: default\nparameters: [{name: x, type: duration, default: soon}]\nsteps: [{at: 0s}]\n",
+		"name: reserved\nparameters: [{name: name}]\nsteps: [{at: 0s}]\n",
+		"name: template\nsteps: [{at: \"{{ .x \"}]\n",
+		"name: invalid\nparameters: [{name: at, default: 1m}]\nsteps: [{at: \"{{ .at }}\"}, {at: 0s}]\n",
+	} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "scenario.yaml"), []byte(content), 0o600)
+		if _, err := loadScenarioLibrary(dir); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
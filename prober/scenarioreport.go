@@ -0,0 +1,259 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scenarioReportMetrics expose the assertions of the scenario reports.
+type scenarioReportMetrics struct {
+	scenarioAssertionPassed *prometheus.GaugeVec
+}
+
+func newScenarioReportMetrics(registry prometheus.Registerer) scenarioReportMetrics {
+	m := scenarioReportMetrics{
+		scenarioAssertionPassed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scenario_assertion_passed",
+			Help: "Whether the assertion of the last run of the scenario passed.",
+		}, []string{"scenario", "assertion"}),
+	}
+	registry.MustRegister(m.scenarioAssertionPassed)
+	return m
+}
+
+// scenarioAssertion is a pass/fail contract on the requests prober served
+// during a scenario, or one of its steps, like kubelet calling /readiness
+// at least 6 times while it fails. Requests are filtered by method, path,
+// a prefix when ending with *, and status, then counted and timed.
+type scenarioAssertion struct {
+	Name       string        `yaml:"name"`
+	Step       string        `yaml:"step"`
+	Method     string        `yaml:"method"`
+	Path       string        `yaml:"path"`
+	Status     string        `yaml:"status"`
+	MinCount   *int          `yaml:"minCount"`
+	MaxCount   *int          `yaml:"maxCount"`
+	MaxLatency time.Duration `yaml:"maxLatency"`
+
+	// step is the index of the step, -1 for the whole scenario.
+	step                 int
+	statusMin, statusMax int
+}
+
+// validate resolves the step of the assertion and names it after what it
+// checks when no name is given.
+func (a *scenarioAssertion) validate(s scenario) error {
+	if a.MinCount == nil && a.MaxCount == nil && a.MaxLatency <= 0 {
+		return errors.New("assertion must set minCount, maxCount or maxLatency")
+	}
+	if a.MinCount != nil && a.MaxCount != nil && *a.MinCount > *a.MaxCount {
+		return errors.New("assertion minCount is above maxCount")
+	}
+	a.Method = strings.ToUpper(a.Method)
+	if a.Status != "" {
+		min, max, ok := parseStatusRange(a.Status)
+		if !ok {
+			return fmt.Errorf("invalid status range %q", a.Status)
+		}
+		a.statusMin, a.statusMax = min, max
+	}
+	a.step = -1
+	if a.Step != "" {
+		for i, step := range s.Steps {
+			if step.Name == a.Step {
+				a.step = i
+			}
+		}
+		if a.step < 0 {
+			return fmt.Errorf("assertion on unknown step %q", a.Step)
+		}
+	}
+
+	if a.Name == "" {
+		var parts []string
+		for _, part := range []string{a.Method, a.Path, a.Status} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "requests")
+		}
+		if a.MinCount != nil {
+			parts = append(parts, ">= "+strconv.Itoa(*a.MinCount))
+		}
+		if a.MaxCount != nil {
+			parts = append(parts, "<= "+strconv.Itoa(*a.MaxCount))
+		}
+		if a.MaxLatency > 0 {
+			parts = append(parts, "within "+a.MaxLatency.String())
+		}
+		if a.Step != "" {
+			parts = append(parts, "in "+a.Step)
+		}
+		a.Name = strings.Join(parts, " ")
+	}
+	return nil
+}
+
+func (a *scenarioAssertion) matches(record requestRecord, step int) bool {
+	if a.step >= 0 && a.step != step {
+		return false
+	}
+	if a.Method != "" && a.Method != record.Method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(a.Path, "*"); ok {
+		if !strings.HasPrefix(record.Path, prefix) {
+			return false
+		}
+	} else if a.Path != "" && a.Path != record.Path {
+		return false
+	}
+	return a.Status == "" || (record.Status >= a.statusMin && record.Status <= a.statusMax)
+}
+
+// assertionTally is what was observed of the requests matching an
+// assertion.
+type assertionTally struct {
+	count   int
+	slowest time.Duration
+	slow    int
+}
+
+type scenarioAssertionResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Count   int    `json:"count"`
+	Slowest string `json:"slowest,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (a *scenarioAssertion) result(tally assertionTally) scenarioAssertionResult {
+	result := scenarioAssertionResult{Name: a.Name, Passed: true, Count: tally.count}
+	if tally.count > 0 {
+		result.Slowest = tally.slowest.String()
+	}
+	var failures []string
+	if a.MinCount != nil && tally.count < *a.MinCount {
+		failures = append(failures, fmt.Sprintf("%d requests, expected at least %d", tally.count, *a.MinCount))
+	}
+	if a.MaxCount != nil && tally.count > *a.MaxCount {
+		failures = append(failures, fmt.Sprintf("%d requests, expected at most %d", tally.count, *a.MaxCount))
+	}
+	if a.MaxLatency > 0 && tally.slow > 0 {
+		failures = append(failures, fmt.Sprintf("%d requests slower than %v", tally.slow, a.MaxLatency))
+	}
+	if len(failures) > 0 {
+		result.Passed, result.Detail = false, strings.Join(failures, ", ")
+	}
+	return result
+}
+
+// scenarioReport tells whether the assertions of a scenario held, final
+// once the scenario ended.
+type scenarioReport struct {
+	Scenario   string                    `json:"scenario"`
+	State      string                    `json:"state"`
+	Reason     string                    `json:"reason,omitempty"`
+	Started    time.Time                 `json:"started"`
+	Ended      *time.Time                `json:"ended,omitempty"`
+	Passed     bool                      `json:"passed"`
+	Assertions []scenarioAssertionResult `json:"assertions"`
+}
+
+// stepAt returns the index of the step the scenario was in at t, -1
+// before the first one.
+func (s *scenario) stepAt(started time.Time, t time.Time) int {
+	step := -1
+	for i, candidate := range s.Steps {
+		if !t.Before(started.Add(candidate.At)) {
+			step = i
+		}
+	}
+	return step
+}
+
+// observe tallies a request served while the scenario runs, in the step
+// it ended in.
+func (e *scenarioEngine) observe(record requestRecord, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state != scenarioRunning || len(e.scenario.Assertions) == 0 {
+		return
+	}
+	step := e.scenario.stepAt(e.started, e.st.proberClock.Now())
+	for i, assertion := range e.scenario.Assertions {
+		if !assertion.matches(record, step) {
+			continue
+		}
+		tally := &e.tallies[i]
+		tally.count++
+		tally.slowest = max(tally.slowest, latency)
+		if assertion.MaxLatency > 0 && latency > assertion.MaxLatency {
+			tally.slow++
+		}
+	}
+}
+
+// report must be called with the lock held.
+func (e *scenarioEngine) report() scenarioReport {
+	report := scenarioReport{
+		Scenario:   e.scenario.Name,
+		State:      e.state,
+		Reason:     e.reason,
+		Started:    e.started,
+		Passed:     true,
+		Assertions: make([]scenarioAssertionResult, 0, len(e.scenario.Assertions)),
+	}
+	if !e.ended.IsZero() {
+		ended := e.ended
+		report.Ended = &ended
+	}
+	for i, assertion := range e.scenario.Assertions {
+		result := assertion.result(e.tallies[i])
+		report.Passed = report.Passed && result.Passed
+		report.Assertions = append(report.Assertions, result)
+	}
+	return report
+}
+
+// publishReport logs the final report and exports its assertions. It must
+// be called with the lock held.
+func (e *scenarioEngine) publishReport() {
+	if len(e.scenario.Assertions) == 0 {
+		return
+	}
+	report := e.report()
+	for _, result := range report.Assertions {
+		passed := 0.0
+		if result.Passed {
+			passed = 1
+		}
+		e.st.scenarioAssertionPassed.WithLabelValues(report.Scenario, result.Name).Set(passed)
+		if !result.Passed {
+			slog.Warn("Scenario assertion failed", "scenario", report.Scenario, "assertion", result.Name, "detail", result.Detail)
+		}
+	}
+	slog.Info("Scenario report", "scenario", report.Scenario, "state", report.State, "passed", report.Passed, "assertions", len(report.Assertions))
+}
+
+// scenarioReportHandler answers GET /scenario/report with the assertions
+// of the last scenario, evaluated so far while it runs.
+func (st *state) scenarioReportHandler(c *web.Context) {
+	st.scenarios.mu.Lock()
+	defer st.scenarios.mu.Unlock()
+	if st.scenarios.scenario == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "No scenario started"})
+		return
+	}
+	c.JSON(http.StatusOK, st.scenarios.report())
+}
@@ -0,0 +1,126 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseScenarioAssertions(t *testing.T) {
+	s, err := parseScenario([]byte(`
+name: drill
+steps:
+  - at: 0s
+  - at: 1m
+    name: degraded
+assertions:
+  - step: degraded
+    path: /readiness
+    minCount: 6
+  - name: fast delays
+    path: /delay/*
+    maxLatency: 5s
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Assertions[0].step != 1 || s.Assertions[0].Name != "/readiness >= 6 in degraded" || s.Assertions[1].step != -1 {
+		t.Errorf("unexpected assertions %+v and %+v", s.Assertions[0], s.Assertions[1])
+	}
+
+	for _, invalid := range []string{
+		`{name: nothing, steps: [{at: 0s}], assertions: [{path: /readiness}]}`,
+		`{name: step, steps: [{at: 0s}], assertions: [{step: unknown, minCount: 1}]}`,
+		`{name: status, steps: [{at: 0s}], assertions: [{status: 7xx, minCount: 1}]}`,
+		`{name: bounds, steps: [{at: 0s}], assertions: [{minCount: 2, maxCount: 1}]}`,
+	} {
+		if _, err := parseScenario([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestScenarioReport(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(startupProbeDelayEnv, "0")
+	t.Setenv(readinessProbeDelayEnv, "0")
+	t.Setenv(livenessProbeDelayEnv, "0")
+	defer st.scenarios.stop()
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := request(http.MethodPost, "/scenario", `
+name: report
+steps:
+  - at: 0s
+    name: healthy
+  - at: 150ms
+    name: degraded
+    probes: {readiness: fail}
+  - at: 300ms
+    recover: true
+assertions:
+  - name: readiness polled while degraded
+    step: degraded
+    path: /readiness
+    status: 5xx
+    minCount: 2
+  - name: no liveness
+    path: /liveness
+    maxCount: 0
+  - name: fast delays
+    path: /delay/*
+    maxLatency: 1s
+`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d %s", w.Code, w.Body.String())
+	}
+	request(http.MethodGet, "/readiness", "")
+	request(http.MethodGet, "/delay/0", "")
+	time.Sleep(200 * time.Millisecond)
+	request(http.MethodGet, "/readiness", "")
+	request(http.MethodGet, "/readiness", "")
+	request(http.MethodGet, "/liveness", "")
+	time.Sleep(200 * time.Millisecond)
+
+	w = request(http.MethodGet, "/scenario/report", "")
+	var report scenarioReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("expected a report, got %d %s", w.Code, w.Body.String())
+	}
+	if report.State != scenarioCompleted || report.Passed || len(report.Assertions) != 3 {
+		t.Fatalf("expected a failed completed report, got %+v", report)
+	}
+	if result := report.Assertions[0]; !result.Passed || result.Count != 2 {
+		t.Errorf("expected the readiness assertion to pass with 2 requests, got %+v", result)
+	}
+	if result := report.Assertions[1]; result.Passed || result.Count != 1 || result.Detail != "1 requests, expected at most 0" {
+		t.Errorf("expected the liveness assertion to fail, got %+v", result)
+	}
+	if result := report.Assertions[2]; !result.Passed || result.Count != 1 {
+		t.Errorf("expected the delay assertion to pass, got %+v", result)
+	}
+	if value := testutil.ToFloat64(st.scenarioAssertionPassed.WithLabelValues("report", "no liveness")); value != 0 {
+		t.Errorf("expected the failed assertion to be exported, got %v", value)
+	}
+
+	// Requests after the scenario ended are not counted
+	request(http.MethodGet, "/liveness", "")
+	w = request(http.MethodGet, "/scenario/report", "")
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.Assertions[1].Count != 1 {
+		t.Errorf("expected the report to be final, got %+v", report.Assertions[1])
+	}
+}
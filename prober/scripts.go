@@ -0,0 +1,410 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.starlark.net/lib/json"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	scriptsConfigEnv = "SCRIPTS_CONFIG"
+
+	defaultScriptTimeout = 10 * time.Second
+	maxScriptBodyBytes   = 1 << 20
+	scriptContextKey     = "context"
+	// scriptStateKey holds the state of the server running the script.
+	scriptStateKey = "state"
+)
+
+// scriptMetrics count the runs of the scripted routes.
+type scriptMetrics struct {
+	scriptRunsTotal *prometheus.CounterVec
+}
+
+func newScriptMetrics(registry prometheus.Registerer) scriptMetrics {
+	m := scriptMetrics{
+		scriptRunsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "script_runs_total",
+			Help: "Runs of the scripted routes by route and result.",
+		}, []string{"route", "result"}),
+	}
+	registry.MustRegister(m.scriptRunsTotal)
+	return m
+}
+
+// routePattern matches request paths against a route template like the
+// ones of gin: ":name" matches a segment and "*name" the rest of the path.
+type routePattern []string
+
+func parseRoutePattern(path string) (routePattern, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "*") && i != len(segments)-1 {
+			return nil, fmt.Errorf("path %q has a wildcard before its last segment", path)
+		}
+	}
+	return segments, nil
+}
+
+// match returns the parameters of the path when it matches.
+func (p routePattern) match(path string) (map[string]string, bool) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	params := make(map[string]string)
+	for i, pattern := range p {
+		if strings.HasPrefix(pattern, "*") {
+			params[pattern[1:]] = "/" + strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(pattern, ":"):
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[pattern[1:]] = segments[i]
+		case pattern != segments[i]:
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(p)
+}
+
+// scriptRoute is a route whose behavior is a Starlark script defining
+// handle(request), for the logic no declarative setting covers.
+type scriptRoute struct {
+	Method  string        `yaml:"method"`
+	Path    string        `yaml:"path"`
+	File    string        `yaml:"file"`
+	Source  string        `yaml:"source"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	pattern routePattern
+	handle  starlark.Value
+}
+
+type scriptsFile struct {
+	Scripts []*scriptRoute `yaml:"scripts"`
+}
+
+var responseConstructor = starlark.String("response")
+
+// scriptBuiltins are predeclared in every script, next to the json and
+// time modules.
+var scriptBuiltins = starlark.StringDict{
+	"json":      json.Module,
+	"time":      startime.Module,
+	"response":  starlark.NewBuiltin("response", scriptResponse),
+	"sleep":     starlark.NewBuiltin("sleep", scriptSleep),
+	"random":    starlark.NewBuiltin("random", scriptRandom),
+	"config":    starlark.NewBuiltin("config", scriptConfig),
+	"counter":   starlark.NewBuiltin("counter", scriptCounter),
+	"increment": starlark.NewBuiltin("increment", scriptIncrement),
+}
+
+// loadScriptsConfig compiles every script, the files being relative to the
+// configuration.
+func loadScriptsConfig(path string) ([]*scriptRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file scriptsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	for i, route := range file.Scripts {
+		if route == nil {
+			return nil, fmt.Errorf("script %d is empty", i)
+		}
+		if route.Method == "" {
+			route.Method = http.MethodGet
+		}
+		route.Method = strings.ToUpper(route.Method)
+		if route.Timeout <= 0 {
+			route.Timeout = defaultScriptTimeout
+		}
+		if route.pattern, err = parseRoutePattern(route.Path); err != nil {
+			return nil, err
+		}
+
+		name, source := route.Path, route.Source
+		switch {
+		case route.File != "" && route.Source != "":
+			return nil, fmt.Errorf("script %q must set one of file or source", route.Path)
+		case route.File != "":
+			name = route.File
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(filepath.Dir(path), name)
+			}
+			content, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			source = string(content)
+		case route.Source == "":
+			return nil, fmt.Errorf("script %q has no source", route.Path)
+		}
+		if err := route.compile(name, source); err != nil {
+			return nil, err
+		}
+	}
+	return file.Scripts, nil
+}
+
+// compile runs the top level of the script once. Its globals are frozen so
+// the requests can call handle concurrently.
+func (r *scriptRoute) compile(name string, source string) error {
+	thread := &starlark.Thread{Name: "load " + name}
+	globals, err := starlark.ExecFile(thread, name, source, scriptBuiltins)
+	if err != nil {
+		return err
+	}
+	globals.Freeze()
+	handle, ok := globals["handle"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("script %q does not define handle(request)", name)
+	}
+	r.handle = handle
+	return nil
+}
+
+func stringDict(values map[string]string) *starlark.Dict {
+	dict := starlark.NewDict(len(values))
+	for key, value := range values {
+		dict.SetKey(starlark.String(key), starlark.String(value))
+	}
+	return dict
+}
+
+// scriptRequest exposes the request to the script, header names in lower
+// case with their first value.
+func scriptRequest(c *web.Context, params map[string]string, body []byte) *starlarkstruct.Struct {
+	headers := make(map[string]string, len(c.Request.Header))
+	for name := range c.Request.Header {
+		headers[strings.ToLower(name)] = c.Request.Header.Get(name)
+	}
+	query := make(map[string]string)
+	for name := range c.Request.URL.Query() {
+		query[name] = c.Query(name)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"method":      starlark.String(c.Request.Method),
+		"path":        starlark.String(c.Request.URL.Path),
+		"params":      stringDict(params),
+		"query":       stringDict(query),
+		"headers":     stringDict(headers),
+		"body":        starlark.String(body),
+		"remote_addr": starlark.String(c.ClientIP()),
+	})
+}
+
+// scriptResponse is response(status=200, body="", headers={}, json=None).
+func scriptResponse(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	status := starlark.MakeInt(http.StatusOK)
+	var body starlark.String
+	headers := starlark.NewDict(0)
+	var value starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "status?", &status, "body?", &body, "headers?", &headers, "json?", &value); err != nil {
+		return nil, err
+	}
+	if value != starlark.None {
+		encoded, err := starlark.Call(thread, json.Module.Members["encode"], starlark.Tuple{value}, nil)
+		if err != nil {
+			return nil, err
+		}
+		body = encoded.(starlark.String)
+		if _, found, _ := headers.Get(starlark.String("content-type")); !found {
+			withType := starlark.NewDict(headers.Len() + 1)
+			for _, item := range headers.Items() {
+				withType.SetKey(item[0], item[1])
+			}
+			withType.SetKey(starlark.String("content-type"), starlark.String("application/json"))
+			headers = withType
+		}
+	}
+	return starlarkstruct.FromStringDict(responseConstructor, starlark.StringDict{
+		"status":  status,
+		"body":    body,
+		"headers": headers,
+	}), nil
+}
+
+// scriptSleep is sleep(seconds), cut short when the request ends.
+func scriptSleep(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seconds starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &seconds); err != nil {
+		return nil, err
+	}
+	value, ok := starlark.AsFloat(seconds)
+	if !ok || value < 0 {
+		return nil, fmt.Errorf("%s: invalid duration %v", fn.Name(), seconds)
+	}
+	ctx, _ := thread.Local(scriptContextKey).(context.Context)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	st := thread.Local(scriptStateKey).(*state)
+	if err := st.sleepRequest(ctx, time.Duration(value*float64(time.Second))); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// scriptRandom is random(), drawing from the seeded source of prober.
+func scriptRandom(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	st := thread.Local(scriptStateKey).(*state)
+	return starlark.Float(st.random.Float64()), nil
+}
+
+// scriptCounter is counter(name), the value of a counter.
+func scriptCounter(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	st := thread.Local(scriptStateKey).(*state)
+	return starlark.MakeInt64(st.counters.get(name)), nil
+}
+
+// scriptIncrement is increment(name, by = 1), returning the new value.
+func scriptIncrement(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	by := 1
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "by?", &by); err != nil {
+		return nil, err
+	}
+	st := thread.Local(scriptStateKey).(*state)
+	return starlark.MakeInt64(st.counters.add(name, int64(by))), nil
+}
+
+// scriptConfig is config(), the runtime settings of prober: the probe
+// delays, the runtime faults and the scenario.
+func scriptConfig(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	st := thread.Local(scriptStateKey).(*state)
+	probes := stringDict(map[string]string{
+		"startup":   st.config.get(startupProbeDelayEnv),
+		"readiness": st.config.get(readinessProbeDelayEnv),
+		"liveness":  st.config.get(livenessProbeDelayEnv),
+	})
+	faults := starlark.NewDict(4)
+	if profile := st.runtimeFaults.Load(); profile != nil {
+		faults.SetKey(starlark.String("latency"), starlark.Float(profile.Latency.Seconds()))
+		faults.SetKey(starlark.String("error_rate"), starlark.Float(profile.ErrorRate))
+		faults.SetKey(starlark.String("error_status"), starlark.MakeInt(profile.ErrorStatus))
+		faults.SetKey(starlark.String("reset_rate"), starlark.Float(profile.ResetRate))
+	}
+	scenario := starlark.NewDict(3)
+	if status, ok := st.scenarios.status(st.proberClock.Now()); ok {
+		scenario = stringDict(map[string]string{"name": status.Scenario, "state": status.State, "step": status.Step})
+	}
+	return starlarkstruct.FromStringDict(starlark.String("config"), starlark.StringDict{
+		"probes":   probes,
+		"faults":   faults,
+		"scenario": scenario,
+	}), nil
+}
+
+// run calls handle(request) and writes what it returns: a response(), a
+// string answered with 200, or None answered with 204.
+func (r *scriptRoute) run(st *state, c *web.Context, params map[string]string) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxScriptBodyBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), r.Timeout)
+	defer cancel()
+	thread := &starlark.Thread{Name: r.Method + " " + r.Path}
+	thread.SetLocal(scriptContextKey, ctx)
+	thread.SetLocal(scriptStateKey, st)
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	result, err := starlark.Call(thread, r.handle, starlark.Tuple{scriptRequest(c, params, body)}, nil)
+	if err != nil {
+		st.scriptRunsTotal.WithLabelValues(r.Path, "error").Inc()
+		status := http.StatusInternalServerError
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		c.JSON(status, web.H{"error": "Script failed", "detail": err.Error()})
+		return
+	}
+	st.scriptRunsTotal.WithLabelValues(r.Path, "success").Inc()
+
+	switch value := result.(type) {
+	case starlark.NoneType:
+		c.Status(http.StatusNoContent)
+		return
+	case starlark.String:
+		c.String(http.StatusOK, string(value))
+		return
+	case *starlarkstruct.Struct:
+		if value.Constructor() != responseConstructor {
+			break
+		}
+		statusValue, _ := value.Attr("status")
+		status, err := starlark.AsInt32(statusValue)
+		if err != nil || status < 100 || status > 599 {
+			c.JSON(http.StatusInternalServerError, web.H{"error": "Script returned an invalid status"})
+			return
+		}
+		headers, _ := value.Attr("headers")
+		if dict, ok := headers.(*starlark.Dict); ok {
+			for _, item := range dict.Items() {
+				name, _ := starlark.AsString(item[0])
+				header, _ := starlark.AsString(item[1])
+				c.Header(name, header)
+			}
+		}
+		bodyValue, _ := value.Attr("body")
+		responseBody, _ := starlark.AsString(bodyValue)
+		c.Status(status)
+		c.Writer.WriteString(responseBody)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, web.H{"error": "Script returned an invalid response", "detail": result.Type()})
+}
+
+// customRoutes answers the requests no built-in route matched with the
+// first matching scripted route, then the first matching mock, built-in
+// routes always winning.
+func (st *state) customRoutes(c *web.Context) {
+	for _, route := range st.customScripts {
+		if route.Method != c.Request.Method && route.Method != "ANY" {
+			continue
+		}
+		if params, ok := route.pattern.match(c.Request.URL.Path); ok {
+			route.run(st, c, params)
+			return
+		}
+	}
+	st.serveMocks(c)
+}
@@ -0,0 +1,155 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestRoutePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+		params  map[string]string
+	}{
+		{"/orders", "/orders", true, map[string]string{}},
+		{"/orders", "/orders/1", false, nil},
+		{"/orders/:id", "/orders/42", true, map[string]string{"id": "42"}},
+		{"/orders/:id", "/orders/", false, nil},
+		{"/files/*path", "/files/a/b.txt", true, map[string]string{"path": "/a/b.txt"}},
+		{"/files/*path", "/other/a", false, nil},
+	}
+	for _, test := range tests {
+		pattern, err := parseRoutePattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, ok := pattern.match(test.path)
+		if ok != test.match {
+			t.Errorf("%s %s: expected match %v, got %v", test.pattern, test.path, test.match, ok)
+			continue
+		}
+		for key, value := range test.params {
+			if params[key] != value {
+				t.Errorf("%s %s: expected %s=%s, got %v", test.pattern, test.path, key, value, params)
+			}
+		}
+	}
+	if _, err := parseRoutePattern("/*all/more"); err == nil {
+		t.Errorf("expected a wildcard before the last segment to fail")
+	}
+}
+
+func TestScriptedRoutes(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "orders.star"), []byte(`
+def handle(request):
+    if request.headers.get("x-fail") == "yes":
+        return response(status = 503, body = "failing on request", headers = {"retry-after": "5"})
+    return response(json = {"id": request.params["id"], "delay": config().probes["readiness"]})
+`), 0o600)
+	path := filepath.Join(dir, "scripts.yaml")
+	os.WriteFile(path, []byte(`
+scripts:
+  - path: /orders/:id
+    file: orders.star
+  - path: /slow
+    method: post
+    timeout: 100ms
+    source: |
+      def handle(request):
+          sleep(1)
+          return "too late"
+  - path: /echo/*rest
+    source: |
+      def handle(request):
+          return request.params["rest"] + "?" + request.query.get("q", "")
+  - path: /readiness
+    source: |
+      def handle(request):
+          return None
+  - path: /count
+    source: |
+      def handle(request):
+          increment("scripted", by = 2)
+          return str(counter("scripted"))
+  - path: /broken
+    source: |
+      def handle(request):
+          return 1 // 0
+`), 0o600)
+
+	var err error
+	st.customScripts, err = loadScriptsConfig(path)
+	if err != nil {
+		t.Fatalf("expected valid scripts, got %v", err)
+	}
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set("X-Fail", header)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/orders/42", "")
+	var order map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil || w.Code != http.StatusOK || order["id"] != "42" || order["delay"] != "0" {
+		t.Errorf("expected the JSON order, got %d %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/orders/42", "yes"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("expected the scripted failure, got %d %v", w.Code, w.Header())
+	}
+	if w := request(http.MethodGet, "/echo/a/b?q=c", ""); w.Body.String() != "/a/b?c" {
+		t.Errorf("expected the wildcard and query, got %q", w.Body.String())
+	}
+
+	start := time.Now()
+	if w := request(http.MethodPost, "/slow", ""); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 after the timeout, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the sleep to be cut short, took %v", elapsed)
+	}
+	if w := request(http.MethodGet, "/slow", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for another method, got %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/broken", ""); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "division by zero") {
+		t.Errorf("expected the script error, got %d %s", w.Code, w.Body.String())
+	}
+	request(http.MethodGet, "/count", "")
+	if w := request(http.MethodGet, "/count", ""); w.Body.String() != "4" {
+		t.Errorf("expected the counter to be shared between runs, got %q", w.Body.String())
+	}
+	if w := request(http.MethodGet, "/readiness", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "readiness") {
+		t.Errorf("expected the built-in route to win, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoadScriptsConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"scripts:\n  - path: /none\n",
+		"scripts:\n  - path: nohandle\n    source: \"x = 1\"\n",
+		"scripts:\n  - path: /nohandle\n    source: \"x = 1\"\n",
+		"scripts:\n  - path: /syntax\n    source: \"def handle(:\"\n",
+	} {
+		if _, err := loadScriptsConfig(writeChecksConfig(t, config)); err == nil {
+			t.Errorf("expected an error for %q", config)
+		}
+	}
+}
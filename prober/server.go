@@ -0,0 +1,844 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	startupProbeDelayEnv   = "STARTUP_PROBE_DELAY"
+	readinessProbeDelayEnv = "READINESS_PROBE_DELAY"
+	livenessProbeDelayEnv  = "LIVENESS_PROBE_DELAY"
+)
+
+type configs struct {
+	Startup   string `json:"startup"`
+	Readiness string `json:"readiness"`
+	Liveness  string `json:"liveness"`
+}
+
+func (st *state) probeDelay(probeEnv string) time.Duration {
+	probeDelay, exists := st.config.lookup(probeEnv)
+	if !exists {
+		return 0
+	}
+	delay, err := strconv.ParseInt(probeDelay, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid delay value", "env", probeEnv, "error", err)
+		return 0
+	}
+	return time.Duration(delay) * time.Second
+}
+
+func (st *state) probeHandler(probeEnv string, message string) web.HandlerFunc {
+	return func(c *web.Context) {
+		if st.sleepRequest(c.Request.Context(), st.probeDelay(probeEnv)) != nil {
+			return
+		}
+		c.JSON(http.StatusOK, web.H{"message": message})
+	}
+}
+
+func (st *state) postConfigs(c *web.Context) {
+	var newConfigs configs
+
+	if err := c.BindJSON(&newConfigs); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid JSON"})
+		return
+	}
+
+	st.config.set(startupProbeDelayEnv, newConfigs.Startup)
+	st.config.set(readinessProbeDelayEnv, newConfigs.Readiness)
+	st.config.set(livenessProbeDelayEnv, newConfigs.Liveness)
+	st.configChangesTotal.Inc()
+
+	c.JSON(http.StatusCreated, newConfigs)
+}
+
+func (st *state) delayRequest(c *web.Context) {
+	delay, err := strconv.ParseInt(c.Param("seconds"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid delay value"})
+		return
+	}
+	if st.sleepRequest(c.Request.Context(), time.Duration(delay)*time.Second) != nil {
+		return
+	}
+	c.JSON(http.StatusOK, web.H{"message": delay})
+}
+
+func (st *state) graceDelayRequest(c *web.Context) {
+	delay, err := strconv.ParseInt(c.Param("seconds"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid delay value"})
+		return
+	}
+	var delayInc int64 = 0
+
+	shutdown := st.serverShutdown.done()
+wait:
+	for delayInc < delay {
+		select {
+		case <-time.After(time.Second):
+			delayInc++
+		case <-shutdown:
+			st.requestsCancelledOnShutdown.Inc()
+			break wait
+		}
+	}
+
+	c.JSON(http.StatusOK, web.H{"message": delayInc})
+}
+
+func (st *state) newRouter(reloader *certReloader, listener listenerConfig) *web.Engine {
+	router := web.New()
+	router.Use(st.inFlight(), recovery(), st.accessLog(), st.metricsMiddleware(), st.recordRequests(st.recentRequests, listener.Name))
+	cors := loadCORSConfig(st.config)
+	if cors.enabled() {
+		router.Use(corsMiddleware(cors))
+	}
+	if st.injectedHeaders != nil {
+		router.Use(st.injectedHeaders.middleware())
+	}
+	if st.requestBodyLimits != nil {
+		router.Use(st.requestBodyLimits.middleware(st.bodyLimitMetrics))
+	}
+	if st.trafficRecording != nil {
+		router.Use(st.trafficRecording.middleware())
+	}
+	router.Use(st.controlAccess())
+	if len(listener.Routes) > 0 {
+		router.Use(routeFilter(listener.Routes))
+	}
+	if listener.Faults.enabled() {
+		router.Use(st.faultMiddleware(listener.Name, listener.Faults))
+	}
+	router.Use(st.runtimeFaultMiddleware())
+	router.Use(st.splitMiddleware())
+	if skew := loadClockSkew(st.config); skew != 0 {
+		router.Use(clockSkewMiddleware(skew))
+	}
+
+	// Probes
+	router.GET("/startup", st.stressProbe(), st.scenarioProbe("startup"), st.relayProbe("startup"), st.probeHandler(startupProbeDelayEnv, "startup"))
+	router.GET("/readiness", st.stressProbe(), st.terminationReadiness(), st.scenarioProbe("readiness"), st.readinessGate(), st.leaderReadiness(), st.relayProbe("readiness"), st.probeHandler(readinessProbeDelayEnv, "readiness"))
+	router.GET("/liveness", st.stressProbe(), st.scenarioProbe("liveness"), st.relayProbe("liveness"), st.probeHandler(livenessProbeDelayEnv, "liveness"))
+	// Config
+	router.POST("/config", st.postConfigs)
+	router.GET("/config/logging", getLoggingConfig)
+	router.POST("/config/logging", st.postLoggingConfig)
+	router.GET("/config/split", st.getSplitConfig)
+	router.POST("/config/split", st.postSplitConfig)
+	router.DELETE("/config/split", st.deleteSplitConfig)
+
+	// Scenarios
+	router.POST("/scenario", st.startScenario)
+	router.DELETE("/scenario", st.stopScenario)
+	router.GET("/scenario/status", st.scenarioStatusHandler)
+	router.GET("/scenario/report", st.scenarioReportHandler)
+	router.POST("/scenario/abort", st.abortScenario)
+	router.GET("/scenarios", st.listLibraryScenarios)
+	router.POST("/scenario/run", st.runLibraryScenario)
+	router.GET("/chaos", st.chaosHandler)
+
+	// Request Delay
+	router.GET("/delay/:seconds", st.delayRequest)
+	router.GET("/graceDelay/:seconds", st.graceDelayRequest)
+	router.GET("/longpoll", st.longPollHandler)
+	router.POST("/longpoll/release", st.releaseLongPolls)
+
+	// Request Inspection
+	router.Any("/echo", st.echoRequest)
+	router.GET("/bytes/:n", bytesRequest)
+	router.GET("/status/:code", statusRequest)
+	router.GET("/ip", ipRequest)
+	router.GET("/requests", requestsHandler(st.recentRequests))
+	router.GET("/trace", traceRequest)
+	router.GET("/cors", corsHandler(cors))
+	router.Any("/idempotency", st.idempotencyHandler)
+	router.DELETE("/idempotency/keys", st.resetIdempotencyKeys)
+	router.GET("/idempotency/keys/:key", st.idempotencyKeyHandler)
+
+	// Outbound checks
+	router.GET("/checks", st.checksHandler)
+	router.GET("/checks/:name/history", st.checkHistoryHandler)
+	router.GET("/checks/:name/summary", st.checkSummaryHandler)
+	router.GET("/resolve/:host", resolveHandler(resolvConfPath))
+	router.GET("/connect/:host/:port", connectRequest)
+	router.GET("/tlscheck", tlsCheckHandler(nil))
+	router.POST("/egress/run", st.egressRunHandler)
+	router.GET("/probe", blackboxProbe(st.probeModules))
+
+	// Replica mesh
+	router.GET("/mesh", st.meshHandler)
+	router.GET("/mesh/ping", st.meshPingHandler)
+	router.GET("/mesh/matrix", st.meshMatrixHandler)
+
+	// Bandwidth between probers
+	router.GET("/bandwidth/download", bandwidthDownload)
+	router.POST("/bandwidth/upload", bandwidthUpload)
+	router.POST("/bandwidth/run", bandwidthRun)
+
+	// TLS
+	router.GET("/tls/info", tlsInfoHandler(reloader))
+
+	// Pod
+	router.GET("/podinfo", st.podInfoHandler)
+	router.GET("/topology", st.topologyHandler)
+	router.GET("/time", st.timeHandler)
+	router.GET("/clock", st.clockHandler)
+	router.POST("/clock/advance", st.advanceClock)
+	router.GET("/serviceaccount", st.serviceAccountHandler)
+	router.GET("/jwt", st.jwtHandler)
+	router.GET("/protected", st.protectedHandler)
+	router.GET(oidcCallbackPath, st.oidcCallbackHandler)
+	router.GET("/oidc/logout", st.oidcLogoutHandler)
+	router.GET("/termination", st.terminationHandler)
+	router.GET("/leader", st.leaderHandler)
+	router.GET("/proberconfig", st.proberConfigHandler)
+	router.GET("/resources", st.resourcesHandler)
+	router.POST("/resources/burn", st.burnHandler)
+	router.GET("/compress", st.cached(), st.compressHandler)
+	router.GET("/load", st.getLoad)
+	router.POST("/load", st.startLoad)
+	router.DELETE("/load", st.stopLoad)
+
+	// Build and self health
+	router.GET("/version", st.versionRequest)
+	router.GET("/healthz", st.healthzHandler())
+
+	// Mocks
+	router.GET("/mocks", st.listMocks)
+	router.POST("/mocks", st.putMock)
+	router.DELETE("/mocks/:name", st.deleteMock)
+
+	// Counters
+	router.GET("/counters", st.listCounters)
+	router.DELETE("/counters", st.resetCounters)
+	router.GET("/counters/:name", st.getCounter)
+	router.POST("/counters/:name/increment", st.incrementCounter)
+	router.POST("/counters/:name/reset", st.resetCounter)
+
+	// Response cache
+	router.GET("/cache", st.cacheHandler)
+	router.DELETE("/cache", st.purgeCache)
+
+	// Scripted and mock routes, behind the built-in ones
+	router.NoRoute(st.customRoutes)
+
+	// Metrics, unless served on their own listener
+	if st.config.get(metricsAddrEnv) == "" {
+		router.GET("/metrics", st.metricsHandler())
+	}
+
+	return router
+}
+
+// Options configure a Server. ParseFlags reads them from the command line,
+// each defaulting to an environment variable.
+type Options struct {
+	// Config holds the other settings by environment variable name, like
+	// CHECKS_CONFIG or READINESS_PROBE_DELAY. When nil, they are read from
+	// the environment, which the changes made through /config then update.
+	Config map[string]string
+
+	// Seed fixes the randomized behaviors when Seeded, neither --seed nor
+	// RANDOM_SEED being set otherwise.
+	Seed   int64
+	Seeded bool
+
+	// OpenAPI is a spec whose paths are served with stub responses, delayed
+	// by OpenAPILatency and failed with a 503 at OpenAPIErrorRate.
+	OpenAPI          string
+	OpenAPILatency   time.Duration
+	OpenAPIErrorRate float64
+
+	// LogLevel and LogFormat configure the logger of the serve command.
+	LogLevel  string
+	LogFormat string
+}
+
+func (o Options) openAPIFaults() faultProfile {
+	return faultProfile{Latency: o.OpenAPILatency, ErrorRate: o.OpenAPIErrorRate}
+}
+
+// ParseFlags parses the server flags: --seed, --log-level, --log-format,
+// --openapi, --openapi-latency and --openapi-error-rate.
+func ParseFlags(args []string, stderr io.Writer) (Options, error) {
+	return parseServerFlags("serve", args, stderr)
+}
+
+// parseServerFlags parses the server flags of the serve and validate-config
+// commands, which default to their environment variables.
+func parseServerFlags(name string, args []string, stderr io.Writer) (Options, error) {
+	var parsed Options
+	flags := newFlagSet(name, stderr)
+	seed := flags.String("seed", os.Getenv(randomSeedEnv), envUsage("seed of the randomized behaviors, for reproducible runs", randomSeedEnv))
+	parsed.LogLevel, parsed.LogFormat = os.Getenv(logLevelEnv), os.Getenv(logFormatEnv)
+	flags.Func("log-level", envUsage("minimum log level: debug, info, warn or error", logLevelEnv), logFlag(&parsed.LogLevel, validateLogLevel))
+	flags.Func("log-format", envUsage("log format: json or text", logFormatEnv), logFlag(&parsed.LogFormat, validateLogFormat))
+	flags.StringVar(&parsed.OpenAPI, "openapi", os.Getenv(openAPISpecEnv), envUsage("OpenAPI 3 spec whose paths are served with stub responses", openAPISpecEnv))
+	flags.DurationVar(&parsed.OpenAPILatency, "openapi-latency", getEnvDuration(openAPILatencyEnv, 0), envUsage("latency added to the stub responses", openAPILatencyEnv))
+	flags.Float64Var(&parsed.OpenAPIErrorRate, "openapi-error-rate", getEnvFloat(openAPIErrorRateEnv, 0), envUsage("share of stub responses answered with a 503", openAPIErrorRateEnv))
+	if err := flags.Parse(args); err != nil {
+		return parsed, err
+	}
+	if err := parsed.openAPIFaults().validate(); err != nil {
+		fmt.Fprintf(stderr, "invalid --openapi-error-rate: %v\n", err)
+		return parsed, err
+	}
+	if *seed == "" {
+		return parsed, nil
+	}
+	value, err := strconv.ParseInt(*seed, 10, 64)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid seed %q\n", *seed)
+		return parsed, err
+	}
+	parsed.Seed, parsed.Seeded = value, true
+	return parsed, nil
+}
+
+// Server is prober configured from its Options, its endpoints served by
+// Router. Every Server has its own state and metrics registry, so several
+// can be embedded in the same process.
+type Server struct {
+	state  *state
+	router *web.Engine
+	// handler is the router under the handler timeouts, behind the fast
+	// path when enabled.
+	handler   http.Handler
+	reloader  *certReloader
+	bind      bindConfig
+	listeners []listenerConfig
+	timeouts  handlerTimeouts
+
+	// successor is the process the listeners were handed off to.
+	successor *successor
+
+	// closers stop the background workers, in reverse order.
+	closers   []func()
+	stopWatch chan struct{}
+}
+
+// NewServer loads the configuration from opts and starts the background
+// workers, like the checks and the chaos monkey, until Close.
+func NewServer(opts Options) (*Server, error) {
+	s := &Server{state: newState(opts), stopWatch: make(chan struct{})}
+	if err := s.load(opts); err != nil {
+		s.Close()
+		return nil, err
+	}
+	web.SetMode(web.ReleaseMode)
+	st := s.state
+	s.router = st.newRouter(s.reloader, listenerConfig{Name: "default"})
+	if err := s.timeouts.validate(s.router.Routes()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid handler timeouts: %w", err)
+	}
+	if err := st.injectedHeaders.validate(s.router.Routes()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid headers configuration: %w", err)
+	}
+	if err := st.requestBodyLimits.validate(s.router.Routes()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid body size limits: %w", err)
+	}
+	s.handler = s.timeouts.handler(s.router, st.timeoutMetrics)
+	if st.config.getBool(fastPathEnv, false) {
+		s.handler = st.fastPathHandler(s.handler)
+	}
+	return s, nil
+}
+
+// onClose registers a background worker to stop on Close.
+func (s *Server) onClose(close func()) {
+	s.closers = append(s.closers, close)
+}
+
+func (s *Server) load(opts Options) error {
+	st := s.state
+	slog.Info("Random seed", "seed", st.random.Seed(), "fixed", opts.Seeded)
+
+	var err error
+	if err := loadServerLimits(st.config).validate(); err != nil {
+		return fmt.Errorf("invalid server limits: %w", err)
+	}
+	if st.stressPool, err = loadWorkerPool(st.config, st.stressMetrics); err != nil {
+		return fmt.Errorf("invalid stress pool configuration: %w", err)
+	}
+	if _, err := loadAdminNetworks(st.config); err != nil {
+		return fmt.Errorf("invalid admin configuration: %w", err)
+	}
+	if st.injectedHeaders, err = loadResponseHeaders(st.config); err != nil {
+		return fmt.Errorf("invalid headers configuration: %w", err)
+	}
+	st.sensitiveHeaders = loadHeaderRedactor(st.config)
+	if st.requestBodyLimits, err = loadBodyLimits(st.config); err != nil {
+		return fmt.Errorf("invalid body size limits: %w", err)
+	}
+	if st.tokenValidator, err = loadJWTValidator(st.config); err != nil {
+		return fmt.Errorf("invalid JWT configuration: %w", err)
+	}
+	if st.relyingParty, err = loadRelyingParty(st.config, st.oidcMetrics); err != nil {
+		return fmt.Errorf("invalid OIDC configuration: %w", err)
+	}
+	if st.payloadCache, err = loadResponseCache(st.config, st.cacheMetrics); err != nil {
+		return fmt.Errorf("invalid response cache configuration: %w", err)
+	}
+	if s.timeouts, err = loadHandlerTimeouts(st.config); err != nil {
+		return fmt.Errorf("invalid handler timeouts: %w", err)
+	}
+	if s.reloader, err = loadCertReloader(st.config); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	if s.bind, err = loadBindConfig(st.config); err != nil {
+		return fmt.Errorf("invalid bind configuration: %w", err)
+	}
+	if path := st.config.get(listenersConfigEnv); path != "" {
+		if s.listeners, err = loadListenersConfig(path); err != nil {
+			return fmt.Errorf("invalid listeners configuration: %w", err)
+		}
+	}
+
+	if st.kube, err = loadKubeClient(st.config); err != nil {
+		return fmt.Errorf("invalid in-cluster Kubernetes configuration: %w", err)
+	}
+
+	st.kubeEvents = st.loadEventRecorder(st.kube)
+	s.onClose(st.kubeEvents.Close)
+	for _, listener := range s.listeners {
+		if listener.Faults.enabled() {
+			st.kubeEvents.faults(listener.Name, listener.Faults)
+		}
+	}
+
+	if st.elector, err = st.loadLeaderElector(st.kube); err != nil {
+		return fmt.Errorf("invalid leader election configuration: %w", err)
+	}
+	if st.elector != nil {
+		go st.elector.run()
+		s.onClose(st.elector.Close)
+	}
+
+	watcher, err := st.loadPodWatcher(st.kube)
+	if err != nil {
+		return fmt.Errorf("invalid pod deletion watch configuration: %w", err)
+	}
+	if watcher != nil {
+		go watcher.run()
+		s.onClose(watcher.Close)
+	}
+
+	if dir := st.config.get(scenarioLibraryEnv); dir != "" {
+		if st.scenarioLibrary, err = loadScenarioLibrary(dir); err != nil {
+			return fmt.Errorf("invalid scenario library: %w", err)
+		}
+	}
+
+	if st.chaos, err = st.loadChaosMonkey(); err != nil {
+		return fmt.Errorf("invalid chaos configuration: %w", err)
+	}
+	if st.chaos != nil {
+		go st.chaos.run()
+		s.onClose(st.chaos.Close)
+	}
+
+	if st.proberConfigs, err = st.loadConfigController(st.kube); err != nil {
+		return fmt.Errorf("invalid ProberConfig controller configuration: %w", err)
+	}
+	if st.proberConfigs != nil {
+		go st.proberConfigs.run()
+		s.onClose(st.proberConfigs.Close)
+	}
+
+	if st.statsdSink, err = loadStatsdClient(st.config); err != nil {
+		return fmt.Errorf("invalid StatsD configuration: %w", err)
+	}
+	s.onClose(func() { st.statsdSink.Close() })
+
+	if path := st.config.get(webhooksConfigEnv); path != "" {
+		configs, err := loadWebhooksConfig(path)
+		if err != nil {
+			return fmt.Errorf("invalid webhooks configuration: %w", err)
+		}
+		st.webhooks = newWebhookNotifier(configs, st.webhookMetrics)
+		s.onClose(st.webhooks.Close)
+	}
+
+	if path := st.config.get(checksConfigEnv); path != "" {
+		checks, err := loadChecksConfig(path)
+		if err != nil {
+			return fmt.Errorf("invalid checks configuration: %w", err)
+		}
+		st.targetChecker = st.newChecker(checks)
+		st.targetChecker.run()
+		s.onClose(st.targetChecker.Close)
+	}
+
+	if path := st.config.get(egressConfigEnv); path != "" {
+		if st.egressChecks, err = loadChecksConfig(path); err != nil {
+			return fmt.Errorf("invalid egress configuration: %w", err)
+		}
+	}
+
+	if path := st.config.get(scriptsConfigEnv); path != "" {
+		if st.customScripts, err = loadScriptsConfig(path); err != nil {
+			return fmt.Errorf("invalid scripts configuration: %w", err)
+		}
+	}
+
+	if path := st.config.get(mocksConfigEnv); path != "" {
+		configured, err := loadMocksConfig(path, st.counters)
+		if err != nil {
+			return fmt.Errorf("invalid mocks configuration: %w", err)
+		}
+		st.mocks.set(configured)
+	}
+	if opts.OpenAPI != "" {
+		stubs, err := loadOpenAPIStubs(opts.OpenAPI, opts.openAPIFaults(), st.counters)
+		if err != nil {
+			return fmt.Errorf("invalid OpenAPI spec: %w", err)
+		}
+		for _, stub := range stubs {
+			st.mocks.put(stub)
+		}
+		slog.Info("Serving OpenAPI stubs", "spec", opts.OpenAPI, "operations", len(stubs))
+	}
+
+	if st.trafficRecording, err = loadTrafficRecorder(st.config); err != nil {
+		return fmt.Errorf("invalid traffic recording: %w", err)
+	}
+	if st.trafficRecording != nil {
+		s.onClose(st.trafficRecording.Close)
+	}
+
+	if path := st.config.get(relayConfigEnv); path != "" {
+		if st.relayTargets, err = loadRelayConfig(path); err != nil {
+			return fmt.Errorf("invalid relay configuration: %w", err)
+		}
+	}
+
+	if st.probeModules, err = loadProbeModules(st.config.get(probeModulesConfigEnv)); err != nil {
+		return fmt.Errorf("invalid probe modules configuration: %w", err)
+	}
+
+	if monitor := loadThrottlingMonitor(st.config, &st.lastThrottling); monitor != nil {
+		go monitor.run()
+		s.onClose(monitor.Close)
+	}
+
+	if st.replicaMesh = st.loadMeshMonitor(); st.replicaMesh != nil {
+		go st.replicaMesh.run()
+		s.onClose(st.replicaMesh.Close)
+	}
+	return nil
+}
+
+// Router returns the handler of the default listener, to embed prober's
+// endpoints into another server or test harness.
+func (s *Server) Router() http.Handler {
+	return s.handler
+}
+
+// Close stops the background workers started by NewServer and Run.
+func (s *Server) Close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+	if s.stopWatch != nil {
+		close(s.stopWatch)
+		s.stopWatch = nil
+	}
+}
+
+// Run serves every configured listener until SIGINT or SIGTERM, a listener
+// failing, ctx being done or SIGUSR2 handing them off to a new process,
+// then drains them. It only returns an error
+// when a listener cannot be opened.
+func (s *Server) Run(ctx context.Context) error {
+	st := s.state
+	var servers []shutdowner
+	// The metrics listener is stopped after the others so the drain can be
+	// scraped while it happens.
+	var metricsSrv *http.Server
+	srvErrs := make(chan error, 8+len(s.listeners))
+	limits := loadServerLimits(st.config)
+	// run serves in the background, tracking the listener state for /healthz.
+	run := func(name string, serveFn func() error) {
+		st.serverHealth.serving(name)
+		go func() {
+			err := serveFn()
+			st.serverHealth.stopped(name, err)
+			srvErrs <- err
+		}()
+	}
+	start := func(srv *http.Server, listener net.Listener) {
+		srv.ConnContext = connContext
+		limits.apply(srv)
+		listener = limits.limitListener(listener, srv.TLSConfig != nil, st.limitMetrics)
+		run(listener.Addr().String(), func() error {
+			if srv.TLSConfig != nil {
+				return srv.ServeTLS(listener, "", "")
+			}
+			return srv.Serve(listener)
+		})
+	}
+	serve := func(srv *http.Server, listener net.Listener) {
+		servers = append(servers, srv)
+		start(srv, listener)
+	}
+	listen := func(addr string, proxyProtocol bool) (net.Listener, error) {
+		network, bindAddr, err := s.bind.resolve(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %s: %w", addr, err)
+		}
+		listener, err := listenTCP(network, bindAddr, proxyProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return listener, nil
+	}
+	// abort closes the listeners already serving when another fails.
+	abort := func(err error) error {
+		closed, cancel := context.WithCancel(context.Background())
+		cancel()
+		for _, srv := range servers {
+			srv.Shutdown(closed)
+		}
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(closed)
+		}
+		return err
+	}
+
+	reloadInterval := st.config.getDuration(tlsReloadIntervalEnv, defaultTLSReloadInterval)
+	proxyProtocol := st.config.getBool(proxyProtocolEnv, false)
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: plaintextHandler(st.config, s.handler),
+	}
+	ln, err := listen(srv.Addr, proxyProtocol)
+	if err != nil {
+		return abort(err)
+	}
+	serve(srv, ln)
+
+	if s.reloader != nil {
+		go s.reloader.watch(reloadInterval, s.stopWatch)
+
+		tlsSrv := newTLSServer(st.config, s.reloader, s.handler)
+		ln, err := listen(tlsSrv.Addr, proxyProtocol)
+		if err != nil {
+			return abort(err)
+		}
+		serve(tlsSrv, ln)
+	}
+
+	if socketPath := st.config.get(unixSocketPathEnv); socketPath != "" {
+		listener, err := listenUnix(socketPath)
+		if err != nil {
+			return abort(fmt.Errorf("failed to listen on %s: %w", socketPath, err))
+		}
+
+		serve(&http.Server{Handler: plaintextHandler(st.config, s.handler)}, listener)
+	}
+
+	if metricsAddr := st.config.get(metricsAddrEnv); metricsAddr != "" {
+		ln, err := listen(metricsAddr, false)
+		if err != nil {
+			return abort(err)
+		}
+		metricsSrv = &http.Server{Addr: metricsAddr, Handler: st.newMetricsRouter()}
+		start(metricsSrv, ln)
+	}
+
+	if adminAddr := st.config.get(adminAddrEnv); adminAddr != "" {
+		ln, err := listen(adminAddr, false)
+		if err != nil {
+			return abort(err)
+		}
+		serve(&http.Server{Addr: adminAddr, Handler: st.newAdminRouter()}, ln)
+	}
+
+	admissionReloader, err := loadAdmissionReloader(st.config)
+	if err != nil {
+		return abort(fmt.Errorf("invalid admission webhook configuration: %w", err))
+	}
+	if admissionReloader != nil {
+		go admissionReloader.watch(reloadInterval, s.stopWatch)
+
+		admissionSrv := newTLSServer(st.config, admissionReloader, st.newAdmissionRouter())
+		admissionSrv.Addr = st.config.get(admissionAddrEnv)
+		ln, err := listen(admissionSrv.Addr, false)
+		if err != nil {
+			return abort(err)
+		}
+		serve(admissionSrv, ln)
+	}
+
+	if grpcAddr := st.config.get(grpcAddrEnv); grpcAddr != "" {
+		ln, err := listen(grpcAddr, proxyProtocol)
+		if err != nil {
+			return abort(err)
+		}
+		grpcSrv := st.newGRPCServer()
+		servers = append(servers, grpcSrv)
+		run(ln.Addr().String(), func() error { return grpcSrv.Serve(ln) })
+	}
+
+	if dnsAddr := st.config.get(dnsAddrEnv); dnsAddr != "" {
+		config, err := loadDNSConfig(st.config.get(dnsConfigEnv))
+		if err != nil {
+			return abort(fmt.Errorf("invalid DNS configuration: %w", err))
+		}
+		stub, err := newDNSStub(config, st.random)
+		if err != nil {
+			return abort(fmt.Errorf("invalid DNS configuration: %w", err))
+		}
+
+		dnsSrv := newDNSServer(dnsAddr, stub)
+		servers = append(servers, dnsSrv)
+		run(dnsAddr, dnsSrv.ListenAndServe)
+	}
+
+	for _, listener := range s.listeners {
+		slog.Info("Listener serving", "listener", listener.Name, "addr", listener.Addr)
+		if listener.Mode == listenerModeHalfOpen {
+			ln, err := listen(listener.Addr, false)
+			if err != nil {
+				return abort(err)
+			}
+			halfOpenSrv := newHalfOpenServer(listener.MaxConnections)
+			servers = append(servers, halfOpenSrv)
+			run(ln.Addr().String(), func() error { return halfOpenSrv.Serve(ln) })
+			continue
+		}
+		ln, err := listen(listener.Addr, listener.ProxyProtocol)
+		if err != nil {
+			return abort(err)
+		}
+		if listener.TLS == nil {
+			namedSrv := &http.Server{
+				Addr:    listener.Addr,
+				Handler: plaintextHandler(st.config, st.newRouter(nil, listener)),
+			}
+			serve(namedSrv, ln)
+			continue
+		}
+
+		namedReloader, err := newCertReloader(listener.TLS.CertFile, listener.TLS.KeyFile)
+		if err != nil {
+			ln.Close()
+			return abort(fmt.Errorf("invalid TLS configuration of listener %s: %w", listener.Name, err))
+		}
+		go namedReloader.watch(reloadInterval, s.stopWatch)
+
+		namedSrv := newTLSServer(st.config, namedReloader, st.newRouter(namedReloader, listener))
+		namedSrv.Addr = listener.Addr
+		serve(namedSrv, ln)
+	}
+
+	listenerHandoff.serving()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	handoffs := make(chan os.Signal, 1)
+	if len(handoffSignals) > 0 {
+		signal.Notify(handoffs, handoffSignals...)
+		defer signal.Stop(handoffs)
+	}
+
+	shutdown := st.gracefulShutdown(servers...)
+
+	pusher := newMetricsPusher(st.config, st.registry)
+	if pusher != nil {
+		go pusher.run()
+	}
+
+wait:
+	for {
+		select {
+		case err := <-srvErrs:
+			shutdown(err)
+			break wait
+		case sig := <-quit:
+			st.observeSignal(sig, time.Now())
+			shutdown(sig)
+			break wait
+		case <-ctx.Done():
+			shutdown(ctx.Err())
+			break wait
+		case <-handoffs:
+			next, err := listenerHandoff.start(handoffTimeout())
+			if err != nil {
+				slog.Error("Failed to hand off the listeners", "error", err)
+				continue
+			}
+			s.successor = next
+			shutdown("handoff")
+			break wait
+		}
+	}
+
+	if metricsSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		metricsSrv.Shutdown(ctx)
+		cancel()
+	}
+	if pusher != nil {
+		pusher.Close()
+	}
+	return nil
+}
+
+// shutdowner is implemented by *http.Server and the raw TCP servers.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+func (st *state) gracefulShutdown(servers ...shutdowner) func(reason interface{}) {
+	return func(reason interface{}) {
+		st.serverShutdown.begin()
+		st.shutdownStartedTimestamp.SetToCurrentTime()
+		started := time.Now()
+
+		slog.Info("Server shutdown", "reason", fmt.Sprint(reason))
+		st.kubeEvents.emit(eventTypeNormal, "ShutdownStarted", fmt.Sprintf("Graceful shutdown started: %v", reason))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 260*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func(srv shutdowner) {
+				defer wg.Done()
+				if err := srv.Shutdown(ctx); err != nil {
+					slog.Error("Errors to gracefully shutdown server", "error", err)
+				}
+			}(srv)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			st.requestsCancelledOnShutdown.Add(float64(st.activeRequests.Load()))
+		}
+		st.shutdownDuration.Set(time.Since(started).Seconds())
+	}
+}
@@ -0,0 +1,261 @@
+package prober
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestStartupProbe(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/startup", st.probeHandler(startupProbeDelayEnv, "startup"))
+
+	req, _ := http.NewRequest("GET", "/startup", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := `{"message":"startup"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/readiness", st.probeHandler(readinessProbeDelayEnv, "readiness"))
+
+	req, _ := http.NewRequest("GET", "/readiness", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := `{"message":"readiness"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestLivenessProbe(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := `{"message":"liveness"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestPostConfigs(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.POST("/config", st.postConfigs)
+
+	body := `{"startup":"5","readiness":"10","liveness":"15"}`
+	req, _ := http.NewRequest("POST", "/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	expected := `{"startup":"5","readiness":"10","liveness":"15"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+
+	if os.Getenv("STARTUP_PROBE_DELAY") != "5" {
+		t.Errorf("expected STARTUP_PROBE_DELAY to be %s, got %s", "5", os.Getenv("STARTUP_PROBE_DELAY"))
+	}
+	if os.Getenv("READINESS_PROBE_DELAY") != "10" {
+		t.Errorf("expected READINESS_PROBE_DELAY to be %s, got %s", "10", os.Getenv("READINESS_PROBE_DELAY"))
+	}
+	if os.Getenv("LIVENESS_PROBE_DELAY") != "15" {
+		t.Errorf("expected LIVENESS_PROBE_DELAY to be %s, got %s", "15", os.Getenv("LIVENESS_PROBE_DELAY"))
+	}
+}
+
+func TestDelayRequest(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/delay/:seconds", st.delayRequest)
+
+	req, _ := http.NewRequest("GET", "/delay/2", nil)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	duration := time.Since(start)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := `{"message":2}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+
+	if duration < 2*time.Second {
+		t.Errorf("expected delay of at least 2 seconds, got %v", duration)
+	}
+}
+
+func TestGraceDelayRequest(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/graceDelay/:seconds", st.graceDelayRequest)
+
+	req, _ := http.NewRequest("GET", "/graceDelay/2", nil)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	duration := time.Since(start)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := `{"message":2}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+
+	if duration < 2*time.Second {
+		t.Errorf("expected delay of at least 2 seconds, got %v", duration)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Setenv(randomSeedEnv, "")
+	t.Setenv(openAPISpecEnv, "")
+	if opts, err := ParseFlags(nil, io.Discard); err != nil || opts.Seeded || opts.OpenAPI != "" {
+		t.Errorf("expected no seed nor spec, got %+v and %v", opts, err)
+	}
+
+	t.Setenv(randomSeedEnv, "7")
+	if opts, err := ParseFlags(nil, io.Discard); err != nil || !opts.Seeded || opts.Seed != 7 {
+		t.Errorf("expected seed 7 from the environment, got %d", opts.Seed)
+	}
+	if opts, err := ParseFlags([]string{"--seed=42"}, io.Discard); err != nil || opts.Seed != 42 {
+		t.Errorf("expected the flag to win, got %d", opts.Seed)
+	}
+	if _, err := ParseFlags([]string{"--seed=forty-two"}, io.Discard); err == nil {
+		t.Errorf("expected an invalid seed to fail")
+	}
+
+	t.Setenv(logLevelEnv, "warn")
+	t.Setenv(logFormatEnv, "text")
+	if opts, err := ParseFlags(nil, io.Discard); err != nil || opts.LogLevel != "warn" || opts.LogFormat != "text" {
+		t.Errorf("expected the log settings from the environment, got %+v and %v", opts, err)
+	}
+	if opts, err := ParseFlags([]string{"--log-level=debug", "--log-format=json"}, io.Discard); err != nil || opts.LogLevel != "debug" || opts.LogFormat != "json" {
+		t.Errorf("expected the log flags to win, got %+v and %v", opts, err)
+	}
+	for _, args := range [][]string{{"--log-level=loud"}, {"--log-format=xml"}} {
+		if _, err := ParseFlags(args, io.Discard); err == nil {
+			t.Errorf("%v: expected an invalid log flag to fail", args)
+		}
+	}
+
+	opts, err := ParseFlags([]string{"--openapi", "spec.yaml", "--openapi-latency=50ms", "--openapi-error-rate=0.1"}, io.Discard)
+	if err != nil || opts.OpenAPI != "spec.yaml" || opts.OpenAPILatency != 50*time.Millisecond || opts.OpenAPIErrorRate != 0.1 {
+		t.Errorf("unexpected OpenAPI flags %+v, %v", opts, err)
+	}
+	if _, err := ParseFlags([]string{"--openapi-error-rate=2"}, io.Discard); err == nil {
+		t.Errorf("expected an error rate above 1 to fail")
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "0")
+	srv, err := NewServer(Options{Seed: 42, Seeded: true})
+	if err != nil {
+		t.Fatalf("expected a server from an empty environment, got %v", err)
+	}
+	defer srv.Close()
+	if seed := srv.state.random.Seed(); seed != 42 {
+		t.Errorf("expected the seed option to apply, got %d", seed)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the router to serve the probes, got %d", w.Code)
+	}
+
+	t.Setenv(scenarioLibraryEnv, "/nonexistent/[")
+	if _, err := NewServer(Options{}); err == nil {
+		t.Errorf("expected an invalid scenario library to fail")
+	}
+}
+
+func TestEmbeddedServers(t *testing.T) {
+	t.Setenv(livenessProbeDelayEnv, "5")
+	serve := func(srv *Server, method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	a, err := NewServer(Options{Config: map[string]string{livenessProbeDelayEnv: "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewServer(Options{Config: map[string]string{livenessProbeDelayEnv: "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if w := serve(a, http.MethodGet, "/liveness", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the configured delay over the environment, got %d", w.Code)
+	}
+	serve(a, http.MethodPost, "/counters/calls/increment", "")
+	if w := serve(b, http.MethodGet, "/counters/calls", ""); !strings.Contains(w.Body.String(), `"value":0`) {
+		t.Errorf("expected the servers not to share counters, got %s", w.Body.String())
+	}
+	if w := serve(a, http.MethodPost, "/config", `{"liveness": "1"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the config to change, got %d", w.Code)
+	}
+	if a.state.probeDelay(livenessProbeDelayEnv) != time.Second || b.state.probeDelay(livenessProbeDelayEnv) != 0 {
+		t.Errorf("expected the config change to stay on its server")
+	}
+	if value := os.Getenv(livenessProbeDelayEnv); value != "5" {
+		t.Errorf("expected the environment untouched, got %q", value)
+	}
+	if w := serve(b, http.MethodGet, "/metrics", ""); !strings.Contains(w.Body.String(), "config_changes_total 0") {
+		t.Errorf("expected the servers not to share metrics")
+	}
+}
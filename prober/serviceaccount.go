@@ -0,0 +1,221 @@
+package prober
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	serviceAccountTokenFileEnv = "SERVICE_ACCOUNT_TOKEN_FILE"
+
+	// tokenRefreshShare is the share of its lifetime after which kubelet
+	// replaces a projected token.
+	tokenRefreshShare = 0.8
+	// tokenRefreshGrace is how late the refresh can be before reporting it
+	// stale, since kubelet checks tokens periodically.
+	tokenRefreshGrace = 5 * time.Minute
+)
+
+// jwtAudience is the aud claim, a string or a list of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type kubeClaimRef struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+// serviceAccountClaims are the claims of both the bound tokens, under
+// kubernetes.io, and of the legacy Secret based tokens.
+type serviceAccountClaims struct {
+	Issuer     string      `json:"iss"`
+	Subject    string      `json:"sub"`
+	Audience   jwtAudience `json:"aud"`
+	Expiry     int64       `json:"exp"`
+	IssuedAt   int64       `json:"iat"`
+	NotBefore  int64       `json:"nbf"`
+	Kubernetes *struct {
+		Namespace      string        `json:"namespace"`
+		Pod            *kubeClaimRef `json:"pod"`
+		Secret         *kubeClaimRef `json:"secret"`
+		Node           *kubeClaimRef `json:"node"`
+		ServiceAccount *kubeClaimRef `json:"serviceaccount"`
+		WarnAfter      int64         `json:"warnafter"`
+	} `json:"kubernetes.io"`
+	LegacyNamespace      string `json:"kubernetes.io/serviceaccount/namespace"`
+	LegacySecretName     string `json:"kubernetes.io/serviceaccount/secret.name"`
+	LegacyServiceAccount string `json:"kubernetes.io/serviceaccount/service-account.name"`
+}
+
+type tokenRefresh struct {
+	// Status is "ok", "stale" when kubelet should have replaced the token
+	// already, "expired", or "static" for tokens that never expire.
+	Status     string     `json:"status"`
+	RefreshDue *time.Time `json:"refreshDue,omitempty"`
+	Modified   time.Time  `json:"modified"`
+	Rotations  int        `json:"rotationsObserved"`
+}
+
+// serviceAccountInfo describes the mounted token without the token itself,
+// which only appears as a short fingerprint to tell rotations apart.
+type serviceAccountInfo struct {
+	File           string        `json:"file"`
+	Fingerprint    string        `json:"fingerprint"`
+	Bound          bool          `json:"bound"`
+	Issuer         string        `json:"issuer,omitempty"`
+	Subject        string        `json:"subject,omitempty"`
+	Audiences      []string      `json:"audiences,omitempty"`
+	Namespace      string        `json:"namespace,omitempty"`
+	ServiceAccount *kubeClaimRef `json:"serviceAccount,omitempty"`
+	Pod            *kubeClaimRef `json:"pod,omitempty"`
+	Secret         *kubeClaimRef `json:"secret,omitempty"`
+	Node           *kubeClaimRef `json:"node,omitempty"`
+	IssuedAt       *time.Time    `json:"issuedAt,omitempty"`
+	Expiry         *time.Time    `json:"expiry,omitempty"`
+	WarnAfter      *time.Time    `json:"warnAfter,omitempty"`
+	Refresh        tokenRefresh  `json:"refresh"`
+}
+
+// tokenRotations counts the rotations of the token seen by /serviceaccount.
+type tokenRotations struct {
+	sync.Mutex
+	fingerprint string
+	rotations   int
+}
+
+func (t *tokenRotations) observe(fingerprint string) int {
+	t.Lock()
+	defer t.Unlock()
+	if t.fingerprint != "" && t.fingerprint != fingerprint {
+		t.rotations++
+	}
+	t.fingerprint = fingerprint
+	return t.rotations
+}
+
+func unixTime(seconds int64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
+
+// decodeJWTClaims returns the claims of a JWT without verifying its
+// signature, which only the API server can do.
+func decodeJWTClaims(token string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, claims)
+}
+
+// inspectServiceAccountToken reads the token file and tells whether kubelet
+// refreshes it on time, as of now.
+func inspectServiceAccountToken(path string, now time.Time) (serviceAccountInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return serviceAccountInfo{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return serviceAccountInfo{}, err
+	}
+	token := strings.TrimSpace(string(data))
+	var claims serviceAccountClaims
+	if err := decodeJWTClaims(token, &claims); err != nil {
+		return serviceAccountInfo{}, err
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	info := serviceAccountInfo{
+		File:        path,
+		Fingerprint: hex.EncodeToString(sum[:8]),
+		Issuer:      claims.Issuer,
+		Subject:     claims.Subject,
+		Audiences:   claims.Audience,
+		IssuedAt:    unixTime(claims.IssuedAt),
+		Expiry:      unixTime(claims.Expiry),
+		Namespace:   claims.LegacyNamespace,
+		Refresh:     tokenRefresh{Modified: stat.ModTime().UTC()},
+	}
+	if k := claims.Kubernetes; k != nil {
+		info.Bound = true
+		info.Namespace, info.ServiceAccount, info.Pod, info.Secret, info.Node = k.Namespace, k.ServiceAccount, k.Pod, k.Secret, k.Node
+		info.WarnAfter = unixTime(k.WarnAfter)
+	} else if claims.LegacyServiceAccount != "" {
+		info.ServiceAccount = &kubeClaimRef{Name: claims.LegacyServiceAccount}
+		info.Secret = &kubeClaimRef{Name: claims.LegacySecretName}
+	}
+
+	switch {
+	case info.Expiry == nil:
+		info.Refresh.Status = "static"
+		return info, nil
+	case !now.Before(*info.Expiry):
+		info.Refresh.Status = "expired"
+		return info, nil
+	}
+	// Tokens extended for the clients that do not reload them report the
+	// warnafter time as their real expiry.
+	expiry := *info.Expiry
+	if info.WarnAfter != nil {
+		expiry = *info.WarnAfter
+	}
+	issued := stat.ModTime()
+	if info.IssuedAt != nil {
+		issued = *info.IssuedAt
+	}
+	due := issued.Add(time.Duration(float64(expiry.Sub(issued)) * tokenRefreshShare)).UTC()
+	info.Refresh.RefreshDue = &due
+	info.Refresh.Status = "ok"
+	if now.After(due.Add(tokenRefreshGrace)) {
+		info.Refresh.Status = "stale"
+	}
+	return info, nil
+}
+
+// serviceAccountHandler answers GET /serviceaccount with the claims of the
+// mounted service account token.
+func (st *state) serviceAccountHandler(c *web.Context) {
+	path := st.config.getString(serviceAccountTokenFileEnv, filepath.Join(st.config.getString(kubeServiceAccountDirEnv, defaultKubeServiceAccountDir), "token"))
+	info, err := inspectServiceAccountToken(path, time.Now())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, web.H{"error": "No service account token mounted"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, web.H{"error": "Invalid service account token"})
+	default:
+		info.Refresh.Rotations = st.observedTokens.observe(info.Fingerprint)
+		c.JSON(http.StatusOK, info)
+	}
+}
@@ -0,0 +1,126 @@
+package prober
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func writeTestToken(t *testing.T, path string, claims map[string]any) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInspectBoundToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	issued := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	writeTestToken(t, path, map[string]any{
+		"iss": "https://kubernetes.default.svc",
+		"sub": "system:serviceaccount:default:prober",
+		"aud": []string{"https://kubernetes.default.svc", "vault"},
+		"iat": issued.Unix(),
+		"exp": issued.Add(time.Hour).Unix(),
+		"kubernetes.io": map[string]any{
+			"namespace":      "default",
+			"pod":            map[string]string{"name": "prober-0", "uid": "pod-uid"},
+			"serviceaccount": map[string]string{"name": "prober", "uid": "sa-uid"},
+		},
+	})
+
+	info, err := inspectServiceAccountToken(path, time.Now())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !info.Bound || info.Pod == nil || info.Pod.Name != "prober-0" || info.ServiceAccount.Name != "prober" {
+		t.Errorf("expected the bound pod and service account, got %+v", info)
+	}
+	if len(info.Audiences) != 2 || info.Audiences[1] != "vault" {
+		t.Errorf("expected two audiences, got %v", info.Audiences)
+	}
+	if info.Refresh.Status != "ok" || !info.Refresh.RefreshDue.Equal(issued.Add(48*time.Minute)) {
+		t.Errorf("expected a refresh due at 80%% of the lifetime, got %+v", info.Refresh)
+	}
+
+	if info, _ := inspectServiceAccountToken(path, issued.Add(55*time.Minute)); info.Refresh.Status != "stale" {
+		t.Errorf("expected a stale token, got %s", info.Refresh.Status)
+	}
+	if info, _ := inspectServiceAccountToken(path, issued.Add(2*time.Hour)); info.Refresh.Status != "expired" {
+		t.Errorf("expected an expired token, got %s", info.Refresh.Status)
+	}
+}
+
+func TestInspectLegacyToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeTestToken(t, path, map[string]any{
+		"iss":                                    "kubernetes/serviceaccount",
+		"sub":                                    "system:serviceaccount:default:prober",
+		"kubernetes.io/serviceaccount/namespace": "default",
+		"kubernetes.io/serviceaccount/secret.name":          "prober-token-x7k2p",
+		"kubernetes.io/serviceaccount/service-account.name": "prober",
+	})
+
+	info, err := inspectServiceAccountToken(path, time.Now())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if info.Bound || info.Refresh.Status != "static" || info.Secret.Name != "prober-token-x7k2p" || info.Namespace != "default" {
+		t.Errorf("expected a static legacy token, got %+v", info)
+	}
+}
+
+func TestServiceAccountHandler(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	dir := t.TempDir()
+	t.Setenv(serviceAccountTokenFileEnv, "")
+	t.Setenv(kubeServiceAccountDirEnv, dir)
+
+	router := web.New()
+	router.GET("/serviceaccount", st.serviceAccountHandler)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/serviceaccount", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without token, got %d", w.Code)
+	}
+
+	path := filepath.Join(dir, "token")
+	token := writeTestToken(t, path, map[string]any{"sub": "first", "exp": time.Now().Add(time.Hour).Unix()})
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), token) || strings.Contains(w.Body.String(), "c2lnbmF0dXJl") {
+		t.Errorf("expected the token not to leak, got %s", w.Body.String())
+	}
+
+	writeTestToken(t, path, map[string]any{"sub": "second", "exp": time.Now().Add(time.Hour).Unix()})
+	var info serviceAccountInfo
+	json.Unmarshal(get().Body.Bytes(), &info)
+	if info.Refresh.Rotations != 1 {
+		t.Errorf("expected one rotation observed, got %d", info.Refresh.Rotations)
+	}
+
+	os.WriteFile(path, []byte("not-a-token"), 0o600)
+	if w := get(); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for an invalid token, got %d", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package prober
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// shutdownState tells handlers that graceful shutdown started: long-running
+// ones select on done to stop waiting instead of holding the drain.
+type shutdownState struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newShutdownState() *shutdownState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shutdownState{ctx: ctx, cancel: cancel}
+}
+
+func (s *shutdownState) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel()
+}
+
+// done is closed once the shutdown started.
+func (s *shutdownState) done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx.Done()
+}
+
+// sleepRequest waits for the delay injected in a request. The shutdown cuts
+// it short like /graceDelay, the request going on, while the cancellation of
+// the request returns its error so the handler gives up.
+func (st *state) sleepRequest(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-st.serverShutdown.done():
+		st.requestsCancelledOnShutdown.Inc()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
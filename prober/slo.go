@@ -0,0 +1,91 @@
+package prober
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const defaultSummaryWindow = time.Hour
+
+// checkSummary answers "has this dependency been flaky lately" from the
+// kept history. Since is the oldest result in the window, later than the
+// window start when the history is shorter than it.
+type checkSummary struct {
+	Target      string    `json:"target"`
+	Window      string    `json:"window"`
+	Since       time.Time `json:"since,omitempty"`
+	Runs        int       `json:"runs"`
+	Failures    int       `json:"failures"`
+	Skipped     int       `json:"skipped"`
+	SuccessRate float64   `json:"successRate"`
+	P50         string    `json:"p50"`
+	P95         string    `json:"p95"`
+	Max         string    `json:"max"`
+}
+
+// summarize computes the success rate and latency percentiles of the
+// results newer than now minus window. Skipped results don't count.
+func summarize(target string, results []checkResult, window time.Duration, now time.Time) checkSummary {
+	summary := checkSummary{Target: target, Window: window.String()}
+	var durations []time.Duration
+	for _, result := range results {
+		if result.Time.Before(now.Add(-window)) {
+			continue
+		}
+		if summary.Since.IsZero() || result.Time.Before(summary.Since) {
+			summary.Since = result.Time
+		}
+		if result.Skipped {
+			summary.Skipped++
+			continue
+		}
+		summary.Runs++
+		if !result.Success {
+			summary.Failures++
+		}
+		durations = append(durations, result.elapsed)
+	}
+	if summary.Runs == 0 {
+		return summary
+	}
+
+	summary.SuccessRate = float64(summary.Runs-summary.Failures) / float64(summary.Runs)
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	summary.P50 = percentile(durations, 0.50).String()
+	summary.P95 = percentile(durations, 0.95).String()
+	summary.Max = durations[len(durations)-1].String()
+	return summary
+}
+
+// percentile uses the nearest rank of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// checkSummaryHandler answers GET /checks/:name/summary?window=1h.
+func (st *state) checkSummaryHandler(c *web.Context) {
+	if st.targetChecker == nil {
+		c.JSON(http.StatusNotFound, web.H{"error": "Checks are not enabled"})
+		return
+	}
+	window := defaultSummaryWindow
+	if value := c.Query("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid window value"})
+			return
+		}
+		window = parsed
+	}
+	results, ok := st.targetChecker.results(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, web.H{"error": "Unknown check"})
+		return
+	}
+	c.JSON(http.StatusOK, summarize(c.Param("name"), results, window, time.Now()))
+}
@@ -0,0 +1,90 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestSummarize(t *testing.T) {
+	now := time.Now()
+	var results []checkResult
+	for i := 1; i <= 20; i++ {
+		results = append(results, checkResult{
+			Time:    now.Add(-time.Duration(i) * time.Minute),
+			Success: i%5 != 0,
+			elapsed: time.Duration(i) * time.Millisecond,
+		})
+	}
+	results = append(results,
+		checkResult{Time: now.Add(-2 * time.Hour), elapsed: time.Second},
+		checkResult{Time: now, Skipped: true},
+	)
+
+	summary := summarize("api", results, time.Hour, now)
+	if summary.Runs != 20 || summary.Failures != 4 || summary.Skipped != 1 {
+		t.Errorf("expected 20 runs, 4 failures and 1 skipped, got %+v", summary)
+	}
+	if summary.SuccessRate != 0.8 {
+		t.Errorf("expected success rate 0.8, got %v", summary.SuccessRate)
+	}
+	if summary.P50 != "10ms" || summary.P95 != "19ms" || summary.Max != "20ms" {
+		t.Errorf("expected p50 10ms, p95 19ms and max 20ms, got %s %s %s", summary.P50, summary.P95, summary.Max)
+	}
+	if !summary.Since.Equal(now.Add(-20 * time.Minute)) {
+		t.Errorf("expected since %v, got %v", now.Add(-20*time.Minute), summary.Since)
+	}
+
+	if empty := summarize("api", nil, time.Hour, now); empty.Runs != 0 || empty.P95 != "" {
+		t.Errorf("expected an empty summary, got %+v", empty)
+	}
+}
+
+func TestCheckSummaryHandler(t *testing.T) {
+	st := newState(Options{})
+	st.targetChecker = st.newChecker([]checkConfig{{Name: "api"}})
+	st.targetChecker.history["api"] = []checkResult{
+		{Time: time.Now().Add(-2 * time.Minute), Success: false, elapsed: time.Second},
+		{Time: time.Now(), Success: true, elapsed: time.Millisecond},
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.GET("/checks/:name/summary", st.checkSummaryHandler)
+
+	tests := []struct {
+		path   string
+		status int
+		runs   int
+	}{
+		{"/checks/api/summary", http.StatusOK, 2},
+		{"/checks/api/summary?window=1m", http.StatusOK, 1},
+		{"/checks/api/summary?window=soon", http.StatusBadRequest, 0},
+		{"/checks/unknown/summary", http.StatusNotFound, 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var summary checkSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if summary.Runs != test.runs {
+			t.Errorf("%s: expected %d runs, got %d", test.path, test.runs, summary.Runs)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// splitVariantHeader tells the client which variant answered, to join the
+// responses with the failure signals canary analysis should see.
+const splitVariantHeader = "X-Prober-Variant"
+
+// splitMetrics count the requests by traffic split variant.
+type splitMetrics struct {
+	splitRequestsTotal *prometheus.CounterVec
+}
+
+func newSplitMetrics(registry prometheus.Registerer) splitMetrics {
+	m := splitMetrics{
+		splitRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "split_requests_total",
+			Help: "Requests served while behaviors are split, by variant, control for the unmatched ones.",
+		}, []string{"variant"}),
+	}
+	registry.MustRegister(m.splitRequestsTotal)
+	return m
+}
+
+// splitRule gives the requests carrying a header or cookie their own
+// faults, like 10% errors for the ones with x-canary: true, while the
+// others behave normally. An empty value matches any request carrying the
+// header or cookie.
+type splitRule struct {
+	Variant string       `json:"variant"`
+	Header  string       `json:"header,omitempty"`
+	Cookie  string       `json:"cookie,omitempty"`
+	Value   string       `json:"value,omitempty"`
+	Faults  faultProfile `json:"faults"`
+}
+
+type splitConfig struct {
+	Rules []splitRule `json:"rules"`
+}
+
+func (r splitRule) validate() error {
+	if r.Variant == "" || r.Variant == "control" {
+		return errors.New("rule needs a variant other than control")
+	}
+	if (r.Header == "") == (r.Cookie == "") {
+		return fmt.Errorf("variant %q must match one of header or cookie", r.Variant)
+	}
+	if err := r.Faults.validate(); err != nil {
+		return fmt.Errorf("variant %q: %w", r.Variant, err)
+	}
+	return nil
+}
+
+func (r splitRule) matches(c *web.Context) bool {
+	var value string
+	var present bool
+	if r.Header != "" {
+		values := c.Request.Header.Values(r.Header)
+		if present = len(values) > 0; present {
+			value = values[0]
+		}
+	} else {
+		cookie, err := c.Request.Cookie(r.Cookie)
+		if present = err == nil; present {
+			value = cookie.Value
+		}
+	}
+	return present && (r.Value == "" || r.Value == value)
+}
+
+// splitMiddleware injects the faults of the first rule matching the
+// request, counted under the "split-<variant>" listener.
+func (st *state) splitMiddleware() web.HandlerFunc {
+	return func(c *web.Context) {
+		rules := st.splitRules.Load()
+		if rules == nil {
+			c.Next()
+			return
+		}
+		for _, rule := range *rules {
+			if rule.matches(c) {
+				st.splitRequestsTotal.WithLabelValues(rule.Variant).Inc()
+				c.Header(splitVariantHeader, rule.Variant)
+				if st.injectFaults(c, "split-"+rule.Variant, rule.Faults) {
+					c.Next()
+				}
+				return
+			}
+		}
+		st.splitRequestsTotal.WithLabelValues("control").Inc()
+		c.Header(splitVariantHeader, "control")
+		c.Next()
+	}
+}
+
+func (st *state) getSplitConfig(c *web.Context) {
+	config := splitConfig{Rules: []splitRule{}}
+	if rules := st.splitRules.Load(); rules != nil {
+		config.Rules = *rules
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+// postSplitConfig replaces the rules, an empty list removing the split.
+func (st *state) postSplitConfig(c *web.Context) {
+	var config splitConfig
+	if err := c.BindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid JSON"})
+		return
+	}
+	for _, rule := range config.Rules {
+		if err := rule.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid split rule", "detail": err.Error()})
+			return
+		}
+	}
+	if len(config.Rules) == 0 {
+		st.splitRules.Store(nil)
+	} else {
+		st.splitRules.Store(&config.Rules)
+	}
+	st.configChangesTotal.Inc()
+	st.getSplitConfig(c)
+}
+
+func (st *state) deleteSplitConfig(c *web.Context) {
+	st.splitRules.Store(nil)
+	st.configChangesTotal.Inc()
+	st.getSplitConfig(c)
+}
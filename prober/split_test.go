@@ -0,0 +1,71 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSplitConfig(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string, header string, cookie string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set("X-Canary", header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "variant", Value: cookie})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/config/split", `{"rules": [
+		{"variant": "canary", "header": "x-canary", "value": "true", "faults": {"errorRate": 1, "errorStatus": 500}},
+		{"variant": "slow", "cookie": "variant", "value": "b", "faults": {"latency": "50ms"}}
+	]}`, "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"latency":"50ms"`) {
+		t.Fatalf("expected the rules, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := request(http.MethodGet, "/readiness", "", "true", ""); w.Code != http.StatusInternalServerError || w.Header().Get(splitVariantHeader) != "canary" {
+		t.Errorf("expected the canary to fail, got %d %v", w.Code, w.Header())
+	}
+	if w := request(http.MethodGet, "/readiness", "", "false", ""); w.Code != http.StatusOK || w.Header().Get(splitVariantHeader) != "control" {
+		t.Errorf("expected another value to behave normally, got %d %v", w.Code, w.Header())
+	}
+	start := time.Now()
+	if w := request(http.MethodGet, "/readiness", "", "", "b"); w.Code != http.StatusOK || w.Header().Get(splitVariantHeader) != "slow" {
+		t.Errorf("expected the cookie variant, got %d %v", w.Code, w.Header())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the latency of the variant, took %v", elapsed)
+	}
+	if value := testutil.ToFloat64(st.faultsInjectedTotal.WithLabelValues("split-canary", "error")); value < 1 {
+		t.Errorf("expected the injected error to be counted, got %v", value)
+	}
+
+	if w := request(http.MethodPost, "/config/split", `{"rules": [{"variant": "both", "header": "a", "cookie": "b"}]}`, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a rule matching both, got %d", w.Code)
+	}
+	if w := request(http.MethodPost, "/config/split", `{"rules": [{"variant": "late", "header": "a", "faults": {"latency": "soon"}}]}`, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid latency, got %d", w.Code)
+	}
+
+	if w := request(http.MethodDelete, "/config/split", "", "", ""); w.Body.String() != `{"rules":[]}` {
+		t.Errorf("expected no rule left, got %s", w.Body.String())
+	}
+	if w := request(http.MethodGet, "/readiness", "", "true", ""); w.Code != http.StatusOK || w.Header().Get(splitVariantHeader) != "" {
+		t.Errorf("expected no split once removed, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,182 @@
+package prober
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// state is what the endpoints and workers of a Server share: its settings,
+// its metrics registry and the runtime state, like the counters, mocks or
+// scenario running. Each Server has its own, so servers embedded in the same
+// process neither share nor overwrite them.
+type state struct {
+	config   *settings
+	registry *prometheus.Registry
+	random   *seededRand
+
+	// Set by NewServer from the configuration, nil when disabled. kube is
+	// the in-cluster API client, nil outside of a cluster.
+	kube             *kubeClient
+	kubeEvents       *eventRecorder
+	elector          *leaderElector
+	proberConfigs    *configController
+	replicaMesh      *meshMonitor
+	chaos            *chaosMonkey
+	targetChecker    *checker
+	webhooks         *webhookNotifier
+	statsdSink       *statsdClient
+	trafficRecording *trafficRecorder
+	stressPool       *workerPool
+	payloadCache     *responseCache
+	tokenValidator   *jwtValidator
+	relyingParty     *oidcRelyingParty
+	injectedHeaders  *responseHeaders
+	sensitiveHeaders headerRedactor
+
+	requestBodyLimits *bodyLimits
+	// egressChecks is the suite run on demand by POST /egress/run.
+	egressChecks    []checkConfig
+	customScripts   []*scriptRoute
+	relayTargets    map[string]*relayTarget
+	scenarioLibrary map[string]*libraryScenario
+	probeModules    map[string]checkConfig
+
+	// Changed at runtime through the endpoints.
+	counters       *counterStore
+	mocks          *mockStore
+	scenarios      *scenarioEngine
+	probeOverrides probeOverrideSet
+	// runtimeFaults is the profile applied on top of the listener ones and
+	// splitRules are matched in order, both nil when unset.
+	runtimeFaults   atomic.Pointer[faultProfile]
+	splitRules      atomic.Pointer[[]splitRule]
+	idempotencyKeys *idempotencyStore
+	longPolls       *longPollHub
+	syntheticLoad   *loadGenerator
+	proberClock     *virtualClock
+	recentRequests  *requestRing
+	observedTokens  tokenRotations
+	nodeTopology    topologyCache
+	termination     terminationState
+	lastThrottling  atomic.Pointer[cpuThrottling]
+
+	serverHealth   *selfHealth
+	serverShutdown *shutdownState
+	activeRequests atomic.Int64
+	metricsRoutes  *routeLabels
+
+	serverMetrics
+	adminMetrics
+	admissionMetrics
+	bodyLimitMetrics
+	cacheMetrics
+	chaosMetrics
+	checkMetrics
+	clockMetrics
+	eventMetrics
+	fastPathMetrics
+	gcMetrics
+	idempotencyMetrics
+	jwtMetrics
+	leaderMetrics
+	limitMetrics
+	loadMetrics
+	longPollMetrics
+	meshMetrics
+	mockMetrics
+	oidcMetrics
+	relayMetrics
+	scenarioMetrics
+	scenarioReportMetrics
+	scriptMetrics
+	splitMetrics
+	stressMetrics
+	terminationMetrics
+	timeoutMetrics
+	webhookMetrics
+}
+
+// newState returns the state of a server with the settings of opts,
+// registering its metrics in a registry of its own.
+func newState(opts Options) *state {
+	config := newSettings(opts.Config)
+	registry := prometheus.NewRegistry()
+	buckets := getMetricsBuckets(config)
+	seed := time.Now().UnixNano()
+	if opts.Seeded {
+		seed = opts.Seed
+	}
+	st := &state{
+		config:           config,
+		registry:         registry,
+		random:           newSeededRand(seed),
+		sensitiveHeaders: newHeaderRedactor(defaultRedactHeaders),
+		probeModules:     blackboxModules,
+
+		counters:        &counterStore{values: make(map[string]int64)},
+		mocks:           &mockStore{},
+		idempotencyKeys: newIdempotencyStore(config.getInt(idempotencyMaxKeysEnv, defaultIdempotencyMaxKeys)),
+		longPolls:       &longPollHub{channels: make(map[string]*longPollRelease)},
+		proberClock:     &virtualClock{enabled: config.getBool(virtualClockEnv, false), changed: make(chan struct{})},
+		recentRequests:  newRequestRing(config.getInt(requestsBufferSizeEnv, defaultRequestsBufferSize)),
+
+		serverHealth:   &selfHealth{listeners: make(map[string]error)},
+		serverShutdown: newShutdownState(),
+		metricsRoutes:  newRouteLabels(config.getInt(metricsMaxRoutesEnv, defaultMetricsMaxRoutes)),
+
+		serverMetrics:         newServerMetrics(registry, buckets),
+		adminMetrics:          newAdminMetrics(registry),
+		admissionMetrics:      newAdmissionMetrics(registry),
+		bodyLimitMetrics:      newBodyLimitMetrics(registry),
+		cacheMetrics:          newCacheMetrics(registry),
+		chaosMetrics:          newChaosMetrics(registry),
+		checkMetrics:          newCheckMetrics(registry, buckets),
+		clockMetrics:          newClockMetrics(registry),
+		eventMetrics:          newEventMetrics(registry),
+		fastPathMetrics:       newFastPathMetrics(registry),
+		gcMetrics:             newGCMetrics(registry),
+		idempotencyMetrics:    newIdempotencyMetrics(registry),
+		jwtMetrics:            newJWTMetrics(registry),
+		leaderMetrics:         newLeaderMetrics(registry),
+		limitMetrics:          newLimitMetrics(registry),
+		loadMetrics:           newLoadMetrics(registry),
+		longPollMetrics:       newLongPollMetrics(registry),
+		meshMetrics:           newMeshMetrics(registry),
+		mockMetrics:           newMockMetrics(registry),
+		oidcMetrics:           newOIDCMetrics(registry),
+		relayMetrics:          newRelayMetrics(registry),
+		scenarioMetrics:       newScenarioMetrics(registry),
+		scenarioReportMetrics: newScenarioReportMetrics(registry),
+		scriptMetrics:         newScriptMetrics(registry),
+		splitMetrics:          newSplitMetrics(registry),
+		stressMetrics:         newStressMetrics(registry),
+		terminationMetrics:    newTerminationMetrics(registry),
+		timeoutMetrics:        newTimeoutMetrics(registry),
+		webhookMetrics:        newWebhookMetrics(registry),
+	}
+	st.scenarios = &scenarioEngine{st: st}
+	st.syntheticLoad = &loadGenerator{loadMetrics: st.loadMetrics}
+	st.heapBallastBytes.Set(float64(len(heapBallast)))
+	registerThrottlingMetrics(registry, &st.lastThrottling)
+	registry.MustRegister(newBuildInfoCollector(config))
+	for probe, env := range map[string]string{
+		"startup":   startupProbeDelayEnv,
+		"readiness": readinessProbeDelayEnv,
+		"liveness":  livenessProbeDelayEnv,
+	} {
+		registry.MustRegister(st.newProbeDelayCollector(probe, env))
+	}
+
+	// Goroutine, heap and file descriptor metrics show the effect of the
+	// stress endpoints on prober itself.
+	if config.getBool(metricsRuntimeCollectorsEnv, true) {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	return st
+}
@@ -0,0 +1,125 @@
+package prober
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statsdAddrEnv   = "STATSD_ADDR"
+	statsdPrefixEnv = "STATSD_PREFIX"
+	statsdFormatEnv = "STATSD_FORMAT"
+	statsdTagsEnv   = "STATSD_TAGS"
+
+	statsdFormatStatsd    = "statsd"
+	statsdFormatDogStatsd = "dogstatsd"
+
+	defaultStatsdPrefix = "prober."
+)
+
+// statsdClient sends the request and probe metrics over UDP for
+// environments that can't scrape Prometheus. DogStatsD receives labels as
+// tags, plain StatsD as extra name segments. A nil client sends nothing.
+type statsdClient struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+	tags      []string
+}
+
+// loadStatsdClient returns a nil client when STATSD_ADDR is unset.
+func loadStatsdClient(config *settings) (*statsdClient, error) {
+	addr := config.get(statsdAddrEnv)
+	if addr == "" {
+		return nil, nil
+	}
+
+	format := config.getString(statsdFormatEnv, statsdFormatDogStatsd)
+	if format != statsdFormatStatsd && format != statsdFormatDogStatsd {
+		return nil, fmt.Errorf("%s must be %s or %s", statsdFormatEnv, statsdFormatStatsd, statsdFormatDogStatsd)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(config.get(statsdTagsEnv), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return &statsdClient{
+		conn:      conn,
+		prefix:    config.getString(statsdPrefixEnv, defaultStatsdPrefix),
+		dogstatsd: format == statsdFormatDogStatsd,
+		tags:      tags,
+	}, nil
+}
+
+// count sends a counter increment. Tags are "key:value" pairs.
+func (s *statsdClient) count(name string, value int64, tags ...string) {
+	s.send(name, strconv.FormatInt(value, 10)+"|c", tags)
+}
+
+// timing sends a duration in milliseconds.
+func (s *statsdClient) timing(name string, duration time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64)+"|ms", tags)
+}
+
+func (s *statsdClient) send(name string, value string, tags []string) {
+	if s == nil {
+		return
+	}
+	// Losing a datagram is acceptable, like with any StatsD client.
+	s.conn.Write([]byte(s.format(name, value, tags)))
+}
+
+func (s *statsdClient) format(name string, value string, tags []string) string {
+	var line strings.Builder
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+
+	if !s.dogstatsd {
+		for _, tag := range tags {
+			_, tagValue, _ := strings.Cut(tag, ":")
+			line.WriteString(".")
+			line.WriteString(statsdSegment(tagValue))
+		}
+		line.WriteString(":")
+		line.WriteString(value)
+		return line.String()
+	}
+
+	line.WriteString(":")
+	line.WriteString(value)
+	tags = append(append([]string{}, s.tags...), tags...)
+	if len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(tags, ","))
+	}
+	return line.String()
+}
+
+// statsdSegment makes a label value safe to use inside a dotted metric name.
+func statsdSegment(value string) string {
+	if value == "" {
+		return "unmatched"
+	}
+	value = strings.Trim(value, "/")
+	if value == "" {
+		return "root"
+	}
+	return strings.NewReplacer("/", "_", ".", "_", ":", "", "|", "_", "@", "_").Replace(value)
+}
+
+func (s *statsdClient) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,83 @@
+package prober
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestStatsdFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *statsdClient
+		expected string
+	}{
+		{"dogstatsd", &statsdClient{prefix: "prober.", dogstatsd: true, tags: []string{"env:test"}}, "prober.http.requests:1|c|#env:test,route:/delay/:seconds,status:200"},
+		{"statsd", &statsdClient{prefix: "prober."}, "prober.http.requests.delay_seconds.200:1|c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := tt.client.format("http.requests", "1|c", []string{"route:/delay/:seconds", "status:200"})
+			if line != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, line)
+			}
+		})
+	}
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	st := newState(Options{})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv(statsdAddrEnv, conn.LocalAddr().String())
+	t.Setenv(livenessProbeDelayEnv, "0")
+	client, err := loadStatsdClient(st.config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+	st.statsdSink = client
+
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.metricsMiddleware())
+	router.GET("/liveness", st.probeHandler(livenessProbeDelayEnv, "liveness"))
+
+	req, _ := http.NewRequest("GET", "/liveness", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := []string{
+		"prober.http.requests:1|c|#method:GET,route:/liveness,status:200,proto:HTTP/1.1,family:unknown",
+		"prober.http.request.duration:",
+		"prober.probe.requests:1|c|#probe:liveness,outcome:success",
+	}
+	buf := make([]byte, 1024)
+	for _, prefix := range expected {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected a datagram: %v", err)
+		}
+		if line := string(buf[:n]); len(line) < len(prefix) || line[:len(prefix)] != prefix {
+			t.Errorf("expected %s, got %s", prefix, line)
+		}
+	}
+}
+
+func TestLoadStatsdClientInvalidFormat(t *testing.T) {
+	t.Setenv(statsdAddrEnv, "127.0.0.1:8125")
+	t.Setenv(statsdFormatEnv, "graphite")
+
+	if _, err := loadStatsdClient(envSettings); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,181 @@
+package prober
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	stressWorkersEnv = "STRESS_WORKERS"
+	stressModeEnv    = "STRESS_MODE"
+
+	// stressModeFair keeps a core out of the pool for the probes, which
+	// don't wait for it; stressModeStarve lets the pool take every core and
+	// makes the probes wait for a worker like the stress requests.
+	stressModeFair   = "fair"
+	stressModeStarve = "starve"
+
+	defaultCompressBytes = 1 << 20
+	maxCompressBytes     = 256 << 20
+)
+
+// stressMetrics expose the occupation of the stress worker pool.
+type stressMetrics struct {
+	stressWorkersBusy    prometheus.Gauge
+	stressWorkersWaiting *prometheus.GaugeVec
+}
+
+func newStressMetrics(registry prometheus.Registerer) stressMetrics {
+	m := stressMetrics{
+		stressWorkersBusy: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "stress_workers_busy",
+			Help: "Workers of the stress pool running a CPU burn or a compression.",
+		}),
+
+		stressWorkersWaiting: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stress_workers_waiting",
+			Help: "Jobs waiting for a worker of the stress pool by kind, stress or probe.",
+		}, []string{"kind"}),
+	}
+	registry.MustRegister(m.stressWorkersBusy, m.stressWorkersWaiting)
+	return m
+}
+
+// workerPool bounds the goroutines of the CPU stress endpoints, so they
+// can't take the cores the probes need unless the mode lets them.
+type workerPool struct {
+	mode  string
+	slots chan struct{}
+
+	stressMetrics
+}
+
+// loadWorkerPool sizes the pool to STRESS_WORKERS, by default every core
+// but one in the fair mode and all of them in the starve mode.
+func loadWorkerPool(config *settings, metrics stressMetrics) (*workerPool, error) {
+	mode := config.getString(stressModeEnv, stressModeFair)
+	workers := runtime.GOMAXPROCS(0)
+	switch mode {
+	case stressModeFair:
+		workers = max(1, workers-1)
+	case stressModeStarve:
+	default:
+		return nil, fmt.Errorf("invalid %s %q, expected %s or %s", stressModeEnv, mode, stressModeFair, stressModeStarve)
+	}
+	workers = config.getInt(stressWorkersEnv, workers)
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid %s %d, expected at least 1", stressWorkersEnv, workers)
+	}
+	return &workerPool{mode: mode, slots: make(chan struct{}, workers), stressMetrics: metrics}, nil
+}
+
+// acquire waits for a free worker until the context is done.
+func (p *workerPool) acquire(ctx context.Context, kind string) bool {
+	if p == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+		p.stressWorkersBusy.Inc()
+		return true
+	default:
+	}
+	waiting := p.stressWorkersWaiting.WithLabelValues(kind)
+	waiting.Inc()
+	defer waiting.Dec()
+	select {
+	case p.slots <- struct{}{}:
+		p.stressWorkersBusy.Inc()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *workerPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.slots
+	p.stressWorkersBusy.Dec()
+}
+
+func (p *workerPool) size() int {
+	if p == nil {
+		return 0
+	}
+	return cap(p.slots)
+}
+
+// stressProbe makes the probes wait for a worker in the starve mode, so
+// saturating the pool fails them once the kubelet timeout is reached.
+func (st *state) stressProbe() web.HandlerFunc {
+	return func(c *web.Context) {
+		pool := st.stressPool
+		if pool == nil || pool.mode != stressModeStarve {
+			c.Next()
+			return
+		}
+		if !pool.acquire(c.Request.Context(), "probe") {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, web.H{"error": "Stress workers busy"})
+			return
+		}
+		defer pool.release()
+		c.Next()
+	}
+}
+
+// compressWords make a text gzip shrinks a few times, like logs or JSON.
+var compressWords = []string{"prober", "readiness", "liveness", "startup", "latency", "request", "status", "pod", "node", "zone", "{", "}", ":", ",", "\n"}
+
+// compressPayload generates size bytes of text, always the same for a size.
+func compressPayload(size int) []byte {
+	source := rand.New(rand.NewSource(int64(size)))
+	payload := make([]byte, 0, size+16)
+	for len(payload) < size {
+		payload = append(payload, compressWords[source.Intn(len(compressWords))]...)
+		payload = append(payload, ' ')
+	}
+	return payload[:size]
+}
+
+// compressHandler answers GET /compress?bytes=N&level=L with N bytes of
+// generated text gzipped at level L, the compression running on a worker of
+// the stress pool.
+func (st *state) compressHandler(c *web.Context) {
+	size, err := strconv.Atoi(c.DefaultQuery("bytes", strconv.Itoa(defaultCompressBytes)))
+	if err != nil || size < 0 || size > maxCompressBytes {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid bytes value"})
+		return
+	}
+	level, err := strconv.Atoi(c.DefaultQuery("level", strconv.Itoa(gzip.DefaultCompression)))
+	if err != nil || level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid level value"})
+		return
+	}
+
+	if !st.stressPool.acquire(c.Request.Context(), "stress") {
+		c.JSON(http.StatusServiceUnavailable, web.H{"error": "Stress workers busy"})
+		return
+	}
+	started := time.Now()
+	var compressed bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&compressed, level)
+	writer.Write(compressPayload(size))
+	writer.Close()
+	st.stressPool.release()
+
+	c.Header("X-Uncompressed-Bytes", strconv.Itoa(size))
+	c.Header("X-Compression-Duration", time.Since(started).String())
+	c.Data(http.StatusOK, "application/gzip", compressed.Bytes())
+}
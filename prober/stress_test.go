@@ -0,0 +1,117 @@
+package prober
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestLoadWorkerPool(t *testing.T) {
+	st := newState(Options{})
+	procs := runtime.GOMAXPROCS(0)
+	tests := []struct {
+		mode    string
+		workers string
+		size    int
+		err     bool
+	}{
+		{"", "", max(1, procs-1), false},
+		{stressModeStarve, "", procs, false},
+		{stressModeFair, "3", 3, false},
+		{stressModeFair, "0", 0, true},
+		{"greedy", "", 0, true},
+	}
+	for _, test := range tests {
+		t.Setenv(stressModeEnv, test.mode)
+		t.Setenv(stressWorkersEnv, test.workers)
+		pool, err := loadWorkerPool(st.config, st.stressMetrics)
+		if (err != nil) != test.err {
+			t.Errorf("%q/%q: expected error %t, got %v", test.mode, test.workers, test.err, err)
+		}
+		if pool.size() != test.size {
+			t.Errorf("%q/%q: expected %d workers, got %d", test.mode, test.workers, test.size, pool.size())
+		}
+	}
+}
+
+func TestStressProbeModes(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(livenessProbeDelayEnv, "0")
+
+	for mode, status := range map[string]int{stressModeFair: http.StatusOK, stressModeStarve: http.StatusServiceUnavailable} {
+		t.Setenv(stressModeEnv, mode)
+		t.Setenv(stressWorkersEnv, "1")
+		pool, err := loadWorkerPool(st.config, st.stressMetrics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.stressPool = pool
+		router := st.newRouter(nil, listenerConfig{})
+
+		// A burn holds the only worker.
+		ctx, cancel := context.WithCancel(context.Background())
+		burned := make(chan int)
+		go func() { burned <- burnCPU(ctx, pool, 2) }()
+		for len(pool.slots) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		probeCtx, probeCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req, _ := http.NewRequestWithContext(probeCtx, "GET", "/liveness", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		probeCancel()
+		cancel()
+
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", mode, status, w.Code)
+		}
+		if workers := <-burned; workers != 1 {
+			t.Errorf("%s: expected 1 core to burn, got %d", mode, workers)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+
+	req, _ := http.NewRequest("GET", "/compress?bytes=65536&level=9", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := io.ReadAll(reader)
+	if !bytes.Equal(payload, compressPayload(65536)) {
+		t.Errorf("expected the generated payload, got %d bytes", len(payload))
+	}
+	if w.Body.Len() >= len(payload)/2 {
+		t.Errorf("expected a compressible payload, got %d bytes out of %d", w.Body.Len(), len(payload))
+	}
+
+	for _, query := range []string{"bytes=-1", "bytes=1073741824", "level=10", "level=fast"} {
+		req, _ := http.NewRequest("GET", "/compress?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	watchPodDeletionEnv = "WATCH_POD_DELETION"
+
+	podWatchRetry = 5 * time.Second
+)
+
+// terminationMetrics time the pod deletion up to the termination signal.
+type terminationMetrics struct {
+	podDeletionObservedTimestamp prometheus.Gauge
+	podDeletionToSignal          prometheus.Gauge
+}
+
+func newTerminationMetrics(registry prometheus.Registerer) terminationMetrics {
+	m := terminationMetrics{
+		podDeletionObservedTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pod_deletion_observed_timestamp_seconds",
+			Help: "Time the deletionTimestamp of the pod was seen through the API.",
+		}),
+
+		podDeletionToSignal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pod_deletion_to_signal_seconds",
+			Help: "Time from seeing the deletionTimestamp of the pod to receiving the termination signal.",
+		}),
+	}
+	registry.MustRegister(m.podDeletionObservedTimestamp, m.podDeletionToSignal)
+	return m
+}
+
+// terminationTimeline is what prober saw of its own termination. The
+// deletion was requested deletionGracePeriodSeconds before the
+// deletionTimestamp, which is the deadline of the graceful deletion.
+type terminationTimeline struct {
+	DeletionRequested *time.Time `json:"deletionRequested,omitempty"`
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+	DeletionObserved  *time.Time `json:"deletionObserved,omitempty"`
+	Signal            string     `json:"signal,omitempty"`
+	SignalReceived    *time.Time `json:"signalReceived,omitempty"`
+	// ObservedToSignal and RequestedToSignal are in seconds, negative when
+	// the signal came first.
+	ObservedToSignal  *float64 `json:"observedToSignal,omitempty"`
+	RequestedToSignal *float64 `json:"requestedToSignal,omitempty"`
+}
+
+// terminationState records the timeline of the pod termination and drains
+// the pod, failing readiness, as soon as the deletion is seen.
+type terminationState struct {
+	sync.Mutex
+	timeline terminationTimeline
+}
+
+// observeDeletion records the deletion of the pod the first time it is
+// seen.
+func (st *state) observeDeletion(meta kubeObjectMeta, observed time.Time) {
+	st.termination.Lock()
+	defer st.termination.Unlock()
+	if meta.DeletionTimestamp == nil || st.termination.timeline.DeletionObserved != nil {
+		return
+	}
+
+	deadline := *meta.DeletionTimestamp
+	requested := deadline
+	if meta.DeletionGracePeriodSeconds != nil {
+		requested = deadline.Add(-time.Duration(*meta.DeletionGracePeriodSeconds) * time.Second)
+	}
+	timeline := &st.termination.timeline
+	timeline.DeletionTimestamp, timeline.DeletionRequested, timeline.DeletionObserved = &deadline, &requested, &observed
+	timeline.computeDeltas(st.podDeletionToSignal)
+	st.podDeletionObservedTimestamp.Set(float64(observed.UnixNano()) / 1e9)
+
+	slog.Info("Pod deletion observed, draining", "deletionTimestamp", deadline, "lag", observed.Sub(requested).String())
+	st.kubeEvents.emit(eventTypeNormal, "DeletionObserved", fmt.Sprintf("Deletion observed %v after it was requested, failing readiness", observed.Sub(requested).Round(time.Millisecond)))
+}
+
+// observeSignal records the termination signal.
+func (st *state) observeSignal(sig os.Signal, received time.Time) {
+	st.termination.Lock()
+	defer st.termination.Unlock()
+	if st.termination.timeline.SignalReceived != nil {
+		return
+	}
+	st.termination.timeline.Signal, st.termination.timeline.SignalReceived = sig.String(), &received
+	st.termination.timeline.computeDeltas(st.podDeletionToSignal)
+}
+
+func (t *terminationTimeline) computeDeltas(toSignal prometheus.Gauge) {
+	if t.SignalReceived == nil || t.DeletionObserved == nil {
+		return
+	}
+	observed := t.SignalReceived.Sub(*t.DeletionObserved).Seconds()
+	requested := t.SignalReceived.Sub(*t.DeletionRequested).Seconds()
+	t.ObservedToSignal, t.RequestedToSignal = &observed, &requested
+	toSignal.Set(observed)
+	slog.Info("Termination signal received", "signal", t.Signal, "afterDeletionObserved", observed, "afterDeletionRequested", requested)
+}
+
+func (st *state) deletionObserved() bool {
+	st.termination.Lock()
+	defer st.termination.Unlock()
+	return st.termination.timeline.DeletionObserved != nil
+}
+
+// podWatcher watches the pod object of prober for its deletionTimestamp.
+type podWatcher struct {
+	st        *state
+	client    *kubeClient
+	namespace string
+	name      string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// loadPodWatcher returns nil when WATCH_POD_DELETION is unset.
+func (st *state) loadPodWatcher(client *kubeClient) (*podWatcher, error) {
+	if !st.config.getBool(watchPodDeletionEnv, false) {
+		return nil, nil
+	}
+	if client == nil {
+		return nil, errors.New("watching the pod deletion needs to run in a cluster")
+	}
+	name := st.config.get(podNameEnv)
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return newPodWatcher(st, client, st.config.getString(podNamespaceEnv, client.namespace), name), nil
+}
+
+func newPodWatcher(st *state, client *kubeClient, namespace string, name string) *podWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &podWatcher{st: st, client: client, namespace: namespace, name: name, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+func (w *podWatcher) run() {
+	defer close(w.done)
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?fieldSelector=%s", w.namespace, url.QueryEscape("metadata.name="+w.name))
+
+	for {
+		err := w.client.watch(w.ctx, path, "", func(event kubeWatchEvent) error {
+			var pod kubePod
+			if err := json.Unmarshal(event.Object, &pod); err != nil {
+				return err
+			}
+			if event.Type == "ADDED" || event.Type == "MODIFIED" {
+				w.st.observeDeletion(pod.Metadata, time.Now())
+			}
+			return nil
+		})
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		slog.Warn("Failed to watch the pod", "pod", w.name, "error", err)
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(podWatchRetry):
+		}
+	}
+}
+
+func (w *podWatcher) Close() {
+	w.cancel()
+	<-w.done
+}
+
+// terminationHandler answers GET /termination with the timeline of the
+// pod deletion and termination signal.
+func (st *state) terminationHandler(c *web.Context) {
+	st.termination.Lock()
+	defer st.termination.Unlock()
+	c.JSON(http.StatusOK, st.termination.timeline)
+}
+
+// terminationReadiness fails /readiness once the deletion of the pod was
+// seen, draining it before the endpoints controller removes it.
+func (st *state) terminationReadiness() web.HandlerFunc {
+	return func(c *web.Context) {
+		if st.deletionObserved() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, web.H{"error": "Pod is terminating"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package prober
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestPodDeletionWatch(t *testing.T) {
+	t.Setenv(readinessProbeDelayEnv, "0")
+	st := newState(Options{})
+
+	requested := time.Now().Add(-2 * time.Second).UTC().Truncate(time.Second)
+	client := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods" || r.URL.Query().Get("fieldSelector") != "metadata.name=prober-0" || r.URL.Query().Get("watch") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"type":"ADDED","object":{"metadata":{"name":"prober-0"}}}`)
+		fmt.Fprintf(w, `{"type":"MODIFIED","object":{"metadata":{"name":"prober-0","deletionTimestamp":%q,"deletionGracePeriodSeconds":30}}}`,
+			requested.Add(30*time.Second).Format(time.RFC3339))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+
+	watcher := newPodWatcher(st, client, "default", "prober-0")
+	go watcher.run()
+	defer watcher.Close()
+	for deadline := time.Now().Add(2 * time.Second); !st.deletionObserved() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := st.newRouter(nil, listenerConfig{})
+	req, _ := http.NewRequest("GET", "/readiness", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail once the deletion is observed, got %d", w.Code)
+	}
+
+	st.observeSignal(syscall.SIGTERM, time.Now())
+	req, _ = http.NewRequest("GET", "/termination", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var timeline terminationTimeline
+	if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if timeline.DeletionRequested == nil || !timeline.DeletionRequested.Equal(requested) || timeline.Signal != "terminated" {
+		t.Errorf("unexpected timeline: %+v", timeline)
+	}
+	if timeline.ObservedToSignal == nil || *timeline.ObservedToSignal < 0 || timeline.RequestedToSignal == nil || *timeline.RequestedToSignal < 2 {
+		t.Errorf("unexpected deltas: %+v", timeline)
+	}
+}
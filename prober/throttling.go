@@ -0,0 +1,220 @@
+package prober
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	cgroupStatIntervalEnv = "CGROUP_STAT_INTERVAL"
+
+	defaultCgroupStatInterval = 10 * time.Second
+	defaultBurnDuration       = 10 * time.Second
+	maxBurnDuration           = 5 * time.Minute
+)
+
+// cpuThrottling are the CFS bandwidth counters of cpu.stat.
+type cpuThrottling struct {
+	Periods          int64   `json:"periods"`
+	ThrottledPeriods int64   `json:"throttledPeriods"`
+	ThrottledSeconds float64 `json:"throttledSeconds"`
+	// RecentRatio is the share of the periods throttled between the last
+	// two reads of the monitor.
+	RecentRatio float64 `json:"recentRatio"`
+}
+
+// registerThrottlingMetrics exposes the last read of the monitor, nil
+// without CPU limit accounting.
+func registerThrottlingMetrics(registry prometheus.Registerer, last *atomic.Pointer[cpuThrottling]) {
+	read := func(value func(cpuThrottling) float64) func() float64 {
+		return func() float64 {
+			if stats := last.Load(); stats != nil {
+				return value(*stats)
+			}
+			return 0
+		}
+	}
+	registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cpu_cfs_periods_total",
+			Help: "CFS enforcement periods elapsed, from the cgroup cpu.stat.",
+		}, read(func(s cpuThrottling) float64 { return float64(s.Periods) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cpu_cfs_throttled_periods_total",
+			Help: "CFS enforcement periods the container was throttled in, from the cgroup cpu.stat.",
+		}, read(func(s cpuThrottling) float64 { return float64(s.ThrottledPeriods) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cpu_cfs_throttled_seconds_total",
+			Help: "Time the container was throttled, from the cgroup cpu.stat.",
+		}, read(func(s cpuThrottling) float64 { return s.ThrottledSeconds })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cpu_cfs_throttled_ratio",
+			Help: "Share of the CFS periods throttled over the last monitor interval.",
+		}, read(func(s cpuThrottling) float64 { return s.RecentRatio })),
+	)
+}
+
+// readThrottling reads cpu.stat of cgroup v2, or of the cpu controller of
+// cgroup v1 where the throttled time is in nanoseconds.
+func readThrottling(root string) (cpuThrottling, bool) {
+	path, unit, timeKey := filepath.Join(root, "cpu.stat"), 1e6, "throttled_usec"
+	if _, ok := readCgroupStat(path, "nr_periods"); !ok {
+		path, unit, timeKey = filepath.Join(root, "cpu", "cpu.stat"), 1e9, "throttled_time"
+	}
+	periods, ok := readCgroupStat(path, "nr_periods")
+	if !ok {
+		return cpuThrottling{}, false
+	}
+	throttled, _ := readCgroupStat(path, "nr_throttled")
+	throttledTime, _ := readCgroupStat(path, timeKey)
+	return cpuThrottling{Periods: periods, ThrottledPeriods: throttled, ThrottledSeconds: float64(throttledTime) / unit}, true
+}
+
+// throttlingMonitor reads the throttling counters every interval, so the
+// metrics and /resources show how much the CPU limit holds the container
+// back.
+type throttlingMonitor struct {
+	root     string
+	interval time.Duration
+	last     *atomic.Pointer[cpuThrottling]
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// loadThrottlingMonitor returns nil when the cgroup has no CPU bandwidth
+// accounting, like outside of a container.
+func loadThrottlingMonitor(config *settings, last *atomic.Pointer[cpuThrottling]) *throttlingMonitor {
+	root := config.getString(cgroupRootEnv, defaultCgroupRoot)
+	if _, ok := readThrottling(root); !ok {
+		return nil
+	}
+	return &throttlingMonitor{
+		root:     root,
+		interval: config.getDuration(cgroupStatIntervalEnv, defaultCgroupStatInterval),
+		last:     last,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (m *throttlingMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.read()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *throttlingMonitor) read() {
+	stats, ok := readThrottling(m.root)
+	if !ok {
+		return
+	}
+	if previous := m.last.Load(); previous != nil && stats.Periods > previous.Periods {
+		stats.RecentRatio = float64(stats.ThrottledPeriods-previous.ThrottledPeriods) / float64(stats.Periods-previous.Periods)
+	}
+	m.last.Store(&stats)
+}
+
+func (m *throttlingMonitor) Close() {
+	close(m.stop)
+	<-m.done
+}
+
+// burnCPU keeps cores goroutines busy until the context is done, each on
+// a worker of the stress pool: the ones getting none before the end don't
+// run. It returns how many burned.
+func burnCPU(ctx context.Context, pool *workerPool, cores int) int {
+	var wg sync.WaitGroup
+	var workers atomic.Int64
+	for i := 0; i < cores; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !pool.acquire(ctx, "stress") {
+				return
+			}
+			defer pool.release()
+			workers.Add(1)
+			for ctx.Err() == nil {
+				for j := 0; j < 1_000_000; j++ {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(workers.Load())
+}
+
+type burnReport struct {
+	Cores int `json:"cores"`
+	// Workers is how many cores the stress pool let burn.
+	Workers          int            `json:"workers"`
+	Duration         string         `json:"duration"`
+	Before           *cpuThrottling `json:"before,omitempty"`
+	After            *cpuThrottling `json:"after,omitempty"`
+	ThrottledPeriods int64          `json:"throttledPeriods"`
+	ThrottledSeconds float64        `json:"throttledSeconds"`
+}
+
+// burnHandler answers POST /resources/burn?cores=N&duration=D by burning
+// CPU, one more core than the limit by default, and reports the throttling
+// it caused.
+func (st *state) burnHandler(c *web.Context) {
+	root := st.config.getString(cgroupRootEnv, defaultCgroupRoot)
+	duration := defaultBurnDuration
+	if value := c.Query("duration"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxBurnDuration {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid duration value"})
+			return
+		}
+		duration = parsed
+	}
+	cores := runtime.GOMAXPROCS(0)
+	if limit := loadResources(root).CPU.LimitCores; limit != nil {
+		cores = int(math.Ceil(*limit)) + 1
+	}
+	if value := c.Query("cores"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 4*runtime.NumCPU() {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid cores value"})
+			return
+		}
+		cores = parsed
+	}
+
+	report := burnReport{Cores: cores, Duration: duration.String()}
+	if before, ok := readThrottling(root); ok {
+		report.Before = &before
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), duration)
+	defer cancel()
+	report.Workers = burnCPU(ctx, st.stressPool, cores)
+	if after, ok := readThrottling(root); ok {
+		report.After = &after
+	}
+	if report.Before != nil && report.After != nil {
+		report.ThrottledPeriods = report.After.ThrottledPeriods - report.Before.ThrottledPeriods
+		report.ThrottledSeconds = report.After.ThrottledSeconds - report.Before.ThrottledSeconds
+	}
+	c.JSON(http.StatusOK, report)
+}
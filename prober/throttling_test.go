@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestReadThrottling(t *testing.T) {
+	v2 := writeCgroupFiles(t, map[string]string{
+		"cpu.stat": "usage_usec 100\nnr_periods 40\nnr_throttled 10\nthrottled_usec 2500000\n",
+	})
+	stats, ok := readThrottling(v2)
+	if !ok || stats.Periods != 40 || stats.ThrottledPeriods != 10 || stats.ThrottledSeconds != 2.5 {
+		t.Errorf("unexpected cgroup v2 throttling: %+v", stats)
+	}
+
+	v1 := writeCgroupFiles(t, map[string]string{
+		"cpu/cpu.stat": "nr_periods 20\nnr_throttled 5\nthrottled_time 1500000000\n",
+	})
+	stats, ok = readThrottling(v1)
+	if !ok || stats.Periods != 20 || stats.ThrottledPeriods != 5 || stats.ThrottledSeconds != 1.5 {
+		t.Errorf("unexpected cgroup v1 throttling: %+v", stats)
+	}
+
+	if _, ok := readThrottling(t.TempDir()); ok {
+		t.Error("expected no throttling without cpu.stat")
+	}
+}
+
+func TestThrottlingMonitor(t *testing.T) {
+	st := newState(Options{})
+	root := writeCgroupFiles(t, map[string]string{"cpu.stat": "nr_periods 100\nnr_throttled 10\nthrottled_usec 0\n"})
+	t.Setenv(cgroupRootEnv, root)
+
+	monitor := loadThrottlingMonitor(st.config, &st.lastThrottling)
+	if monitor == nil {
+		t.Fatal("expected a monitor")
+	}
+	monitor.read()
+	if err := os.WriteFile(filepath.Join(root, "cpu.stat"), []byte("nr_periods 200\nnr_throttled 60\nthrottled_usec 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	monitor.read()
+	if stats := st.lastThrottling.Load(); stats == nil || stats.RecentRatio != 0.5 {
+		t.Errorf("expected half of the recent periods throttled, got %+v", stats)
+	}
+}
+
+func TestBurnHandler(t *testing.T) {
+	t.Setenv(cgroupRootEnv, writeCgroupFiles(t, map[string]string{"cpu.stat": "nr_periods 1\nnr_throttled 0\nthrottled_usec 0\n"}))
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.POST("/resources/burn", st.burnHandler)
+
+	tests := map[string]int{
+		"/resources/burn?cores=1&duration=50ms": http.StatusOK,
+		"/resources/burn?cores=0":               http.StatusBadRequest,
+		"/resources/burn?duration=1h":           http.StatusBadRequest,
+	}
+	for path, status := range tests {
+		req, _ := http.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, w.Code)
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		var report burnReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if report.Cores != 1 || report.Duration != "50ms" || report.Before == nil || report.After == nil {
+			t.Errorf("unexpected report: %+v", report)
+		}
+	}
+}
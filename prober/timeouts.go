@@ -0,0 +1,242 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	handlerTimeoutEnv       = "HANDLER_TIMEOUT"
+	handlerTimeoutRoutesEnv = "HANDLER_TIMEOUT_ROUTES"
+)
+
+// timeoutMetrics count the requests cut by the handler timeouts.
+type timeoutMetrics struct {
+	handlerTimeoutsTotal *prometheus.CounterVec
+}
+
+func newTimeoutMetrics(registry prometheus.Registerer) timeoutMetrics {
+	m := timeoutMetrics{
+		handlerTimeoutsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "handler_timeouts_total",
+			Help: "Requests answered with a 503 because their handler exceeded its timeout, by route.",
+		}, []string{"route"}),
+	}
+	registry.MustRegister(m.handlerTimeoutsTotal)
+	return m
+}
+
+type routeTimeout struct {
+	route    string
+	segments []string
+	timeout  time.Duration
+}
+
+// handlerTimeouts bounds the time the handlers have to answer, the routes
+// of overrides taking precedence over the default, zero meaning no limit.
+type handlerTimeouts struct {
+	fallback  time.Duration
+	overrides []routeTimeout
+}
+
+// loadHandlerTimeouts reads HANDLER_TIMEOUT and HANDLER_TIMEOUT_ROUTES, a
+// list of route templates and timeouts like "/delay/:seconds=30s,/longpoll=0".
+func loadHandlerTimeouts(config *settings) (handlerTimeouts, error) {
+	timeouts := handlerTimeouts{fallback: config.getDuration(handlerTimeoutEnv, 0)}
+	if timeouts.fallback < 0 {
+		return timeouts, fmt.Errorf("invalid %s %s", handlerTimeoutEnv, timeouts.fallback)
+	}
+	for _, entry := range strings.Split(config.getString(handlerTimeoutRoutesEnv, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(entry, "=")
+		timeout, err := time.ParseDuration(value)
+		if !ok || !strings.HasPrefix(route, "/") || err != nil || timeout < 0 {
+			return timeouts, fmt.Errorf("invalid %s entry %q, expected route=duration", handlerTimeoutRoutesEnv, entry)
+		}
+		timeouts.overrides = append(timeouts.overrides, routeTimeout{route: route, segments: strings.Split(route, "/"), timeout: timeout})
+	}
+	// The routes with fewer parameters are more specific, like in gin.
+	sort.SliceStable(timeouts.overrides, func(i, j int) bool {
+		return strings.Count(timeouts.overrides[i].route, ":") < strings.Count(timeouts.overrides[j].route, ":")
+	})
+	return timeouts, nil
+}
+
+func (t handlerTimeouts) enabled() bool {
+	return t.fallback > 0 || len(t.overrides) > 0
+}
+
+// validate checks the overrides name routes of the router.
+func (t handlerTimeouts) validate(routes web.RoutesInfo) error {
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		known[route.Path] = true
+	}
+	for _, override := range t.overrides {
+		if !known[override.route] {
+			return fmt.Errorf("unknown route %s in %s", override.route, handlerTimeoutRoutesEnv)
+		}
+	}
+	return nil
+}
+
+// lookup returns the timeout of the path and the route it matched, the path
+// itself for the default.
+func (t handlerTimeouts) lookup(path string) (time.Duration, string) {
+	segments := strings.Split(path, "/")
+	for _, override := range t.overrides {
+		if matchRoute(override.segments, segments) {
+			return override.timeout, override.route
+		}
+	}
+	return t.fallback, "default"
+}
+
+// matchRoute tells whether the path segments match the ones of a gin route
+// template, with :param and *wildcard segments.
+func matchRoute(route []string, path []string) bool {
+	for i, segment := range route {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(path) || (segment != path[i] && !strings.HasPrefix(segment, ":")) {
+			return false
+		}
+		if strings.HasPrefix(segment, ":") && path[i] == "" {
+			return false
+		}
+	}
+	return len(route) == len(path)
+}
+
+// timeoutWriter buffers the answer of a handler which may time out, like
+// http.TimeoutHandler does, so it is dropped for the 503 when it does.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+	// answered is the status the timeout sent in place of the handler.
+	answered int
+}
+
+// timeoutWriterKey is the context key of the timeoutWriter of a request.
+type timeoutWriterKey struct{}
+
+// answeredStatus is the status the client got: the one of the handler, or
+// the 503 of the handler timeout when it answered first. The middlewares
+// run inside the timeout, so they would see the discarded one otherwise.
+func answeredStatus(c *web.Context) int {
+	if tw, ok := c.Request.Context().Value(timeoutWriterKey{}).(*timeoutWriter); ok {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if tw.answered != 0 {
+			return tw.answered
+		}
+	}
+	return c.Writer.Status()
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.timedOut && w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// handler cancels the context of the requests past their timeout and
+// answers them with a 503, the handler ignoring it running on with its
+// answer discarded. The answers under a timeout are buffered, so streaming
+// routes should have theirs disabled.
+func (t handlerTimeouts) handler(next http.Handler, metrics timeoutMetrics) http.Handler {
+	if !t.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, route := t.lookup(r.URL.Path)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panics := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(context.WithValue(ctx, timeoutWriterKey{}, tw)))
+			close(done)
+		}()
+
+		select {
+		case p := <-panics:
+			// Aborting the connection, like the reset faults do, needs the
+			// panic in the goroutine of net/http.
+			panic(p)
+		case <-done:
+			header := w.Header()
+			for name, values := range tw.header {
+				header[name] = values
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			gone := r.Context().Err() != nil
+			if !gone {
+				tw.answered = http.StatusServiceUnavailable
+			}
+			tw.mu.Unlock()
+			if gone {
+				// The client went away first.
+				return
+			}
+			metrics.handlerTimeoutsTotal.WithLabelValues(route).Inc()
+			body, _ := json.Marshal(web.H{"error": "Handler timeout", "detail": "no answer within " + timeout.String()})
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(body)
+		}
+	})
+}
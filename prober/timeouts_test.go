@@ -0,0 +1,129 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadHandlerTimeouts(t *testing.T) {
+	t.Setenv(handlerTimeoutEnv, "2s")
+	t.Setenv(handlerTimeoutRoutesEnv, "/delay/:seconds=50ms, /longpoll=0,/bytes/:n=1s,/bytes/0=3s")
+	timeouts, err := loadHandlerTimeouts(envSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		timeout time.Duration
+		route   string
+	}{
+		{"/delay/3", 50 * time.Millisecond, "/delay/:seconds"},
+		{"/delay/", 2 * time.Second, "default"},
+		{"/delay/3/more", 2 * time.Second, "default"},
+		{"/longpoll", 0, "/longpoll"},
+		{"/bytes/0", 3 * time.Second, "/bytes/0"},
+		{"/bytes/10", time.Second, "/bytes/:n"},
+		{"/echo", 2 * time.Second, "default"},
+	}
+	for _, test := range tests {
+		timeout, route := timeouts.lookup(test.path)
+		if timeout != test.timeout || route != test.route {
+			t.Errorf("%s: expected %s from %s, got %s from %s", test.path, test.timeout, test.route, timeout, route)
+		}
+	}
+
+	for _, value := range []string{"/delay", "delay=1s", "/delay=soon", "/delay=-1s"} {
+		t.Setenv(handlerTimeoutRoutesEnv, value)
+		if _, err := loadHandlerTimeouts(envSettings); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestHandlerTimeouts(t *testing.T) {
+	st := newState(Options{})
+	captureLogs(t)
+	web.SetMode(web.ReleaseMode)
+	router := web.New()
+	router.Use(st.metricsMiddleware())
+	router.GET("/slow/:delay", func(c *web.Context) {
+		delay, _ := time.ParseDuration(c.Param("delay"))
+		select {
+		case <-time.After(delay):
+			c.JSON(http.StatusOK, web.H{"message": "done"})
+		case <-c.Request.Context().Done():
+		}
+	})
+	router.GET("/delay/:seconds", st.delayRequest)
+	router.GET("/abort", func(c *web.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	timeouts := handlerTimeouts{
+		fallback:  50 * time.Millisecond,
+		overrides: []routeTimeout{{route: "/slow/:delay", segments: []string{"", "slow", ":delay"}, timeout: 100 * time.Millisecond}},
+	}
+	if err := timeouts.validate(router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+	handler := timeouts.handler(router, st.timeoutMetrics)
+
+	timedOut := testutil.ToFloat64(st.handlerTimeoutsTotal.WithLabelValues("/slow/:delay"))
+	unavailable := st.httpRequestsTotal.WithLabelValues("GET", "/slow/:delay", "503", "HTTP/1.1", "unknown")
+	counted := testutil.ToFloat64(unavailable)
+	for delay, status := range map[string]int{"10ms": http.StatusOK, "1s": http.StatusServiceUnavailable} {
+		req, _ := http.NewRequest("GET", "/slow/"+delay, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d %s", delay, status, w.Code, w.Body.String())
+		}
+	}
+	if got := testutil.ToFloat64(st.handlerTimeoutsTotal.WithLabelValues("/slow/:delay")); got != timedOut+1 {
+		t.Errorf("expected %v timeouts, got %v", timedOut+1, got)
+	}
+	// The handler returns once its context is canceled, after the 503.
+	for i := 0; i < 100 && testutil.ToFloat64(unavailable) == counted; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(unavailable); got != counted+1 {
+		t.Errorf("expected the timeout counted as the 503 the client got, got %v -> %v", counted, got)
+	}
+
+	// /delay gives up its sleep once the timeout answered, instead of
+	// holding its goroutine for the whole delay.
+	delayed := st.httpRequestsTotal.WithLabelValues("GET", "/delay/:seconds", "503", "HTTP/1.1", "unknown")
+	before := testutil.ToFloat64(delayed)
+	req, _ := http.NewRequest("GET", "/delay/30", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/delay/30: expected status 503, got %d", w.Code)
+	}
+	for i := 0; i < 100 && testutil.ToFloat64(delayed) == before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(delayed); got != before+1 {
+		t.Errorf("expected /delay to return after its timeout, got %v -> %v", before, got)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("expected the abort to reach net/http, got %v", p)
+			}
+		}()
+		req, _ := http.NewRequest("GET", "/abort", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	unknown := handlerTimeouts{overrides: []routeTimeout{{route: "/nope"}}}
+	if err := unknown.validate(router.Routes()); err == nil {
+		t.Error("expected an unknown route to be refused")
+	}
+}
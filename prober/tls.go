@@ -0,0 +1,217 @@
+package prober
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	tlsCertFileEnv       = "TLS_CERT_FILE"
+	tlsKeyFileEnv        = "TLS_KEY_FILE"
+	tlsAddrEnv           = "TLS_ADDR"
+	tlsReloadIntervalEnv = "TLS_RELOAD_INTERVAL"
+
+	defaultTLSAddr           = ":8443"
+	defaultTLSReloadInterval = 10 * time.Second
+)
+
+type certInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	DNSNames     []string  `json:"dnsNames,omitempty"`
+	IPAddresses  []string  `json:"ipAddresses,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	ExpiresIn    string    `json:"expiresIn"`
+}
+
+func newCertInfo(cert *x509.Certificate) certInfo {
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return certInfo{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		DNSNames:     cert.DNSNames,
+		IPAddresses:  ips,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		ExpiresIn:    time.Until(cert.NotAfter).Round(time.Second).String(),
+	}
+}
+
+type tlsInfo struct {
+	CertFile  string     `json:"certFile"`
+	KeyFile   string     `json:"keyFile"`
+	LoadedAt  time.Time  `json:"loadedAt"`
+	Rotations int        `json:"rotations"`
+	Chain     []certInfo `json:"chain"`
+}
+
+// certReloader keeps the served certificate in sync with the files on disk,
+// so rotations done by cert-manager or a Secret update don't need a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	certPEM   []byte
+	keyPEM    []byte
+	loadedAt  time.Time
+	rotations int
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the cert/key pair and swaps it in when the content changed.
+// It reports whether a new certificate was loaded.
+func (r *certReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil {
+		r.rotations++
+	}
+	r.cert = &cert
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.loadedAt = time.Now()
+	return true, nil
+}
+
+// watch polls the files every interval until stop is closed. Polling is used
+// instead of inotify because mounted Secrets are swapped through symlinks.
+// A zero interval disables the watch.
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rotated, err := r.reload()
+			if err != nil {
+				slog.Error("Failed to reload TLS certificate", "certFile", r.certFile, "error", err)
+				continue
+			}
+			if rotated {
+				slog.Info("TLS certificate reloaded", "certFile", r.certFile)
+			}
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) info() (tlsInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info := tlsInfo{
+		CertFile:  r.certFile,
+		KeyFile:   r.keyFile,
+		LoadedAt:  r.loadedAt,
+		Rotations: r.rotations,
+	}
+	for _, der := range r.cert.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return info, err
+		}
+		info.Chain = append(info.Chain, newCertInfo(cert))
+	}
+	return info, nil
+}
+
+// loadCertReloader loads the configured cert/key pair. It returns a nil
+// reloader when TLS is disabled.
+func loadCertReloader(config *settings) (*certReloader, error) {
+	certFile := config.get(tlsCertFileEnv)
+	keyFile := config.get(tlsKeyFileEnv)
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both " + tlsCertFileEnv + " and " + tlsKeyFileEnv + " must be set")
+	}
+	return newCertReloader(certFile, keyFile)
+}
+
+func newTLSServer(config *settings, reloader *certReloader, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:    config.getString(tlsAddrEnv, defaultTLSAddr),
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.getCertificate,
+		},
+	}
+	if !config.getBool(http2EnabledEnv, true) {
+		// A non-nil empty map stops net/http from negotiating h2 over ALPN.
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return srv
+}
+
+func tlsInfoHandler(reloader *certReloader) web.HandlerFunc {
+	return func(c *web.Context) {
+		if reloader == nil {
+			c.JSON(http.StatusNotFound, web.H{"error": "TLS is not enabled"})
+			return
+		}
+		info, err := reloader.info()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, web.H{"error": "Invalid certificate chain"})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	}
+}
@@ -0,0 +1,128 @@
+package prober
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func writeTestCert(t *testing.T, dir string, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first.example.com")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rotated, err := reloader.reload()
+	if err != nil || rotated {
+		t.Errorf("expected no rotation for unchanged files, got rotated=%v err=%v", rotated, err)
+	}
+
+	writeTestCert(t, dir, "second.example.com")
+	rotated, err = reloader.reload()
+	if err != nil || !rotated {
+		t.Fatalf("expected rotation after files changed, got rotated=%v err=%v", rotated, err)
+	}
+
+	info, err := reloader.info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Rotations != 1 {
+		t.Errorf("expected 1 rotation, got %d", info.Rotations)
+	}
+	if len(info.Chain) != 1 || info.Chain[0].Subject != "CN=second.example.com" {
+		t.Errorf("expected served certificate for second.example.com, got %+v", info.Chain)
+	}
+}
+
+func TestTLSInfo(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "prober.example.com")
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/tls/info", tlsInfoHandler(reloader))
+
+	req, _ := http.NewRequest("GET", "/tls/info", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var info tlsInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(info.Chain) != 1 || info.Chain[0].DNSNames[0] != "prober.example.com" {
+		t.Errorf("expected certificate for prober.example.com, got %+v", info.Chain)
+	}
+}
+
+func TestTLSInfoDisabled(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/tls/info", tlsInfoHandler(nil))
+
+	req, _ := http.NewRequest("GET", "/tls/info", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const defaultTLSCheckTimeout = 5 * time.Second
+
+type tlsCheckResponse struct {
+	Target      string     `json:"target"`
+	ServerName  string     `json:"serverName"`
+	Version     string     `json:"version,omitempty"`
+	CipherSuite string     `json:"cipherSuite,omitempty"`
+	ALPN        string     `json:"alpn,omitempty"`
+	Verified    bool       `json:"verified"`
+	VerifyError string     `json:"verifyError,omitempty"`
+	Handshake   string     `json:"handshake"`
+	Chain       []certInfo `json:"chain"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// tlsHandshake connects to target and reports the certificate it presents.
+// Verification against the system roots is done after the handshake, so an
+// invalid certificate can still be inspected.
+func tlsHandshake(ctx context.Context, target string, serverName string, roots *x509.CertPool) tlsCheckResponse {
+	response := tlsCheckResponse{Target: target, ServerName: serverName, Chain: []certInfo{}}
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	}}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	response.Handshake = time.Since(start).String()
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	response.Version = tls.VersionName(state.Version)
+	response.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	response.ALPN = state.NegotiatedProtocol
+	for _, cert := range state.PeerCertificates {
+		response.Chain = append(response.Chain, newCertInfo(cert))
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			response.VerifyError = err.Error()
+		} else {
+			response.Verified = true
+		}
+	}
+	return response
+}
+
+// tlsCheckHandler answers GET /tlscheck?target=host:port. The SNI defaults
+// to the target host and can be set with serverName.
+func tlsCheckHandler(roots *x509.CertPool) web.HandlerFunc {
+	return func(c *web.Context) {
+		target := c.Query("target")
+		host, _, err := net.SplitHostPort(target)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, web.H{"error": "Invalid target, expected host:port"})
+			return
+		}
+		timeout := defaultTLSCheckTimeout
+		if value := c.Query("timeout"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, web.H{"error": "Invalid timeout value"})
+				return
+			}
+			timeout = parsed
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		response := tlsHandshake(ctx, target, c.DefaultQuery("serverName", host), roots)
+		if response.Error != "" {
+			c.JSON(http.StatusBadGateway, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,69 @@
+package prober
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestTLSHandshake(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	addr := strings.TrimPrefix(target.URL, "https://")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(target.Certificate())
+
+	response := tlsHandshake(context.Background(), addr, "example.com", roots)
+	if response.Error != "" || !response.Verified {
+		t.Fatalf("expected a verified handshake, got %+v", response)
+	}
+	if response.Version == "" || response.CipherSuite == "" {
+		t.Errorf("expected negotiated version and cipher, got %+v", response)
+	}
+	if len(response.Chain) != 1 || len(response.Chain[0].IPAddresses) == 0 {
+		t.Errorf("expected the served certificate with its SANs, got %+v", response.Chain)
+	}
+
+	response = tlsHandshake(context.Background(), addr, "other.example.org", roots)
+	if response.Verified || response.VerifyError == "" {
+		t.Errorf("expected a name mismatch, got %+v", response)
+	}
+}
+
+func TestTLSCheckHandler(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/tlscheck", tlsCheckHandler(x509.NewCertPool()))
+
+	req, _ := http.NewRequest("GET", "/tlscheck?target="+strings.TrimPrefix(target.URL, "https://"), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response tlsCheckResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if response.Verified || response.VerifyError == "" {
+		t.Errorf("expected an untrusted certificate, got %+v", response)
+	}
+
+	req, _ = http.NewRequest("GET", "/tlscheck?target=no-port", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	nodeZoneEnv   = "NODE_ZONE"
+	nodeRegionEnv = "NODE_REGION"
+
+	zoneLabel   = "topology.kubernetes.io/zone"
+	regionLabel = "topology.kubernetes.io/region"
+)
+
+type topology struct {
+	Pod    string `json:"pod"`
+	Node   string `json:"node,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+	Region string `json:"region,omitempty"`
+	Source string `json:"source"`
+}
+
+// kubeNode is the part of a Node prober reads.
+type kubeNode struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+}
+
+func (k *kubeClient) getNode(ctx context.Context, name string) (kubeNode, error) {
+	var node kubeNode
+	err := k.get(ctx, fmt.Sprintf("/api/v1/nodes/%s", name), &node)
+	return node, err
+}
+
+// topologyCache holds the labels of the node once read, since a pod never
+// moves to another node.
+type topologyCache struct {
+	sync.Mutex
+	labels map[string]string
+}
+
+// loadTopology finds the zone and region of the pod in NODE_ZONE and
+// NODE_REGION, then in the topology labels the pod gets copied from its
+// node on recent clusters, then in the labels of the node itself.
+func (st *state) loadTopology(ctx context.Context, client *kubeClient) topology {
+	info := loadPodInfo(ctx, st.config, client)
+	topo := topology{Pod: info.Name, Node: info.Node, Source: "unknown"}
+
+	if zone, region := st.config.get(nodeZoneEnv), st.config.get(nodeRegionEnv); zone != "" || region != "" {
+		topo.Zone, topo.Region, topo.Source = zone, region, "env"
+		return topo
+	}
+	if zone, region := info.Labels[zoneLabel], info.Labels[regionLabel]; zone != "" || region != "" {
+		topo.Zone, topo.Region, topo.Source = zone, region, "pod"
+		return topo
+	}
+	if client == nil || topo.Node == "" {
+		return topo
+	}
+
+	st.nodeTopology.Lock()
+	defer st.nodeTopology.Unlock()
+	if st.nodeTopology.labels == nil {
+		node, err := client.getNode(ctx, topo.Node)
+		if err != nil {
+			return topo
+		}
+		st.nodeTopology.labels = node.Metadata.Labels
+		if st.nodeTopology.labels == nil {
+			st.nodeTopology.labels = map[string]string{}
+		}
+	}
+	topo.Zone, topo.Region, topo.Source = st.nodeTopology.labels[zoneLabel], st.nodeTopology.labels[regionLabel], "node"
+	return topo
+}
+
+// topologyHandler answers GET /topology with the zone and region of the
+// replica serving the request, to verify topology aware routing.
+func (st *state) topologyHandler(c *web.Context) {
+	c.JSON(http.StatusOK, st.loadTopology(c.Request.Context(), st.kube))
+}
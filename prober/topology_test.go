@@ -0,0 +1,78 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestTopologySources(t *testing.T) {
+	st := newState(Options{})
+	dir := t.TempDir()
+	t.Setenv(podInfoDirEnv, dir)
+	t.Setenv(podNameEnv, "prober-0")
+	t.Setenv(nodeNameEnv, "node-a")
+	t.Setenv(nodeZoneEnv, "eu-west-1a")
+	t.Setenv(nodeRegionEnv, "")
+
+	if topo := st.loadTopology(context.Background(), nil); topo.Zone != "eu-west-1a" || topo.Source != "env" {
+		t.Errorf("expected the zone of NODE_ZONE, got %+v", topo)
+	}
+
+	t.Setenv(nodeZoneEnv, "")
+	os.WriteFile(filepath.Join(dir, "labels"), []byte("topology.kubernetes.io/zone=\"eu-west-1b\"\ntopology.kubernetes.io/region=\"eu-west-1\""), 0o600)
+	if topo := st.loadTopology(context.Background(), nil); topo.Zone != "eu-west-1b" || topo.Region != "eu-west-1" || topo.Source != "pod" {
+		t.Errorf("expected the zone of the pod labels, got %+v", topo)
+	}
+}
+
+func TestTopologyHandlerNode(t *testing.T) {
+	t.Setenv(podInfoDirEnv, t.TempDir())
+	t.Setenv(podNameEnv, "prober-0")
+	t.Setenv(nodeNameEnv, "node-a")
+	t.Setenv(podIPEnv, "10.0.1.12")
+	t.Setenv(podServiceAccountEnv, "prober")
+	t.Setenv(nodeZoneEnv, "")
+	t.Setenv(nodeRegionEnv, "")
+	st := newState(Options{})
+
+	nodeRequests := 0
+	st.kube = newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/nodes/node-a":
+			nodeRequests++
+			w.Write([]byte(`{"metadata":{"name":"node-a","labels":{"topology.kubernetes.io/zone":"us-east-1c","topology.kubernetes.io/region":"us-east-1"}}}`))
+		case "/api/v1/namespaces/default/pods/prober-0":
+			w.Write([]byte(`{"metadata":{"name":"prober-0"},"spec":{"nodeName":"node-a"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/topology", st.topologyHandler)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/topology", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var topo topology
+		if err := json.Unmarshal(w.Body.Bytes(), &topo); err != nil {
+			t.Fatalf("invalid response body: %v", err)
+		}
+		if topo.Zone != "us-east-1c" || topo.Region != "us-east-1" || topo.Node != "node-a" || topo.Source != "node" {
+			t.Errorf("unexpected topology: %+v", topo)
+		}
+	}
+	if nodeRequests != 1 {
+		t.Errorf("expected the node to be read once, got %d", nodeRequests)
+	}
+}
@@ -0,0 +1,217 @@
+package prober
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	b3Header             = "b3"
+	b3TraceIDHeader      = "X-B3-TraceId"
+	b3SpanIDHeader       = "X-B3-SpanId"
+	b3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	b3SampledHeader      = "X-B3-Sampled"
+	b3FlagsHeader        = "X-B3-Flags"
+)
+
+// proxyHeaders are set by the usual proxies and meshes on their way in, and
+// hint that the request went through one before reaching prober.
+var proxyHeaders = []string{
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Envoy-Attempt-Count",
+	"X-Envoy-External-Address",
+	"X-Envoy-Expected-Rq-Timeout-Ms",
+	"L5d-Dst-Canonical",
+}
+
+// traceparent is a parsed W3C Trace Context traceparent header.
+type traceparent struct {
+	Version  string `json:"version"`
+	TraceID  string `json:"traceId"`
+	ParentID string `json:"parentId"`
+	Flags    string `json:"flags"`
+	Sampled  bool   `json:"sampled"`
+}
+
+func isHex(value string, size int) bool {
+	if len(value) != size {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// parseTraceparent validates a "00-<trace-id>-<parent-id>-<flags>" header as
+// described by https://www.w3.org/TR/trace-context/#traceparent-header.
+func parseTraceparent(header string) (traceparent, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return traceparent{}, false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return traceparent{}, false
+	}
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return traceparent{}, false
+	}
+	if !isHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return traceparent{}, false
+	}
+	if !isHex(flags, 2) {
+		return traceparent{}, false
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return traceparent{
+		Version:  version,
+		TraceID:  strings.ToLower(traceID),
+		ParentID: strings.ToLower(parentID),
+		Flags:    flags,
+		Sampled:  flagBits[0]&0x01 == 1,
+	}, true
+}
+
+// parseTracestate splits a tracestate header into its vendor entries,
+// dropping the empty members the specification allows.
+func parseTracestate(header string) []string {
+	entries := []string{}
+	for _, member := range strings.Split(header, ",") {
+		if member = strings.TrimSpace(member); member != "" {
+			entries = append(entries, member)
+		}
+	}
+	return entries
+}
+
+// b3 is a Zipkin B3 context, sent either as the single b3 header or the
+// X-B3-* headers.
+type b3 struct {
+	Format       string `json:"format"`
+	TraceID      string `json:"traceId,omitempty"`
+	SpanID       string `json:"spanId,omitempty"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+	Sampled      string `json:"sampled,omitempty"`
+	Valid        bool   `json:"valid"`
+}
+
+// parseB3 reads the single header format first, as described by
+// https://github.com/openzipkin/b3-propagation, then the multiple headers one.
+// It reports false when no B3 header was sent.
+func parseB3(header http.Header) (b3, bool) {
+	if single := header.Get(b3Header); single != "" {
+		parts := strings.Split(single, "-")
+		context := b3{Format: "single"}
+		switch len(parts) {
+		case 1:
+			context.Sampled = parts[0]
+			context.Valid = validB3Sampled(parts[0])
+			return context, true
+		case 2, 3, 4:
+			context.TraceID, context.SpanID = parts[0], parts[1]
+			if len(parts) > 2 {
+				context.Sampled = parts[2]
+			}
+			if len(parts) > 3 {
+				context.ParentSpanID = parts[3]
+			}
+		}
+		context.Valid = validB3IDs(context) && (context.Sampled == "" || validB3Sampled(context.Sampled))
+		return context, true
+	}
+
+	context := b3{
+		Format:       "multi",
+		TraceID:      header.Get(b3TraceIDHeader),
+		SpanID:       header.Get(b3SpanIDHeader),
+		ParentSpanID: header.Get(b3ParentSpanIDHeader),
+		Sampled:      header.Get(b3SampledHeader),
+	}
+	if header.Get(b3FlagsHeader) == "1" {
+		context.Sampled = "d"
+	}
+	if context.TraceID == "" && context.SpanID == "" && context.Sampled == "" {
+		return b3{}, false
+	}
+	if context.TraceID == "" && context.SpanID == "" {
+		context.Valid = validB3Sampled(context.Sampled)
+		return context, true
+	}
+	context.Valid = validB3IDs(context) && (context.Sampled == "" || validB3Sampled(context.Sampled))
+	return context, true
+}
+
+func validB3IDs(context b3) bool {
+	if !isHex(context.TraceID, 16) && !isHex(context.TraceID, 32) {
+		return false
+	}
+	if !isHex(context.SpanID, 16) {
+		return false
+	}
+	return context.ParentSpanID == "" || isHex(context.ParentSpanID, 16)
+}
+
+func validB3Sampled(value string) bool {
+	switch value {
+	case "0", "1", "d", "true", "false":
+		return true
+	}
+	return false
+}
+
+type traceResponse struct {
+	Traceparent    string       `json:"traceparent,omitempty"`
+	TraceContext   *traceparent `json:"traceContext,omitempty"`
+	Tracestate     []string     `json:"tracestate,omitempty"`
+	B3             *b3          `json:"b3,omitempty"`
+	Propagated     bool         `json:"propagated"`
+	ProxiedBy      []string     `json:"proxiedBy"`
+	ProxyInjected  bool         `json:"proxyInjected"`
+	InvalidHeaders []string     `json:"invalidHeaders"`
+}
+
+// traceRequest echoes the trace context prober received. Propagated is set
+// when a valid context arrived, and proxyInjected when it did while proxy
+// headers are present, which is what a mesh starting or forwarding the
+// trace looks like.
+func traceRequest(c *web.Context) {
+	response := traceResponse{ProxiedBy: []string{}, InvalidHeaders: []string{}}
+
+	if header := c.GetHeader(traceparentHeader); header != "" {
+		response.Traceparent = header
+		if trace, ok := parseTraceparent(header); ok {
+			response.TraceContext = &trace
+			response.Propagated = true
+		} else {
+			response.InvalidHeaders = append(response.InvalidHeaders, traceparentHeader)
+		}
+	}
+	if header := c.GetHeader(tracestateHeader); header != "" {
+		response.Tracestate = parseTracestate(header)
+	}
+	if context, ok := parseB3(c.Request.Header); ok {
+		response.B3 = &context
+		if context.Valid {
+			response.Propagated = true
+		} else {
+			response.InvalidHeaders = append(response.InvalidHeaders, "b3")
+		}
+	}
+
+	for _, header := range proxyHeaders {
+		if c.GetHeader(header) != "" {
+			response.ProxiedBy = append(response.ProxiedBy, header)
+		}
+	}
+	response.ProxyInjected = response.Propagated && len(response.ProxiedBy) > 0
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,110 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	trace, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected valid traceparent")
+	}
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.ParentID != "00f067aa0ba902b7" || !trace.Sampled {
+		t.Errorf("unexpected traceparent: %+v", trace)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	}
+	for _, header := range invalid {
+		if _, ok := parseTraceparent(header); ok {
+			t.Errorf("expected %q to be invalid", header)
+		}
+	}
+}
+
+func TestParseB3(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		valid   bool
+		sampled string
+	}{
+		{"single", map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}, true, "1"},
+		{"single sampling only", map[string]string{"b3": "0"}, true, "0"},
+		{"single invalid", map[string]string{"b3": "80f198ee-e457b5a2e4d86bd1"}, false, ""},
+		{"multi", map[string]string{"X-B3-TraceId": "463ac35c9f6413ad", "X-B3-SpanId": "a2fb4a1d1a96d312", "X-B3-Sampled": "1"}, true, "1"},
+		{"multi debug", map[string]string{"X-B3-TraceId": "463ac35c9f6413ad", "X-B3-SpanId": "a2fb4a1d1a96d312", "X-B3-Flags": "1"}, true, "d"},
+		{"multi missing span", map[string]string{"X-B3-TraceId": "463ac35c9f6413ad"}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tt.headers {
+				header.Set(key, value)
+			}
+			context, ok := parseB3(header)
+			if !ok {
+				t.Fatal("expected B3 headers to be found")
+			}
+			if context.Valid != tt.valid {
+				t.Errorf("expected valid=%v, got %+v", tt.valid, context)
+			}
+			if tt.valid && context.Sampled != tt.sampled {
+				t.Errorf("expected sampled %q, got %q", tt.sampled, context.Sampled)
+			}
+		})
+	}
+
+	if _, ok := parseB3(http.Header{}); ok {
+		t.Error("expected no B3 context without headers")
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/trace", traceRequest)
+
+	req, _ := http.NewRequest("GET", "/trace", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE, ,rojo=00f067aa0ba902b7")
+	req.Header.Set("b3", "not-valid")
+	req.Header.Set("X-Envoy-Attempt-Count", "1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response traceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if response.TraceContext == nil || response.TraceContext.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected parsed traceparent, got %+v", response.TraceContext)
+	}
+	if len(response.Tracestate) != 2 {
+		t.Errorf("expected 2 tracestate entries, got %v", response.Tracestate)
+	}
+	if len(response.InvalidHeaders) != 1 || response.InvalidHeaders[0] != "b3" {
+		t.Errorf("expected invalid b3 header, got %v", response.InvalidHeaders)
+	}
+	if !response.Propagated || !response.ProxyInjected {
+		t.Errorf("expected propagated trace through a proxy, got %+v", response)
+	}
+}
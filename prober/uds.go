@@ -0,0 +1,40 @@
+package prober
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+)
+
+const unixSocketPathEnv = "UNIX_SOCKET_PATH"
+
+// listenUnix listens on a Unix domain socket, removing a stale socket left
+// behind by a previous container run. The socket is made writable by the
+// group so sidecars running under another user can connect.
+func listenUnix(path string) (net.Listener, error) {
+	return listenerHandoff.listen(handoffKey("unix", path), func() (net.Listener, error) {
+		return openUnix(path)
+	})
+}
+
+func openUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
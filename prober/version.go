@@ -0,0 +1,111 @@
+package prober
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time with
+// -ldflags "-X github.com/hpettenuci/probe/prober.version=...", likewise for
+// commit and buildDate.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionInfo describes the build, Router being gin or, in the binaries
+// built with the chi tag, chi.
+type versionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"buildDate"`
+	GoVersion string   `json:"goVersion"`
+	Router    string   `json:"router"`
+	Features  []string `json:"features"`
+}
+
+// getVersionInfo falls back to the VCS stamp of the Go toolchain when the
+// binary was built without ldflags, like with a plain `go build`.
+func getVersionInfo(config *settings) versionInfo {
+	info := versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Router:    web.Router,
+		Features:  enabledFeatures(config),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// enabledFeatures lists the optional features turned on by the settings.
+func enabledFeatures(config *settings) []string {
+	features := map[string]bool{
+		"tls":             config.get(tlsCertFileEnv) != "",
+		"http2":           config.get(tlsCertFileEnv) != "" && config.getBool(http2EnabledEnv, true),
+		"h2c":             config.getBool(h2cEnabledEnv, false),
+		"uds":             config.get(unixSocketPathEnv) != "",
+		"listeners":       config.get(listenersConfigEnv) != "",
+		"proxyProtocol":   config.getBool(proxyProtocolEnv, false),
+		"grpc":            config.get(grpcAddrEnv) != "",
+		"dns":             config.get(dnsAddrEnv) != "",
+		"metricsListener": config.get(metricsAddrEnv) != "",
+		"metricsPush":     config.get(metricsPushURLEnv) != "" || config.get(metricsRemoteWriteURLEnv) != "",
+		"exemplars":       config.getBool(metricsExemplarsEnv, false),
+		"admin":           config.get(adminAddrEnv) != "",
+	}
+
+	enabled := []string{}
+	for feature, on := range features {
+		if on {
+			enabled = append(enabled, feature)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// newBuildInfoCollector exposes the same fields as /version as a constant
+// prober_build_info gauge, to join on in queries.
+func newBuildInfoCollector(config *settings) prometheus.Collector {
+	info := getVersionInfo(config)
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "prober_build_info",
+		Help: "A metric with a constant '1' value labeled by the prober build.",
+		ConstLabels: prometheus.Labels{
+			"version":   info.Version,
+			"commit":    info.Commit,
+			"builddate": info.BuildDate,
+			"goversion": info.GoVersion,
+			"router":    info.Router,
+			"features":  strings.Join(info.Features, ","),
+		},
+	}, func() float64 { return 1 })
+}
+
+func (st *state) versionRequest(c *web.Context) {
+	c.JSON(http.StatusOK, getVersionInfo(st.config))
+}
@@ -0,0 +1,61 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+func TestVersionRequest(t *testing.T) {
+	t.Setenv(grpcAddrEnv, ":9090")
+	t.Setenv(h2cEnabledEnv, "true")
+	st := newState(Options{})
+
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/version", st.versionRequest)
+
+	req, _ := http.NewRequest("GET", "/version", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var info versionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if info.Version != "dev" {
+		t.Errorf("expected version dev, got %s", info.Version)
+	}
+	if !strings.HasPrefix(info.GoVersion, "go") {
+		t.Errorf("expected Go version, got %s", info.GoVersion)
+	}
+	if info.Router != web.Router {
+		t.Errorf("expected router %s, got %s", web.Router, info.Router)
+	}
+	if strings.Join(info.Features, ",") != "grpc,h2c" {
+		t.Errorf("expected features grpc,h2c, got %v", info.Features)
+	}
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	router := web.Default()
+	router.GET("/metrics", st.metricsHandler())
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), `prober_build_info{`) || !strings.Contains(w.Body.String(), `version="dev"`) {
+		t.Errorf("expected prober_build_info metric, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package prober
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+const virtualClockEnv = "VIRTUAL_CLOCK"
+
+var errVirtualClockDisabled = errors.New("virtual clock disabled")
+
+// virtualClock is the time of the time-based behaviors, like the steps of
+// scenarios and the check schedules. It follows the wall clock, plus the
+// offset it was advanced by when VIRTUAL_CLOCK is enabled, so integration
+// tests do not wait real minutes for a transition.
+type virtualClock struct {
+	mu      sync.Mutex
+	enabled bool
+	offset  time.Duration
+	// changed is closed and replaced on every advance, waking the waiters.
+	changed chan struct{}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// advance moves the clock forward, never backward, returning the total
+// offset to the wall clock.
+func (c *virtualClock) advance(d time.Duration) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return 0, errVirtualClockDisabled
+	}
+	c.offset += d
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return c.offset, nil
+}
+
+// waitUntil blocks until the clock reaches t, returning false when done is
+// closed first.
+func (c *virtualClock) waitUntil(t time.Time, done <-chan struct{}) bool {
+	for {
+		c.mu.Lock()
+		remaining, changed := t.Sub(time.Now().Add(c.offset)), c.changed
+		c.mu.Unlock()
+		if remaining <= 0 {
+			return true
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-done:
+			timer.Stop()
+			return false
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+type virtualClockInfo struct {
+	Virtual bool      `json:"virtual"`
+	Now     time.Time `json:"now"`
+	Offset  string    `json:"offset"`
+}
+
+func (c *virtualClock) info() virtualClockInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return virtualClockInfo{Virtual: c.enabled, Now: time.Now().Add(c.offset), Offset: c.offset.String()}
+}
+
+func (st *state) clockHandler(c *web.Context) {
+	c.JSON(http.StatusOK, st.proberClock.info())
+}
+
+// advanceClock answers POST /clock/advance?d=10m by moving the virtual
+// clock forward, running the steps and checks that became due.
+func (st *state) advanceClock(c *web.Context) {
+	d, err := time.ParseDuration(c.Query("d"))
+	if err != nil || d < 0 {
+		c.JSON(http.StatusBadRequest, web.H{"error": "Invalid duration " + c.Query("d")})
+		return
+	}
+	offset, err := st.proberClock.advance(d)
+	if err != nil {
+		c.JSON(http.StatusConflict, web.H{"error": "Virtual clock disabled, set " + virtualClockEnv})
+		return
+	}
+	slog.Info("Virtual clock advanced", "by", d.String(), "offset", offset.String())
+	c.JSON(http.StatusOK, st.proberClock.info())
+}
@@ -0,0 +1,96 @@
+package prober
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpettenuci/probe/prober/internal/web"
+)
+
+// enableVirtualClock turns the virtual clock of st on.
+func enableVirtualClock(st *state) {
+	st.proberClock.mu.Lock()
+	defer st.proberClock.mu.Unlock()
+	st.proberClock.enabled = true
+}
+
+func TestVirtualClockWait(t *testing.T) {
+	st := newState(Options{})
+	enableVirtualClock(st)
+
+	woken := make(chan bool)
+	go func() { woken <- st.proberClock.waitUntil(st.proberClock.Now().Add(time.Hour), nil) }()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := st.proberClock.advance(30 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-woken:
+		t.Fatalf("expected the waiter to keep waiting half way")
+	case <-time.After(20 * time.Millisecond):
+	}
+	st.proberClock.advance(30 * time.Minute)
+	select {
+	case ok := <-woken:
+		if !ok {
+			t.Errorf("expected the waiter to reach the time")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the advance to wake the waiter")
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if st.proberClock.waitUntil(st.proberClock.Now().Add(time.Hour), done) {
+		t.Errorf("expected a closed done to stop the wait")
+	}
+}
+
+func TestAdvanceClock(t *testing.T) {
+	st := newState(Options{})
+	web.SetMode(web.ReleaseMode)
+	t.Setenv(readinessProbeDelayEnv, "0")
+	defer st.scenarios.stop()
+
+	router := st.newRouter(nil, listenerConfig{})
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := request(http.MethodPost, "/clock/advance?d=10m", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 with the wall clock, got %d", w.Code)
+	}
+	enableVirtualClock(st)
+	if w := request(http.MethodPost, "/clock/advance?d=-1m", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 going backward, got %d", w.Code)
+	}
+
+	if w := request(http.MethodPost, "/scenario", `
+name: slow-drill
+steps:
+  - at: 0s
+  - at: 10m
+    probes: {readiness: fail}
+`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d %s", w.Code, w.Body.String())
+	}
+	w := request(http.MethodPost, "/clock/advance?d=10m", "")
+	var info virtualClockInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || !info.Virtual || info.Offset != "10m0s" {
+		t.Errorf("unexpected clock %d %s", w.Code, w.Body.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for request(http.MethodGet, "/readiness", "").Code != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the step 10 minutes later to apply right away")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,236 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	webhooksConfigEnv = "WEBHOOKS_CONFIG"
+
+	webhookEventProbe = "probe"
+	webhookEventCheck = "check"
+
+	webhookQueueSize = 100
+	webhookTimeout   = 5 * time.Second
+)
+
+// webhookMetrics count the webhook deliveries.
+type webhookMetrics struct {
+	webhookDeliveriesTotal *prometheus.CounterVec
+}
+
+func newWebhookMetrics(registry prometheus.Registerer) webhookMetrics {
+	m := webhookMetrics{
+		webhookDeliveriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Webhook notifications sent by result.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.webhookDeliveriesTotal)
+	return m
+}
+
+// webhookConfig is an URL called on state changes. The body is the event
+// as JSON unless template, a Go text/template executed with the event, is
+// set.
+type webhookConfig struct {
+	URL      string            `yaml:"url"`
+	Events   []string          `yaml:"events"`
+	Headers  map[string]string `yaml:"headers"`
+	Template string            `yaml:"template"`
+
+	template *template.Template
+}
+
+type webhooksFile struct {
+	Webhooks []webhookConfig `yaml:"webhooks"`
+}
+
+// webhookEvent is sent when a simulated probe or an outbound check changes
+// between success and failure.
+type webhookEvent struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Previous string    `json:"previous"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// webhookTemplateFuncs lets templates embed values in JSON safely, as in
+// {"text": {{json .Name}}}.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func loadWebhooksConfig(path string) ([]webhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file webhooksFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	for i := range file.Webhooks {
+		webhook := &file.Webhooks[i]
+		if webhook.URL == "" {
+			return nil, errors.New("webhook without url")
+		}
+		for _, event := range webhook.Events {
+			if event != webhookEventProbe && event != webhookEventCheck {
+				return nil, fmt.Errorf("webhook %q has unknown event %q", webhook.URL, event)
+			}
+		}
+		if webhook.Template != "" {
+			tmpl, err := template.New(webhook.URL).Funcs(webhookTemplateFuncs).Parse(webhook.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %q has invalid template: %w", webhook.URL, err)
+			}
+			webhook.template = tmpl
+		}
+	}
+	return file.Webhooks, nil
+}
+
+// webhookNotifier tracks the state of probes and checks and calls the
+// webhooks in the background when one flips. Events are dropped when the
+// queue is full rather than slowing down the probes. A nil notifier does
+// nothing.
+type webhookNotifier struct {
+	webhooks []webhookConfig
+	client   *http.Client
+
+	mu     sync.Mutex
+	states map[string]bool
+
+	queue chan webhookEvent
+	done  chan struct{}
+
+	webhookMetrics
+}
+
+func newWebhookNotifier(configs []webhookConfig, metrics webhookMetrics) *webhookNotifier {
+	n := &webhookNotifier{
+		webhooks: configs,
+		client:   &http.Client{Timeout: webhookTimeout},
+		states:   make(map[string]bool),
+		queue:    make(chan webhookEvent, webhookQueueSize),
+		done:     make(chan struct{}),
+
+		webhookMetrics: metrics,
+	}
+	go n.run()
+	return n
+}
+
+// observe records the outcome of a probe or check. The first outcome only
+// sets the state, the following ones notify when it changes.
+func (n *webhookNotifier) observe(kind string, name string, success bool, errMsg string) {
+	if n == nil {
+		return
+	}
+	key := kind + "/" + name
+
+	n.mu.Lock()
+	previous, seen := n.states[key]
+	n.states[key] = success
+	n.mu.Unlock()
+	if !seen || previous == success {
+		return
+	}
+
+	event := webhookEvent{Kind: kind, Name: name, State: outcome(success), Previous: outcome(previous), Time: time.Now(), Error: errMsg}
+	select {
+	case n.queue <- event:
+	default:
+		n.webhookDeliveriesTotal.WithLabelValues("dropped").Inc()
+		slog.Warn("Webhook queue full, dropping event", "kind", kind, "name", name)
+	}
+}
+
+func outcome(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for event := range n.queue {
+		for _, webhook := range n.webhooks {
+			if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Kind) {
+				continue
+			}
+			result := "success"
+			if err := n.send(webhook, event); err != nil {
+				result = "failure"
+				slog.Warn("Failed to send webhook", "url", webhook.URL, "error", err)
+			}
+			n.webhookDeliveriesTotal.WithLabelValues(result).Inc()
+		}
+	}
+}
+
+func (n *webhookNotifier) send(webhook webhookConfig, event webhookEvent) error {
+	var body bytes.Buffer
+	if webhook.template != nil {
+		if err := webhook.template.Execute(&body, event); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(event); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close sends the queued events before returning.
+func (n *webhookNotifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	<-n.done
+}
@@ -0,0 +1,100 @@
+package prober
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadWebhooksConfig(t *testing.T) {
+	path := writeChecksConfig(t, `
+webhooks:
+  - url: http://hooks.example.com/chat
+    events: [check]
+    template: '{"text": {{json .Name}}}'
+  - url: http://ci.example.com/events
+`)
+	configs, err := loadWebhooksConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(configs) != 2 || configs[0].template == nil || configs[1].template != nil {
+		t.Errorf("expected 2 webhooks with the first templated, got %+v", configs)
+	}
+
+	invalid := []string{
+		"webhooks:\n  - events: [probe]\n",
+		"webhooks:\n  - url: http://x\n    events: [deploy]\n",
+		"webhooks:\n  - url: http://x\n    template: '{{.Name'\n",
+	}
+	for _, content := range invalid {
+		if _, err := loadWebhooksConfig(writeChecksConfig(t, content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	st := newState(Options{})
+	received := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- string(body)
+	}))
+	defer hook.Close()
+
+	path := writeChecksConfig(t, `
+webhooks:
+  - url: `+hook.URL+`/chat
+    events: [check]
+    headers:
+      Authorization: Bearer secret
+    template: '{"text": {{json (printf "%s is now %s" .Name .State)}}}'
+  - url: `+hook.URL+`/ci
+    events: [probe]
+`)
+	configs, err := loadWebhooksConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := newWebhookNotifier(configs, st.webhookMetrics)
+
+	notifier.observe(webhookEventCheck, "api", true, "")
+	notifier.observe(webhookEventCheck, "api", true, "")
+	notifier.observe(webhookEventCheck, "api", false, "unexpected status 500")
+	notifier.observe(webhookEventProbe, "readiness", false, "")
+	notifier.observe(webhookEventProbe, "readiness", true, "")
+	notifier.Close()
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(received))
+	}
+
+	chat, body := <-received, <-bodies
+	if chat.URL.Path != "/chat" || chat.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the chat webhook with its header, got %s %v", chat.URL.Path, chat.Header)
+	}
+	if body != `{"text": "api is now failure"}` {
+		t.Errorf("expected templated body, got %s", body)
+	}
+
+	ci, body := <-received, <-bodies
+	if ci.URL.Path != "/ci" {
+		t.Errorf("expected the ci webhook, got %s", ci.URL.Path)
+	}
+	var event webhookEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		t.Fatalf("invalid webhook body: %v", err)
+	}
+	if event.Kind != webhookEventProbe || event.Name != "readiness" || event.State != "success" || event.Previous != "failure" {
+		t.Errorf("expected readiness back to success, got %+v", event)
+	}
+	if time.Since(event.Time) > time.Minute {
+		t.Errorf("expected a recent event time, got %v", event.Time)
+	}
+}